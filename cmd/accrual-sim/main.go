@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/accrualsim"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"go.uber.org/zap"
+)
+
+func main() {
+	cfg := accrualsim.NewConfig()
+	if err := cfg.Parse(); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := logger.Initialize(cfg.LogLevel); err != nil {
+		log.Fatalln("failed initializing logger:", err)
+	}
+	defer logger.Sync()
+
+	logger.Log.Info("Starting accrual simulator", zap.Any("config", cfg))
+
+	if err := accrualsim.Start(cfg); err != nil {
+		logger.Log.Fatal("Accrual simulator Start returned error", zap.Error(err))
+	}
+}
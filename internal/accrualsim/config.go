@@ -0,0 +1,95 @@
+package accrualsim
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/caarlos0/env/v10"
+)
+
+// Config is a struct to setup the simulator with.
+type Config struct {
+	RunAddress string `env:"RUN_ADDRESS"` // flag: -a
+	GinMode    string `env:"GIN_MODE"`    // flag: --gin_mode
+	LogLevel   string `env:"LOG_LVL"`     // flag: --log_lvl
+	RulesFile  string `env:"RULES_FILE"`  // flag: --rules (json array of reward rules registered on start)
+
+	// order status transitions
+	RegisteredDelayMs int64 `env:"REGISTERED_DELAY"` // flag: --registered_delay (REGISTERED -> PROCESSING)
+	ProcessingDelayMs int64 `env:"PROCESSING_DELAY"` // flag: --processing_delay (PROCESSING -> PROCESSED/INVALID)
+
+	// unknown orders are registered on the first request instead of 204
+	AutoRegister bool    `env:"AUTO_REGISTER"` // flag: --auto_register
+	AutoAccrual  float64 `env:"AUTO_ACCRUAL"`  // flag: --auto_accrual (accrual of auto registered orders)
+
+	// injected faults
+	RateLimit     int     `env:"RATE_LIMIT"`      // flag: --rate_limit (requests per minute, 0 - unlimited)
+	RetryAfterSec int     `env:"RETRY_AFTER"`     // flag: --retry_after
+	ErrorRate     float64 `env:"ERROR_RATE"`      // flag: --error_rate (share of 500 responses)
+	NoContentRate float64 `env:"NO_CONTENT_RATE"` // flag: --no_content_rate (share of 204 responses for registered orders)
+	Seed          int64   `env:"SEED"`            // flag: --seed (random faults seed, 0 - random)
+}
+
+// NewConfig creates config with default values set
+func NewConfig() *Config {
+	return &Config{
+		RunAddress:        "localhost:8081",
+		LogLevel:          "info",
+		RegisteredDelayMs: 500,
+		ProcessingDelayMs: 1000,
+		AutoRegister:      true,
+		AutoAccrual:       100,
+		RetryAfterSec:     60,
+	}
+}
+
+// parseFlags defines and parses command-line flags.
+func (cfg *Config) parseFlags() {
+	flag.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "TCP address for the server to listen on")
+	flag.StringVar(&cfg.GinMode, "gin_mode", cfg.GinMode, "gin mode")
+	flag.StringVar(&cfg.LogLevel, "log_lvl", cfg.LogLevel, "logger level")
+	flag.StringVar(&cfg.RulesFile, "rules", cfg.RulesFile, "json file with reward rules to be registered on start")
+	flag.Int64Var(&cfg.RegisteredDelayMs, "registered_delay", cfg.RegisteredDelayMs, "milliseconds order stays REGISTERED")
+	flag.Int64Var(&cfg.ProcessingDelayMs, "processing_delay", cfg.ProcessingDelayMs, "milliseconds order stays PROCESSING")
+	flag.BoolVar(&cfg.AutoRegister, "auto_register", cfg.AutoRegister, "register unknown orders on the first request instead of 204")
+	flag.Float64Var(&cfg.AutoAccrual, "auto_accrual", cfg.AutoAccrual, "accrual of auto registered orders")
+	flag.IntVar(&cfg.RateLimit, "rate_limit", cfg.RateLimit, "max requests per minute, 429 is returned when exceeded (0 - unlimited)")
+	flag.IntVar(&cfg.RetryAfterSec, "retry_after", cfg.RetryAfterSec, "Retry-After header value in seconds sent with 429")
+	flag.Float64Var(&cfg.ErrorRate, "error_rate", cfg.ErrorRate, "share of requests to fail with 500 (0..1)")
+	flag.Float64Var(&cfg.NoContentRate, "no_content_rate", cfg.NoContentRate, "share of requests for registered orders to get 204 (0..1)")
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random faults seed (0 - random)")
+
+	flag.Parse()
+}
+
+// Parse parses config from both command-line flags and env.
+func (cfg *Config) Parse() (err error) {
+	cfg.parseFlags()
+
+	if err = env.Parse(cfg); err != nil {
+		return fmt.Errorf("config parse failed: %w", err)
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return fmt.Errorf("config parse failed: %w", err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.RegisteredDelayMs < 0 || cfg.ProcessingDelayMs < 0 {
+		return errors.New("invalid config: delays can't be negative")
+	}
+
+	if cfg.RateLimit < 0 || cfg.RetryAfterSec < 0 {
+		return errors.New("invalid config: rate limit and retry after can't be negative")
+	}
+
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 || cfg.NoContentRate < 0 || cfg.NoContentRate > 1 {
+		return errors.New("invalid config: fault rates must be within 0..1")
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package accrualsim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	ginzap "github.com/gin-contrib/zap"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type server struct {
+	cfg    *Config
+	sim    *Simulator
+	router *gin.Engine
+}
+
+func NewServer(cfg *Config, sim *Simulator) *server {
+	s := &server{
+		cfg: cfg,
+		sim: sim,
+	}
+
+	gin.SetMode(cfg.GinMode)
+	s.router = gin.New()
+	s.router.Use(
+		gin.Logger(),
+		ginzap.RecoveryWithZap(logger.Log, true),
+	)
+
+	api := s.router.Group("/api")
+	{
+		api.GET("/orders/:number", s.GetOrder)
+		api.POST("/orders", s.PostOrder)
+		api.POST("/goods", s.PostGoods)
+	}
+
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// GetOrder - получение информации о расчёте начислений баллов лояльности.
+// Ответы 429, 500 и 204 могут быть внедрены согласно настройкам.
+//
+// Route: GET /api/orders/{number}
+func (s *server) GetOrder(c *gin.Context) {
+	now := time.Now()
+
+	switch s.sim.Fault(now) {
+	case FaultTooManyRequests:
+		c.Header("Retry-After", strconv.Itoa(s.cfg.RetryAfterSec))
+		c.String(http.StatusTooManyRequests, "No more than %d requests per minute allowed", s.cfg.RateLimit)
+		return
+	case FaultInternal:
+		c.String(http.StatusInternalServerError, "injected internal server error")
+		return
+	case FaultNoContent:
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	res, err := s.sim.Accrual(model.OrderNumber(c.Param("number")), now)
+	if err != nil {
+		if errors.Is(err, ErrNotRegistered) {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// PostOrder - регистрация нового совершённого заказа.
+//
+// Route: POST /api/orders
+func (s *server) PostOrder(c *gin.Context) {
+	var order Order
+	if err := json.NewDecoder(c.Request.Body).Decode(&order); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := s.sim.RegisterOrder(order, time.Now()); err != nil {
+		switch {
+		case errors.Is(err, ErrBadRequest):
+			c.AbortWithStatus(http.StatusBadRequest)
+		case errors.Is(err, ErrOrderExists):
+			c.AbortWithStatus(http.StatusConflict)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// PostGoods - регистрация информации о вознаграждении за товар.
+//
+// Route: POST /api/goods
+func (s *server) PostGoods(c *gin.Context) {
+	var rule RewardRule
+	if err := json.NewDecoder(c.Request.Body).Decode(&rule); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := s.sim.AddRule(rule); err != nil {
+		switch {
+		case errors.Is(err, ErrBadRequest):
+			c.AbortWithStatus(http.StatusBadRequest)
+		case errors.Is(err, ErrRuleExists):
+			c.AbortWithStatus(http.StatusConflict)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// loadRules registers reward rules from json file.
+func loadRules(sim *Simulator, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read reward rules: %w", err)
+	}
+
+	var rules []RewardRule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("can't decode reward rules: %w", err)
+	}
+
+	for _, rule := range rules {
+		if err = sim.AddRule(rule); err != nil {
+			return fmt.Errorf("can't register reward rule %q: %w", rule.Match, err)
+		}
+	}
+
+	logger.Log.Info("Reward rules registered", zap.Int("count", len(rules)))
+
+	return nil
+}
+
+func Start(cfg *Config) error {
+	sim := New(cfg)
+
+	if cfg.RulesFile != "" {
+		if err := loadRules(sim, cfg.RulesFile); err != nil {
+			return err
+		}
+	}
+
+	s := &http.Server{
+		Addr:    cfg.RunAddress,
+		Handler: NewServer(cfg, sim),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Log.Fatal("Server's ListenAndServe returned error", zap.Error(err))
+		}
+	}()
+
+	<-ctx.Done()
+
+	logger.Log.Info("Accrual simulator caught os signal. Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,278 @@
+// Package accrualsim implements accrual system simulator to be used instead
+// of the real one in local runs and integration tests.
+package accrualsim
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+)
+
+// Order accrual statuses, the same as accrual system returns.
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+	StatusInvalid    = "INVALID"
+)
+
+// Reward types.
+const (
+	RewardPercent = "%"  // reward is a percent of goods price
+	RewardPoints  = "pt" // reward is a fixed points amount
+)
+
+var (
+	ErrBadRequest    = errors.New("bad request")
+	ErrRuleExists    = errors.New("reward rule with such match is already registered")
+	ErrOrderExists   = errors.New("order is already registered")
+	ErrNotRegistered = errors.New("order is not registered")
+)
+
+// RewardRule rewards goods which description contains Match.
+type RewardRule struct {
+	Match      string       `json:"match"`
+	Reward     model.Points `json:"reward"`
+	RewardType string       `json:"reward_type"`
+}
+
+func (r RewardRule) Validate() error {
+	if strings.TrimSpace(r.Match) == "" || r.Reward <= 0 {
+		return ErrBadRequest
+	}
+
+	if r.RewardType != RewardPercent && r.RewardType != RewardPoints {
+		return ErrBadRequest
+	}
+
+	return nil
+}
+
+// Good is an item of the order.
+type Good struct {
+	Description string       `json:"description"`
+	Price       model.Points `json:"price"`
+}
+
+// Order is an order registered by a shop.
+type Order struct {
+	Number model.OrderNumber `json:"order"`
+	Goods  []Good            `json:"goods"`
+}
+
+// OrderAccrual is a response on order accrual request. Accrual is omitted
+// when nothing is credited.
+type OrderAccrual struct {
+	Number  model.OrderNumber `json:"order"`
+	Status  string            `json:"status"`
+	Accrual *model.Points     `json:"accrual,omitempty"`
+}
+
+type order struct {
+	goods        []Good
+	auto         bool // registered on the first request
+	registeredAt time.Time
+
+	final   bool
+	status  string
+	accrual model.Points
+}
+
+// Fault is an error response to be injected instead of the normal one.
+type Fault int
+
+const (
+	FaultNone Fault = iota
+	FaultTooManyRequests
+	FaultInternal
+	FaultNoContent
+)
+
+// Simulator keeps reward rules and registered orders in memory.
+type Simulator struct {
+	mu  sync.Mutex
+	cfg *Config
+	rnd *rand.Rand
+
+	rules  []RewardRule
+	orders map[model.OrderNumber]*order
+
+	// fixed one minute window for rate limit
+	windowStart time.Time
+	requests    int
+}
+
+func New(cfg *Config) *Simulator {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &Simulator{
+		cfg:    cfg,
+		rnd:    rand.New(rand.NewSource(seed)),
+		rules:  make([]RewardRule, 0),
+		orders: make(map[model.OrderNumber]*order),
+	}
+}
+
+// AddRule registers new reward rule. Rules are applied in registration order,
+// the first matching one rewards the good.
+func (s *Simulator) AddRule(rule RewardRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.rules {
+		if r.Match == rule.Match {
+			return ErrRuleExists
+		}
+	}
+
+	s.rules = append(s.rules, rule)
+
+	return nil
+}
+
+// RegisterOrder takes order into accrual calculation.
+func (s *Simulator) RegisterOrder(o Order, now time.Time) error {
+	if err := o.Number.Validate(); err != nil {
+		return ErrBadRequest
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[o.Number]; ok {
+		return ErrOrderExists
+	}
+
+	s.orders[o.Number] = &order{
+		goods:        o.Goods,
+		registeredAt: now,
+	}
+
+	return nil
+}
+
+// Accrual returns order's accrual calculation state at the moment now.
+// Order moves REGISTERED -> PROCESSING -> PROCESSED/INVALID as configured
+// delays pass. ErrNotRegistered is returned for unknown orders unless auto
+// registration is on.
+func (s *Simulator) Accrual(number model.OrderNumber, now time.Time) (res OrderAccrual, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		if !s.cfg.AutoRegister || number.Validate() != nil {
+			return res, ErrNotRegistered
+		}
+
+		o = &order{
+			auto:         true,
+			registeredAt: now,
+		}
+		s.orders[number] = o
+	}
+
+	res.Number = number
+
+	registered := time.Duration(s.cfg.RegisteredDelayMs) * time.Millisecond
+	processing := time.Duration(s.cfg.ProcessingDelayMs) * time.Millisecond
+
+	switch elapsed := now.Sub(o.registeredAt); {
+	case o.final:
+	case elapsed < registered:
+		res.Status = StatusRegistered
+		return res, nil
+	case elapsed < registered+processing:
+		res.Status = StatusProcessing
+		return res, nil
+	default:
+		// accrual is calculated once with the rules registered by this moment
+		s.calculate(o)
+	}
+
+	res.Status = o.status
+	if o.status == StatusProcessed {
+		accrual := o.accrual
+		res.Accrual = &accrual
+	}
+
+	return res, nil
+}
+
+// calculate sets order's final status. Order is invalid when none of its
+// goods matched any reward rule. Must be called with lock held.
+func (s *Simulator) calculate(o *order) {
+	o.final = true
+
+	if o.auto {
+		o.status = StatusProcessed
+		o.accrual = model.PointsFromFloat(s.cfg.AutoAccrual)
+		return
+	}
+
+	matched := false
+	for _, good := range o.goods {
+		for _, rule := range s.rules {
+			if !strings.Contains(good.Description, rule.Match) {
+				continue
+			}
+
+			matched = true
+			if rule.RewardType == RewardPercent {
+				o.accrual += good.Price.MulRatio(int64(rule.Reward), 100*model.PointsScale)
+			} else {
+				o.accrual += rule.Reward
+			}
+
+			break
+		}
+	}
+
+	if !matched {
+		o.status = StatusInvalid
+		o.accrual = 0
+		return
+	}
+
+	o.status = StatusProcessed
+}
+
+// Fault decides whether order accrual request must fail. Rate limit is
+// checked first, then random 500 and 204 faults are applied.
+func (s *Simulator) Fault(now time.Time) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg.RateLimit > 0 {
+		if now.Sub(s.windowStart) >= time.Minute {
+			s.windowStart = now
+			s.requests = 0
+		}
+
+		s.requests++
+		if s.requests > s.cfg.RateLimit {
+			return FaultTooManyRequests
+		}
+	}
+
+	if s.cfg.ErrorRate > 0 && s.rnd.Float64() < s.cfg.ErrorRate {
+		return FaultInternal
+	}
+
+	if s.cfg.NoContentRate > 0 && s.rnd.Float64() < s.cfg.NoContentRate {
+		return FaultNoContent
+	}
+
+	return FaultNone
+}
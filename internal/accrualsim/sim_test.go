@@ -0,0 +1,313 @@
+package accrualsim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// valid by Luhn
+const (
+	orderA model.OrderNumber = "12345678903"
+	orderB model.OrderNumber = "79927398713"
+)
+
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// newTestSimulator creates simulator without delays and random faults.
+func newTestSimulator(t *testing.T, cfg *Config, rules ...RewardRule) *Simulator {
+	t.Helper()
+
+	sim := New(cfg)
+	for _, rule := range rules {
+		if err := sim.AddRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return sim
+}
+
+func TestSimulatorRewardRules(t *testing.T) {
+	rules := []RewardRule{
+		{Match: "Bork", Reward: points(t, "10"), RewardType: RewardPercent},
+		{Match: "Cup", Reward: points(t, "15"), RewardType: RewardPercent},
+		{Match: "Mug", Reward: points(t, "5"), RewardType: RewardPoints},
+		// never applied to Bork goods, the first matching rule wins
+		{Match: "Bork kettle", Reward: points(t, "1000"), RewardType: RewardPoints},
+	}
+
+	tests := []struct {
+		name    string
+		goods   []Good
+		status  string
+		accrual string
+	}{
+		{
+			name:    "percent",
+			goods:   []Good{{Description: "Bork kettle", Price: points(t, "1000")}},
+			status:  StatusProcessed,
+			accrual: "100",
+		},
+		{
+			name:    "percent is rounded half away from zero",
+			goods:   []Good{{Description: "Cup", Price: points(t, "123.4567")}},
+			status:  StatusProcessed,
+			accrual: "18.5185",
+		},
+		{
+			name:    "smallest unit rounded up",
+			goods:   []Good{{Description: "Bork spoon", Price: points(t, "0.0005")}},
+			status:  StatusProcessed,
+			accrual: "0.0001",
+		},
+		{
+			name:    "fixed",
+			goods:   []Good{{Description: "Mug", Price: points(t, "1000")}},
+			status:  StatusProcessed,
+			accrual: "5",
+		},
+		{
+			name: "goods are summed up",
+			goods: []Good{
+				{Description: "Bork kettle", Price: points(t, "500")},
+				{Description: "Mug", Price: points(t, "100")},
+				{Description: "Plate", Price: points(t, "100")},
+			},
+			status:  StatusProcessed,
+			accrual: "55",
+		},
+		{
+			name:   "nothing matched",
+			goods:  []Good{{Description: "Plate", Price: points(t, "100")}},
+			status: StatusInvalid,
+		},
+		{
+			name:   "no goods",
+			status: StatusInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{RegisteredDelayMs: 0, ProcessingDelayMs: 0}
+			sim := newTestSimulator(t, cfg, rules...)
+
+			now := time.Now()
+			if err := sim.RegisterOrder(Order{Number: orderA, Goods: tt.goods}, now); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := sim.Accrual(orderA, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Status != tt.status {
+				t.Fatalf("expected status %s, got %s", tt.status, res.Status)
+			}
+
+			if tt.accrual == "" {
+				if res.Accrual != nil {
+					t.Errorf("expected accrual to be omitted, got %s", res.Accrual)
+				}
+				return
+			}
+
+			if res.Accrual == nil || *res.Accrual != points(t, tt.accrual) {
+				t.Errorf("expected accrual %s, got %v", tt.accrual, res.Accrual)
+			}
+		})
+	}
+}
+
+func TestSimulatorStatusProgression(t *testing.T) {
+	cfg := &Config{RegisteredDelayMs: 100, ProcessingDelayMs: 200}
+	sim := newTestSimulator(t, cfg, RewardRule{Match: "Mug", Reward: points(t, "5"), RewardType: RewardPoints})
+
+	start := time.Now()
+	if err := sim.RegisterOrder(Order{Number: orderA, Goods: []Good{{Description: "Mug"}}}, start); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		status  string
+	}{
+		{elapsed: 0, status: StatusRegistered},
+		{elapsed: time.Millisecond * 99, status: StatusRegistered},
+		{elapsed: time.Millisecond * 100, status: StatusProcessing},
+		{elapsed: time.Millisecond * 299, status: StatusProcessing},
+		{elapsed: time.Millisecond * 300, status: StatusProcessed},
+		{elapsed: time.Hour, status: StatusProcessed},
+	}
+
+	for _, tt := range tests {
+		res, err := sim.Accrual(orderA, start.Add(tt.elapsed))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Status != tt.status {
+			t.Errorf("after %s: expected status %s, got %s", tt.elapsed, tt.status, res.Status)
+		}
+		if tt.status != StatusProcessed && res.Accrual != nil {
+			t.Errorf("after %s: expected no accrual before processed, got %s", tt.elapsed, res.Accrual)
+		}
+	}
+
+	// final accrual doesn't change with the rules registered later
+	if err := sim.AddRule(RewardRule{Match: "M", Reward: points(t, "50"), RewardType: RewardPoints}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := sim.Accrual(orderA, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Accrual == nil || *res.Accrual != points(t, "5") {
+		t.Errorf("expected final accrual to stay 5, got %v", res.Accrual)
+	}
+}
+
+func TestSimulatorRateLimit(t *testing.T) {
+	sim := New(&Config{RateLimit: 2})
+
+	start := time.Now()
+
+	tests := []struct {
+		at    time.Duration
+		fault Fault
+	}{
+		{at: 0, fault: FaultNone},
+		{at: time.Second, fault: FaultNone},
+		{at: time.Second * 2, fault: FaultTooManyRequests},
+		{at: time.Second * 59, fault: FaultTooManyRequests},
+		// new window begins
+		{at: time.Minute, fault: FaultNone},
+		{at: time.Minute + time.Second, fault: FaultNone},
+		{at: time.Minute + time.Second*2, fault: FaultTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		if fault := sim.Fault(start.Add(tt.at)); fault != tt.fault {
+			t.Errorf("at %s: expected fault %d, got %d", tt.at, tt.fault, fault)
+		}
+	}
+}
+
+func TestServerGetOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		number     model.OrderNumber
+		code       int
+		retryAfter string
+		status     string
+	}{
+		{
+			name:   "registered order",
+			number: orderA,
+			code:   http.StatusOK,
+			status: StatusProcessed,
+		},
+		{
+			name:   "unknown order",
+			number: orderB,
+			code:   http.StatusNoContent,
+		},
+		{
+			name:   "unknown order auto registered",
+			cfg:    Config{AutoRegister: true, AutoAccrual: 100},
+			number: orderB,
+			code:   http.StatusOK,
+			status: StatusProcessed,
+		},
+		{
+			name:   "invalid number isn't auto registered",
+			cfg:    Config{AutoRegister: true, AutoAccrual: 100},
+			number: "12345678900",
+			code:   http.StatusNoContent,
+		},
+		{
+			name:       "rate limited",
+			cfg:        Config{RateLimit: 1, RetryAfterSec: 30},
+			number:     orderA,
+			code:       http.StatusTooManyRequests,
+			retryAfter: "30",
+		},
+		{
+			name:   "injected no content",
+			cfg:    Config{NoContentRate: 1},
+			number: orderA,
+			code:   http.StatusNoContent,
+		},
+		{
+			name:   "injected error",
+			cfg:    Config{ErrorRate: 1},
+			number: orderA,
+			code:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.GinMode = gin.TestMode
+			cfg.Seed = 1
+
+			sim := newTestSimulator(t, &cfg, RewardRule{Match: "Mug", Reward: points(t, "5"), RewardType: RewardPoints})
+			if err := sim.RegisterOrder(Order{Number: orderA, Goods: []Good{{Description: "Mug"}}}, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			srv := NewServer(&cfg, sim)
+
+			get := func() *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+string(tt.number), nil))
+				return w
+			}
+
+			w := get()
+			if cfg.RateLimit > 0 {
+				// the first request fits the limit
+				w = get()
+			}
+
+			if w.Code != tt.code {
+				t.Fatalf("expected code %d, got %d", tt.code, w.Code)
+			}
+
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != tt.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.retryAfter, retryAfter)
+			}
+
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var res OrderAccrual
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Number != tt.number || res.Status != tt.status {
+				t.Errorf("expected order %s with status %s, got %+v", tt.number, tt.status, res)
+			}
+		})
+	}
+}
@@ -5,21 +5,87 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/caarlos0/env/v10"
 )
 
 // Config is a struct to setup the service with.
 type Config struct {
 	RunAddress           string `env:"RUN_ADDRESS"`            // flag: -a
-	DatabaseDSN          string `env:"DATABASE_URI"`           // flag: -d
+	DatabaseDSN          string `env:"DATABASE_URI"`           // flag: -d (empty or memory:// for in-memory storage)
 	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"` // flag: -r
 	GinMode              string `env:"GIN_MODE"`               // flag: --gin_mode
 	AuthSecretKey        string `env:"SECRET"`                 // flag: -s
-	AuthTokenLifetimeSec int64  `env:"TOKEN_LIFETIME"`         // flag: --token_lifetime
+	AuthTokenLifetimeSec int64  `env:"TOKEN_LIFETIME"`         // flag: --token_lifetime (access token)
+	RefreshLifetimeSec   int64  `env:"REFRESH_TOKEN_LIFETIME"` // flag: --refresh_token_lifetime
+	AdminLogin           string `env:"ADMIN_LOGIN"`            // flag: --admin_login (user granted admin role on start while there is no admin)
+	AuthAlg              string `env:"AUTH_ALG"`               // flag: --auth_alg (HS256, RS256 or EdDSA)
+	KeyRotationSec       int64  `env:"KEY_ROTATION"`           // flag: --key_rotation (0 - never)
+	KeyGraceSec          int64  `env:"KEY_GRACE"`              // flag: --key_grace (old key is still accepted)
+	PasswordHasher       string `env:"PASSWORD_HASHER"`        // flag: --password_hasher (argon2id or bcrypt)
 	LogLevel             string `env:"LOG_LVL"`                // flag: --log_lvl
 	VerboseMigrateLogger bool   `env:"VERBOSE_MIGRATE_LOGGER"` // flag: --verbose_migrate_logger
+	ShutdownTimeoutSec   int64  `env:"SHUTDOWN_TIMEOUT"`       // flag: --shutdown_timeout
+	AccrualRateLimit     int    `env:"ACCRUAL_RATE_LIMIT"`     // flag: --accrual_rate_limit (requests per minute, 0 - unlimited)
+
+	// manual balance adjustments larger than threshold (in whole points) wait
+	// for another admin's approval, 0 - approval is never required
+	AdjustmentApprovalThreshold int64 `env:"ADJUSTMENT_APPROVAL_THRESHOLD"` // flag: --adjustment_approval_threshold
+
+	// points expiration, 0 months - points never expire
+	PointsExpiryMonths      int   `env:"POINTS_EXPIRY_MONTHS"`      // flag: --points_expiry_months (since accrual)
+	PointsExpiringSoonDays  int   `env:"POINTS_EXPIRING_SOON_DAYS"` // flag: --points_expiring_soon_days (shown on balance)
+	PointsExpiryIntervalSec int64 `env:"POINTS_EXPIRY_INTERVAL"`    // flag: --points_expiry_interval (how often expired points are looked for)
+
+	// loyalty tiers, empty list disables them
+	Tiers          string `env:"TIERS"`            // flag: --tiers (name:threshold:multiplier,... e.g. bronze:0:1,silver:1000:1.05)
+	TierBasis      string `env:"TIER_BASIS"`       // flag: --tier_basis (accrued or spent)
+	TierWindowDays int    `env:"TIER_WINDOW_DAYS"` // flag: --tier_window_days (rolling sum window)
+
+	// referral program, zero rewards disable it
+	ReferrerReward         int64 `env:"REFERRER_REWARD"`           // flag: --referrer_reward (whole points)
+	RefereeReward          int64 `env:"REFEREE_REWARD"`            // flag: --referee_reward (whole points)
+	ReferralMaxPerReferrer int   `env:"REFERRAL_MAX_PER_REFERRER"` // flag: --referral_max_per_referrer (0 - unlimited)
+	ReferralRejectSharedIP bool  `env:"REFERRAL_REJECT_SHARED_IP"` // flag: --referral_reject_shared_ip
+
+	// two-phase withdrawals, held points are released when not captured in time
+	HoldTTLSec             int64 `env:"HOLD_TTL"`              // flag: --hold_ttl
+	HoldReleaseIntervalSec int64 `env:"HOLD_RELEASE_INTERVAL"` // flag: --hold_release_interval (how often expired holds are looked for)
+
+	// points transfers between users, limits are per sender within the last
+	// 24 hours, 0 - unlimited
+	TransferDailyLimit int64 `env:"TRANSFER_DAILY_LIMIT"` // flag: --transfer_daily_limit (whole points)
+	TransferDailyCount int   `env:"TRANSFER_DAILY_COUNT"` // flag: --transfer_daily_count
+
+	// cancelled orders, what to do with their points user has already spent
+	ClawbackPolicy string `env:"CLAWBACK_POLICY"` // flag: --clawback_policy (debt or partial)
+
+	// accrual service circuit breaker
+	BreakerFailureThreshold int   `env:"BREAKER_FAILURES"`     // flag: --breaker_failures
+	BreakerOpenTimeoutSec   int64 `env:"BREAKER_OPEN_TIMEOUT"` // flag: --breaker_open_timeout
+	BreakerSuccessThreshold int   `env:"BREAKER_SUCCESSES"`    // flag: --breaker_successes
+
+	// new passwords policy
+	PasswordMinLength   int  `env:"PASSWORD_MIN_LENGTH"`   // flag: --password_min_length
+	PasswordMinClasses  int  `env:"PASSWORD_MIN_CLASSES"`  // flag: --password_min_classes (of lowercase, uppercase, digits, other)
+	PasswordRejectLogin bool `env:"PASSWORD_REJECT_LOGIN"` // flag: --password_reject_login
+
+	// login brute-force protection, 0 max failures disables the limit
+	LoginWindowSec     int64 `env:"LOGIN_WINDOW"`          // flag: --login_window
+	LoginMaxFailures   int64 `env:"LOGIN_MAX_FAILURES"`    // flag: --login_max_failures (per login)
+	LoginIPMaxFailures int64 `env:"LOGIN_IP_MAX_FAILURES"` // flag: --login_ip_max_failures (per client ip)
+	LoginLockoutSec    int64 `env:"LOGIN_LOCKOUT"`         // flag: --login_lockout (doubles on every successive lockout)
+	LoginMaxLockoutSec int64 `env:"LOGIN_LOCKOUT_MAX"`     // flag: --login_lockout_max
+
+	// password hashers cost parameters, stored hashes with weaker ones are
+	// upgraded on login
+	Argon2Memory      int64 `env:"ARGON2_MEMORY"`      // flag: --argon2_memory (KiB)
+	Argon2Iterations  int64 `env:"ARGON2_ITERATIONS"`  // flag: --argon2_iterations
+	Argon2Parallelism int64 `env:"ARGON2_PARALLELISM"` // flag: --argon2_parallelism
+	BcryptCost        int   `env:"BCRYPT_COST"`        // flag: --bcrypt_cost
 }
 
 // New creates config with default values set
@@ -27,21 +93,99 @@ func New() *Config {
 	return &Config{
 		RunAddress:           "localhost:8080",
 		LogLevel:             "info",
-		AuthTokenLifetimeSec: 3600, // 1h
+		AuthTokenLifetimeSec: 900,     // 15m
+		RefreshLifetimeSec:   2592000, // 30d
+		AuthAlg:              "HS256",
+		KeyRotationSec:       604800, // 7d
+		KeyGraceSec:          3600,   // 1h
+		PasswordHasher:       "argon2id",
 		VerboseMigrateLogger: true,
+		ShutdownTimeoutSec:   10,
+
+		BreakerFailureThreshold: 5,
+		BreakerOpenTimeoutSec:   10,
+		BreakerSuccessThreshold: 1,
+
+		PasswordMinLength: 1,
+
+		PointsExpiringSoonDays:  30,
+		PointsExpiryIntervalSec: 3600,
+
+		TierBasis:      model.TierBasisAccrued,
+		TierWindowDays: 365,
+
+		ReferralMaxPerReferrer: 50,
+
+		HoldTTLSec:             900, // 15m
+		HoldReleaseIntervalSec: 60,
+
+		TransferDailyLimit: 10000,
+		TransferDailyCount: 10,
+
+		ClawbackPolicy: model.ClawbackDebt,
+
+		LoginWindowSec:     900, // 15m
+		LoginMaxFailures:   5,
+		LoginIPMaxFailures: 20,
+		LoginLockoutSec:    60,
+		LoginMaxLockoutSec: 3600,
+
+		Argon2Memory:      19456, // 19MiB
+		Argon2Iterations:  2,
+		Argon2Parallelism: 1,
+		BcryptCost:        10,
 	}
 }
 
 // parseFlags defines and parses command-line flags.
 func (cfg *Config) parseFlags() {
 	flag.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "TCP address for the server to listen on")
-	flag.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "data source name to connect to database")
+	flag.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "data source name to connect to database (empty or memory:// for in-memory storage)")
 	flag.StringVar(&cfg.AccrualSystemAddress, "r", cfg.AccrualSystemAddress, "bonuses calculator service address")
 	flag.StringVar(&cfg.GinMode, "gin_mode", cfg.GinMode, "gin mode")
 	flag.StringVar(&cfg.AuthSecretKey, "s", cfg.AuthSecretKey, "secret key")
-	flag.Int64Var(&cfg.AuthTokenLifetimeSec, "token_lifetime", cfg.AuthTokenLifetimeSec, "auth token lifetime in seconds")
+	flag.Int64Var(&cfg.AuthTokenLifetimeSec, "token_lifetime", cfg.AuthTokenLifetimeSec, "access token lifetime in seconds")
+	flag.Int64Var(&cfg.RefreshLifetimeSec, "refresh_token_lifetime", cfg.RefreshLifetimeSec, "refresh token (session) lifetime in seconds since the last refresh")
+	flag.StringVar(&cfg.AdminLogin, "admin_login", cfg.AdminLogin, "login of registered user to be granted admin role on start while there is no admin yet")
+	flag.StringVar(&cfg.AuthAlg, "auth_alg", cfg.AuthAlg, "auth tokens signing algorithm: HS256, RS256 or EdDSA")
+	flag.Int64Var(&cfg.KeyRotationSec, "key_rotation", cfg.KeyRotationSec, "signing key rotation period in seconds (0 - never)")
+	flag.Int64Var(&cfg.KeyGraceSec, "key_grace", cfg.KeyGraceSec, "seconds rotated signing key is still accepted for tokens validation")
+	flag.IntVar(&cfg.PasswordMinLength, "password_min_length", cfg.PasswordMinLength, "min new password length in characters")
+	flag.IntVar(&cfg.PasswordMinClasses, "password_min_classes", cfg.PasswordMinClasses, "min number of character classes (lowercase, uppercase, digits, other) new password must contain")
+	flag.BoolVar(&cfg.PasswordRejectLogin, "password_reject_login", cfg.PasswordRejectLogin, "reject new passwords containing login")
+	flag.Int64Var(&cfg.LoginWindowSec, "login_window", cfg.LoginWindowSec, "seconds failed login attempts are counted within")
+	flag.Int64Var(&cfg.LoginMaxFailures, "login_max_failures", cfg.LoginMaxFailures, "failed attempts per login within the window before lockout (0 - unlimited)")
+	flag.Int64Var(&cfg.LoginIPMaxFailures, "login_ip_max_failures", cfg.LoginIPMaxFailures, "failed attempts per client ip within the window before lockout (0 - unlimited)")
+	flag.Int64Var(&cfg.LoginLockoutSec, "login_lockout", cfg.LoginLockoutSec, "first lockout duration in seconds, doubles on every successive one")
+	flag.Int64Var(&cfg.LoginMaxLockoutSec, "login_lockout_max", cfg.LoginMaxLockoutSec, "max lockout duration in seconds")
+	flag.StringVar(&cfg.PasswordHasher, "password_hasher", cfg.PasswordHasher, "passwords hashing algorithm: argon2id or bcrypt")
+	flag.Int64Var(&cfg.Argon2Memory, "argon2_memory", cfg.Argon2Memory, "argon2id memory cost in KiB")
+	flag.Int64Var(&cfg.Argon2Iterations, "argon2_iterations", cfg.Argon2Iterations, "argon2id iterations")
+	flag.Int64Var(&cfg.Argon2Parallelism, "argon2_parallelism", cfg.Argon2Parallelism, "argon2id parallelism")
+	flag.IntVar(&cfg.BcryptCost, "bcrypt_cost", cfg.BcryptCost, "bcrypt cost")
 	flag.StringVar(&cfg.LogLevel, "log_lvl", cfg.LogLevel, "logger level")
 	flag.BoolVar(&cfg.VerboseMigrateLogger, "verbose_migrate_logger", cfg.VerboseMigrateLogger, "verbose logging on migration run")
+	flag.Int64Var(&cfg.ShutdownTimeoutSec, "shutdown_timeout", cfg.ShutdownTimeoutSec, "graceful shutdown deadline in seconds")
+	flag.IntVar(&cfg.AccrualRateLimit, "accrual_rate_limit", cfg.AccrualRateLimit, "max requests per minute to accrual system (0 - unlimited until accrual system tells its limit)")
+	flag.Int64Var(&cfg.AdjustmentApprovalThreshold, "adjustment_approval_threshold", cfg.AdjustmentApprovalThreshold, "manual adjustments larger than this points amount require another admin's approval (0 - never)")
+	flag.IntVar(&cfg.PointsExpiryMonths, "points_expiry_months", cfg.PointsExpiryMonths, "months accrued points live for (0 - never expire)")
+	flag.IntVar(&cfg.PointsExpiringSoonDays, "points_expiring_soon_days", cfg.PointsExpiringSoonDays, "points expiring within this number of days are shown on balance")
+	flag.Int64Var(&cfg.PointsExpiryIntervalSec, "points_expiry_interval", cfg.PointsExpiryIntervalSec, "seconds between expired points lookups")
+	flag.StringVar(&cfg.Tiers, "tiers", cfg.Tiers, "loyalty tiers as name:threshold:multiplier comma separated list, e.g. bronze:0:1,silver:1000:1.05 (empty - no tiers)")
+	flag.StringVar(&cfg.TierBasis, "tier_basis", cfg.TierBasis, "points sum tier is computed from: accrued or spent")
+	flag.IntVar(&cfg.TierWindowDays, "tier_window_days", cfg.TierWindowDays, "days of rolling window tier points are summed within")
+	flag.Int64Var(&cfg.ReferrerReward, "referrer_reward", cfg.ReferrerReward, "points credited to referrer when referee's first order is processed (0 with zero referee reward disables referral program)")
+	flag.Int64Var(&cfg.RefereeReward, "referee_reward", cfg.RefereeReward, "points credited to referee for the first processed order")
+	flag.IntVar(&cfg.ReferralMaxPerReferrer, "referral_max_per_referrer", cfg.ReferralMaxPerReferrer, "max rewarded referrals per referrer (0 - unlimited)")
+	flag.BoolVar(&cfg.ReferralRejectSharedIP, "referral_reject_shared_ip", cfg.ReferralRejectSharedIP, "reject referees registered from the address another referee of the same referrer registered from")
+	flag.Int64Var(&cfg.HoldTTLSec, "hold_ttl", cfg.HoldTTLSec, "seconds withdrawal hold lives for until it's captured or voided")
+	flag.Int64Var(&cfg.HoldReleaseIntervalSec, "hold_release_interval", cfg.HoldReleaseIntervalSec, "seconds between expired withdrawal holds lookups")
+	flag.Int64Var(&cfg.TransferDailyLimit, "transfer_daily_limit", cfg.TransferDailyLimit, "max points user can transfer to others within 24 hours (0 - unlimited)")
+	flag.IntVar(&cfg.TransferDailyCount, "transfer_daily_count", cfg.TransferDailyCount, "max transfers user can send within 24 hours (0 - unlimited)")
+	flag.StringVar(&cfg.ClawbackPolicy, "clawback_policy", cfg.ClawbackPolicy, "cancelled order's points user has already spent: debt (paid off by future accruals) or partial (written off)")
+	flag.IntVar(&cfg.BreakerFailureThreshold, "breaker_failures", cfg.BreakerFailureThreshold, "consecutive accrual system failures to open circuit breaker")
+	flag.Int64Var(&cfg.BreakerOpenTimeoutSec, "breaker_open_timeout", cfg.BreakerOpenTimeoutSec, "seconds circuit breaker stays open before probing accrual system")
+	flag.IntVar(&cfg.BreakerSuccessThreshold, "breaker_successes", cfg.BreakerSuccessThreshold, "consecutive successful probes to close circuit breaker")
 
 	flag.Parse()
 }
@@ -66,6 +210,92 @@ func (cfg *Config) Validate() error {
 		return validationError("accrual system address is required")
 	}
 
+	if cfg.AccrualRateLimit < 0 {
+		return validationError("accrual rate limit can't be negative")
+	}
+
+	if cfg.PointsExpiryMonths < 0 || cfg.PointsExpiringSoonDays < 0 || cfg.PointsExpiryIntervalSec < 1 {
+		return validationError("points expiry months and expiring soon days can't be negative, interval must be positive")
+	}
+
+	if _, err := model.ParseTiers(cfg.Tiers); err != nil {
+		return validationError(err.Error())
+	}
+
+	if cfg.TierBasis != model.TierBasisAccrued && cfg.TierBasis != model.TierBasisSpent {
+		return validationError("tier basis must be one of accrued, spent")
+	}
+
+	if cfg.TierWindowDays < 1 {
+		return validationError("tier window must be positive")
+	}
+
+	if cfg.ReferrerReward < 0 || cfg.RefereeReward < 0 || cfg.ReferralMaxPerReferrer < 0 {
+		return validationError("referral rewards and max referrals per referrer can't be negative")
+	}
+
+	if cfg.HoldTTLSec < 1 || cfg.HoldReleaseIntervalSec < 1 {
+		return validationError("hold ttl and release interval must be positive")
+	}
+
+	if cfg.TransferDailyLimit < 0 || cfg.TransferDailyCount < 0 {
+		return validationError("transfer daily limits can't be negative")
+	}
+
+	if cfg.ClawbackPolicy != model.ClawbackDebt && cfg.ClawbackPolicy != model.ClawbackPartial {
+		return validationError("clawback policy must be one of debt, partial")
+	}
+
+	if cfg.AdjustmentApprovalThreshold < 0 {
+		return validationError("adjustment approval threshold can't be negative")
+	}
+
+	switch cfg.AuthAlg {
+	case "HS256", "RS256", "EdDSA":
+	default:
+		return validationError("auth alg must be one of HS256, RS256, EdDSA")
+	}
+
+	if cfg.KeyRotationSec < 0 || cfg.KeyGraceSec < 0 {
+		return validationError("key rotation and grace period can't be negative")
+	}
+
+	if cfg.PasswordMinLength < 1 || cfg.PasswordMinClasses < 0 || cfg.PasswordMinClasses > 4 {
+		return validationError("password min length must be positive and min classes within 0..4")
+	}
+
+	if cfg.LoginWindowSec < 0 || cfg.LoginMaxFailures < 0 || cfg.LoginIPMaxFailures < 0 ||
+		cfg.LoginLockoutSec < 0 || cfg.LoginMaxLockoutSec < 0 {
+		return validationError("login attempts limits can't be negative")
+	}
+
+	switch cfg.PasswordHasher {
+	case "argon2id":
+		if cfg.Argon2Iterations < 1 || cfg.Argon2Iterations > math.MaxUint32 {
+			return validationError("argon2id iterations must be within 1..4294967295")
+		}
+
+		if cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > math.MaxUint8 {
+			return validationError("argon2id parallelism must be within 1..255")
+		}
+
+		// argon2 requires at least 8KiB per thread
+		if cfg.Argon2Memory < 8*cfg.Argon2Parallelism || cfg.Argon2Memory > math.MaxUint32 {
+			return validationError("argon2id memory must be within 8*parallelism..4294967295 KiB")
+		}
+	case "bcrypt":
+		if cfg.BcryptCost < 4 || cfg.BcryptCost > 31 {
+			return validationError("bcrypt cost must be within 4..31")
+		}
+	default:
+		return validationError("password hasher must be one of argon2id, bcrypt")
+	}
+
+	// tokens signed right before rotation must stay valid till they expire
+	if cfg.KeyRotationSec > 0 && cfg.KeyGraceSec < cfg.AuthTokenLifetimeSec {
+		return validationError("key grace period can't be less than access token lifetime")
+	}
+
 	// XXX: Might move such checks to proper services initialization funcs
 	// instead of making config package to be responsible of it as it is now.
 	if strings.TrimSpace(cfg.AuthSecretKey) == "" {
@@ -0,0 +1,53 @@
+package config
+
+import "testing"
+
+func TestValidateArgon2Params(t *testing.T) {
+	tests := []struct {
+		name        string
+		memory      int64
+		iterations  int64
+		parallelism int64
+		wantErr     bool
+	}{
+		{name: "defaults", memory: 19456, iterations: 2, parallelism: 1},
+		{name: "min memory per thread", memory: 32, iterations: 1, parallelism: 4},
+		{name: "zero iterations", memory: 19456, iterations: 0, parallelism: 1, wantErr: true},
+		{name: "negative iterations", memory: 19456, iterations: -1, parallelism: 1, wantErr: true},
+		{name: "too many iterations", memory: 19456, iterations: 1 << 32, parallelism: 1, wantErr: true},
+		{name: "zero parallelism", memory: 19456, iterations: 2, parallelism: 0, wantErr: true},
+		{name: "too many threads", memory: 1 << 20, iterations: 2, parallelism: 256, wantErr: true},
+		{name: "zero memory", memory: 0, iterations: 2, parallelism: 1, wantErr: true},
+		{name: "negative memory", memory: -19456, iterations: 2, parallelism: 1, wantErr: true},
+		{name: "memory less than 8KiB per thread", memory: 31, iterations: 2, parallelism: 4, wantErr: true},
+		{name: "too much memory", memory: 1 << 32, iterations: 2, parallelism: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := New()
+			cfg.AccrualSystemAddress = "http://localhost:8081"
+			cfg.AuthSecretKey = "secret"
+			cfg.Argon2Memory = tt.memory
+			cfg.Argon2Iterations = tt.iterations
+			cfg.Argon2Parallelism = tt.parallelism
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateArgon2ParamsIgnoredForBcrypt(t *testing.T) {
+	cfg := New()
+	cfg.AccrualSystemAddress = "http://localhost:8081"
+	cfg.AuthSecretKey = "secret"
+	cfg.PasswordHasher = "bcrypt"
+	cfg.Argon2Iterations = 0
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package model
+
+import "github.com/google/uuid"
+
+// AdjustmentReason tells why balance was corrected manually.
+type AdjustmentReason string
+
+const (
+	ReasonGoodwill     AdjustmentReason = "goodwill"     // gesture of goodwill
+	ReasonCompensation AdjustmentReason = "compensation" // service failure compensation
+	ReasonCorrection   AdjustmentReason = "correction"   // fixing erroneous accrual or withdrawal
+	ReasonFraud        AdjustmentReason = "fraud"        // taking back fraudulently obtained points
+	ReasonOther        AdjustmentReason = "other"        // comment must explain it
+)
+
+// Valid reports if reason is a known one.
+func (r AdjustmentReason) Valid() bool {
+	switch r {
+	case ReasonGoodwill, ReasonCompensation, ReasonCorrection, ReasonFraud, ReasonOther:
+		return true
+	default:
+		return false
+	}
+}
+
+// AdjustmentStatus is a state of the adjustment. Large adjustments are
+// pending until another admin approves or rejects them.
+type AdjustmentStatus string
+
+const (
+	AdjustmentPending  AdjustmentStatus = "pending"
+	AdjustmentApplied  AdjustmentStatus = "applied"
+	AdjustmentRejected AdjustmentStatus = "rejected"
+)
+
+// Adjustment is a manual credit or debit of user's balance made by support
+// staff.
+type Adjustment struct {
+	ID         uuid.UUID        `json:"id"`
+	UserID     int64            `json:"user_id"`
+	Amount     Points           `json:"amount"` // positive amount credits balance, negative debits
+	Reason     AdjustmentReason `json:"reason"`
+	Comment    string           `json:"comment"`
+	OperatorID int64            `json:"operator_id"`           // who made the adjustment
+	ApproverID int64            `json:"approver_id,omitempty"` // who approved or rejected it
+	Status     AdjustmentStatus `json:"status"`
+	CreatedAt  string           `json:"created_at"`
+	DecidedAt  string           `json:"decided_at,omitempty"`
+}
+
+// AdjustmentsFilter selects adjustments. Zero fields match everything.
+type AdjustmentsFilter struct {
+	UserID int64
+	Status AdjustmentStatus
+	Limit  int // 0 - no limit
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Campaign is a marketing promotion, e.g. "double points this weekend" or
+// "+100 points on your first order". It credits bonus points for eligible
+// orders processed within its time window until its budget runs out.
+type Campaign struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"` // exclusive
+
+	// reward, both can be combined
+	Points     Points `json:"points"`     // fixed bonus for the order
+	Multiplier Points `json:"multiplier"` // order's accrual multiplier, 2 doubles points, 0 - none
+
+	// eligibility rules, zero rules match any order
+	FirstOrder  bool     `json:"first_order"`  // user's first processed order only
+	Tiers       []string `json:"tiers"`        // user must be in one of the tiers
+	OrderPrefix string   `json:"order_prefix"` // order number must start with it
+
+	// stacking rules
+	Priority  int  `json:"priority"`  // campaigns with higher priority are applied first
+	Exclusive bool `json:"exclusive"` // is never combined with other campaigns
+
+	Budget    Points    `json:"budget"` // total bonus points to be credited, 0 - unlimited
+	Spent     Points    `json:"spent"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks if campaign's settings are consistent.
+func (c Campaign) Validate() error {
+	switch {
+	case strings.TrimSpace(c.Name) == "":
+		return errors.New("campaign name is required")
+	case c.StartsAt.IsZero() || !c.EndsAt.After(c.StartsAt):
+		return errors.New("campaign must end after it starts")
+	case c.Points < 0 || (c.Multiplier != 0 && c.Multiplier <= PointsScale):
+		return errors.New("campaign points can't be negative and multiplier must be greater than 1")
+	case c.Points == 0 && c.Multiplier == 0:
+		return errors.New("campaign must reward either points or multiplier")
+	case c.Budget < 0:
+		return errors.New("campaign budget can't be negative")
+	case strings.Trim(c.OrderPrefix, "0123456789") != "":
+		return ErrOrderNumberBadChars
+	case slices.Contains(c.Tiers, ""):
+		return errors.New("campaign tier name can't be empty")
+	}
+
+	return nil
+}
+
+// Running reports if campaign can credit bonuses at the moment now.
+func (c Campaign) Running(now time.Time) bool {
+	if c.Disabled || now.Before(c.StartsAt) || !now.Before(c.EndsAt) {
+		return false
+	}
+
+	return c.Budget == 0 || c.Spent < c.Budget
+}
+
+// Eligible reports if the order matches campaign's rules. User's tier and
+// whether it's the first processed order of the user must be provided.
+func (c Campaign) Eligible(order Order, tier string, first bool) bool {
+	if c.FirstOrder && !first {
+		return false
+	}
+
+	if len(c.Tiers) > 0 && !slices.Contains(c.Tiers, tier) {
+		return false
+	}
+
+	return strings.HasPrefix(string(order.ID), c.OrderPrefix)
+}
+
+// Bonus returns points credited for the order on top of its accrual.
+func (c Campaign) Bonus(accrual Points) Points {
+	bonus := c.Points
+	if c.Multiplier > 0 {
+		bonus += accrual.MulRatio(int64(c.Multiplier), PointsScale) - accrual
+	}
+
+	return bonus
+}
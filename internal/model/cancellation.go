@@ -0,0 +1,34 @@
+package model
+
+import "github.com/google/uuid"
+
+// Order statuses the loyalty system relies on itself, the rest of them come
+// from accrual system as is.
+const (
+	OrderProcessed = "PROCESSED"
+	OrderCancelled = "CANCELLED" // merchant cancelled processed order, its points were taken back
+)
+
+// Clawback policies tell what to do with points of cancelled order which
+// user has already spent.
+const (
+	ClawbackDebt    = "debt"    // the rest becomes user's debt paid off by future accruals
+	ClawbackPartial = "partial" // only available points are taken back, the rest is written off
+)
+
+// Cancellation takes points credited for the processed order (accrual and
+// bonuses) back from user's balance. Owed points exceeding available ones
+// are either left as debt or written off according to clawback policy.
+// Referrer's reward for the order is taken back by the same policy, and
+// campaigns' bonuses are returned to their budgets.
+type Cancellation struct {
+	ID         uuid.UUID   `json:"id"`
+	Order      OrderNumber `json:"order"`
+	UserID     int64       `json:"user_id"`
+	Owed       Points      `json:"owed"`   // credited for the order
+	Clawed     Points      `json:"clawed"` // taken from balance right away
+	Debt       Points      `json:"debt"`   // left to be paid off by future accruals
+	Comment    string      `json:"comment,omitempty"`
+	OperatorID int64       `json:"operator_id"` // who cancelled the order
+	CreatedAt  string      `json:"created_at"`
+}
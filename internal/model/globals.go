@@ -4,3 +4,6 @@ import "time"
 
 // Times are required in RFC3339 by specification.
 const LayoutTimestamps = time.RFC3339
+
+// LayoutDate is used where time of the day doesn't matter.
+const LayoutDate = time.DateOnly
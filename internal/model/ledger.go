@@ -0,0 +1,61 @@
+package model
+
+import "github.com/google/uuid"
+
+// Ledger entry kinds.
+const (
+	LedgerAccrual    = "accrual"    // points earned for the order
+	LedgerBonus      = "bonus"      // extra points for the order
+	LedgerWithdrawal = "withdrawal" // points spent on the new order
+	LedgerAdjustment = "adjustment" // manual balance correction
+	LedgerReversal   = "reversal"   // withdrawal was (partially) returned back
+	LedgerExpiry     = "expiry"     // points weren't spent in time
+	LedgerReferral   = "referral"   // reward for inviting another user
+	LedgerTransfer   = "transfer"   // points sent from one user to another
+	LedgerClawback   = "clawback"   // points of cancelled order were taken back
+)
+
+// Ledger accounts. Every ledger transaction moves points between user's
+// account and one of system accounts (or another user's account in case of
+// transfer), so postings always sum up to zero.
+const (
+	AccountUser       = "user"       // user's loyalty points balance
+	AccountAccrual    = "accrual"    // points issued for orders by accrual system
+	AccountBonus      = "bonus"      // points issued by loyalty program on top of accruals
+	AccountRedemption = "redemption" // points spent by users
+	AccountAdjustment = "adjustment" // manual corrections
+	AccountExpiry     = "expiry"     // expired points
+)
+
+// LedgerEntry is a single posting to user's account. Statement built from
+// entries explains current balance.
+type LedgerEntry struct {
+	ID           int64      `json:"id"`
+	TxnID        uuid.UUID  `json:"txn_id"`
+	Kind         string     `json:"kind"`
+	Amount       Points     `json:"amount"` // positive amount increases balance
+	Order        string     `json:"order,omitempty"`
+	WithdrawalID *uuid.UUID `json:"withdrawal_id,omitempty"`
+	AdjustmentID *uuid.UUID `json:"adjustment_id,omitempty"`
+	TransferID   *uuid.UUID `json:"transfer_id,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	CreatedAt    string     `json:"created_at"`
+	UserID       int64      `json:"user_id"`
+}
+
+// CounterAccount returns system account which is the other side of user's
+// posting for the entry kind.
+func CounterAccount(kind string) string {
+	switch kind {
+	case LedgerAccrual, LedgerClawback:
+		return AccountAccrual
+	case LedgerBonus, LedgerReferral:
+		return AccountBonus
+	case LedgerWithdrawal, LedgerReversal:
+		return AccountRedemption
+	case LedgerExpiry:
+		return AccountExpiry
+	default:
+		return AccountAdjustment
+	}
+}
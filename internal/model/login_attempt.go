@@ -0,0 +1,45 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// LoginAttempt is a failed login attempt.
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Login     string    `json:"login"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttemptsFilter selects login attempts by login and/or ip. Empty
+// fields match everything.
+type LoginAttemptsFilter struct {
+	Login string
+	IP    string
+	Limit int // 0 - no limit
+}
+
+// Lockout blocks login attempts for a login or from an ip address.
+type Lockout struct {
+	Key         string    `json:"key"`   // see LoginLockoutKey and IPLockoutKey
+	Level       int       `json:"level"` // number of successive lockouts, each one lasts twice longer
+	LockedUntil time.Time `json:"locked_until"`
+	ResetAt     time.Time `json:"reset_at"` // failures before this moment are not counted
+}
+
+// Locked reports if lockout is in effect at the moment now.
+func (l Lockout) Locked(now time.Time) bool {
+	return now.Before(l.LockedUntil)
+}
+
+// LoginLockoutKey returns key of the login's lockout.
+func LoginLockoutKey(login string) string {
+	return "login:" + strings.ToLower(login)
+}
+
+// IPLockoutKey returns key of the ip address lockout.
+func IPLockoutKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// PointsLot is a portion of points credited at once. Debits consume the
+// oldest lots first, so lots tell when the remaining points expire.
+type PointsLot struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Amount    Points    `json:"amount"`    // credited amount
+	Remaining Points    `json:"remaining"` // not yet spent or expired
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExpiresAt returns the moment lot expires when points live for months.
+func (l PointsLot) ExpiresAt(months int) time.Time {
+	return AddMonths(l.CreatedAt, months)
+}
+
+// AddMonths adds months to t. Day of month is clamped to the last day of the
+// resulting month (Jan 31 + 1 month is Feb 28), the same as postgres
+// interval arithmetic does.
+func AddMonths(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+
+	first := time.Date(y, m+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+
+	return first.AddDate(0, 0, min(d, last)-1)
+}
+
+// ExpiringPoints is an amount of points expiring on the date.
+type ExpiringPoints struct {
+	Sum  Points `json:"sum"`
+	Date string `json:"date"` // LayoutDate
+}
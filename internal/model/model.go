@@ -1,8 +1,9 @@
 package model
 
 import (
+	"encoding/json"
 	"regexp"
-	"sync"
+	"time"
 
 	"github.com/ShiraazMoollatjie/goluhn"
 	"github.com/google/uuid"
@@ -11,6 +12,7 @@ import (
 type User struct {
 	ID           int64  `json:"id"`
 	Login        string `json:"login"`
+	Role         Role   `json:"role"`
 	PasswordHash string `json:"-"`
 }
 
@@ -31,80 +33,145 @@ func (num OrderNumber) Validate() (err error) {
 }
 
 type Order struct {
-	ID          OrderNumber `json:"number"`
-	UploadedAt  string      `json:"uploaded_at"`
-	ProcessedAt string      `json:"processed_at,omitempty"`
-	Status      string      `json:"status"`
-	Accrual     float64     `json:"accrual"`
-	UserID      int64       `json:"user_id"` // FIXME: might remove UserID from struct
+	ID          OrderNumber  `json:"number"`
+	UploadedAt  string       `json:"uploaded_at"`
+	ProcessedAt string       `json:"processed_at,omitempty"`
+	Status      string       `json:"status"`
+	Accrual     Points       `json:"accrual"`
+	Bonuses     []OrderBonus `json:"bonuses,omitempty"` // credited on top of the accrual
+	UserID      int64        `json:"user_id"`           // FIXME: might remove UserID from struct
+}
+
+// Order bonus sources.
+const (
+	BonusTier     = "tier"     // user's tier multiplier
+	BonusCampaign = "campaign" // marketing campaign, ref is campaign's id
+	BonusReferral = "referral" // referee's reward, ref is referral code
+)
+
+// OrderBonus is points credited for the order on top of the accrual.
+type OrderBonus struct {
+	Source string `json:"source"`
+	Ref    string `json:"ref"` // source specific reference, e.g. tier name
+	Amount Points `json:"amount"`
 }
 
 type AccrualOrder struct {
 	OrderID OrderNumber `json:"order"`
 	Status  string      `json:"status"`
-	Accrual float64     `json:"accrual"`
+	Accrual Points      `json:"accrual"`
+}
+
+// UnmarshalJSON decodes accrual system's response. Accrual isn't limited to
+// Points precision there, so it's rounded rather than rejected, otherwise
+// such order would be polled forever.
+func (o *AccrualOrder) UnmarshalJSON(data []byte) (err error) {
+	type plain AccrualOrder
+
+	aux := struct {
+		*plain
+		Accrual json.Number `json:"accrual"`
+	}{
+		plain: (*plain)(o),
+	}
+
+	if err = json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	o.Accrual = 0
+	if aux.Accrual != "" {
+		o.Accrual, err = RoundPoints(aux.Accrual.String())
+	}
+
+	return err
 }
 
 // Balance shows current user's loyalty points.
 type Balance struct {
-	UserID         int64   `json:"user_id"`   // FIXME: might remove UserID from struct
-	Balance        float64 `json:"current"`   // current balance of loyalty points
-	TotalWithdrawn float64 `json:"withdrawn"` // total withdrawn points amount
-	Updated        string  `json:"updated"`
+	UserID         int64  `json:"user_id"`   // FIXME: might remove UserID from struct
+	Balance        Points `json:"current"`   // current balance of loyalty points
+	Held           Points `json:"held"`      // part of balance reserved by withdrawal holds
+	Available      Points `json:"available"` // balance which can be spent, i.e. not held
+	TotalWithdrawn Points `json:"withdrawn"` // total withdrawn points amount
+	Debt           Points `json:"debt"`      // owed for cancelled orders, paid off by future accruals
+	Updated        string `json:"updated"`
+
+	// points expiring soon grouped by date, set only when points expire
+	Expiring []ExpiringPoints `json:"expiring,omitempty"`
 }
 
 // Withdrawal is a single withdrawal entry to be shown in history later.
+// Reversals are listed along with the withdrawal they return points of.
+// The rest of balance operations are listed in HistoryEntry only.
 type Withdrawal struct {
-	ID          uuid.UUID `json:"id"`           // withdrawal id
-	Order       string    `json:"order"`        // specs: "гипотетический номер нового заказа пользователя"
-	ProcessedAt string    `json:"processed_at"` // timestamp
-	Value       float64   `json:"sum"`          // withdrawn points amount
-	UserID      int64     `json:"user_id"`      // FIXME: might remove UserID from struct
-}
-
-type OrdersMap struct {
-	orders map[OrderNumber]Order
-	mu     sync.RWMutex
+	ID          uuid.UUID            `json:"id"`                   // withdrawal id
+	Order       string               `json:"order"`                // specs: "гипотетический номер нового заказа пользователя"
+	ProcessedAt string               `json:"processed_at"`         // timestamp
+	Value       Points               `json:"sum"`                  // withdrawn points amount
+	Status      WithdrawalStatus     `json:"status,omitempty"`     // withdrawal's state
+	ExpiresAt   string               `json:"expires_at,omitempty"` // hold's expiration timestamp
+	Reversed    Points               `json:"reversed,omitempty"`   // withdrawal's points returned back by reversals
+	Reversals   []WithdrawalReversal `json:"reversals,omitempty"`  // reversals returning withdrawal's points, earliest first
+	UserID      int64                `json:"user_id"`              // FIXME: might remove UserID from struct
 }
 
-func NewOrdersMap(size int) *OrdersMap {
-	return &OrdersMap{
-		orders: make(map[OrderNumber]Order, size),
-	}
+// WithdrawalReversal is a reversal linked to the withdrawal in withdrawals
+// history.
+type WithdrawalReversal struct {
+	ID           uuid.UUID `json:"id"`
+	WithdrawalID uuid.UUID `json:"withdrawal_id"`
+	Order        string    `json:"order"`        // reversed withdrawal's order
+	Value        Points    `json:"sum"`          // points returned back
+	ProcessedAt  string    `json:"processed_at"` // timestamp
 }
 
-func (m *OrdersMap) Get(id OrderNumber) (order Order, ok bool) {
-	m.mu.RLock()
-	order, ok = m.orders[id]
-	m.mu.RUnlock()
-
-	return
+// HistoryEntry is a single entry of user's balance history. Besides
+// withdrawals history lists their reversals, manual adjustments, transfers
+// and clawbacks of cancelled orders, Kind tells them apart.
+type HistoryEntry struct {
+	ID           uuid.UUID        `json:"id"`                      // withdrawal, reversal, adjustment, transfer or cancellation id
+	Kind         string           `json:"kind"`                    // LedgerWithdrawal, LedgerReversal, LedgerAdjustment, LedgerTransfer or LedgerClawback
+	Order        string           `json:"order,omitempty"`         // withdrawal's, reversed withdrawal's or cancelled order number
+	ProcessedAt  string           `json:"processed_at"`            // timestamp
+	Value        Points           `json:"sum"`                     // spent points amount, negative for credits
+	Reason       AdjustmentReason `json:"reason,omitempty"`        // adjustment's reason
+	Comment      string           `json:"comment,omitempty"`       // adjustment's, reversal's, transfer's or cancellation's comment
+	Counterparty string           `json:"counterparty,omitempty"`  // login of the other transfer party
+	Status       WithdrawalStatus `json:"status,omitempty"`        // withdrawal's state
+	ExpiresAt    string           `json:"expires_at,omitempty"`    // hold's expiration timestamp
+	Reversed     Points           `json:"reversed,omitempty"`      // withdrawal's points returned back by reversals
+	WithdrawalID *uuid.UUID       `json:"withdrawal_id,omitempty"` // withdrawal the reversal returns points of
 }
 
-func (m *OrdersMap) GetAll() (orders []Order) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// WithdrawalStatus is a state of the withdrawal. Withdrawal made in two
+// phases holds points until it's captured, voided or the hold expires.
+// Plain withdrawal is captured right away.
+type WithdrawalStatus string
 
-	if len(m.orders) == 0 {
-		return nil
-	}
-
-	orders = make([]Order, 0, len(m.orders))
-	for _, order := range m.orders {
-		orders = append(orders, order)
-	}
-
-	return orders
-}
+const (
+	WithdrawalHeld     WithdrawalStatus = "held"
+	WithdrawalCaptured WithdrawalStatus = "captured"
+	WithdrawalVoided   WithdrawalStatus = "voided"
+	WithdrawalExpired  WithdrawalStatus = "expired" // released by the job
+)
 
-func (m *OrdersMap) Set(order Order) {
-	m.mu.Lock()
-	m.orders[order.ID] = order
-	m.mu.Unlock()
+// AccrualStatus shows state of the accrual service client.
+type AccrualStatus struct {
+	Breaker     string `json:"breaker"`                // circuit breaker state: closed, open or half-open
+	Failures    int    `json:"failures"`               // consecutive failed calls
+	OpenedAt    string `json:"opened_at,omitempty"`    // last time breaker was opened
+	RateLimit   int    `json:"rate_limit"`             // requests per minute, 0 - unlimited
+	PausedUntil string `json:"paused_until,omitempty"` // set while calls are paused after 429
 }
 
-func (m *OrdersMap) Delete(id OrderNumber) {
-	m.mu.Lock()
-	delete(m.orders, id)
-	m.mu.Unlock()
+// AccrualJob is an order queued to be polled from accrual service.
+type AccrualJob struct {
+	OrderID     OrderNumber
+	UserID      int64
+	Attempts    int       // number of times the job was claimed
+	NextRunAt   time.Time // job can't be claimed before this time
+	LockedBy    string    // id of the worker holding the lease
+	LockedUntil time.Time // lease expiration
+	LastError   string
 }
@@ -0,0 +1,171 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Points is an exact amount of loyalty points. It is a fixed-point decimal
+// with the same precision as numeric(20,4) columns in db, so 1.5 points are
+// stored as 15000. Usual arithmetic and comparison operators can be used.
+type Points int64
+
+// PointsScale is a number of Points units in a single point.
+const PointsScale = 10_000
+
+const pointsDecimals = 4
+
+var (
+	ErrPointsSyntax    = errors.New("invalid points value")
+	ErrPointsPrecision = errors.New("points value has more than 4 decimal places")
+	ErrPointsRange     = errors.New("points value is out of range")
+)
+
+var bigPointsScale = big.NewRat(PointsScale, 1)
+
+// ParsePoints parses decimal number string exactly. Exponent notation is
+// supported as it may appear in json.
+func ParsePoints(s string) (Points, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrPointsSyntax, s)
+	}
+
+	r.Mul(r, bigPointsScale)
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%w: %q", ErrPointsPrecision, s)
+	}
+
+	n := r.Num()
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrPointsRange, s)
+	}
+
+	return Points(n.Int64()), nil
+}
+
+// RoundPoints parses decimal number string the same as ParsePoints, but
+// rounds extra decimal places half away from zero instead of rejecting them.
+// Meant for amounts coming from external systems.
+func RoundPoints(s string) (Points, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrPointsSyntax, s)
+	}
+
+	r.Mul(r, bigPointsScale)
+
+	n := roundRat(r)
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrPointsRange, s)
+	}
+
+	return Points(n.Int64()), nil
+}
+
+// roundRat rounds r to integer half away from zero.
+func roundRat(r *big.Rat) *big.Int {
+	q, m := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if new(big.Int).Mul(m.Abs(m), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		if r.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return q
+}
+
+// PointsFromFloat converts float value rounding it to the nearest Points unit.
+func PointsFromFloat(f float64) Points {
+	return Points(math.Round(f * PointsScale))
+}
+
+// Float64 returns approximate float value. Must only be used where precision
+// doesn't matter (e.g. logs or metrics).
+func (p Points) Float64() float64 {
+	return float64(p) / PointsScale
+}
+
+// String formats points as decimal number without trailing zeros.
+func (p Points) String() string {
+	sign := ""
+	u := uint64(p)
+	if p < 0 {
+		sign = "-"
+		u = uint64(-p)
+	}
+
+	s := sign + strconv.FormatUint(u/PointsScale, 10)
+
+	frac := u % PointsScale
+	if frac == 0 {
+		return s
+	}
+
+	fs := strconv.FormatUint(frac, 10)
+	fs = strings.Repeat("0", pointsDecimals-len(fs)) + fs
+
+	return s + "." + strings.TrimRight(fs, "0")
+}
+
+// MulRatio multiplies points by num/den rounding half away from zero.
+func (p Points) MulRatio(num, den int64) Points {
+	r := new(big.Rat).SetFrac(big.NewInt(int64(p)), big.NewInt(1))
+	r.Mul(r, big.NewRat(num, den))
+
+	return Points(roundRat(r).Int64())
+}
+
+// MarshalJSON emits points as json number.
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON accepts both json numbers and numeric strings.
+func (p *Points) UnmarshalJSON(data []byte) (err error) {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+
+	*p, err = ParsePoints(s)
+
+	return err
+}
+
+// Scan implements sql.Scanner for numeric columns.
+func (p *Points) Scan(src any) (err error) {
+	switch v := src.(type) {
+	case nil:
+		*p = 0
+	case []byte:
+		*p, err = ParsePoints(string(v))
+	case string:
+		*p, err = ParsePoints(v)
+	case int64:
+		*p = Points(v * PointsScale)
+	case float64:
+		*p = PointsFromFloat(v)
+	default:
+		return fmt.Errorf("can't scan %T into Points", src)
+	}
+
+	return err
+}
+
+// Value implements driver.Valuer. Decimal string is used, so no precision is
+// lost on the way to numeric column.
+func (p Points) Value() (driver.Value, error) {
+	return p.String(), nil
+}
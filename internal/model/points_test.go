@@ -0,0 +1,91 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Points
+		err  error
+	}{
+		{in: "0", want: 0},
+		{in: "500", want: 500 * PointsScale},
+		{in: "1.5", want: 15000},
+		{in: "-0.0001", want: -1},
+		{in: "1e2", want: 100 * PointsScale},
+		{in: "500.12345", err: ErrPointsPrecision},
+		{in: "abc", err: ErrPointsSyntax},
+		{in: "1e30", err: ErrPointsRange},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePoints(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ParsePoints(%q) = %v, %v; want %v, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRoundPoints(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Points
+		err  error
+	}{
+		{in: "500", want: 500 * PointsScale},
+		{in: "500.12345", want: 5001235},
+		{in: "500.12344", want: 5001234},
+		{in: "-0.00005", want: -1},
+		{in: "0.00004", want: 0},
+		{in: "abc", err: ErrPointsSyntax},
+		{in: "1e30", err: ErrPointsRange},
+	}
+
+	for _, tt := range tests {
+		got, err := RoundPoints(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("RoundPoints(%q) = %v, %v; want %v, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAccrualOrderUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		body string
+		want AccrualOrder
+	}{
+		{
+			body: `{"order":"12345678903","status":"PROCESSED","accrual":500.12345}`,
+			want: AccrualOrder{OrderID: "12345678903", Status: "PROCESSED", Accrual: 5001235},
+		},
+		{
+			body: `{"order":"12345678903","status":"PROCESSED","accrual":"729.98"}`,
+			want: AccrualOrder{OrderID: "12345678903", Status: "PROCESSED", Accrual: 7299800},
+		},
+		{
+			body: `{"order":"12345678903","status":"PROCESSING"}`,
+			want: AccrualOrder{OrderID: "12345678903", Status: "PROCESSING"},
+		},
+	}
+
+	for _, tt := range tests {
+		var got AccrualOrder
+		if err := json.Unmarshal([]byte(tt.body), &got); err != nil {
+			t.Errorf("unexpected error decoding %s: %v", tt.body, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("decoded %s into %+v; want %+v", tt.body, got, tt.want)
+		}
+	}
+
+	var order AccrualOrder
+	if err := json.Unmarshal([]byte(`{"accrual":"abc"}`), &order); err == nil {
+		t.Errorf("expected error decoding malformed accrual")
+	}
+}
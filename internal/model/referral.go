@@ -0,0 +1,57 @@
+package model
+
+import "errors"
+
+// ErrReferralCode is returned when referral code doesn't exist.
+var ErrReferralCode = errors.New("unknown referral code")
+
+// ReferralStatus is a state of the referral.
+type ReferralStatus string
+
+const (
+	ReferralPending  ReferralStatus = "pending"  // waiting for referee's first processed order
+	ReferralRewarded ReferralStatus = "rewarded" // both parties were rewarded
+	ReferralRejected ReferralStatus = "rejected" // fraud guard tripped, e.g. self-referral
+	ReferralCapped   ReferralStatus = "capped"   // referrer had reached rewards cap
+
+	// referee's first order was cancelled, referrer's reward was taken back
+	ReferralCancelled ReferralStatus = "cancelled"
+)
+
+// ReferralCode is user's personal code others register with.
+type ReferralCode struct {
+	Code   string
+	UserID int64
+	IP     string // address code was issued to, referees from it are rejected
+}
+
+// Referral links invited user (referee) to the one who invited them
+// (referrer).
+type Referral struct {
+	RefereeID      int64          `json:"-"`
+	Referee        string         `json:"referee"` // referee's login
+	ReferrerID     int64          `json:"-"`
+	Code           string         `json:"-"`
+	Status         ReferralStatus `json:"status"`
+	IP             string         `json:"-"` // referee's registration address
+	Order          string         `json:"-"` // referee's first processed order
+	ReferrerReward Points         `json:"reward"`
+	RefereeReward  Points         `json:"-"`
+	CreatedAt      string         `json:"created_at"`
+	DecidedAt      string         `json:"decided_at,omitempty"`
+}
+
+// ReferralRewards are points credited to both parties of the referral.
+type ReferralRewards struct {
+	Referrer Points
+	Referee  Points
+}
+
+// ReferralsSummary is a list of user's referrals along with the user's own
+// code.
+type ReferralsSummary struct {
+	Code      string     `json:"code"`
+	Rewarded  int        `json:"rewarded"` // number of rewarded referrals
+	Earned    Points     `json:"earned"`
+	Referrals []Referral `json:"referrals"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import "github.com/google/uuid"
+
+// Reversal returns points of the withdrawal back to user's balance, e.g.
+// when purchase paid with points was returned. Withdrawal can be reversed
+// partially by several reversals, but never more than was withdrawn.
+type Reversal struct {
+	ID           uuid.UUID `json:"id"`
+	WithdrawalID uuid.UUID `json:"withdrawal_id"`
+	UserID       int64     `json:"user_id"`
+	Amount       Points    `json:"amount"`
+	Comment      string    `json:"comment,omitempty"`
+	OperatorID   int64     `json:"operator_id"` // who made the reversal
+	CreatedAt    string    `json:"created_at"`
+}
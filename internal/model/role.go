@@ -0,0 +1,38 @@
+package model
+
+import "github.com/google/uuid"
+
+// Role defines what user is allowed to do.
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleSupport Role = "support" // can view any user's data
+	RoleAdmin   Role = "admin"   // can also manage users
+)
+
+// Valid reports if role is known.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	}
+
+	return false
+}
+
+// Principal is an authenticated user's session, access tokens are issued
+// for it.
+type Principal struct {
+	UserID    int64
+	SessionID uuid.UUID
+	Role      Role
+}
+
+// UsersFilter selects users for search. Empty fields match everything.
+type UsersFilter struct {
+	Login  string // login substring
+	Role   Role
+	Limit  int // 0 - no limit
+	Offset int
+}
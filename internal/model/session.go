@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a user's login session. Access tokens are bound to the session,
+// so they stop working as soon as it's revoked. Refresh token is rotated on
+// every refresh, only hash of the current one is stored.
+type Session struct {
+	ID          uuid.UUID
+	UserID      int64
+	RefreshHash string
+	CreatedAt   time.Time
+	RefreshedAt time.Time
+	ExpiresAt   time.Time
+	RevokedAt   time.Time // zero when session wasn't revoked
+}
+
+// Active reports if session can still be used.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// Auth tokens signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is an auth tokens signing key shared by all app instances.
+type SigningKey struct {
+	ID        string // kid
+	Alg       string
+	Sealed    []byte    // encrypted private key (or hmac secret)
+	CreatedAt time.Time // newest key is used for signing
+	ExpiresAt time.Time // tokens signed with the key aren't accepted after, zero - never
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+}
+
+// JWKSet is a set of public keys tokens can be verified with.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Points sums tier can be computed from.
+const (
+	TierBasisAccrued = "accrued" // points credited for orders
+	TierBasisSpent   = "spent"   // points withdrawn
+)
+
+// Tier is a loyalty level reached when user's rolling points sum gets to
+// the threshold.
+type Tier struct {
+	Name       string `json:"name"`
+	Threshold  Points `json:"threshold"`
+	Multiplier Points `json:"multiplier"` // order accruals are multiplied by it
+}
+
+// ParseTiers parses comma separated list of name:threshold:multiplier
+// triples, e.g. "bronze:0:1,silver:1000:1.05,gold:5000:1.1". Tiers are
+// returned sorted by threshold, the lowest one must start from zero.
+func ParseTiers(s string) ([]Tier, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var tiers []Tier
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tier %q: must be name:threshold:multiplier", item)
+		}
+
+		threshold, err := ParsePoints(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tier %q threshold: %w", parts[0], err)
+		}
+
+		multiplier, err := ParsePoints(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tier %q multiplier: %w", parts[0], err)
+		}
+
+		if threshold < 0 || multiplier < PointsScale {
+			return nil, fmt.Errorf("invalid tier %q: threshold can't be negative and multiplier less than 1", parts[0])
+		}
+
+		tiers = append(tiers, Tier{
+			Name:       parts[0],
+			Threshold:  threshold,
+			Multiplier: multiplier,
+		})
+	}
+
+	slices.SortFunc(tiers, func(a, b Tier) int {
+		return cmp.Compare(a.Threshold, b.Threshold)
+	})
+
+	if tiers[0].Threshold != 0 {
+		return nil, errors.New("the lowest tier threshold must be 0")
+	}
+
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Threshold == tiers[i-1].Threshold {
+			return nil, fmt.Errorf("tiers %q and %q have the same threshold", tiers[i-1].Name, tiers[i].Name)
+		}
+
+		for _, prev := range tiers[:i] {
+			if prev.Name == tiers[i].Name {
+				return nil, fmt.Errorf("tier %q is defined twice", prev.Name)
+			}
+		}
+	}
+
+	return tiers, nil
+}
+
+// PointsActivity is a sum of user's points movements within some period.
+type PointsActivity struct {
+	Accrued Points // credited for orders, bonuses included
+	Spent   Points // withdrawn, net of reversals
+}
+
+// TierStatus shows user's current tier and progress to the next one.
+type TierStatus struct {
+	Tier          string  `json:"tier"`
+	Multiplier    Points  `json:"multiplier"`
+	Basis         string  `json:"basis"`       // TierBasisAccrued or TierBasisSpent
+	WindowDays    int     `json:"window_days"` // rolling window of the sum
+	Value         Points  `json:"value"`       // rolling sum tier is computed from
+	Next          string  `json:"next,omitempty"`
+	NextThreshold Points  `json:"next_threshold,omitempty"`
+	Remaining     Points  `json:"remaining,omitempty"` // left to reach the next tier
+	Progress      float64 `json:"progress"`            // from current tier threshold to the next one, 0..1
+}
+
+// Bonus returns points credited on top of the accrual by tier multiplier.
+func (s TierStatus) Bonus(accrual Points) Points {
+	return accrual.MulRatio(int64(s.Multiplier), PointsScale) - accrual
+}
@@ -0,0 +1,21 @@
+package model
+
+import "github.com/google/uuid"
+
+// Transfer moves points from one user's balance to another's.
+type Transfer struct {
+	ID         uuid.UUID `json:"id"`
+	FromUserID int64     `json:"-"`
+	ToUserID   int64     `json:"-"`
+	To         string    `json:"to,omitempty"` // recipient's login
+	Amount     Points    `json:"sum"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  string    `json:"created_at"`
+}
+
+// TransferLimits caps points user can send to others within the last 24
+// hours. Zero fields mean no limit.
+type TransferLimits struct {
+	Amount Points
+	Count  int
+}
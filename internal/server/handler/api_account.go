@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	actionDeleteAccount   = "delete_account"
+	deleteConfirmationTTL = time.Minute * 5
+)
+
+type requestChangePassword struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePassword - смена пароля пользователя. Требует текущий пароль,
+// все остальные сессии пользователя завершаются.
+//
+// Route: PUT /api/user/password
+func (h *handlers) ChangePassword(c *gin.Context) {
+	var req requestChangePassword
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// sanitize inputs the same way as on register
+	req.OldPassword = strings.TrimSpace(req.OldPassword)
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+
+	if req.OldPassword == "" || req.NewPassword == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.checkPassword(c, req.OldPassword)
+	if !ok {
+		return
+	}
+
+	if req.NewPassword == req.OldPassword {
+		c.String(http.StatusBadRequest, "new password must differ from the old one")
+		c.Abort()
+		return
+	}
+
+	if err := h.auth.ValidatePassword(user.Login, req.NewPassword); err != nil {
+		abortPasswordPolicy(c, err)
+		return
+	}
+
+	hash, err := h.auth.PasswordHash(req.NewPassword)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the current session stays, others might belong to whoever knew
+	// the old password
+	err = h.storage.InTx(c.Request.Context(), func(tx storage.Storage) error {
+		if err := tx.Users().SetPasswordHash(c.Request.Context(), user.ID, hash); err != nil {
+			return err
+		}
+
+		_, err := tx.Sessions().RevokeOthers(c.Request.Context(), user.ID, readContextSessionID(c))
+		return err
+	})
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+type requestDeleteAccount struct {
+	Password          string `json:"password"`
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+type responseDeleteConfirmation struct {
+	ConfirmationToken string `json:"confirmation_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// DeleteAccount - удаление аккаунта пользователя вместе с его заказами,
+// балансом и историей. Выполняется в два шага: запрос с текущим паролем
+// возвращает токен подтверждения (202), повторный запрос с этим токеном
+// удаляет аккаунт.
+//
+// Route: DELETE /api/user
+func (h *handlers) DeleteAccount(c *gin.Context) {
+	var req requestDeleteAccount
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	userID := readContextUserID(c)
+	sessionID := readContextSessionID(c)
+
+	if req.ConfirmationToken == "" {
+		// step 1: confirm it's the user and issue confirmation token
+		req.Password = strings.TrimSpace(req.Password)
+		if req.Password == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := h.checkPassword(c, req.Password); !ok {
+			return
+		}
+
+		token, err := h.auth.CreateActionToken(c.Request.Context(), userID, sessionID,
+			actionDeleteAccount, deleteConfirmationTTL)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, responseDeleteConfirmation{
+			ConfirmationToken: token,
+			ExpiresIn:         int64(deleteConfirmationTTL.Seconds()),
+		})
+		return
+	}
+
+	// step 2: token must be issued to the same session
+	tokenUserID, tokenSessionID, err := h.auth.ParseActionToken(c.Request.Context(),
+		req.ConfirmationToken, actionDeleteAccount)
+	if err != nil || tokenUserID != userID || tokenSessionID != sessionID {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	// orders, balance, history and sessions are removed along with user
+	if err = h.storage.Users().Delete(c.Request.Context(), userID); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// checkPassword verifies password of the authorized user. Failures are
+// tracked the same way as login ones. Response is written when ok is false.
+func (h *handlers) checkPassword(c *gin.Context, password string) (user model.User, ok bool) {
+	user, err := h.storage.Users().Get(c.Request.Context(), readContextUserID(c))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return user, false
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return user, false
+	}
+
+	ip := c.ClientIP()
+	retryAfter, err := h.guard.Check(c.Request.Context(), user.Login, ip)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return user, false
+	}
+
+	if retryAfter > 0 {
+		abortTooManyAttempts(c, retryAfter)
+		return user, false
+	}
+
+	if len(password) > h.auth.MaxPasswordLength() {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return user, false
+	}
+
+	if err = h.auth.CheckPasswordHash(user.PasswordHash, password); err != nil {
+		// 401 would look like expired access token
+		h.passwordFailed(c, user.Login, ip, http.StatusForbidden)
+		return user, false
+	}
+
+	if err = h.guard.Succeed(c.Request.Context(), user.Login); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return user, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAccrualStatus - получение состояния клиента системы расчёта начислений
+// (circuit breaker, ограничение частоты запросов).
+//
+// Route: GET /api/admin/accrual/status
+func (h *handlers) AdminAccrualStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.accrual.Status())
+}
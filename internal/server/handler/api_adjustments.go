@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type requestAdjustment struct {
+	Amount  model.Points           `json:"amount"` // positive amount credits balance, negative debits
+	Reason  model.AdjustmentReason `json:"reason"`
+	Comment string                 `json:"comment"`
+}
+
+// AdminAdjustBalance - ручное начисление или списание баллов пользователю.
+// Корректировка больше порога ожидает подтверждения другим администратором,
+// в этом случае возвращается 202.
+//
+// Route: POST /api/admin/users/{id}/adjustments
+func (h *handlers) AdminAdjustBalance(c *gin.Context) {
+	var req requestAdjustment
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	req.Comment = strings.TrimSpace(req.Comment)
+
+	// "other" reason must be explained
+	if req.Amount == 0 || !req.Reason.Valid() || (req.Reason == model.ReasonOther && req.Comment == "") {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	adj := model.Adjustment{
+		UserID:     user.ID,
+		Amount:     req.Amount,
+		Reason:     req.Reason,
+		Comment:    req.Comment,
+		OperatorID: readContextUserID(c),
+	}
+
+	threshold := model.Points(h.cfg.AdjustmentApprovalThreshold) * model.PointsScale
+	if threshold > 0 && (adj.Amount > threshold || -adj.Amount > threshold) {
+		adj.Status = model.AdjustmentPending
+	}
+
+	adj, err := h.storage.Adjustments().Create(c.Request.Context(), adj)
+	if err != nil {
+		if errors.Is(err, storage.ErrNegativeBalance) {
+			// "debit exceeds user's balance"
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if adj.Status == model.AdjustmentPending {
+		c.JSON(http.StatusAccepted, adj)
+		return
+	}
+
+	c.JSON(http.StatusOK, adj)
+}
+
+// AdminAdjustments - получение списка ручных корректировок баланса.
+// Параметры: user_id, status, limit.
+//
+// Route: GET /api/admin/adjustments
+func (h *handlers) AdminAdjustments(c *gin.Context) {
+	limit, _, ok := readPaging(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	filter := model.AdjustmentsFilter{
+		Status: model.AdjustmentStatus(c.Query("status")),
+		Limit:  limit,
+	}
+
+	if v := c.Query("user_id"); v != "" {
+		var err error
+		if filter.UserID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	adjustments, err := h.storage.Adjustments().List(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(adjustments) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, adjustments)
+}
+
+// AdminApproveAdjustment - подтверждение ожидающей корректировки баланса.
+// Подтвердить корректировку может только другой администратор.
+//
+// Route: POST /api/admin/adjustments/{id}/approve
+func (h *handlers) AdminApproveAdjustment(c *gin.Context) {
+	h.decideAdjustment(c, true)
+}
+
+// AdminRejectAdjustment - отклонение ожидающей корректировки баланса.
+//
+// Route: POST /api/admin/adjustments/{id}/reject
+func (h *handlers) AdminRejectAdjustment(c *gin.Context) {
+	h.decideAdjustment(c, false)
+}
+
+func (h *handlers) decideAdjustment(c *gin.Context, approve bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	adj, err := h.storage.Adjustments().Get(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	approverID := readContextUserID(c)
+	if approve && adj.OperatorID == approverID {
+		// "adjustment must be approved by another admin"
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	adj, err = h.storage.Adjustments().Decide(c.Request.Context(), id, approverID, approve)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, storage.ErrStateConflict), errors.Is(err, storage.ErrNegativeBalance):
+			// "already decided" or "debit exceeds user's balance by now"
+			c.AbortWithStatus(http.StatusConflict)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, adj)
+}
@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/accrual"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAdminListLimit = 100
+
+// AdminSearchUsers - поиск пользователей по подстроке логина и роли.
+// Параметры: login, role, limit, offset.
+//
+// Route: GET /api/admin/users
+func (h *handlers) AdminSearchUsers(c *gin.Context) {
+	limit, offset, ok := readPaging(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	role := model.Role(c.Query("role"))
+	if role != "" && !role.Valid() {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	users, err := h.storage.Users().Search(c.Request.Context(), model.UsersFilter{
+		Login:  c.Query("login"),
+		Role:   role,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(users) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// AdminGetUser - получение информации о пользователе.
+//
+// Route: GET /api/admin/users/{id}
+func (h *handlers) AdminGetUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminUserOrders - получение списка заказов любого пользователя.
+//
+// Route: GET /api/admin/users/{id}/orders
+func (h *handlers) AdminUserOrders(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	orders, err := h.storage.Orders().GetByUserID(c.Request.Context(), user.ID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(orders) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+// AdminUserBalance - получение баланса любого пользователя.
+//
+// Route: GET /api/admin/users/{id}/balance
+func (h *handlers) AdminUserBalance(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	balance, err := h.balance(c.Request.Context(), user.ID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, balance)
+}
+
+// AdminUserWithdrawals - получение истории списаний любого пользователя.
+//
+// Route: GET /api/admin/users/{id}/withdrawals
+func (h *handlers) AdminUserWithdrawals(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	history, err := h.storage.Balance().Withdrawals(c.Request.Context(), user.ID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(history) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// AdminUserHistory - получение полной истории операций с баллами любого
+// пользователя.
+//
+// Route: GET /api/admin/users/{id}/history
+func (h *handlers) AdminUserHistory(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	history, err := h.storage.Balance().History(c.Request.Context(), user.ID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(history) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+type requestSetRole struct {
+	Role model.Role `json:"role"`
+}
+
+// AdminSetUserRole - изменение роли пользователя. Все сессии пользователя
+// завершаются, чтобы новая роль применилась сразу. Доступно только
+// администраторам.
+//
+// Route: PUT /api/admin/users/{id}/role
+func (h *handlers) AdminSetUserRole(c *gin.Context) {
+	var req requestSetRole
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil || !req.Role.Valid() {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	// role is carried in access tokens, so they must be reissued
+	err := h.storage.InTx(c.Request.Context(), func(tx storage.Storage) error {
+		if err := tx.Users().SetRole(c.Request.Context(), user.ID, req.Role); err != nil {
+			return err
+		}
+
+		_, err := tx.Sessions().RevokeAll(c.Request.Context(), user.ID)
+		return err
+	})
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// AdminRepollOrder - повторный запуск опроса системы расчёта начислений для
+// зависшего заказа.
+//
+// Route: POST /api/admin/orders/{number}/repoll
+func (h *handlers) AdminRepollOrder(c *gin.Context) {
+	err := h.accrual.Poller().Repoll(c.Request.Context(), model.OrderNumber(c.Param("number")))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, accrual.ErrOrderFinal):
+			c.AbortWithStatus(http.StatusConflict)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// AdminLoginAttempts - получение неудачных попыток входа.
+// Параметры: login, ip, limit.
+//
+// Route: GET /api/admin/login-attempts
+func (h *handlers) AdminLoginAttempts(c *gin.Context) {
+	limit, _, ok := readPaging(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	attempts, err := h.storage.LoginAttempts().List(c.Request.Context(), model.LoginAttemptsFilter{
+		Login: c.Query("login"),
+		IP:    c.Query("ip"),
+		Limit: limit,
+	})
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(attempts) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
+
+// AdminLockouts - получение действующих блокировок входа.
+//
+// Route: GET /api/admin/login-lockouts
+func (h *handlers) AdminLockouts(c *gin.Context) {
+	lockouts, err := h.storage.LoginAttempts().Lockouts(c.Request.Context(), time.Now())
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(lockouts) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, lockouts)
+}
+
+type responseCleared struct {
+	Cleared int64 `json:"cleared"`
+}
+
+// AdminClearLoginAttempts - удаление неудачных попыток входа и снятие
+// блокировок по логину и/или IP. Доступно только администраторам.
+//
+// Route: DELETE /api/admin/login-attempts
+func (h *handlers) AdminClearLoginAttempts(c *gin.Context) {
+	filter := model.LoginAttemptsFilter{
+		Login: c.Query("login"),
+		IP:    c.Query("ip"),
+	}
+
+	// clearing everything at once is most likely a mistake
+	if filter.Login == "" && filter.IP == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.storage.LoginAttempts().Clear(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, responseCleared{Cleared: count})
+}
+
+// adminUser loads user by id path param. Response is written when ok is
+// false.
+func (h *handlers) adminUser(c *gin.Context) (user model.User, ok bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return user, false
+	}
+
+	user, err = h.storage.Users().Get(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return user, false
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return user, false
+	}
+
+	return user, true
+}
+
+// readPaging parses limit and offset query params. Limit defaults to
+// defaultAdminListLimit.
+func readPaging(c *gin.Context) (limit, offset int, ok bool) {
+	limit, offset = defaultAdminListLimit, 0
+
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, 0, false
+		}
+	}
+
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, false
+		}
+	}
+
+	return limit, offset, true
+}
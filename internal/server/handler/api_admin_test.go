@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+// mustAuthAs authorizes user having the role.
+func mustAuthAs(t *testing.T, r http.Handler, s storage.Storage, login string, role model.Role) (token string, userID int64) {
+	t.Helper()
+
+	mustAuth(t, r, login)
+
+	user, err := s.Users().FindByLogin(context.Background(), login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Users().SetRole(context.Background(), user.ID, role); err != nil {
+		t.Fatal(err)
+	}
+
+	// role is carried by token, so it's issued again
+	return mustAuth(t, r, login), user.ID
+}
+
+func TestRequireRole(t *testing.T) {
+	s := memory.New()
+	r := newTestRouter(testConfig(), s)
+
+	userToken, userID := mustAuthAs(t, r, s, "user", model.RoleUser)
+	supportToken, _ := mustAuthAs(t, r, s, "support", model.RoleSupport)
+	adminToken, _ := mustAuthAs(t, r, s, "admin", model.RoleAdmin)
+
+	userPath := "/api/admin/users/" + strconv.FormatInt(userID, 10)
+	setRole := requestSetRole{Role: model.RoleUser}
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   any
+		code   int
+	}{
+		{name: "no token", method: http.MethodGet, code: http.StatusUnauthorized},
+		{name: "user reads", method: http.MethodGet, token: userToken, code: http.StatusForbidden},
+		{name: "support reads", method: http.MethodGet, token: supportToken, code: http.StatusOK},
+		{name: "admin reads", method: http.MethodGet, token: adminToken, code: http.StatusOK},
+		{name: "user sets role", method: http.MethodPut, token: userToken, body: setRole, code: http.StatusForbidden},
+		{name: "support sets role", method: http.MethodPut, token: supportToken, body: setRole, code: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := userPath
+			if tt.method == http.MethodPut {
+				path += "/role"
+			}
+
+			if w := serve(t, r, tt.method, path, tt.token, tt.body); w.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestSetRoleRevokesSessions(t *testing.T) {
+	s := memory.New()
+	r := newTestRouter(testConfig(), s)
+
+	adminToken, _ := mustAuthAs(t, r, s, "admin", model.RoleAdmin)
+	userToken, userID := mustAuthAs(t, r, s, "user", model.RoleUser)
+
+	// the user is logged in from two devices
+	otherToken := mustAuth(t, r, "user")
+
+	userPath := "/api/admin/users/" + strconv.FormatInt(userID, 10)
+
+	w := serve(t, r, http.MethodPut, userPath+"/role", adminToken, requestSetRole{Role: model.RoleSupport})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected role to be set, got %d", w.Code)
+	}
+
+	// tokens carrying the old role are rejected
+	for _, token := range []string{userToken, otherToken} {
+		if w = serve(t, r, http.MethodGet, "/api/user/withdrawals", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected session to be revoked, got %d", w.Code)
+		}
+	}
+
+	// the new role applies after login
+	supportToken := mustAuth(t, r, "user")
+	if w = serve(t, r, http.MethodGet, userPath, supportToken, nil); w.Code != http.StatusOK {
+		t.Errorf("expected new role to be granted, got %d", w.Code)
+	}
+
+	// admin's own sessions are kept
+	if w = serve(t, r, http.MethodGet, userPath, adminToken, nil); w.Code != http.StatusOK {
+		t.Errorf("expected admin session to be kept, got %d", w.Code)
+	}
+}
@@ -3,12 +3,18 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/auth"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 type requestUserLogin struct {
@@ -16,14 +22,19 @@ type requestUserLogin struct {
 	Password string `json:"password"`
 }
 
-// Register - регистрация пользователя.
+type requestUserRegister struct {
+	requestUserLogin
+	ReferralCode string `json:"referral_code"` // optional
+}
+
+// Register - регистрация пользователя. Необязательный referral_code
+// привязывает пользователя к пригласившему, неизвестный код - 422.
 //
 // Route: POST /api/user/register
 func (h *handlers) Register(c *gin.Context) {
 	var (
-		creds requestUserLogin
+		creds requestUserRegister
 		user  model.User
-		token string
 		err   error
 	)
 
@@ -44,19 +55,19 @@ func (h *handlers) Register(c *gin.Context) {
 		return
 	}
 
-	if len(creds.Password) > h.auth.MaxPasswordLength() || len(creds.Password) < 1 {
-		// "password is too long - must be less then 72 bytes"
-		c.AbortWithStatus(http.StatusBadRequest)
+	if err = h.auth.ValidatePassword(creds.Login, creds.Password); err != nil {
+		abortPasswordPolicy(c, err)
 		return
 	}
 
 	// check if user with provided login is already exists
-	if _, err = h.storage.Users().FindByLogin(creds.Login); err == nil {
+	if _, err = h.storage.Users().FindByLogin(c.Request.Context(), creds.Login); err == nil {
 		c.AbortWithStatus(http.StatusConflict)
 		return
 	} else {
 		if errors.Is(err, storage.ErrNotFound) {
 			user.Login = creds.Login
+			user.Role = model.RoleUser
 		} else {
 			_ = c.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -69,22 +80,37 @@ func (h *handlers) Register(c *gin.Context) {
 		return
 	}
 
-	// register new user
-	if user.ID, err = h.storage.Users().Create(user); err != nil {
+	// register new user along with the referral
+	err = h.storage.InTx(c.Request.Context(), func(tx storage.Storage) (err error) {
+		if user.ID, err = tx.Users().Create(c.Request.Context(), user); err != nil {
+			return err
+		}
+
+		// code is ignored when referral program is disabled
+		if creds.ReferralCode == "" || h.referrals == nil {
+			return nil
+		}
+
+		return h.referrals.Refer(c.Request.Context(), tx, user.ID, creds.ReferralCode, c.ClientIP())
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrReferralCode) {
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	// create new auth token
-	token, err = h.auth.CreateToken(user.ID)
+	// start new session
+	tokens, err := h.startSession(c, user)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	// TODO: return created id and jwt token as json?
-	c.Header("Authorization", "Bearer "+token)
-	c.Status(http.StatusOK)
+	c.Header("Authorization", "Bearer "+tokens.AccessToken)
+	c.JSON(http.StatusOK, tokens)
 }
 
 // Login - аутентификация пользователя.
@@ -94,7 +120,6 @@ func (h *handlers) Login(c *gin.Context) {
 	var (
 		creds requestUserLogin
 		user  model.User
-		token string
 		err   error
 	)
 
@@ -121,17 +146,30 @@ func (h *handlers) Login(c *gin.Context) {
 		return
 	}
 
+	// refuse attempts while login or client ip is locked out
+	ip := c.ClientIP()
+	retryAfter, err := h.guard.Check(c.Request.Context(), creds.Login, ip)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		abortTooManyAttempts(c, retryAfter)
+		return
+	}
+
 	// check if user with provided login is already exists
-	if user, err = h.storage.Users().FindByLogin(creds.Login); err == nil {
+	if user, err = h.storage.Users().FindByLogin(c.Request.Context(), creds.Login); err == nil {
 		if pErr := h.auth.CheckPasswordHash(user.PasswordHash, creds.Password); pErr != nil {
 			// "wrong login or password"
-			c.AbortWithStatus(http.StatusUnauthorized)
+			h.passwordFailed(c, creds.Login, ip, http.StatusUnauthorized)
 			return
 		}
 	} else {
 		if errors.Is(err, storage.ErrNotFound) {
 			// again, "wrong login or password"
-			c.AbortWithStatus(http.StatusUnauthorized)
+			h.passwordFailed(c, creds.Login, ip, http.StatusUnauthorized)
 			return
 		} else {
 			_ = c.AbortWithError(http.StatusInternalServerError, err)
@@ -139,13 +177,80 @@ func (h *handlers) Login(c *gin.Context) {
 		}
 	}
 
-	// create new auth token
-	token, err = h.auth.CreateToken(user.ID)
+	if err = h.guard.Succeed(c.Request.Context(), creds.Login); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// upgrade hash calculated by old hasher or with weaker parameters,
+	// password is known only now
+	if h.auth.NeedsRehash(user.PasswordHash) {
+		h.rehashPassword(c, user.ID, creds.Password)
+	}
+
+	// start new session
+	tokens, err := h.startSession(c, user)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Authorization", "Bearer "+tokens.AccessToken)
+	c.JSON(http.StatusOK, tokens)
+}
+
+// rehashPassword replaces user's password hash with the one calculated by
+// the current hasher. Failure doesn't prevent user from logging in, hash is
+// upgraded on the next login then.
+func (h *handlers) rehashPassword(c *gin.Context, userID int64, password string) {
+	hash, err := h.auth.PasswordHash(password)
+	if err == nil {
+		err = h.storage.Users().SetPasswordHash(c.Request.Context(), userID, hash)
+	}
+
+	if err != nil {
+		logger.Log.Error("Failed to upgrade password hash",
+			zap.Int64("user_id", userID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	logger.Log.Debug("Password hash upgraded", zap.Int64("user_id", userID))
+}
+
+// passwordFailed records failed login attempt and responds with status, or
+// with 429 when the attempt caused lockout.
+func (h *handlers) passwordFailed(c *gin.Context, login, ip string, status int) {
+	retryAfter, err := h.guard.Fail(c.Request.Context(), login, ip)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	c.Header("Authorization", "Bearer "+token)
-	c.Status(http.StatusOK)
+	if retryAfter > 0 {
+		abortTooManyAttempts(c, retryAfter)
+		return
+	}
+
+	c.AbortWithStatus(status)
+}
+
+// abortTooManyAttempts responds with 429 and Retry-After header set in
+// whole seconds.
+func abortTooManyAttempts(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	c.AbortWithStatus(http.StatusTooManyRequests)
+}
+
+// abortPasswordPolicy responds with 400 and policy violation description.
+func abortPasswordPolicy(c *gin.Context, err error) {
+	var policyErr auth.PolicyError
+	if errors.As(err, &policyErr) {
+		c.String(http.StatusBadRequest, policyErr.Error())
+		c.Abort()
+		return
+	}
+
+	_ = c.AbortWithError(http.StatusInternalServerError, err)
 }
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	cfg := testConfig()
+	cfg.PasswordMinLength = 8
+	cfg.PasswordMinClasses = 3
+	cfg.PasswordRejectLogin = true
+
+	r := newTestRouter(cfg, memory.New())
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		code     int
+	}{
+		{name: "meets policy", login: "first", password: "Secret-42", code: http.StatusOK},
+		{name: "login is taken", login: "first", password: "Secret-42", code: http.StatusConflict},
+		{name: "empty password", login: "empty", password: "", code: http.StatusBadRequest},
+		{name: "too short", login: "short", password: "Sec-42", code: http.StatusBadRequest},
+		{name: "too few classes", login: "classes", password: "secretsecret", code: http.StatusBadRequest},
+		{name: "contains login", login: "alice", password: "Alice-1234", code: http.StatusBadRequest},
+		{name: "too long for hasher", login: "long", password: "Sec-42" + strings.Repeat("x", 72), code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, r, http.MethodPost, "/api/user/register", "", requestUserLogin{Login: tt.login, Password: tt.password})
+			if w.Code != tt.code {
+				t.Fatalf("expected %d, got %d %s", tt.code, w.Code, w.Body)
+			}
+
+			// policy violation is explained to user
+			if w.Code == http.StatusBadRequest && tt.password != "" && !strings.HasPrefix(w.Body.String(), "password must") {
+				t.Errorf("expected policy violation message, got %q", w.Body)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
@@ -11,11 +14,13 @@ import (
 )
 
 // Balance - получение текущего баланса счёта баллов лояльности пользователя.
+// Баллы, срок действия которых скоро истекает, группируются по датам.
+// Зарезервированные баллы входят в баланс, но недоступны для списаний.
 //
 // Route: GET /api/user/balance
 func (h *handlers) Balance(c *gin.Context) {
-	balance, err := h.storage.Balance().Get(readContextUserID(c))
-	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+	balance, err := h.balance(c.Request.Context(), readContextUserID(c))
+	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -23,9 +28,25 @@ func (h *handlers) Balance(c *gin.Context) {
 	c.JSON(http.StatusOK, balance)
 }
 
+// balance returns user's balance along with points expiring soon. Zero
+// balance is returned when user has never had any points.
+func (h *handlers) balance(ctx context.Context, userID int64) (balance model.Balance, err error) {
+	balance, err = h.storage.Balance().Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return balance, nil
+		}
+		return balance, err
+	}
+
+	balance.Expiring, err = h.expirer.Expiring(ctx, userID)
+
+	return balance, err
+}
+
 type requestWithdraw struct {
 	Order model.OrderNumber `json:"order"`
-	Sum   float64           `json:"sum"`
+	Sum   model.Points      `json:"sum"`
 }
 
 // Withdraw - запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа.
@@ -49,20 +70,26 @@ func (h *handlers) Withdraw(c *gin.Context) {
 		return
 	}
 
+	if req.Sum <= 0 {
+		// "withdrawal sum must be positive"
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
 	// check if user has enough points to withdraw
-	balance, err := h.storage.Balance().Get(userID)
+	balance, err := h.storage.Balance().Get(c.Request.Context(), userID)
 	if err != nil && !errors.Is(err, storage.ErrNotFound) {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	if balance.Balance < req.Sum {
+	if balance.Available < req.Sum {
 		// "insufficient funds"
 		c.AbortWithStatus(http.StatusPaymentRequired)
 		return
 	}
 
-	err = h.storage.Balance().Withdraw(req.Sum, userID, req.Order)
+	err = h.storage.Balance().Withdraw(c.Request.Context(), req.Sum, userID, req.Order)
 	if err != nil {
 		if errors.Is(err, storage.ErrNegativeBalance) {
 			// "insufficient funds"
@@ -77,11 +104,109 @@ func (h *handlers) Withdraw(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// comment is shown to both parties of the transfer
+const maxTransferCommentLength = 255
+
+type requestTransfer struct {
+	Login   string       `json:"login"`
+	Sum     model.Points `json:"sum"`
+	Comment string       `json:"comment"`
+}
+
+// Transfer - перевод баллов со своего счёта на счёт другого пользователя.
+// Суммы и количество переводов за последние 24 часа ограничены.
+//
+// Route: POST /api/user/balance/transfer
+func (h *handlers) Transfer(c *gin.Context) {
+	userID := readContextUserID(c)
+
+	var req requestTransfer
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	req.Login = strings.TrimSpace(req.Login)
+	req.Comment = strings.TrimSpace(req.Comment)
+
+	if req.Login == "" || req.Sum <= 0 || utf8.RuneCountInString(req.Comment) > maxTransferCommentLength {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	recipient, err := h.storage.Users().FindByLogin(c.Request.Context(), req.Login)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if recipient.ID == userID {
+		// "can't transfer points to yourself"
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	limits := model.TransferLimits{
+		Amount: model.Points(h.cfg.TransferDailyLimit) * model.PointsScale,
+		Count:  h.cfg.TransferDailyCount,
+	}
+
+	transfer, err := h.storage.Transfers().Create(c.Request.Context(), model.Transfer{
+		FromUserID: userID,
+		ToUserID:   recipient.ID,
+		Amount:     req.Sum,
+		Comment:    req.Comment,
+	}, limits)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNegativeBalance):
+			// "insufficient funds"
+			c.AbortWithStatus(http.StatusPaymentRequired)
+		case errors.Is(err, storage.ErrLimitExceeded):
+			// "daily transfers limit exceeded"
+			c.AbortWithStatus(http.StatusTooManyRequests)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	transfer.To = recipient.Login
+
+	c.JSON(http.StatusOK, transfer)
+}
+
 // Withdrawals - получение информации о выводе средств с накопительного счёта пользователем.
+// Возвраты показываются в поле reversals того списания, к которому относятся.
 //
 // Route: GET /api/user/withdrawals
 func (h *handlers) Withdrawals(c *gin.Context) {
-	history, err := h.storage.Balance().Withdrawals(readContextUserID(c))
+	history, err := h.storage.Balance().Withdrawals(c.Request.Context(), readContextUserID(c))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(history) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// History - получение полной истории операций с баллами пользователя:
+// кроме списаний в ней показываются их возвраты, ручные корректировки,
+// переводы баллов другим пользователям и от них, а также списания баллов
+// за отменённые заказы.
+//
+// Route: GET /api/user/history
+func (h *handlers) History(c *gin.Context) {
+	history, err := h.storage.Balance().History(c.Request.Context(), readContextUserID(c))
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
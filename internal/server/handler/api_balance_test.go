@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+// mustAuthWith authorizes user having the points.
+func mustAuthWith(t *testing.T, r http.Handler, s storage.Storage, login string, sum model.Points) string {
+	t.Helper()
+
+	token := mustAuth(t, r, login)
+
+	user, err := s.Users().FindByLogin(context.Background(), login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Balance().Add(context.Background(), sum, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestTransferStatusCodes(t *testing.T) {
+	cfg := testConfig()
+	cfg.TransferDailyLimit = 100
+	cfg.TransferDailyCount = 2
+
+	s := memory.New()
+	r := newTestRouter(cfg, s)
+
+	senderToken := mustAuthWith(t, r, s, "sender", 1000*model.PointsScale)
+	poorToken := mustAuthWith(t, r, s, "poor", 10*model.PointsScale)
+	mustAuth(t, r, "recipient")
+
+	// transfers are made one after another, so limits are used up
+	tests := []struct {
+		name  string
+		token string
+		login string
+		sum   model.Points
+		code  int
+	}{
+		{name: "unknown recipient", token: senderToken, login: "nobody", sum: 10 * model.PointsScale, code: http.StatusNotFound},
+		{name: "to oneself", token: senderToken, login: "sender", sum: 10 * model.PointsScale, code: http.StatusUnprocessableEntity},
+		{name: "zero sum", token: senderToken, login: "recipient", code: http.StatusUnprocessableEntity},
+		{name: "within limits", token: senderToken, login: "recipient", sum: 50 * model.PointsScale, code: http.StatusOK},
+		{name: "daily amount exceeded", token: senderToken, login: "recipient", sum: 60 * model.PointsScale, code: http.StatusTooManyRequests},
+		{name: "up to daily amount", token: senderToken, login: "recipient", sum: 50 * model.PointsScale, code: http.StatusOK},
+		{name: "daily count exceeded", token: senderToken, login: "poor", sum: model.PointsScale / 100, code: http.StatusTooManyRequests},
+		{name: "insufficient funds", token: poorToken, login: "recipient", sum: 20 * model.PointsScale, code: http.StatusPaymentRequired},
+		{name: "no token", login: "recipient", sum: 10 * model.PointsScale, code: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		w := serve(t, r, http.MethodPost, "/api/user/balance/transfer", tt.token, requestTransfer{Login: tt.login, Sum: tt.sum})
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.code, w.Code)
+		}
+	}
+
+	// failed transfers changed nothing
+	recipient, err := s.Users().FindByLogin(context.Background(), "recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := s.Balance().Get(context.Background(), recipient.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Balance != 100*model.PointsScale {
+		t.Errorf("expected recipient to get 100 points, got %s", balance.Balance)
+	}
+}
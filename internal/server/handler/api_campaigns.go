@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type requestCampaign struct {
+	Name        string       `json:"name"`
+	StartsAt    time.Time    `json:"starts_at"`
+	EndsAt      time.Time    `json:"ends_at"`
+	Points      model.Points `json:"points"`
+	Multiplier  model.Points `json:"multiplier"`
+	FirstOrder  bool         `json:"first_order"`
+	Tiers       []string     `json:"tiers"`
+	OrderPrefix string       `json:"order_prefix"`
+	Priority    int          `json:"priority"`
+	Exclusive   bool         `json:"exclusive"`
+	Budget      model.Points `json:"budget"`
+	Disabled    bool         `json:"disabled"`
+}
+
+// AdminCreateCampaign - создание маркетинговой кампании, начисляющей
+// бонусные баллы за заказы.
+//
+// Route: POST /api/admin/campaigns
+func (h *handlers) AdminCreateCampaign(c *gin.Context) {
+	campaign, ok := h.readCampaign(c)
+	if !ok {
+		return
+	}
+
+	id, err := h.storage.Campaigns().Create(c.Request.Context(), campaign)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	campaign, err = h.storage.Campaigns().Get(c.Request.Context(), id)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// AdminUpdateCampaign - изменение настроек кампании. Израсходованный бюджет
+// сохраняется, новый бюджет не может быть меньше него. Для остановки
+// кампании передаётся disabled.
+//
+// Route: PUT /api/admin/campaigns/{id}
+func (h *handlers) AdminUpdateCampaign(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	campaign, ok := h.readCampaign(c)
+	if !ok {
+		return
+	}
+	campaign.ID = id
+
+	current, err := h.storage.Campaigns().Get(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if campaign.Budget > 0 && campaign.Budget < current.Spent {
+		// "budget can't be less than already spent"
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err = h.storage.Campaigns().Update(c.Request.Context(), campaign); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	h.campaign(c, id)
+}
+
+// AdminGetCampaign - получение кампании.
+//
+// Route: GET /api/admin/campaigns/{id}
+func (h *handlers) AdminGetCampaign(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	h.campaign(c, id)
+}
+
+// AdminCampaigns - получение списка кампаний, сначала новые.
+//
+// Route: GET /api/admin/campaigns
+func (h *handlers) AdminCampaigns(c *gin.Context) {
+	campaigns, err := h.storage.Campaigns().List(c.Request.Context())
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(campaigns) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaigns)
+}
+
+func (h *handlers) campaign(c *gin.Context, id int64) {
+	campaign, err := h.storage.Campaigns().Get(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// readCampaign decodes and validates campaign settings. Tiers must be the
+// configured ones, otherwise campaign would never match any order.
+func (h *handlers) readCampaign(c *gin.Context) (campaign model.Campaign, ok bool) {
+	var req requestCampaign
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return campaign, false
+	}
+
+	campaign = model.Campaign{
+		Name:        strings.TrimSpace(req.Name),
+		StartsAt:    req.StartsAt,
+		EndsAt:      req.EndsAt,
+		Points:      req.Points,
+		Multiplier:  req.Multiplier,
+		FirstOrder:  req.FirstOrder,
+		Tiers:       req.Tiers,
+		OrderPrefix: req.OrderPrefix,
+		Priority:    req.Priority,
+		Exclusive:   req.Exclusive,
+		Budget:      req.Budget,
+		Disabled:    req.Disabled,
+	}
+
+	if err := campaign.Validate(); err != nil {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return campaign, false
+	}
+
+	if len(campaign.Tiers) > 0 {
+		tiers, _ := model.ParseTiers(h.cfg.Tiers) // validated on startup
+		for _, name := range campaign.Tiers {
+			known := slices.ContainsFunc(tiers, func(t model.Tier) bool {
+				return t.Name == name
+			})
+			if !known {
+				c.AbortWithStatus(http.StatusUnprocessableEntity)
+				return campaign, false
+			}
+		}
+	}
+
+	return campaign, true
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type requestCancellation struct {
+	Comment string `json:"comment"`
+}
+
+// AdminCancelOrder - отмена магазином уже обработанного заказа. Начисленные
+// за заказ баллы (вместе с бонусами) списываются обратно. Если пользователь
+// успел их потратить, остаток становится долгом, который гасится будущими
+// начислениями, либо списывается - в зависимости от настроек. По тем же
+// правилам списывается награда пригласившего, если заказ завершил реферал,
+// а бонусы акций возвращаются в их бюджеты.
+//
+// Route: POST /api/admin/orders/{number}/cancel
+func (h *handlers) AdminCancelOrder(c *gin.Context) {
+	var req requestCancellation
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	cancellation, err := h.storage.Cancellations().Create(c.Request.Context(), model.Cancellation{
+		Order:      model.OrderNumber(c.Param("number")),
+		Comment:    strings.TrimSpace(req.Comment),
+		OperatorID: readContextUserID(c),
+	}, h.cfg.ClawbackPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, storage.ErrStateConflict):
+			// "order isn't processed or is already cancelled"
+			c.AbortWithStatus(http.StatusConflict)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, cancellation)
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Hold - резервирование баллов в счёт оплаты нового заказа. Баллы списываются
+// только при подтверждении (capture), до этого они недоступны для других
+// списаний. Неподтверждённый резерв снимается по истечении срока.
+//
+// Route: POST /api/user/balance/hold
+func (h *handlers) Hold(c *gin.Context) {
+	userID := readContextUserID(c)
+
+	var req requestWithdraw
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Order.Validate(); err != nil {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if req.Sum <= 0 {
+		// "withdrawal sum must be positive"
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(h.cfg.HoldTTLSec) * time.Second)
+
+	wd, err := h.storage.Holds().Create(c.Request.Context(), req.Sum, userID, req.Order, expiresAt)
+	if err != nil {
+		if errors.Is(err, storage.ErrNegativeBalance) {
+			// "insufficient funds"
+			c.AbortWithStatus(http.StatusPaymentRequired)
+			return
+		}
+
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, wd)
+}
+
+type requestCapture struct {
+	Sum model.Points `json:"sum"` // optional, whole held sum by default
+}
+
+// Capture - подтверждение зарезервированного списания. Можно списать меньше
+// зарезервированного, остаток резерва снимается.
+//
+// Route: POST /api/user/withdrawals/{id}/capture
+func (h *handlers) Capture(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var req requestCapture
+	if err = json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if req.Sum < 0 {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	wd, err := h.storage.Holds().Capture(c.Request.Context(), id, readContextUserID(c), req.Sum)
+	if err != nil {
+		h.abortHoldError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wd)
+}
+
+// Void - отмена зарезервированного списания, баллы снова доступны.
+//
+// Route: POST /api/user/withdrawals/{id}/void
+func (h *handlers) Void(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	wd, err := h.storage.Holds().Void(c.Request.Context(), id, readContextUserID(c))
+	if err != nil {
+		h.abortHoldError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wd)
+}
+
+func (h *handlers) abortHoldError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.AbortWithStatus(http.StatusNotFound)
+	case errors.Is(err, storage.ErrStateConflict):
+		// "withdrawal isn't held or hold has expired"
+		c.AbortWithStatus(http.StatusConflict)
+	case errors.Is(err, storage.ErrCheckViolation):
+		// "captured sum exceeds held one"
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+	default:
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
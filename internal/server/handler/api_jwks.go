@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS - получение публичных ключей, которыми можно проверить подпись
+// токенов доступа (JSON Web Key Set). Ключи HS256 не публикуются.
+//
+// Route: GET /.well-known/jwks.json
+func (h *handlers) JWKS(c *gin.Context) {
+	set, err := h.auth.JWKS(c.Request.Context())
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// new key is used right after rotation, so keep cache short
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
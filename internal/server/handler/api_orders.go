@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -35,7 +36,7 @@ func (h *handlers) PostOrders(c *gin.Context) {
 	}
 
 	// check if order already exist
-	orderFound, err := h.storage.Orders().Get(orderNumber)
+	orderFound, err := h.storage.Orders().Get(c.Request.Context(), orderNumber)
 	if err != nil && !errors.Is(err, storage.ErrNotFound) {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -61,14 +62,16 @@ func (h *handlers) PostOrders(c *gin.Context) {
 		UserID: userID,
 	}
 
-	_, err = h.storage.Orders().Create(order)
+	_, err = h.storage.Orders().Create(c.Request.Context(), order)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	// request context is cancelled as soon as response is sent
+	ctx := context.WithoutCancel(c.Request.Context())
 	go func() {
-		if err = h.accrual.Poller().RegisterNewOrder(order.ID); err != nil {
+		if err := h.accrual.Poller().RegisterNewOrder(ctx, order.ID); err != nil {
 			logger.Log.Error("RegisterNewOrder for Poller failed",
 				zap.Error(err),
 				zap.String("order", string(order.ID)),
@@ -87,7 +90,7 @@ func (h *handlers) PostOrders(c *gin.Context) {
 func (h *handlers) GetOrders(c *gin.Context) {
 	userID := readContextUserID(c)
 
-	orders, err := h.storage.Orders().GetByUserID(userID)
+	orders, err := h.storage.Orders().GetByUserID(c.Request.Context(), userID)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Referrals - получение реферального кода пользователя и списка
+// приглашённых им пользователей. Код создаётся при первом запросе.
+// Возвращает 404, если реферальная программа отключена.
+//
+// Route: GET /api/user/referrals
+func (h *handlers) Referrals(c *gin.Context) {
+	if h.referrals == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	summary, err := h.referrals.Summary(c.Request.Context(), readContextUserID(c), c.ClientIP())
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type requestReversal struct {
+	Amount  model.Points `json:"amount"` // optional, all the rest of withdrawal by default
+	Comment string       `json:"comment"`
+}
+
+// AdminReverseWithdrawal - возврат списанных баллов на счёт пользователя,
+// например при возврате оплаченной баллами покупки. Списание можно вернуть
+// частями, но не больше списанного.
+//
+// Route: POST /api/admin/withdrawals/{id}/reversals
+func (h *handlers) AdminReverseWithdrawal(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var req requestReversal
+	if err = json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if req.Amount < 0 {
+		c.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+
+	rev, err := h.storage.Reversals().Create(c.Request.Context(), model.Reversal{
+		WithdrawalID: id,
+		Amount:       req.Amount,
+		Comment:      strings.TrimSpace(req.Comment),
+		OperatorID:   readContextUserID(c),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.AbortWithStatus(http.StatusNotFound)
+		case errors.Is(err, storage.ErrStateConflict):
+			// "withdrawal isn't captured or is already fully reversed"
+			c.AbortWithStatus(http.StatusConflict)
+		case errors.Is(err, storage.ErrCheckViolation):
+			// "amount exceeds withdrawn points left"
+			c.AbortWithStatus(http.StatusUnprocessableEntity)
+		default:
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, rev)
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type responseTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
+}
+
+// startSession creates new session for user and issues its tokens.
+func (h *handlers) startSession(c *gin.Context, user model.User) (tokens responseTokens, err error) {
+	sessionID, err := uuid.NewV7()
+	if err != nil {
+		return tokens, err
+	}
+
+	refreshToken, refreshHash, err := h.auth.NewRefreshToken(sessionID)
+	if err != nil {
+		return tokens, err
+	}
+
+	err = h.storage.Sessions().Create(c.Request.Context(), model.Session{
+		ID:          sessionID,
+		UserID:      user.ID,
+		RefreshHash: refreshHash,
+		ExpiresAt:   time.Now().Add(h.auth.RefreshTokenLifetime()),
+	})
+	if err != nil {
+		return tokens, err
+	}
+
+	return h.issueTokens(c, model.Principal{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Role:      user.Role,
+	}, refreshToken)
+}
+
+func (h *handlers) issueTokens(c *gin.Context, p model.Principal, refreshToken string) (tokens responseTokens, err error) {
+	accessToken, err := h.auth.CreateToken(c.Request.Context(), p)
+	if err != nil {
+		return tokens, err
+	}
+
+	return responseTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(h.auth.TokenLifetime().Seconds()),
+	}, nil
+}
+
+type requestRefresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh - обновление токенов сессии. Refresh токен одноразовый, вместе с
+// новым access токеном выдаётся новый refresh токен. Повторное использование
+// старого refresh токена отзывает сессию.
+//
+// Route: POST /api/user/token/refresh
+func (h *handlers) Refresh(c *gin.Context) {
+	var req requestRefresh
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	sessionID, oldHash, err := h.auth.ParseRefreshToken(req.RefreshToken)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	session, err := h.storage.Sessions().Get(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	refreshToken, newHash, err := h.auth.NewRefreshToken(sessionID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(h.auth.RefreshTokenLifetime())
+
+	err = h.storage.Sessions().Rotate(ctx, sessionID, oldHash, newHash, expiresAt)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if session.Active(time.Now()) {
+			// refresh token was already used, so it might have been stolen
+			if err = h.storage.Sessions().Revoke(ctx, sessionID); err != nil {
+				_ = c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// role might have been changed since the last refresh
+	user, err := h.storage.Users().Get(ctx, session.UserID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	tokens, err := h.issueTokens(c, model.Principal{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Role:      user.Role,
+	}, refreshToken)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Authorization", "Bearer "+tokens.AccessToken)
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout - завершение текущей сессии пользователя.
+//
+// Route: POST /api/user/logout
+func (h *handlers) Logout(c *gin.Context) {
+	err := h.storage.Sessions().Revoke(c.Request.Context(), readContextSessionID(c))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// LogoutAll - завершение всех сессий пользователя на всех устройствах.
+//
+// Route: POST /api/user/logout/all
+func (h *handlers) LogoutAll(c *gin.Context) {
+	_, err := h.storage.Sessions().RevokeAll(c.Request.Context(), readContextUserID(c))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tier - получение текущего уровня программы лояльности пользователя и
+// прогресса до следующего уровня. Возвращает 404, если уровни не настроены.
+//
+// Route: GET /api/user/tier
+func (h *handlers) Tier(c *gin.Context) {
+	if h.tiers == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	status, err := h.tiers.Status(c.Request.Context(), h.storage.Balance(), readContextUserID(c))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
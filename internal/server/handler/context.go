@@ -1,9 +1,15 @@
 package handler
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
 
 const (
-	ctxKeyUserID = "userID"
+	ctxKeyUserID    = "userID"
+	ctxKeySessionID = "sessionID"
+	ctxKeyRole      = "role"
 )
 
 func setContextUserID(c *gin.Context, userID int64) {
@@ -13,3 +19,21 @@ func setContextUserID(c *gin.Context, userID int64) {
 func readContextUserID(c *gin.Context) (userID int64) {
 	return c.GetInt64(ctxKeyUserID)
 }
+
+func setContextSessionID(c *gin.Context, sessionID uuid.UUID) {
+	c.Set(ctxKeySessionID, sessionID)
+}
+
+func readContextSessionID(c *gin.Context) (sessionID uuid.UUID) {
+	sessionID, _ = c.Value(ctxKeySessionID).(uuid.UUID)
+	return sessionID
+}
+
+func setContextRole(c *gin.Context, role model.Role) {
+	c.Set(ctxKeyRole, role)
+}
+
+func readContextRole(c *gin.Context) (role model.Role) {
+	role, _ = c.Value(ctxKeyRole).(model.Role)
+	return role
+}
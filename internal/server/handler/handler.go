@@ -6,25 +6,68 @@ import (
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/config"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/auth"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/loginguard"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
 )
 
 type handlers struct {
-	cfg     *config.Config
-	auth    service.AuthService
-	accrual service.AccrualService
-	Mids    *middlewares
-	storage storage.Storage
+	cfg       *config.Config
+	auth      service.AuthService
+	accrual   service.AccrualService
+	expirer   service.PointsExpirer
+	tiers     service.TierLadder
+	referrals service.ReferralProgram
+	guard     service.LoginGuard
+	Mids      *middlewares
+	storage   storage.Storage
 }
 
-func New(cfg *config.Config, s storage.Storage, accrual service.AccrualService) *handlers {
-	auther := auth.New(cfg.AuthSecretKey, time.Second*time.Duration(cfg.AuthTokenLifetimeSec))
+func New(cfg *config.Config, s storage.Storage, accrual service.AccrualService, expirer service.PointsExpirer, tiers service.TierLadder, referrals service.ReferralProgram) *handlers {
+	keys := auth.NewKeyring(s.SigningKeys(), auth.KeyringOptions{
+		Alg:      cfg.AuthAlg,
+		Rotation: time.Second * time.Duration(cfg.KeyRotationSec),
+		Grace:    time.Second * time.Duration(cfg.KeyGraceSec),
+		Secret:   cfg.AuthSecretKey,
+	})
+
+	var hasher auth.Hasher
+	switch cfg.PasswordHasher {
+	case auth.HasherBcrypt:
+		hasher = auth.NewBcrypt(cfg.BcryptCost)
+	default:
+		hasher = auth.NewArgon2id(auth.Argon2Params{
+			Memory:      uint32(cfg.Argon2Memory),
+			Iterations:  uint32(cfg.Argon2Iterations),
+			Parallelism: uint8(cfg.Argon2Parallelism),
+		})
+	}
+
+	policy := auth.PasswordPolicy{
+		MinLength:   cfg.PasswordMinLength,
+		MinClasses:  cfg.PasswordMinClasses,
+		RejectLogin: cfg.PasswordRejectLogin,
+	}
+
+	auther := auth.New(keys, hasher, policy,
+		time.Second*time.Duration(cfg.AuthTokenLifetimeSec),
+		time.Second*time.Duration(cfg.RefreshLifetimeSec),
+	)
 
 	return &handlers{
-		cfg:     cfg,
-		auth:    auther,
-		accrual: accrual,
-		Mids:    NewMiddlewares(cfg, auther),
+		cfg:       cfg,
+		auth:      auther,
+		accrual:   accrual,
+		expirer:   expirer,
+		tiers:     tiers,
+		referrals: referrals,
+		guard: loginguard.New(s.LoginAttempts(), loginguard.Options{
+			Window:           time.Second * time.Duration(cfg.LoginWindowSec),
+			MaxLoginFailures: cfg.LoginMaxFailures,
+			MaxIPFailures:    cfg.LoginIPMaxFailures,
+			Lockout:          time.Second * time.Duration(cfg.LoginLockoutSec),
+			MaxLockout:       time.Second * time.Duration(cfg.LoginMaxLockoutSec),
+		}),
+		Mids:    NewMiddlewares(cfg, auther, s),
 		storage: s,
 	}
 }
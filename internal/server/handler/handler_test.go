@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/config"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/auth"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// testConfig returns config with the cheapest password hashing.
+func testConfig() *config.Config {
+	cfg := config.New()
+	cfg.GinMode = gin.TestMode
+	cfg.AuthSecretKey = "secret"
+	cfg.PasswordHasher = auth.HasherBcrypt
+	cfg.BcryptCost = 4
+
+	return cfg
+}
+
+// newTestRouter routes requests to handlers under test the same way the
+// server does.
+func newTestRouter(cfg *config.Config, s storage.Storage) *gin.Engine {
+	gin.SetMode(cfg.GinMode)
+
+	h := New(cfg, s, nil, nil, nil, nil)
+	r := gin.New()
+
+	api := r.Group("/api")
+	api.POST("/user/register", h.Register)
+	api.POST("/user/login", h.Login)
+
+	user := api.Group("/user")
+	user.Use(h.Mids.CheckAuth())
+	user.POST("/balance/transfer", h.Transfer)
+	user.GET("/withdrawals", h.Withdrawals)
+
+	admin := api.Group("/admin")
+	admin.Use(h.Mids.CheckAuth(), h.Mids.RequireRole(model.RoleSupport, model.RoleAdmin))
+	admin.GET("/users/:id", h.AdminGetUser)
+	admin.PUT("/users/:id/role", h.Mids.RequireRole(model.RoleAdmin), h.AdminSetUserRole)
+
+	return r
+}
+
+// serve sends request with json body authorized by token, empty token is
+// omitted.
+func serve(t *testing.T, r http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+// mustAuth registers user, or logs in when login is already taken, and
+// returns access token.
+func mustAuth(t *testing.T, r http.Handler, login string) string {
+	t.Helper()
+
+	creds := requestUserLogin{Login: login, Password: "Passw0rd!"}
+
+	w := serve(t, r, http.MethodPost, "/api/user/register", "", creds)
+	if w.Code == http.StatusConflict {
+		w = serve(t, r, http.MethodPost, "/api/user/login", "", creds)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("can't authorize %s: got %d %s", login, w.Code, w.Body)
+	}
+
+	token := GetToken(&http.Request{Header: w.Header()})
+	if token == "" {
+		t.Fatalf("no token issued to %s", login)
+	}
+
+	return token
+}
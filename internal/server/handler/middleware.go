@@ -2,15 +2,19 @@ package handler
 
 import (
 	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/config"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -28,19 +32,22 @@ func GetToken(r *http.Request) string {
 }
 
 type middlewares struct {
-	cfg  *config.Config
-	auth service.AuthService
+	cfg     *config.Config
+	auth    service.AuthService
+	storage storage.Storage
 }
 
-func NewMiddlewares(cfg *config.Config, auth service.AuthService) *middlewares {
+func NewMiddlewares(cfg *config.Config, auth service.AuthService, storage storage.Storage) *middlewares {
 	return &middlewares{
-		cfg:  cfg,
-		auth: auth,
+		cfg:     cfg,
+		auth:    auth,
+		storage: storage,
 	}
 }
 
-// CheckAuth checks if user is authorized properly. Stores user id in context on
-// success. Parses and validates auth token. Paths can be skipped by using arg.
+// CheckAuth checks if user is authorized properly. Stores user and session ids
+// and user's role in context on success. Parses and validates auth token, token's session must
+// not be revoked or expired. Paths can be skipped by using arg.
 func (m *middlewares) CheckAuth(exclude ...string) gin.HandlerFunc {
 	// Build a set of excluded paths to later be checked on.
 	// Race conditions must not be the case since I initialize the map only once
@@ -66,14 +73,43 @@ func (m *middlewares) CheckAuth(exclude ...string) gin.HandlerFunc {
 			return
 		}
 
-		userID, err := m.auth.ParseToken(authToken)
+		p, err := m.auth.ParseToken(c.Request.Context(), authToken)
 		if err != nil {
 			// "bad/invalid/expired/wrong token"
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		setContextUserID(c, userID)
+		session, err := m.storage.Sessions().Get(c.Request.Context(), p.SessionID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if session.UserID != p.UserID || !session.Active(time.Now()) {
+			// "session was revoked or expired"
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		setContextUserID(c, p.UserID)
+		setContextSessionID(c, p.SessionID)
+		setContextRole(c, p.Role)
+	}
+}
+
+// RequireRole allows request only when authorized user has one of the roles.
+// Must be used after CheckAuth.
+func (m *middlewares) RequireRole(roles ...model.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, readContextRole(c)) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
 	}
 }
 
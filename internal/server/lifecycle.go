@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// lifecycle shuts the app down gracefully. New orders are rejected first,
+// then in-flight requests and accrual jobs are given time to finish, each
+// within its own half of the deadline, and storage is closed at last.
+type lifecycle struct {
+	timeout  time.Duration
+	draining atomic.Bool
+
+	server  *http.Server // set on Start
+	poller  service.AccrualPoller
+	expirer service.PointsExpirer
+	holds   service.HoldsReleaser
+	storage storage.Storage
+}
+
+func newLifecycle(timeout time.Duration, poller service.AccrualPoller, expirer service.PointsExpirer, holds service.HoldsReleaser, storage storage.Storage) *lifecycle {
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+
+	return &lifecycle{
+		timeout: timeout,
+		poller:  poller,
+		expirer: expirer,
+		holds:   holds,
+		storage: storage,
+	}
+}
+
+// AcceptingOrders rejects new orders with 503 once shutdown has begun.
+func (l *lifecycle) AcceptingOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.draining.Load() {
+			// "server is shutting down"
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+	}
+}
+
+// waitShutdown waits for root context to be cancelled by os signal and then
+// shuts the app down.
+func (l *lifecycle) waitShutdown(root context.Context) error {
+	<-root.Done()
+
+	logger.Log.Info("Server caught os signal. Starting shutdown...",
+		zap.NamedError("cause", context.Cause(root)),
+		zap.Duration("deadline", l.timeout),
+	)
+
+	return l.shutdown()
+}
+
+func (l *lifecycle) shutdown() error {
+	// 1. stop accepting new orders
+	l.draining.Store(true)
+
+	// every stage has its own share of the deadline, so slow requests
+	// draining can't leave background workers no time to release their
+	// accrual jobs leases
+	stageTimeout := l.timeout / 2
+
+	var errs []error
+
+	// 2. let in-flight requests finish
+	if l.server != nil {
+		if err := withTimeout(stageTimeout, l.server.Shutdown); err != nil {
+			errs = append(errs, fmt.Errorf("server shutdown got error: %w", err))
+		}
+	}
+
+	// 3. let accrual jobs in flight save their results, workers are
+	// independent, so they are stopped at once
+	workers := []struct {
+		name string
+		stop func(ctx context.Context) error
+	}{
+		{"accrual poller", l.poller.Stop},
+		{"points expirer", l.expirer.Stop},
+		{"holds releaser", l.holds.Stop},
+	}
+
+	workerErrs := make([]error, len(workers))
+
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func(i int, name string, stop func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := withTimeout(stageTimeout, stop); err != nil {
+				workerErrs[i] = fmt.Errorf("%s stop got error: %w", name, err)
+			}
+		}(i, w.name, w.stop)
+	}
+	wg.Wait()
+
+	errs = append(errs, workerErrs...)
+
+	// 4. nobody uses storage anymore
+	if err := l.storage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("storage close got error: %w", err))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	logger.Log.Info("Server was stopped")
+
+	return nil
+}
+
+// withTimeout runs shutdown stage with its own deadline.
+func withTimeout(timeout time.Duration, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return f(ctx)
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+	"github.com/gin-gonic/gin"
+)
+
+// events records shutdown stages in the order they happened.
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, event)
+}
+
+func (e *events) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.list)
+}
+
+// fakeWorker is a background worker which calls onStop when stopped. Only
+// Start and Stop are implemented, the rest of the methods panic.
+type fakeWorker struct {
+	service.AccrualPoller
+	service.PointsExpirer
+	onStop func(ctx context.Context) error
+}
+
+func (w *fakeWorker) Start(ctx context.Context) error {
+	return nil
+}
+
+func (w *fakeWorker) Stop(ctx context.Context) error {
+	return w.onStop(ctx)
+}
+
+// fakeStorage records its Close.
+type fakeStorage struct {
+	storage.Storage
+	events *events
+}
+
+func (s *fakeStorage) Close() error {
+	s.events.add("storage")
+	return nil
+}
+
+func newTestLifecycle(ev *events, poller, expirer, holds func(ctx context.Context) error) *lifecycle {
+	return newLifecycle(time.Second*2,
+		&fakeWorker{onStop: poller},
+		&fakeWorker{onStop: expirer},
+		&fakeWorker{onStop: holds},
+		&fakeStorage{Storage: memory.New(), events: ev},
+	)
+}
+
+func TestLifecycleRejectsOrdersWhileDraining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var l *lifecycle
+
+	router := gin.New()
+	router.POST("/api/user/orders", func(c *gin.Context) {
+		l.AcceptingOrders()(c)
+		if !c.IsAborted() {
+			c.Status(http.StatusAccepted)
+		}
+	})
+
+	postOrder := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/user/orders", nil))
+		return w.Code
+	}
+
+	ev := &events{}
+	var drainingCode int
+	stop := func(ctx context.Context) error { return nil }
+	l = newTestLifecycle(ev, func(ctx context.Context) error {
+		drainingCode = postOrder()
+		return nil
+	}, stop, stop)
+
+	if code := postOrder(); code != http.StatusAccepted {
+		t.Fatalf("expected order to be accepted before shutdown, got %d", code)
+	}
+
+	if err := l.shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if drainingCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", drainingCode)
+	}
+	if code := postOrder(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after shutdown, got %d", code)
+	}
+}
+
+func TestLifecycleStagesOrder(t *testing.T) {
+	ev := &events{}
+
+	stop := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			ev.add(name)
+			return nil
+		}
+	}
+	l := newTestLifecycle(ev, stop("poller"), stop("expirer"), stop("holds"))
+
+	// request in flight must be finished before workers are stopped
+	started := make(chan struct{})
+	l.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(time.Millisecond * 100)
+			ev.add("request")
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = l.server.Serve(ln) }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	if err := l.shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	got := ev.get()
+	if len(got) != 5 {
+		t.Fatalf("expected 5 events, got %v", got)
+	}
+	if got[0] != "request" {
+		t.Errorf("expected in-flight request to finish first, got %v", got)
+	}
+	workers := slices.Clone(got[1:4])
+	slices.Sort(workers)
+	if !slices.Equal(workers, []string{"expirer", "holds", "poller"}) {
+		t.Errorf("expected all workers to be stopped after requests, got %v", got)
+	}
+	if got[4] != "storage" {
+		t.Errorf("expected storage to be closed last, got %v", got)
+	}
+}
+
+func TestLifecycleStopsWorkersConcurrently(t *testing.T) {
+	ev := &events{}
+
+	// every worker waits for the others to begin stopping, so sequential
+	// stopping would run out of the deadline
+	var begun sync.WaitGroup
+	begun.Add(3)
+	stop := func(ctx context.Context) error {
+		begun.Done()
+
+		all := make(chan struct{})
+		go func() {
+			begun.Wait()
+			close(all)
+		}()
+
+		select {
+		case <-all:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	l := newTestLifecycle(ev, stop, stop, stop)
+
+	if err := l.shutdown(); err != nil {
+		t.Fatalf("expected workers to be stopped at once, got %v", err)
+	}
+}
+
+func TestLifecycleClosesStorageOnStageError(t *testing.T) {
+	ev := &events{}
+
+	errStop := errors.New("stop failed")
+	stop := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			ev.add(name)
+			return err
+		}
+	}
+	l := newTestLifecycle(ev, stop("poller", errStop), stop("expirer", nil), stop("holds", nil))
+
+	err := l.shutdown()
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected stage error to be returned, got %v", err)
+	}
+
+	got := ev.get()
+	if len(got) != 4 || got[3] != "storage" {
+		t.Errorf("expected storage to be closed last, got %v", got)
+	}
+}
@@ -6,17 +6,24 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/config"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/server/handler"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/accrual"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/campaign"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/expiry"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/hold"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/referral"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service/tier"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/postgres"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -29,22 +36,39 @@ type server struct {
 	router  *gin.Engine
 	storage storage.Storage
 	accrual service.AccrualService
+	expirer service.PointsExpirer
+	tiers   service.TierLadder // nil when tiers are disabled
+
+	referrals service.ReferralProgram // nil when referral program is disabled
+
+	lifecycle *lifecycle
 }
 
-func New(cfg *config.Config, storage storage.Storage, accrual service.AccrualService) *server {
+func New(cfg *config.Config, storage storage.Storage, accrual service.AccrualService, expirer service.PointsExpirer, holds service.HoldsReleaser, tiers service.TierLadder, referrals service.ReferralProgram) *server {
 	s := &server{
-		cfg:     cfg,
-		storage: storage,
-		accrual: accrual,
+		cfg:       cfg,
+		storage:   storage,
+		accrual:   accrual,
+		expirer:   expirer,
+		tiers:     tiers,
+		referrals: referrals,
 	}
 
+	s.lifecycle = newLifecycle(
+		time.Duration(cfg.ShutdownTimeoutSec)*time.Second,
+		accrual.Poller(),
+		expirer,
+		holds,
+		storage,
+	)
+
 	s.configureRouter()
 
 	return s
 }
 
 func (s *server) configureRouter() {
-	h := handler.New(s.cfg, s.storage, s.accrual)
+	h := handler.New(s.cfg, s.storage, s.accrual, s.expirer, s.tiers, s.referrals)
 
 	gin.SetMode(s.cfg.GinMode)
 	s.router = gin.New()
@@ -57,21 +81,77 @@ func (s *server) configureRouter() {
 		h.Mids.Gzip(),
 	)
 
+	// public keys for auth tokens validation
+	s.router.GET("/.well-known/jwks.json", h.JWKS)
+
 	api := s.router.Group("/api")
 	{
 		// auth routes
 		api.POST("/user/register", h.Register)
 		api.POST("/user/login", h.Login)
+		api.POST("/user/token/refresh", h.Refresh)
 
 		// other routes which require auth token
 		user := api.Group("/user")
 		user.Use(h.Mids.CheckAuth())
 		{
-			user.POST("/orders", h.PostOrders)
+			user.POST("/orders", s.lifecycle.AcceptingOrders(), h.PostOrders)
 			user.GET("/orders", h.GetOrders)
 			user.GET("/balance", h.Balance)
 			user.POST("/balance/withdraw", h.Withdraw)
+			user.POST("/balance/transfer", h.Transfer)
+			user.POST("/balance/hold", h.Hold)
 			user.GET("/withdrawals", h.Withdrawals)
+			user.GET("/history", h.History)
+			user.POST("/withdrawals/:id/capture", h.Capture)
+			user.POST("/withdrawals/:id/void", h.Void)
+			user.GET("/tier", h.Tier)
+			user.GET("/referrals", h.Referrals)
+
+			// sessions
+			user.POST("/logout", h.Logout)
+			user.POST("/logout/all", h.LogoutAll)
+
+			// account
+			user.PUT("/password", h.ChangePassword)
+			user.DELETE("", h.DeleteAccount)
+		}
+
+		// support staff and admins routes
+		admin := api.Group("/admin")
+		admin.Use(h.Mids.CheckAuth(), h.Mids.RequireRole(model.RoleSupport, model.RoleAdmin))
+		{
+			adminOnly := h.Mids.RequireRole(model.RoleAdmin)
+
+			admin.GET("/users", h.AdminSearchUsers)
+			admin.GET("/users/:id", h.AdminGetUser)
+			admin.GET("/users/:id/orders", h.AdminUserOrders)
+			admin.GET("/users/:id/balance", h.AdminUserBalance)
+			admin.GET("/users/:id/withdrawals", h.AdminUserWithdrawals)
+			admin.GET("/users/:id/history", h.AdminUserHistory)
+			admin.PUT("/users/:id/role", adminOnly, h.AdminSetUserRole)
+			admin.POST("/users/:id/adjustments", h.AdminAdjustBalance)
+
+			admin.GET("/adjustments", h.AdminAdjustments)
+			admin.POST("/adjustments/:id/approve", adminOnly, h.AdminApproveAdjustment)
+			admin.POST("/adjustments/:id/reject", adminOnly, h.AdminRejectAdjustment)
+
+			admin.POST("/withdrawals/:id/reversals", h.AdminReverseWithdrawal)
+
+			admin.POST("/orders/:number/repoll", h.AdminRepollOrder)
+			admin.POST("/orders/:number/cancel", h.AdminCancelOrder)
+
+			// accrual service client state
+			admin.GET("/accrual/status", h.AdminAccrualStatus)
+
+			admin.GET("/campaigns", h.AdminCampaigns)
+			admin.GET("/campaigns/:id", h.AdminGetCampaign)
+			admin.POST("/campaigns", adminOnly, h.AdminCreateCampaign)
+			admin.PUT("/campaigns/:id", adminOnly, h.AdminUpdateCampaign)
+
+			admin.GET("/login-attempts", h.AdminLoginAttempts)
+			admin.GET("/login-lockouts", h.AdminLockouts)
+			admin.DELETE("/login-attempts", adminOnly, h.AdminClearLoginAttempts)
 		}
 	}
 }
@@ -93,11 +173,21 @@ func newDB(dsn string) (db *sql.DB, err error) {
 	return db, nil
 }
 
+// memoryDSNScheme selects in-memory storage when used as data source name.
+const memoryDSNScheme = "memory://"
+
+// isMemoryDSN reports if in-memory storage must be used. It is the case when
+// data source name is empty or has memory:// scheme.
+func isMemoryDSN(dsn string) bool {
+	return dsn == "" || strings.HasPrefix(dsn, memoryDSNScheme)
+}
+
 // ConfigureStorage creates new storage instance for
 // provided data source name (database url).
 func ConfigureStorage(dsn string) (storage.Storage, error) {
-	if dsn == "" {
-		return nil, errors.New("can't configure storage: empty data source name (database url)")
+	if isMemoryDSN(dsn) {
+		logger.Log.Warn("Using in-memory storage, all data will be lost on shutdown")
+		return memory.New(), nil
 	}
 
 	db, err := newDB(dsn)
@@ -109,7 +199,7 @@ func ConfigureStorage(dsn string) (storage.Storage, error) {
 }
 
 func Start(cfg *config.Config) (err error) {
-	if cfg.DatabaseDSN != "" {
+	if !isMemoryDSN(cfg.DatabaseDSN) {
 		err = postgres.RunMigrations(cfg.DatabaseDSN, cfg.VerboseMigrateLogger)
 		if err != nil {
 			return err
@@ -121,17 +211,58 @@ func Start(cfg *config.Config) (err error) {
 		return err
 	}
 
-	accrualService := accrual.New(cfg.AccrualSystemAddress, storage)
-	if err = accrualService.Poller().Start(); err != nil {
+	// root context is cancelled on shutdown signal, it stops all background
+	// work (accrual poller, points expirer, holds releaser)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err = grantAdmin(ctx, storage, cfg.AdminLogin); err != nil {
+		return err
+	}
+
+	tiers, err := newTierLadder(cfg)
+	if err != nil {
+		return err
+	}
+
+	referrals := newReferralProgram(cfg, storage)
+
+	accrualService := accrual.New(cfg.AccrualSystemAddress, storage, accrual.Options{
+		RateLimit: cfg.AccrualRateLimit,
+		Breaker: accrual.BreakerOptions{
+			FailureThreshold: cfg.BreakerFailureThreshold,
+			OpenTimeout:      time.Duration(cfg.BreakerOpenTimeoutSec) * time.Second,
+			SuccessThreshold: cfg.BreakerSuccessThreshold,
+		},
+		Tiers:     tiers,
+		Campaigns: campaign.New(),
+		Referrals: referrals,
+	})
+	if err = accrualService.Poller().Start(ctx); err != nil {
+		return err
+	}
+
+	expirer := expiry.New(storage, expiry.Options{
+		Months:   cfg.PointsExpiryMonths,
+		Soon:     time.Duration(cfg.PointsExpiringSoonDays) * time.Hour * 24,
+		Interval: time.Duration(cfg.PointsExpiryIntervalSec) * time.Second,
+	})
+	if err = expirer.Start(ctx); err != nil {
 		return err
 	}
 
-	server := New(cfg, storage, accrualService)
+	holds := hold.New(storage, time.Duration(cfg.HoldReleaseIntervalSec)*time.Second)
+	if err = holds.Start(ctx); err != nil {
+		return err
+	}
+
+	server := New(cfg, storage, accrualService, expirer, holds, tiers, referrals)
 
 	s := &http.Server{
 		Addr:    cfg.RunAddress,
 		Handler: server,
 	}
+	server.lifecycle.server = s
 
 	go func() {
 		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -139,26 +270,77 @@ func Start(cfg *config.Config) (err error) {
 		}
 	}()
 
-	return waitShutdown(s)
+	return server.lifecycle.waitShutdown(ctx)
+}
+
+// newTierLadder creates tiers ladder from config. Nil is returned when no
+// tiers are configured.
+func newTierLadder(cfg *config.Config) (service.TierLadder, error) {
+	tiers, err := model.ParseTiers(cfg.Tiers)
+	if err != nil || len(tiers) == 0 {
+		return nil, err
+	}
+
+	return tier.New(tier.Options{
+		Tiers:  tiers,
+		Basis:  cfg.TierBasis,
+		Window: time.Duration(cfg.TierWindowDays) * time.Hour * 24,
+	}), nil
 }
 
-func waitShutdown(s *http.Server) (err error) {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-quit
+// newReferralProgram creates referral program from config. Nil is returned
+// when no rewards are configured.
+func newReferralProgram(cfg *config.Config, storage storage.Storage) service.ReferralProgram {
+	if cfg.ReferrerReward == 0 && cfg.RefereeReward == 0 {
+		return nil
+	}
 
-	logger.Log.Info("Server caught os signal. Starting shutdown...",
-		zap.String("signal", sig.String()),
-	)
+	return referral.New(storage, referral.Options{
+		Rewards: model.ReferralRewards{
+			Referrer: model.Points(cfg.ReferrerReward) * model.PointsScale,
+			Referee:  model.Points(cfg.RefereeReward) * model.PointsScale,
+		},
+		MaxPerReferrer: cfg.ReferralMaxPerReferrer,
+		RejectSharedIP: cfg.ReferralRejectSharedIP,
+	})
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// grantAdmin gives admin role to the user with login unless some admin
+// already exists. It's the way to get the first admin, others can be
+// appointed through admin api then. Once there is an admin the login is
+// ignored, so whoever registers it later doesn't become admin on restart.
+// Unregistered login is an error, server must not run waiting for someone
+// to register it.
+func grantAdmin(ctx context.Context, s storage.Storage, login string) error {
+	if login == "" {
+		return nil
+	}
 
-	if err := s.Shutdown(ctx); err != nil {
-		return fmt.Errorf("server shutdown got error: %v", err)
+	admins, err := s.Users().Search(ctx, model.UsersFilter{Role: model.RoleAdmin, Limit: 1})
+	if err != nil {
+		return err
+	}
+
+	if len(admins) > 0 {
+		if admins[0].Login != login {
+			logger.Log.Warn("Admin already exists, admin login is ignored", zap.String("login", login))
+		}
+		return nil
+	}
+
+	user, err := s.Users().FindByLogin(ctx, login)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("admin user %q is not registered, register it before granting admin role", login)
+		}
+		return err
+	}
+
+	if err = s.Users().SetRole(ctx, user.ID, model.RoleAdmin); err != nil {
+		return err
 	}
 
-	logger.Log.Info("Server was stopped")
+	logger.Log.Info("Admin role granted", zap.String("login", user.Login))
 
 	return nil
 }
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+func mustCreateUser(t *testing.T, s storage.Storage, login string) int64 {
+	t.Helper()
+
+	id, err := s.Users().Create(context.Background(), model.User{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func userRole(t *testing.T, s storage.Storage, id int64) model.Role {
+	t.Helper()
+
+	user, err := s.Users().Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.Role
+}
+
+func TestGrantAdmin(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	if err := grantAdmin(ctx, s, ""); err != nil {
+		t.Fatalf("empty login must be skipped, got %v", err)
+	}
+
+	// server refuses to start until the login is registered
+	if err := grantAdmin(ctx, s, "root"); err == nil {
+		t.Fatal("expected error for unregistered admin login")
+	}
+
+	rootID := mustCreateUser(t, s, "root")
+	if err := grantAdmin(ctx, s, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if role := userRole(t, s, rootID); role != model.RoleAdmin {
+		t.Fatalf("expected admin role to be granted, got %q", role)
+	}
+
+	// restart with the same login changes nothing
+	if err := grantAdmin(ctx, s, "root"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGrantAdminOnlyWithoutAdmins(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	adminID := mustCreateUser(t, s, "admin")
+	if err := s.Users().SetRole(ctx, adminID, model.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// login configured later is ignored, even unregistered one
+	if err := grantAdmin(ctx, s, "intruder"); err != nil {
+		t.Fatalf("expected login to be ignored while admin exists, got %v", err)
+	}
+
+	intruderID := mustCreateUser(t, s, "intruder")
+	if err := grantAdmin(ctx, s, "intruder"); err != nil {
+		t.Fatal(err)
+	}
+	if role := userRole(t, s, intruderID); role == model.RoleAdmin {
+		t.Error("admin role must not be granted while another admin exists")
+	}
+}
@@ -3,16 +3,23 @@
 package accrual
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"time"
 
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/client"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/ratelimit"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/sync"
+	"go.uber.org/zap"
 )
 
 const (
@@ -20,6 +27,7 @@ const (
 	StatusInvalid    = "INVALID"    // заказ не принят к расчёту, и вознаграждение не будет начислено
 	StatusProcessing = "PROCESSING" // расчёт начисления в процессе
 	StatusProcessed  = "PROCESSED"  // расчёт начисления окончен
+	StatusCancelled  = "CANCELLED"  // заказ отменён магазином, начисление забрано обратно
 	StatusOrderNew   = "NEW"
 )
 
@@ -29,22 +37,48 @@ var (
 
 const DefaultMaxReq = 32
 
+// DefaultRetryAfter is used when accrual service responds with 429 without
+// proper Retry-After header.
+const DefaultRetryAfter = time.Second * 60
+
 // AccrualService implements AccrualService interface.
 type AccrualService struct {
 	client    *http.Client
 	semaphore *sync.Semaphore
+	limiter   *ratelimit.Limiter // shared by all accrual calls
+	breaker   *Breaker
 	poller    *Poller
 }
 
-func New(addr string, storage storage.Storage) *AccrualService {
+type Options struct {
+	// RateLimit is max number of requests per minute to accrual service, 0 -
+	// unlimited. Limit is adapted at runtime if accrual service tells another.
+	RateLimit int
+	Breaker   BreakerOptions
+
+	// Tiers multiply accruals of processed orders, nil - no multipliers
+	Tiers service.TierLadder
+	// Campaigns credit promotional bonuses for processed orders, optional
+	Campaigns service.CampaignEngine
+	// Referrals reward referrals completed by processed orders, optional
+	Referrals service.ReferralProgram
+}
+
+func New(addr string, storage storage.Storage, opts Options) *AccrualService {
 	pathGetOrderAccrual = addr + pathGetOrderAccrual
 
 	accrualService := &AccrualService{
 		client:    client.NewClientDefault(),
 		semaphore: sync.NewSemaphore(DefaultMaxReq),
+		limiter:   ratelimit.New(opts.RateLimit),
+		breaker:   NewBreaker(opts.Breaker),
 	}
 
-	accrualService.poller = NewPoller(accrualService, storage)
+	accrualService.poller = NewPoller(accrualService, storage, PollerOptions{
+		Tiers:     opts.Tiers,
+		Campaigns: opts.Campaigns,
+		Referrals: opts.Referrals,
+	})
 
 	return accrualService
 }
@@ -53,31 +87,82 @@ func (a *AccrualService) Poller() service.AccrualPoller {
 	return a.poller
 }
 
+// Status returns current state of the accrual service client.
+func (a *AccrualService) Status() model.AccrualStatus {
+	state, failures, openedAt := a.breaker.State()
+
+	status := model.AccrualStatus{
+		Breaker:   state.String(),
+		Failures:  failures,
+		RateLimit: a.limiter.Limit(),
+	}
+
+	if !openedAt.IsZero() {
+		status.OpenedAt = openedAt.Format(model.LayoutTimestamps)
+	}
+
+	if pausedUntil := a.limiter.PausedUntil(); pausedUntil.After(time.Now()) {
+		status.PausedUntil = pausedUntil.Format(model.LayoutTimestamps)
+	}
+
+	return status
+}
+
 // Order - получение информации о расчёте начислений баллов лояльности.
 //
 // GET {accrual_service}/api/orders/{number}
-func (a *AccrualService) Order(id model.OrderNumber) (accrual model.AccrualOrder, err error) {
-	a.semaphore.Acquire()
+func (a *AccrualService) Order(ctx context.Context, id model.OrderNumber) (accrual model.AccrualOrder, err error) {
+	if err = a.semaphore.Acquire(ctx); err != nil {
+		return accrual, err
+	}
 	defer a.semaphore.Release()
 
+	// wait first, so half-open breaker's probe slot isn't held for the
+	// whole rate limit wait
+	if err = a.limiter.Wait(ctx); err != nil {
+		return accrual, err
+	}
+
+	ticket, err := a.breaker.Allow()
+	if err != nil {
+		return accrual, model.NewRetriableError(err)
+	}
+
+	// accrual service is considered to be failing on network errors and 5xx
+	failed := true
+	defer func() {
+		if ctx.Err() != nil {
+			a.breaker.Cancel(ticket)
+			return
+		}
+		a.breaker.Done(ticket, !failed)
+	}()
+
 	url := pathGetOrderAccrual + string(id)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return accrual, fmt.Errorf("error preparing request: %w", err)
 	}
 
 	resp, err := a.client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			// cancelled, no need to retry
+			return accrual, fmt.Errorf("error while doing the request: %w", err)
+		}
 		return accrual, model.NewRetriableError(fmt.Errorf("error while doing the request: %w", err))
 	}
 	defer resp.Body.Close()
 
+	failed = resp.StatusCode >= http.StatusInternalServerError
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return accrual, fmt.Errorf("error reading response bytes: %w", err)
 	}
 
-	if len(body) > 0 {
+	// only 200 has json body, 429 responds with plain text
+	if resp.StatusCode == http.StatusOK && len(body) > 0 {
 		if err = json.Unmarshal(body, &accrual); err != nil {
 			return accrual, fmt.Errorf("error decoding response body: %w", err)
 		}
@@ -95,6 +180,7 @@ func (a *AccrualService) Order(id model.OrderNumber) (accrual model.AccrualOrder
 		// 429 - превышено количество запросов к сервису
 		case http.StatusTooManyRequests:
 			headerRetryAfter := resp.Header.Get("Retry-After")
+			a.throttle(headerRetryAfter, body)
 			err = model.NewRetriableError(fmt.Errorf(
 				"too many requests - status code: %s, order: %s, retry-after: %s, body: %s",
 				resp.Status, string(id), headerRetryAfter, string(body),
@@ -114,3 +200,57 @@ func (a *AccrualService) Order(id model.OrderNumber) (accrual model.AccrualOrder
 
 	return accrual, err
 }
+
+// throttle pauses all accrual calls for duration told by accrual service and
+// adapts rate limit when it's present in 429 response body.
+func (a *AccrualService) throttle(headerRetryAfter string, body []byte) {
+	retryAfter := parseRetryAfter(headerRetryAfter, time.Now())
+	a.limiter.Pause(retryAfter)
+
+	limit, ok := parseRateLimit(body)
+	if ok && limit != a.limiter.Limit() {
+		a.limiter.SetLimit(limit)
+		logger.Log.Info("Accrual service rate limit changed", zap.Int("rpm", limit))
+	}
+
+	logger.Log.Warn("Accrual service requests paused", zap.Duration("retry_after", retryAfter))
+}
+
+// parseRetryAfter parses Retry-After header value which is either delay in
+// seconds or http date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return DefaultRetryAfter
+	}
+
+	if sec, err := strconv.Atoi(value); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return DefaultRetryAfter
+}
+
+// reRateLimit matches accrual service's 429 response body.
+var reRateLimit = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// parseRateLimit extracts requests per minute limit from 429 response body.
+func parseRateLimit(body []byte) (limit int, ok bool) {
+	m := reRateLimit.FindSubmatch(body)
+	if m == nil {
+		return 0, false
+	}
+
+	limit, err := strconv.Atoi(string(m[1]))
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+
+	return limit, true
+}
@@ -0,0 +1,97 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/ratelimit"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/sync"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"missing", "", DefaultRetryAfter},
+		{"seconds", "60", time.Minute},
+		{"zero seconds", "0", 0},
+		{"negative seconds", "-5", DefaultRetryAfter},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"http date in the past", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"garbage", "soon", DefaultRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   int
+		wantOk bool
+	}{
+		{"accrual service body", "No more than 10 requests per minute allowed", 10, true},
+		{"surrounded by text", "error: No more than 1200 requests per minute allowed\n", 1200, true},
+		{"zero", "No more than 0 requests per minute allowed", 0, false},
+		{"other text", "Too Many Requests", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRateLimit([]byte(tt.body))
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseRateLimit(%q) = %d, %v, want %d, %v", tt.body, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+// 429 responds with plain text body, which must reach throttling instead of
+// failing json decoding.
+func TestOrderThrottledOnTooManyRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 5 requests per minute allowed"))
+	}))
+	defer srv.Close()
+
+	path := pathGetOrderAccrual
+	pathGetOrderAccrual = srv.URL + path
+	defer func() { pathGetOrderAccrual = path }()
+
+	a := &AccrualService{
+		client:    srv.Client(),
+		semaphore: sync.NewSemaphore(1),
+		limiter:   ratelimit.New(0),
+		breaker:   NewBreaker(BreakerOptions{}),
+	}
+
+	_, err := a.Order(context.Background(), "12345678903")
+	if err == nil {
+		t.Fatal("expected error on 429")
+	}
+
+	if got := a.limiter.Limit(); got != 5 {
+		t.Errorf("expected rate limit to be adapted to 5 rpm, got %d", got)
+	}
+
+	if until := a.limiter.PausedUntil(); time.Until(until) < 25*time.Second {
+		t.Errorf("expected calls to be paused for Retry-After, paused until %v", until)
+	}
+}
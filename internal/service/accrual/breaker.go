@@ -0,0 +1,190 @@
+package accrual
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ErrBreakerOpen is returned instead of calling accrual service while it's
+// considered to be down.
+var ErrBreakerOpen = errors.New("accrual service circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // calls are passed through
+	BreakerOpen                         // calls are rejected
+	BreakerHalfOpen                     // single probe calls are passed through
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerTicket identifies the call allowed by breaker. It tells the result
+// of the call allowed in the current state from a stale one, e.g. of a slow
+// call allowed while breaker was closed and finished when it's half-open.
+type BreakerTicket uint64
+
+type BreakerOptions struct {
+	FailureThreshold int           // consecutive failures to open the breaker
+	OpenTimeout      time.Duration // how long breaker stays open before probing
+	SuccessThreshold int           // consecutive successful probes to close the breaker
+}
+
+// Breaker is a circuit breaker guarding accrual service calls. It opens after
+// a number of consecutive failures, so calls are short-circuited. After open
+// timeout it lets probe calls through one at a time (half-open) and closes
+// again when enough of them succeed. Any failed probe opens it again.
+type Breaker struct {
+	mu   sync.Mutex
+	opts BreakerOptions
+
+	state      BreakerState
+	failures   int // consecutive failures, reset when breaker is closed
+	successes  int // consecutive successful probes in half-open state
+	probing    bool
+	openedAt   time.Time
+	generation uint64 // incremented on every state change, older tickets are stale
+}
+
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = time.Second * 10
+	}
+
+	if opts.SuccessThreshold <= 0 {
+		opts.SuccessThreshold = 1
+	}
+
+	return &Breaker{
+		opts: opts,
+	}
+}
+
+// Allow reports whether call may be done. ErrBreakerOpen is returned when it
+// must not. Every allowed call must be followed by Done or Cancel with the
+// returned ticket.
+func (b *Breaker) Allow() (ticket BreakerTicket, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.opts.OpenTimeout {
+			return ticket, ErrBreakerOpen
+		}
+		b.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return ticket, ErrBreakerOpen
+		}
+		b.probing = true
+	}
+
+	return BreakerTicket(b.generation), nil
+}
+
+// Done records result of the call allowed before. Result of the call allowed
+// in another state is ignored, so only the probe decides half-open breaker's
+// fate.
+func (b *Breaker) Done(ticket BreakerTicket, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ticket != BreakerTicket(b.generation) {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+
+		if !success {
+			b.failures++
+			b.setState(BreakerOpen)
+			return
+		}
+
+		b.successes++
+		if b.successes >= b.opts.SuccessThreshold {
+			b.failures = 0
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// Cancel releases the call allowed before without recording its result, used
+// when the call was cancelled by caller.
+func (b *Breaker) Cancel(ticket BreakerTicket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && ticket == BreakerTicket(b.generation) {
+		b.probing = false
+	}
+}
+
+// setState must be called with lock held.
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.Stringer("from", b.state),
+		zap.Stringer("to", state),
+		zap.Int("failures", b.failures),
+	}
+
+	b.state = state
+	b.generation++
+	b.successes = 0
+	b.probing = false
+
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+		logger.Log.Warn("Accrual service circuit breaker opened", append(fields,
+			zap.Duration("open_timeout", b.opts.OpenTimeout),
+		)...)
+		return
+	}
+
+	logger.Log.Info("Accrual service circuit breaker state changed", fields...)
+}
+
+// State returns current breaker state along with the time it was opened last.
+func (b *Breaker) State() (state BreakerState, failures int, openedAt time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state, b.failures, b.openedAt
+}
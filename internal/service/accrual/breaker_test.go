@@ -0,0 +1,160 @@
+package accrual
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func assertBreakerState(t *testing.T, b *Breaker, want BreakerState) {
+	t.Helper()
+
+	if state, _, _ := b.State(); state != want {
+		t.Fatalf("expected breaker to be %s, got %s", want, state)
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	b := NewBreaker(BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		SuccessThreshold: 2,
+	})
+
+	// closed: success resets failures counter
+	for _, success := range []bool{false, true, false} {
+		ticket, err := b.Allow()
+		if err != nil {
+			t.Fatalf("closed breaker rejected the call: %v", err)
+		}
+		b.Done(ticket, success)
+	}
+	assertBreakerState(t, b, BreakerClosed)
+
+	// closed -> open
+	ticket, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(ticket, false)
+	assertBreakerState(t, b, BreakerOpen)
+
+	if _, err := b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected open breaker to reject the call, got %v", err)
+	}
+
+	// open -> half-open after timeout, one probe at a time
+	time.Sleep(30 * time.Millisecond)
+
+	ticket, err = b.Allow()
+	if err != nil {
+		t.Fatalf("expected probe to be let through, got %v", err)
+	}
+	assertBreakerState(t, b, BreakerHalfOpen)
+
+	if _, err := b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected the second probe to be rejected, got %v", err)
+	}
+
+	// half-open -> closed after enough successful probes
+	b.Done(ticket, true)
+	assertBreakerState(t, b, BreakerHalfOpen)
+
+	ticket, err = b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(ticket, true)
+	assertBreakerState(t, b, BreakerClosed)
+
+	if _, failures, _ := b.State(); failures != 0 {
+		t.Errorf("expected failures to be reset on close, got %d", failures)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	b := NewBreaker(BreakerOptions{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+
+	ticket, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(ticket, false)
+	assertBreakerState(t, b, BreakerOpen)
+
+	_, _, openedAt := b.State()
+
+	time.Sleep(20 * time.Millisecond)
+
+	ticket, err = b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(ticket, false)
+	assertBreakerState(t, b, BreakerOpen)
+
+	if _, _, reopenedAt := b.State(); !reopenedAt.After(openedAt) {
+		t.Errorf("expected open timeout to start over, opened at %v, reopened at %v", openedAt, reopenedAt)
+	}
+}
+
+func TestBreakerCancelReleasesProbe(t *testing.T) {
+	b := NewBreaker(BreakerOptions{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+
+	ticket, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(ticket, false)
+
+	time.Sleep(20 * time.Millisecond)
+
+	ticket, err = b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelled probe doesn't count, the next one is let through
+	b.Cancel(ticket)
+	assertBreakerState(t, b, BreakerHalfOpen)
+
+	if _, err = b.Allow(); err != nil {
+		t.Errorf("expected probe slot to be released by cancel, got %v", err)
+	}
+}
+
+func TestBreakerIgnoresStaleCalls(t *testing.T) {
+	b := NewBreaker(BreakerOptions{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+
+	// slow call allowed while closed outlives the open state
+	slow, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Done(ticket, false)
+	assertBreakerState(t, b, BreakerOpen)
+
+	time.Sleep(20 * time.Millisecond)
+
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// slow call's result isn't taken as the probe's one
+	b.Done(slow, true)
+	assertBreakerState(t, b, BreakerHalfOpen)
+
+	b.Cancel(slow)
+	if _, err = b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected probe slot to be kept by the probe, got %v", err)
+	}
+
+	b.Done(probe, false)
+	assertBreakerState(t, b, BreakerOpen)
+}
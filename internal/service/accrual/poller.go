@@ -1,65 +1,210 @@
 package accrual
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/service"
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
-	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/retry"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// Poller polls accrual service for orders stored in the persistent jobs queue.
+// Jobs are leased before being processed, so several app instances can share
+// the same database without polling the same order twice.
 type Poller struct {
 	client  service.AccrualClient
 	storage storage.Storage
+	opts    PollerOptions
 
-	// currently tracked orders
-	orders *model.OrdersMap
+	// unique id of this poller instance, used as lease owner
+	workerID string
 
-	// order accrual results channel
-	accrualResults chan model.AccrualOrder
+	// number of jobs currently being processed
+	inFlight atomic.Int64
+
+	// wakes up the poller when new order was registered
+	wake chan struct{}
+
+	// stops claiming new jobs
+	stopClaiming context.CancelFunc
+	// cancels jobs in flight and stops heartbeat
+	cancelJobs context.CancelFunc
+
+	claimDone     chan struct{} // closed when claim loop exits
+	heartbeatDone chan struct{} // closed when heartbeat loop exits
+	jobs          sync.WaitGroup
+}
+
+type PollerOptions struct {
+	PollInterval      time.Duration           // how often queue is checked for due jobs
+	HeartbeatInterval time.Duration           // how often worker's leases are extended
+	Lease             time.Duration           // job lease duration
+	MaxInFlight       int                     // max jobs to be processed concurrently
+	RetryInterval     time.Duration           // initial delay between attempts
+	MaxRetryInterval  time.Duration           // delay between attempts won't grow further
+	Tiers             service.TierLadder      // multiplies accruals, optional
+	Campaigns         service.CampaignEngine  // credits promotional bonuses, optional
+	Referrals         service.ReferralProgram // rewards completed referrals, optional
 }
 
-func NewPoller(accrual service.AccrualClient, storage storage.Storage) *Poller {
+func NewPoller(accrual service.AccrualClient, storage storage.Storage, opts PollerOptions) *Poller {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = time.Second * 10
+	}
+
+	if opts.Lease <= opts.HeartbeatInterval {
+		// lease must survive at least a couple of missed heartbeats
+		opts.Lease = opts.HeartbeatInterval * 3
+	}
+
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = DefaultMaxReq
+	}
+
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Millisecond * 500
+	}
+
+	if opts.MaxRetryInterval < opts.RetryInterval {
+		opts.MaxRetryInterval = time.Second * 30
+	}
+
 	return &Poller{
-		client:         accrual,
-		storage:        storage,
-		accrualResults: make(chan model.AccrualOrder, 32),
+		client:        accrual,
+		storage:       storage,
+		opts:          opts,
+		workerID:      newWorkerID(),
+		wake:          make(chan struct{}, 1),
+		claimDone:     make(chan struct{}),
+		heartbeatDone: make(chan struct{}),
+	}
+}
+
+// newWorkerID generates unique poller id. Hostname is added to make it easier
+// to find out which instance holds a job.
+func newWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
 	}
+
+	return host + "-" + uuid.NewString()
 }
 
-func (p *Poller) Start() error {
-	logger.Log.Info("Starting accrual poller")
+// Start starts heartbeat and polling loops, both of them exit when ctx is done.
+func (p *Poller) Start(ctx context.Context) error {
+	logger.Log.Info("Starting accrual poller", zap.String("worker", p.workerID))
 
-	// fetch all new orders
-	orders, err := p.storage.Orders().GetByStatus(StatusOrderNew)
+	if err := p.storage.AccrualJobs().Heartbeat(ctx, p.workerID, p.opts.Lease); err != nil {
+		return fmt.Errorf("error starting accrual poller: %w", err)
+	}
+
+	// enqueue new orders which could have been missed (e.g. app crashed right
+	// after order was created)
+	count, err := p.storage.AccrualJobs().EnqueueByStatus(ctx, StatusOrderNew)
 	if err != nil {
 		return fmt.Errorf("error starting accrual poller: %w", err)
 	}
 
-	p.orders = model.NewOrdersMap(len(orders))
-	for _, order := range orders {
-		p.orders.Set(order)
+	if count > 0 {
+		logger.Log.Info("Enqueued untracked new orders", zap.Int64("count", count))
+	}
+
+	claimCtx, stopClaiming := context.WithCancel(ctx)
+
+	// jobs in flight must outlive ctx to be able to save their results on
+	// shutdown, they are cancelled by Stop when drain deadline is exceeded
+	jobsCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+
+	p.stopClaiming = stopClaiming
+	p.cancelJobs = cancelJobs
+
+	go p.heartbeat(jobsCtx)
+	go p.run(claimCtx, jobsCtx)
+
+	return nil
+}
+
+// releaseShare is the part (1/releaseShare) of Stop deadline reserved for
+// releasing leases of the jobs left unfinished.
+const releaseShare = 5
+
+// Stop stops claiming new jobs and waits for jobs in flight to finish until
+// ctx is done, unfinished jobs are cancelled then. Leases of the jobs still
+// held by the poller are released, so other instances can claim them right
+// away. Release is done within ctx as well, a part of its deadline is
+// reserved for it.
+func (p *Poller) Stop(ctx context.Context) error {
+	if p.stopClaiming == nil {
+		// wasn't started
+		return nil
+	}
+
+	p.stopClaiming()
+	<-p.claimDone
+
+	drainCtx, cancel := context.WithCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		reserve := time.Until(deadline) / releaseShare
+		drainCtx, cancel = context.WithDeadline(ctx, deadline.Add(-reserve))
+	}
+	defer cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		p.jobs.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		logger.Log.Info("Accrual poller drained", zap.String("worker", p.workerID))
+	case <-drainCtx.Done():
+		logger.Log.Warn("Accrual poller drain deadline exceeded, cancelling jobs in flight",
+			zap.String("worker", p.workerID),
+			zap.Int64("in_flight", p.inFlight.Load()),
+		)
+		p.cancelJobs()
+		<-drained
 	}
 
-	go p.process(p.accrualResults)
+	p.cancelJobs()
+	<-p.heartbeatDone
 
-	// ask accrual service
-	for _, order := range p.orders.GetAll() {
-		go p.askAccrualService(order.ID, p.accrualResults)
+	orders, err := p.storage.AccrualJobs().Release(ctx, p.workerID)
+	if err != nil {
+		// leases will expire and the jobs will be claimed again anyway
+		return fmt.Errorf("error releasing accrual jobs: %w", err)
 	}
 
-	go p.checkFailedOrdersTicker()
+	if len(orders) > 0 {
+		logger.Log.Warn("Accrual jobs left unfinished",
+			zap.String("worker", p.workerID),
+			zap.Int("count", len(orders)),
+			zap.Any("orders", orders),
+		)
+	}
+
+	logger.Log.Info("Accrual poller was stopped", zap.String("worker", p.workerID))
 
 	return nil
 }
 
-func (p *Poller) RegisterNewOrder(orderNumber model.OrderNumber) error {
-	order, err := p.storage.Orders().Get(orderNumber)
+func (p *Poller) RegisterNewOrder(ctx context.Context, orderNumber model.OrderNumber) error {
+	order, err := p.storage.Orders().Get(ctx, orderNumber)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			// must never happen
@@ -75,166 +220,279 @@ func (p *Poller) RegisterNewOrder(orderNumber model.OrderNumber) error {
 		return nil
 	}
 
-	p.orders.Set(*order)
+	if err = p.storage.AccrualJobs().Enqueue(ctx, order.ID); err != nil {
+		return err
+	}
 
-	go p.askAccrualService(order.ID, p.accrualResults)
+	// don't wait for the next tick
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
 
 	return nil
 }
 
-// process handles final response state from accrual service.
-// Orders only must go in channel with StatusProcessed or StatusInvalid.
-func (p *Poller) process(accrualOrders <-chan model.AccrualOrder) {
-	for accrual := range accrualOrders {
-		order, ok := p.orders.Get(accrual.OrderID)
-		if !ok {
-			continue
-		}
+// ErrOrderFinal is returned on attempt to repoll order which accrual is
+// already calculated.
+var ErrOrderFinal = errors.New("order accrual status is already final")
+
+// Repoll makes stuck order to be polled right away regardless of its retry
+// backoff. Order's job is queued again if it's missing.
+func (p *Poller) Repoll(ctx context.Context, orderNumber model.OrderNumber) error {
+	order, err := p.storage.Orders().Get(ctx, orderNumber)
+	if err != nil {
+		return err
+	}
+
+	if p.isStatusFinal(order.Status) {
+		return ErrOrderFinal
+	}
+
+	if err = p.storage.AccrualJobs().Reschedule(ctx, order.ID); err != nil {
+		return err
+	}
+
+	logger.Log.Info("Order accrual polling was retriggered", zap.String("order", string(order.ID)))
+
+	// don't wait for the next tick
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// heartbeat keeps leases of the jobs held by this worker alive.
+func (p *Poller) heartbeat(ctx context.Context) {
+	defer close(p.heartbeatDone)
 
-		// update info in tracker map
-		order.Status = accrual.Status
-		order.Accrual = accrual.Accrual
-		p.orders.Set(order)
+	ticker := time.NewTicker(p.opts.HeartbeatInterval)
+	defer ticker.Stop()
 
-		processedAt, ok := p.updateProcessedOrders(order)
-		if !ok {
-			continue
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
 		}
 
-		// set order status and accrual value in db
-		/* ts, err := p.storage.Orders().SetProcessedStatus(order.ID, order.Status, order.Accrual)
-		if err != nil {
-			logger.Log.Error("Error changing order status", zap.Error(err),
-				zap.String("order", string(order.ID)),
-				zap.String("status", order.Status),
-				zap.Float64("accrual", order.Accrual),
+		if err := p.storage.AccrualJobs().Heartbeat(ctx, p.workerID, p.opts.Lease); err != nil {
+			logger.Log.Error("Accrual poller heartbeat failed", zap.Error(err),
+				zap.String("worker", p.workerID),
 			)
-			// failed orders will be tried again in checkFailedOrdersTicker()
-			continue
 		}
+	}
+}
 
-		if order.Status == StatusProcessed {
-			// add earned points to user's balance
-			_, err = p.storage.Balance().Add(order.Accrual, order.UserID)
-			if err != nil {
-				logger.Log.Error("Error changing user balance", zap.Error(err),
-					zap.String("order", string(order.ID)),
-					zap.String("status", order.Status),
-					zap.Float64("accrual", order.Accrual),
-				)
-				// failed orders will be tried again in checkFailedOrdersTicker()
-				continue
-			}
-		} */
+// run claims due jobs from the queue and processes them within jobsCtx.
+// Claiming stops when ctx is done.
+func (p *Poller) run(ctx, jobsCtx context.Context) {
+	defer close(p.claimDone)
 
-		// stop tracking order
-		p.orders.Delete(order.ID)
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
 
-		logger.Log.Info("Order processed successfuly",
-			zap.String("order", string(order.ID)),
-			zap.String("status", order.Status),
-			zap.Float64("accrual", order.Accrual),
-			zap.Time("processed_at", processedAt),
-		)
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		p.claim(ctx, jobsCtx)
 	}
 }
 
-// updateProcessedOrders sets order status and accrual value in db, and also
-// updates user's balance if approved.
-func (p *Poller) updateProcessedOrders(order model.Order) (processedAt time.Time, ok bool) {
-	// set order status and accrual value in db
-	processedAt, err := p.storage.Orders().SetProcessedStatus(order.ID, order.Status, order.Accrual)
+// claim leases as many due jobs as there are free processing slots.
+func (p *Poller) claim(ctx, jobsCtx context.Context) {
+	limit := p.opts.MaxInFlight - int(p.inFlight.Load())
+	if limit <= 0 {
+		return
+	}
+
+	jobs, err := p.storage.AccrualJobs().Claim(ctx, p.workerID, limit, p.opts.Lease)
 	if err != nil {
-		logger.Log.Error("Error changing order status", zap.Error(err),
-			zap.String("order", string(order.ID)),
-			zap.String("status", order.Status),
-			zap.Float64("accrual", order.Accrual),
+		logger.Log.Error("Error claiming accrual jobs", zap.Error(err),
+			zap.String("worker", p.workerID),
 		)
-		// failed orders will be tried again in checkFailedOrdersTicker()
-		return processedAt, false
+		return
 	}
 
-	if order.Status == StatusProcessed {
-		// add earned points to user's balance
-		_, err = p.storage.Balance().Add(order.Accrual, order.UserID)
-		if err != nil {
-			logger.Log.Error("Error changing user balance", zap.Error(err),
-				zap.String("order", string(order.ID)),
-				zap.String("status", order.Status),
-				zap.Float64("accrual", order.Accrual),
-			)
-			// failed orders will be tried again in checkFailedOrdersTicker()
-			return processedAt, false
-		}
+	for _, job := range jobs {
+		p.jobs.Add(1)
+		p.inFlight.Add(1)
+		go func(job model.AccrualJob) {
+			defer p.jobs.Done()
+			defer p.inFlight.Add(-1)
+			p.process(jobsCtx, job)
+		}(job)
 	}
+}
 
-	return processedAt, true
+// process asks accrual service for the order once. Final result is saved to
+// db, otherwise the job is rescheduled.
+func (p *Poller) process(ctx context.Context, job model.AccrualJob) {
+	result, err := p.client.Order(ctx, job.OrderID)
+	if err == nil && !p.isStatusFinal(result.Status) {
+		err = model.NewRetriableError(fmt.Errorf("got retriable order accrual status: %s", result.Status))
+	}
+
+	if err != nil {
+		p.retry(ctx, job, err)
+		return
+	}
+
+	order := model.Order{
+		ID:      job.OrderID,
+		UserID:  job.UserID,
+		Status:  result.Status,
+		Accrual: result.Accrual,
+	}
+
+	processedAt, ok := p.updateProcessedOrders(ctx, order)
+	if !ok {
+		// failed orders will be tried again later
+		p.retry(ctx, job, errors.New("failed saving order accrual result"))
+		return
+	}
+
+	logger.Log.Info("Order processed successfuly",
+		zap.String("order", string(order.ID)),
+		zap.String("status", order.Status),
+		zap.Stringer("accrual", order.Accrual),
+		zap.Time("processed_at", processedAt),
+		zap.Int("attempts", job.Attempts),
+	)
 }
 
-func (p *Poller) checkFailedOrdersTicker() {
-	ticker := time.NewTicker(time.Second * 3)
-	for range ticker.C {
-		for _, order := range p.orders.GetAll() {
-			// get only processed orders that failed
-			if order.Status == StatusOrderNew {
-				continue
-			}
+// retry schedules next job attempt.
+func (p *Poller) retry(ctx context.Context, job model.AccrualJob, cause error) {
+	if ctx.Err() != nil {
+		// job was cancelled on shutdown, its lease is released by Stop
+		return
+	}
 
-			processedAt, ok := p.updateProcessedOrders(order)
-			if !ok {
-				// try again later on next tick
-				continue
-			}
+	delay := p.backoff(job.Attempts)
 
-			// stop tracking order
-			p.orders.Delete(order.ID)
+	logger.Log.Debug("Accrual job rescheduled", zap.Error(cause),
+		zap.String("order", string(job.OrderID)),
+		zap.Int("attempt", job.Attempts),
+		zap.Duration("delay", delay),
+	)
 
-			logger.Log.Info("Order processed successfuly (after retry on ticker checker)",
-				zap.String("order", string(order.ID)),
-				zap.String("status", order.Status),
-				zap.Float64("accrual", order.Accrual),
-				zap.Time("processed_at", processedAt),
-			)
+	if err := p.storage.AccrualJobs().Retry(ctx, job.OrderID, p.workerID, delay, cause.Error()); err != nil {
+		// lease will expire and the job will be claimed again anyway
+		logger.Log.Error("Error rescheduling accrual job", zap.Error(err),
+			zap.String("order", string(job.OrderID)),
+		)
+	}
+}
+
+// backoff decides what duration till next attempt should be waited.
+func (p *Poller) backoff(attempt int) time.Duration {
+	delay := p.opts.RetryInterval
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.opts.MaxRetryInterval {
+			return p.opts.MaxRetryInterval
 		}
 	}
+
+	return delay
 }
 
-// askAccrualService registers new order in accrual service and starts asking it
-// waiting for final accrual status.
-func (p *Poller) askAccrualService(order model.OrderNumber, accruals chan<- model.AccrualOrder) {
-	var (
-		err    error
-		result model.AccrualOrder
-	)
+// updateProcessedOrders sets order status and accrual value in db, updates
+// user's balance if approved and stops tracking the order. All changes are
+// done atomically, so the order is never left processed without points being
+// credited.
+func (p *Poller) updateProcessedOrders(ctx context.Context, order model.Order) (processedAt time.Time, ok bool) {
+	err := p.storage.InTx(ctx, func(tx storage.Storage) (err error) {
+		// set order status and accrual value in db
+		processedAt, err = tx.Orders().SetProcessedStatus(ctx, order.ID, order.Status, order.Accrual)
+		if err != nil {
+			return fmt.Errorf("error changing order status: %w", err)
+		}
+
+		if order.Status == StatusProcessed {
+			if err = p.credit(ctx, tx, order); err != nil {
+				return err
+			}
+		}
 
-	retrier := retry.NewRetrier(retry.RetrierOptions{
-		RetryAny: true,
-		Infinite: true,
+		// stop tracking order
+		if err = tx.AccrualJobs().Complete(ctx, order.ID); err != nil {
+			return fmt.Errorf("error completing accrual job: %w", err)
+		}
+
+		return nil
 	})
+	if err != nil {
+		logger.Log.Error("Error saving order accrual result", zap.Error(err),
+			zap.String("order", string(order.ID)),
+			zap.String("status", order.Status),
+			zap.Stringer("accrual", order.Accrual),
+		)
+		return processedAt, false
+	}
+
+	return processedAt, true
+}
 
-	// retrier will run till final status retrieved
-	if err = retrier.Do("ask accrual", func() (cErr error) {
-		result, cErr = p.client.Order(order)
-		if cErr != nil {
-			return cErr
+// credit adds order's accrual to user's balance along with tier and
+// campaigns bonuses and completes user's referral. Tier is determined before
+// the accrual is credited, so the order itself doesn't count towards the tier
+// it's multiplied by. Must be called within transaction.
+func (p *Poller) credit(ctx context.Context, tx storage.Storage, order model.Order) error {
+	var tier model.TierStatus
+	if p.opts.Tiers != nil {
+		var err error
+		if tier, err = p.opts.Tiers.Status(ctx, tx.Balance(), order.UserID); err != nil {
+			return fmt.Errorf("error getting user tier: %w", err)
 		}
+	}
 
-		if p.isStatusFinal(result.Status) {
+	// add earned points to user's balance
+	_, err := tx.Balance().Accrue(ctx, order.ID, order.Accrual, order.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrDuplicateEntry) {
+			// was already credited by previous attempt, bonuses too
 			return nil
 		}
+		return fmt.Errorf("error changing user balance: %w", err)
+	}
 
-		return model.NewRetriableError(fmt.Errorf("got retriable order accrual status: %s", result.Status))
-	}); err != nil {
-		logger.Log.Error("Retry finished with error", zap.Error(err))
-		return
+	if bonus := tier.Bonus(order.Accrual); bonus > 0 {
+		err = tx.Balance().AccrueBonus(ctx, order.ID, order.UserID, model.OrderBonus{
+			Source: model.BonusTier,
+			Ref:    tier.Tier,
+			Amount: bonus,
+		})
+		if err != nil {
+			return fmt.Errorf("error crediting tier bonus: %w", err)
+		}
+	}
+
+	if p.opts.Campaigns != nil {
+		if _, err = p.opts.Campaigns.Apply(ctx, tx, order, tier.Tier); err != nil {
+			return fmt.Errorf("error applying campaigns: %w", err)
+		}
 	}
 
-	accruals <- result
+	if p.opts.Referrals != nil {
+		if err = p.opts.Referrals.Reward(ctx, tx, order); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// isStatusFinal returns true when retry calls must be stopped.
+// isStatusFinal returns true when polling of the order must be stopped.
 func (p *Poller) isStatusFinal(s string) bool {
-	if s == StatusProcessed || s == StatusInvalid {
+	if s == StatusProcessed || s == StatusInvalid || s == StatusCancelled {
 		return true
 	}
 
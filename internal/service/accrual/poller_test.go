@@ -0,0 +1,318 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/ratelimit"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/sync"
+)
+
+const testOrder model.OrderNumber = "12345678903"
+
+// accrualStub is accrual service responding with the set status code and
+// order accrual.
+type accrualStub struct {
+	code    int
+	status  string
+	accrual string
+}
+
+func (a *accrualStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.code != http.StatusOK {
+		w.WriteHeader(a.code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"order":   strings.TrimPrefix(r.URL.Path, "/api/orders/"),
+		"status":  a.status,
+		"accrual": json.Number(a.accrual),
+	})
+}
+
+// newTestPoller creates poller asking accrual stub for orders.
+func newTestPoller(t *testing.T, a *accrualStub, s storage.Storage, opts PollerOptions) *Poller {
+	t.Helper()
+
+	srv := httptest.NewServer(a)
+	t.Cleanup(srv.Close)
+
+	path := pathGetOrderAccrual
+	pathGetOrderAccrual = srv.URL + path
+	t.Cleanup(func() { pathGetOrderAccrual = path })
+
+	client := &AccrualService{
+		client:    srv.Client(),
+		semaphore: sync.NewSemaphore(1),
+		limiter:   ratelimit.New(0),
+		breaker:   NewBreaker(BreakerOptions{}),
+	}
+
+	return NewPoller(client, s, opts)
+}
+
+// mustCreateOrder creates user's new order and queues its job.
+func mustCreateOrder(t *testing.T, s storage.Storage) int64 {
+	t.Helper()
+
+	ctx := context.Background()
+
+	userID, err := s.Users().Create(ctx, model.User{Login: "user", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Orders().Create(ctx, model.Order{ID: testOrder, UserID: userID, Status: StatusOrderNew}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.AccrualJobs().Enqueue(ctx, testOrder); err != nil {
+		t.Fatal(err)
+	}
+
+	return userID
+}
+
+func mustClaim(t *testing.T, s storage.Storage, workerID string, lease time.Duration) model.AccrualJob {
+	t.Helper()
+
+	jobs, err := s.AccrualJobs().Claim(context.Background(), workerID, 1, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 {
+		t.Fatalf("expected job to be claimed by %s, got %d jobs", workerID, len(jobs))
+	}
+
+	return jobs[0]
+}
+
+func claimable(t *testing.T, s storage.Storage) bool {
+	t.Helper()
+
+	jobs, err := s.AccrualJobs().Claim(context.Background(), "other", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(jobs) > 0
+}
+
+func assertBalance(t *testing.T, s storage.Storage, userID int64, want string) {
+	t.Helper()
+
+	balance, err := s.Balance().Get(context.Background(), userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal(err)
+	}
+
+	if balance.Balance.String() != want {
+		t.Errorf("expected balance %s, got %s", want, balance.Balance)
+	}
+}
+
+func assertOrderStatus(t *testing.T, s storage.Storage, want string) {
+	t.Helper()
+
+	order, err := s.Orders().Get(context.Background(), testOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != want {
+		t.Errorf("expected order status %s, got %s", want, order.Status)
+	}
+}
+
+func TestPollerCreditsOnce(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	// failed attempt would be due again right away
+	p := newTestPoller(t, &accrualStub{code: http.StatusOK, status: StatusProcessed, accrual: "100"}, s, PollerOptions{
+		RetryInterval: time.Nanosecond,
+	})
+
+	userID := mustCreateOrder(t, s)
+
+	p.process(ctx, mustClaim(t, s, p.workerID, time.Minute))
+
+	assertOrderStatus(t, s, StatusProcessed)
+	assertBalance(t, s, userID, "100")
+	if claimable(t, s) {
+		t.Fatal("expected job to be completed")
+	}
+
+	// the same order delivered again, e.g. repolled by admin or processed by
+	// another instance which has lost the lease
+	if err := s.AccrualJobs().Reschedule(ctx, testOrder); err != nil {
+		t.Fatal(err)
+	}
+
+	p.process(ctx, mustClaim(t, s, p.workerID, time.Minute))
+
+	assertBalance(t, s, userID, "100")
+	if claimable(t, s) {
+		t.Error("expected re-delivered job to be completed")
+	}
+
+	order := model.Order{ID: testOrder, UserID: userID, Status: StatusProcessed, Accrual: 100 * model.PointsScale}
+	if _, ok := p.updateProcessedOrders(ctx, order); !ok {
+		t.Error("expected already credited order to be saved without error")
+	}
+	assertBalance(t, s, userID, "100")
+}
+
+// campaignsStub fails to apply campaigns.
+type campaignsStub struct{}
+
+func (campaignsStub) Apply(ctx context.Context, tx storage.Storage, order model.Order, tier string) ([]model.OrderBonus, error) {
+	return nil, errors.New("campaigns unavailable")
+}
+
+func TestPollerRequeuesOnFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int
+		status    string
+		campaigns bool
+		lastError string
+	}{
+		{name: "accrual service error", code: http.StatusInternalServerError, lastError: "internal server error"},
+		{name: "order not registered", code: http.StatusNoContent, lastError: "order was not registered"},
+		{name: "status isn't final", code: http.StatusOK, status: StatusProcessing, lastError: "retriable order accrual status"},
+		{name: "result isn't saved", code: http.StatusOK, status: StatusProcessed, campaigns: true, lastError: "failed saving order accrual result"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := memory.New()
+
+			opts := PollerOptions{RetryInterval: time.Hour}
+			if tt.campaigns {
+				opts.Campaigns = campaignsStub{}
+			}
+			p := newTestPoller(t, &accrualStub{code: tt.code, status: tt.status, accrual: "100"}, s, opts)
+
+			userID := mustCreateOrder(t, s)
+
+			p.process(ctx, mustClaim(t, s, p.workerID, time.Minute))
+
+			// nothing is saved partially
+			assertOrderStatus(t, s, StatusOrderNew)
+			assertBalance(t, s, userID, "0")
+
+			// lease is released, but the next attempt isn't due yet
+			if claimable(t, s) {
+				t.Fatal("expected job to be delayed by backoff")
+			}
+
+			if err := s.AccrualJobs().Reschedule(ctx, testOrder); err != nil {
+				t.Fatal(err)
+			}
+
+			job := mustClaim(t, s, "other", time.Minute)
+			if job.Attempts != 2 {
+				t.Errorf("expected the second attempt, got %d", job.Attempts)
+			}
+			if !strings.Contains(job.LastError, tt.lastError) {
+				t.Errorf("expected last error to contain %q, got %q", tt.lastError, job.LastError)
+			}
+		})
+	}
+}
+
+// jobsStub fails worker's heartbeat when asked to.
+type jobsStub struct {
+	storage.AccrualJobsRepository
+	failHeartbeat atomic.Bool
+}
+
+func (j *jobsStub) Heartbeat(ctx context.Context, workerID string, lease time.Duration) error {
+	if j.failHeartbeat.Load() {
+		return errors.New("heartbeat failed")
+	}
+
+	return j.AccrualJobsRepository.Heartbeat(ctx, workerID, lease)
+}
+
+type storageStub struct {
+	storage.Storage
+	jobs *jobsStub
+}
+
+func (s *storageStub) AccrualJobs() storage.AccrualJobsRepository {
+	return s.jobs
+}
+
+func TestPollerLosesLeaseOnHeartbeatMiss(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New()
+	s := &storageStub{Storage: mem, jobs: &jobsStub{AccrualJobsRepository: mem.AccrualJobs()}}
+
+	const lease = time.Millisecond * 100
+
+	// poller doesn't claim by itself, the job is claimed on its behalf
+	p := newTestPoller(t, &accrualStub{code: http.StatusOK, status: StatusProcessed, accrual: "100"}, s, PollerOptions{
+		PollInterval:      time.Hour,
+		HeartbeatInterval: time.Millisecond * 10,
+		Lease:             lease,
+		RetryInterval:     time.Hour,
+	})
+
+	userID := mustCreateOrder(t, s)
+
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := p.Stop(stopCtx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	job := mustClaim(t, s, p.workerID, lease)
+
+	// heartbeat keeps the lease alive past its initial duration
+	time.Sleep(lease * 3)
+	if claimable(t, s) {
+		t.Fatal("expected lease to be extended by heartbeat")
+	}
+
+	s.jobs.failHeartbeat.Store(true)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !claimable(t, s) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected lease to expire without heartbeat")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// the job now belongs to another worker, poller's late failure doesn't
+	// take it back
+	p.retry(ctx, job, errors.New("late failure"))
+	if claimable(t, s) {
+		t.Error("expected another worker's lease to be kept")
+	}
+
+	// nor is it credited twice when both finish it
+	p.process(ctx, job)
+	p.process(ctx, job)
+	assertBalance(t, s, userID, "100")
+}
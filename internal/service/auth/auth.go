@@ -2,65 +2,183 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/google/uuid"
 )
 
-const defaultTokenLifetime = time.Hour
+const (
+	defaultTokenLifetime        = time.Minute * 15
+	defaultRefreshTokenLifetime = time.Hour * 24 * 30
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
 
 // authService implements jwt auth tokens signing and validation,
 // and passwords hashing. Implements AuthService interface.
 type authService struct {
-	// secret key for tokens to be signed with
-	secretKey []byte
+	// keys for tokens to be signed with
+	keys *Keyring
+
+	// new passwords are hashed with the current hasher
+	passwords *passwords
 
-	// token lifetime duration until expiration
+	// new passwords must meet the policy
+	policy PasswordPolicy
+
+	// access token lifetime duration until expiration
 	expiry time.Duration
+
+	// session lifetime, it's prolonged on every refresh
+	refreshExpiry time.Duration
 }
 
-func New(secretKey string, expiry time.Duration) *authService {
+func New(keys *Keyring, hasher Hasher, policy PasswordPolicy, expiry, refreshExpiry time.Duration) *authService {
 	if expiry <= 0 {
 		expiry = defaultTokenLifetime
 	}
 
+	if refreshExpiry <= 0 {
+		refreshExpiry = defaultRefreshTokenLifetime
+	}
+
 	return &authService{
-		secretKey: []byte(secretKey),
-		expiry:    expiry,
+		keys:          keys,
+		passwords:     newPasswords(hasher),
+		policy:        policy,
+		expiry:        expiry,
+		refreshExpiry: refreshExpiry,
 	}
 }
 
-const maxPasswordLength = 72 // limited by bcrypt
-
 // MaxPasswordLength returns max password length, which sometimes can be
 // limited (like in bcrypt).
 func (a *authService) MaxPasswordLength() int {
-	return maxPasswordLength
+	return a.passwords.current.MaxPasswordLength()
+}
+
+// ValidatePassword checks if new password meets the password policy.
+// PolicyError describing violation is returned when it doesn't.
+func (a *authService) ValidatePassword(login, password string) error {
+	return a.policy.validate(login, password, a.MaxPasswordLength())
+}
+
+// PasswordHash calculates hash for password with the current hasher.
+func (a *authService) PasswordHash(password string) (hash string, err error) {
+	return a.passwords.current.Hash(password)
+}
+
+// CheckPasswordHash compares password and its expected hash. Hash might be
+// calculated by any known hasher.
+func (a *authService) CheckPasswordHash(hash, password string) (err error) {
+	return a.passwords.Verify(hash, password)
+}
+
+// NeedsRehash reports if hash must be replaced, because it was calculated
+// by another hasher or with weaker parameters.
+func (a *authService) NeedsRehash(hash string) bool {
+	return a.passwords.NeedsRehash(hash)
 }
 
 // Claims - jwt fields used as auth claims.
 // FIXME: might move auth code somewhere else...
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID int64 `json:"user_id"`
+	UserID    int64      `json:"user_id"`
+	SessionID uuid.UUID  `json:"sid"`
+	Role      model.Role `json:"role,omitempty"`
+
+	// action confirmation tokens are only good for the action,
+	// empty for access tokens
+	Action string `json:"act,omitempty"`
+}
+
+// CreateToken creates new jwt access token for user's session. Token is
+// signed with the current keyring key, its id is set as kid header.
+func (a *authService) CreateToken(ctx context.Context, p model.Principal) (string, error) {
+	return a.sign(ctx, Claims{
+		UserID:    p.UserID,
+		SessionID: p.SessionID,
+		Role:      p.Role,
+	}, a.expiry)
+}
+
+// ParseToken parses and validates the token. Token is verified with the
+// keyring key its kid header points to.
+func (a *authService) ParseToken(ctx context.Context, tokenString string) (p model.Principal, err error) {
+	claims, err := a.parse(ctx, tokenString)
+	if err != nil {
+		return p, err
+	}
+
+	if claims.Action != "" {
+		return p, errors.New("not an access token")
+	}
+
+	p = model.Principal{
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+		Role:      claims.Role,
+	}
+
+	// tokens issued before roles were introduced
+	if p.Role == "" {
+		p.Role = model.RoleUser
+	}
+
+	return p, nil
+}
+
+// CreateActionToken creates short-lived token confirming user's intention to
+// perform the action from the session.
+func (a *authService) CreateActionToken(ctx context.Context, userID int64, sessionID uuid.UUID, action string, ttl time.Duration) (string, error) {
+	return a.sign(ctx, Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Action:    action,
+	}, ttl)
 }
 
-// CreateToken creates new jwt token for user.
-func (a *authService) CreateToken(userID int64) (string, error) {
+// ParseActionToken parses and validates the action confirmation token.
+func (a *authService) ParseActionToken(ctx context.Context, tokenString, action string) (userID int64, sessionID uuid.UUID, err error) {
+	claims, err := a.parse(ctx, tokenString)
+	if err != nil {
+		return -1, sessionID, err
+	}
+
+	if action == "" || claims.Action != action {
+		return -1, sessionID, errors.New("token is not meant for the action")
+	}
+
+	return claims.UserID, claims.SessionID, nil
+}
+
+// sign signs claims with the current keyring key, its id is set as kid
+// header.
+func (a *authService) sign(ctx context.Context, claims Claims, ttl time.Duration) (string, error) {
+	key, err := a.keys.Current(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	ts := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(ts),
-			ExpiresAt: jwt.NewNumericDate(ts.Add(a.expiry)),
-		},
-		UserID: userID,
-	})
+	claims.IssuedAt = jwt.NewNumericDate(ts)
+	claims.ExpiresAt = jwt.NewNumericDate(ts.Add(ttl))
 
-	tokenString, err := token.SignedString(a.secretKey)
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.sign)
 	if err != nil {
 		return "", err
 	}
@@ -68,48 +186,91 @@ func (a *authService) CreateToken(userID int64) (string, error) {
 	return tokenString, nil
 }
 
-// ParseToken parses and validates the token.
-func (a *authService) ParseToken(tokenString string) (userID int64, err error) {
+// parse parses and validates the token. Token is verified with the keyring
+// key its kid header points to.
+func (a *authService) parse(ctx context.Context, tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims,
 		func(t *jwt.Token) (interface{}, error) {
-			// validate the alg is what we expect
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			kid, _ := t.Header["kid"].(string)
+			if kid == "" {
+				return nil, errors.New("missing kid header")
+			}
+
+			key, err := a.keys.Lookup(ctx, kid)
+			if err != nil {
+				return nil, err
+			}
+
+			// validate the alg is what the key is meant for
+			if t.Method.Alg() != key.method.Alg() {
 				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 			}
-			return a.secretKey, nil
+
+			return key.verify, nil
 		},
+		jwt.WithValidMethods([]string{model.AlgHS256, model.AlgRS256, model.AlgEdDSA}),
 	)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return -1, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
 
-// PasswordHash calculates hash for password.
-func (a *authService) PasswordHash(password string) (hash string, err error) {
-	bytesHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+// JWKS returns public keys tokens can be verified with.
+func (a *authService) JWKS(ctx context.Context) (model.JWKSet, error) {
+	return a.keys.JWKS(ctx)
+}
+
+// TokenLifetime returns access token lifetime.
+func (a *authService) TokenLifetime() time.Duration {
+	return a.expiry
+}
+
+// RefreshTokenLifetime returns session lifetime since the last refresh.
+func (a *authService) RefreshTokenLifetime() time.Duration {
+	return a.refreshExpiry
+}
+
+// NewRefreshToken creates new random refresh token for the session. Token
+// must be given to user and only its hash must be stored.
+//
+// Token format: <session id>.<random secret>
+func (a *authService) NewRefreshToken(sessionID uuid.UUID) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", err
 	}
 
-	// Not shure if I need to hex it. I guess not, but at least it's easier to read while debugging.
-	hash = hex.EncodeToString(bytesHash)
+	token = sessionID.String() + "." + base64.RawURLEncoding.EncodeToString(secret)
 
-	return hash, nil
+	return token, hashRefreshToken(token), nil
 }
 
-// CheckPasswordHash compares password and its expected hash.
-func (a *authService) CheckPasswordHash(hash, password string) (err error) {
-	bytesHash, err := hex.DecodeString(hash)
+// ParseRefreshToken extracts session id from refresh token and calculates
+// token's hash to be compared with the stored one.
+func (a *authService) ParseRefreshToken(token string) (sessionID uuid.UUID, hash string, err error) {
+	sid, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return sessionID, "", ErrInvalidRefreshToken
+	}
+
+	sessionID, err = uuid.Parse(sid)
 	if err != nil {
-		return err
+		return sessionID, "", ErrInvalidRefreshToken
 	}
 
-	return bcrypt.CompareHashAndPassword(bytesHash, []byte(password))
+	return sessionID, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken hashes refresh token. Token is random enough, so plain
+// sha256 is fine here (unlike passwords).
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,417 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	rsaKeyBits = 2048
+
+	// unknown kid or jwks request makes keyring reload keys from storage not
+	// more often than this, so garbage tokens can't flood the storage
+	reloadInterval = time.Second * 5
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+)
+
+// KeyringOptions sets up signing keys rotation.
+type KeyringOptions struct {
+	// algorithm new keys are generated for
+	Alg string
+
+	// signing key is replaced with a new one after this duration, 0 - never
+	Rotation time.Duration
+
+	// replaced key is still accepted for tokens validation during this period,
+	// must be not less than access token lifetime
+	Grace time.Duration
+
+	// app secret private keys are encrypted with before being stored
+	Secret string
+}
+
+// signingKey is a stored key with decoded key material.
+type signingKey struct {
+	model.SigningKey
+	method jwt.SigningMethod
+	sign   crypto.PrivateKey // or hmac secret
+	verify crypto.PublicKey  // or hmac secret
+}
+
+// Keyring keeps auth tokens signing keys identified by kid. Keys are kept in
+// storage, so all app instances sign and verify tokens with the same keys.
+type Keyring struct {
+	storage storage.SigningKeysRepository
+	opts    KeyringOptions
+	aead    cipher.AEAD
+
+	mu       sync.RWMutex
+	keys     map[string]*signingKey
+	current  *signingKey
+	loadedAt time.Time
+}
+
+func NewKeyring(storage storage.SigningKeysRepository, opts KeyringOptions) *Keyring {
+	if opts.Alg == "" {
+		opts.Alg = model.AlgHS256
+	}
+
+	// aes-256 key derived from app secret
+	secret := sha256.Sum256([]byte(opts.Secret))
+	block, err := aes.NewCipher(secret[:])
+	if err != nil {
+		// unreachable, key size is always valid
+		panic(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Keyring{
+		storage: storage,
+		opts:    opts,
+		aead:    aead,
+		keys:    make(map[string]*signingKey),
+	}
+}
+
+// Current returns key new tokens must be signed with. The key is rotated
+// when it gets older than configured rotation period.
+func (k *Keyring) Current(ctx context.Context) (*signingKey, error) {
+	now := time.Now()
+
+	k.mu.RLock()
+	key := k.current
+	k.mu.RUnlock()
+
+	if key != nil && !k.needsRotation(key, now) {
+		return key, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// another instance might have rotated the key already
+	if err := k.load(ctx, now); err != nil {
+		return nil, err
+	}
+
+	if k.current != nil && !k.needsRotation(k.current, now) {
+		return k.current, nil
+	}
+
+	key, err := k.generate(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	k.keys[key.ID] = key
+	k.current = key
+
+	// previous keys are removed only when their grace period is over
+	if n, err := k.storage.DeleteExpired(ctx, now); err != nil {
+		logger.Log.Error("Failed to delete expired signing keys", zap.Error(err))
+	} else if n > 0 {
+		logger.Log.Info("Expired signing keys deleted", zap.Int64("count", n))
+	}
+
+	logger.Log.Info("Signing key rotated",
+		zap.String("kid", key.ID),
+		zap.String("alg", key.Alg),
+	)
+
+	return key, nil
+}
+
+// Lookup finds key token was signed with.
+func (k *Keyring) Lookup(ctx context.Context, kid string) (*signingKey, error) {
+	now := time.Now()
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+
+	if !ok {
+		// key might have been created by another instance
+		if err := k.reload(ctx, now); err != nil {
+			return nil, err
+		}
+
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if !ok || expired(key.SigningKey, now) {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// JWKS returns public keys of all valid asymmetric keys. HMAC keys are
+// never published. Keys are served from cache, which is refreshed not more
+// often than reload interval, as the endpoint is public.
+func (k *Keyring) JWKS(ctx context.Context) (set model.JWKSet, err error) {
+	now := time.Now()
+
+	if err = k.reload(ctx, now); err != nil {
+		k.mu.RLock()
+		loaded := !k.loadedAt.IsZero()
+		k.mu.RUnlock()
+
+		if !loaded {
+			return set, err
+		}
+
+		logger.Log.Warn("Serving cached signing keys", zap.Error(err))
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set.Keys = make([]model.JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		if expired(key.SigningKey, now) {
+			continue
+		}
+
+		if jwk, ok := publicJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set, nil
+}
+
+// reload loads keys from storage unless they were loaded within reload
+// interval, so callers can't flood the storage.
+func (k *Keyring) reload(ctx context.Context, now time.Time) error {
+	k.mu.RLock()
+	fresh := now.Sub(k.loadedAt) < reloadInterval
+	k.mu.RUnlock()
+
+	if fresh {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// another caller might have reloaded keys while the lock was awaited
+	if now.Sub(k.loadedAt) < reloadInterval {
+		return nil
+	}
+
+	return k.load(ctx, now)
+}
+
+func (k *Keyring) needsRotation(key *signingKey, now time.Time) bool {
+	if key.Alg != k.opts.Alg {
+		return true
+	}
+
+	return k.opts.Rotation > 0 && now.Sub(key.CreatedAt) >= k.opts.Rotation
+}
+
+// load replaces cached keys with the stored ones. Newest key of configured
+// algorithm becomes current. Must be called with lock held.
+func (k *Keyring) load(ctx context.Context, now time.Time) error {
+	stored, err := k.storage.List(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*signingKey, len(stored))
+	var current *signingKey
+
+	for _, sk := range stored {
+		key, ok := k.keys[sk.ID]
+		if !ok {
+			if key, err = k.open(sk); err != nil {
+				// e.g. app secret was changed, key is useless now
+				logger.Log.Warn("Skipping signing key",
+					zap.String("kid", sk.ID),
+					zap.Error(err),
+				)
+				continue
+			}
+		}
+
+		keys[key.ID] = key
+
+		// keys are listed newest first
+		if current == nil && key.Alg == k.opts.Alg {
+			current = key
+		}
+	}
+
+	k.keys = keys
+	k.current = current
+	k.loadedAt = now
+
+	return nil
+}
+
+// generate creates and stores new key. Must be called with lock held.
+func (k *Keyring) generate(ctx context.Context, now time.Time) (*signingKey, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	var material []byte
+	switch k.opts.Alg {
+	case model.AlgHS256:
+		material = make([]byte, 32)
+		if _, err = rand.Read(material); err != nil {
+			return nil, err
+		}
+	case model.AlgRS256:
+		pk, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		if material, err = x509.MarshalPKCS8PrivateKey(pk); err != nil {
+			return nil, err
+		}
+	case model.AlgEdDSA:
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if material, err = x509.MarshalPKCS8PrivateKey(pk); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, k.opts.Alg)
+	}
+
+	sk := model.SigningKey{
+		ID:        id.String(),
+		Alg:       k.opts.Alg,
+		CreatedAt: now,
+	}
+
+	if k.opts.Rotation > 0 {
+		sk.ExpiresAt = now.Add(k.opts.Rotation + k.opts.Grace)
+	}
+
+	if sk.Sealed, err = k.seal(sk.ID, material); err != nil {
+		return nil, err
+	}
+
+	if err = k.storage.Create(ctx, sk); err != nil {
+		return nil, err
+	}
+
+	return k.open(sk)
+}
+
+// open decrypts stored key material.
+func (k *Keyring) open(sk model.SigningKey) (*signingKey, error) {
+	size := k.aead.NonceSize()
+	if len(sk.Sealed) < size {
+		return nil, errors.New("sealed key is too short")
+	}
+
+	material, err := k.aead.Open(nil, sk.Sealed[:size], sk.Sealed[size:], []byte(sk.ID))
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt key: %w", err)
+	}
+
+	key := &signingKey{SigningKey: sk}
+
+	switch sk.Alg {
+	case model.AlgHS256:
+		key.method = jwt.SigningMethodHS256
+		key.sign, key.verify = material, material
+		return key, nil
+	case model.AlgRS256:
+		key.method = jwt.SigningMethodRS256
+	case model.AlgEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, sk.Alg)
+	}
+
+	pk, err := x509.ParsePKCS8PrivateKey(material)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pk := pk.(type) {
+	case *rsa.PrivateKey:
+		key.sign, key.verify = pk, &pk.PublicKey
+	case ed25519.PrivateKey:
+		key.sign, key.verify = pk, pk.Public()
+	default:
+		return nil, fmt.Errorf("unexpected private key type %T", pk)
+	}
+
+	return key, nil
+}
+
+// seal encrypts key material with app secret. Key id is bound to the
+// ciphertext, so sealed material can't be moved to another key.
+func (k *Keyring) seal(kid string, material []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return k.aead.Seal(nonce, nonce, material, []byte(kid)), nil
+}
+
+func expired(key model.SigningKey, now time.Time) bool {
+	return !key.ExpiresAt.IsZero() && !key.ExpiresAt.After(now)
+}
+
+func publicJWK(key *signingKey) (jwk model.JWK, ok bool) {
+	jwk = model.JWK{
+		Kid: key.ID,
+		Alg: key.Alg,
+		Use: "sig",
+	}
+
+	switch pub := key.verify.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return jwk, false
+	}
+
+	return jwk, true
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// countingKeys counts keys listings, i.e. keyring reloads.
+type countingKeys struct {
+	storage.SigningKeysRepository
+	lists atomic.Int64
+}
+
+func (c *countingKeys) List(ctx context.Context, now time.Time) ([]model.SigningKey, error) {
+	c.lists.Add(1)
+	return c.SigningKeysRepository.List(ctx, now)
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ctx := context.Background()
+
+	k := NewKeyring(memory.New().SigningKeys(), KeyringOptions{
+		Alg:      model.AlgHS256,
+		Rotation: 20 * time.Millisecond,
+		Grace:    time.Hour,
+		Secret:   "secret",
+	})
+
+	first, err := k.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := k.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Fatalf("key was rotated too early: %s -> %s", first.ID, again.ID)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	second, err := k.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID == first.ID {
+		t.Fatal("key wasn't rotated")
+	}
+
+	// replaced key is still accepted during grace period
+	if _, err = k.Lookup(ctx, first.ID); err != nil {
+		t.Errorf("expected replaced key to be found, got %v", err)
+	}
+}
+
+func TestKeyringLookup(t *testing.T) {
+	ctx := context.Background()
+	keys := memory.New().SigningKeys()
+	opts := KeyringOptions{Alg: model.AlgEdDSA, Secret: "secret"}
+
+	key, err := NewKeyring(keys, opts).Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// key created by another instance is found in shared storage
+	other := NewKeyring(keys, opts)
+
+	found, err := other.Lookup(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("expected key to be found by kid, got %v", err)
+	}
+	if found.Alg != model.AlgEdDSA || found.method != jwt.SigningMethodEdDSA {
+		t.Errorf("unexpected key found: %+v", found.SigningKey)
+	}
+
+	if _, err = other.Lookup(ctx, "garbage"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected unknown kid to be rejected, got %v", err)
+	}
+}
+
+func TestKeyringSealRoundTrip(t *testing.T) {
+	k := NewKeyring(memory.New().SigningKeys(), KeyringOptions{Secret: "secret"})
+
+	material := []byte("key material")
+	sk := model.SigningKey{ID: "kid", Alg: model.AlgHS256}
+
+	var err error
+	if sk.Sealed, err = k.seal(sk.ID, material); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := k.open(sk)
+	if err != nil {
+		t.Fatalf("can't open sealed key: %v", err)
+	}
+	if string(key.sign.([]byte)) != string(material) {
+		t.Errorf("opened material differs: %q", key.sign)
+	}
+
+	// sealed material is bound to key id
+	moved := sk
+	moved.ID = "another"
+	if _, err = k.open(moved); err == nil {
+		t.Error("expected sealed material moved to another key to be rejected")
+	}
+
+	// and to app secret
+	other := NewKeyring(memory.New().SigningKeys(), KeyringOptions{Secret: "another secret"})
+	if _, err = other.open(sk); err == nil {
+		t.Error("expected key sealed with another secret to be rejected")
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	ctx := context.Background()
+	keys := &countingKeys{SigningKeysRepository: memory.New().SigningKeys()}
+
+	rs := NewKeyring(keys, KeyringOptions{Alg: model.AlgRS256, Secret: "secret"})
+	rsKey, err := rs.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := NewKeyring(keys, KeyringOptions{Alg: model.AlgHS256, Secret: "secret"})
+	if _, err = hs.Current(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	k := NewKeyring(keys, KeyringOptions{Alg: model.AlgRS256, Secret: "secret"})
+	lists := keys.lists.Load()
+
+	set, err := k.JWKS(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hmac secret is never published
+	if len(set.Keys) != 1 || set.Keys[0].Kid != rsKey.ID || set.Keys[0].Kty != "RSA" {
+		t.Fatalf("unexpected jwks: %+v", set)
+	}
+
+	// repeated requests are served from cache
+	for i := 0; i < 10; i++ {
+		if _, err = k.JWKS(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := keys.lists.Load() - lists; n != 1 {
+		t.Errorf("expected keys to be loaded once, loaded %d times", n)
+	}
+}
+
+func TestParseTokenRejectsAlgMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	keys := NewKeyring(memory.New().SigningKeys(), KeyringOptions{Alg: model.AlgRS256, Secret: "secret"})
+	a := New(keys, NewBcrypt(4), PasswordPolicy{}, time.Minute, time.Hour)
+
+	valid, err := a.CreateToken(ctx, model.Principal{UserID: 1, Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := a.ParseToken(ctx, valid); err != nil || p.UserID != 1 {
+		t.Fatalf("expected valid token to be accepted, got %+v, %v", p, err)
+	}
+
+	key, err := keys.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// classic attack: hmac token signed with the public key known to anyone
+	public, err := x509.MarshalPKIXPublicKey(key.verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID: 1,
+		Role:   model.RoleAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	forged.Header["kid"] = key.ID
+
+	tokenString, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = a.ParseToken(ctx, tokenString); err == nil {
+		t.Error("expected token with alg not matching the key to be rejected")
+	}
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms.
+const (
+	HasherArgon2id = "argon2id"
+	HasherBcrypt   = "bcrypt"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password doesn't match the hash")
+	ErrUnknownHash      = errors.New("unknown password hash format")
+	ErrHashTooCostly    = errors.New("password hash parameters exceed the limits")
+)
+
+// Hasher is a password hashing algorithm. Hashes are PHC formatted strings
+// which record the algorithm and its parameters:
+//
+//	$<id>[$v=<version>][$<param>=<value>(,<param>=<value>)*][$<salt>[$<hash>]]
+type Hasher interface {
+	// Hash calculates hash for password.
+	Hash(password string) (hash string, err error)
+	// Verify compares password and its hash produced by the hasher.
+	Verify(hash, password string) error
+	// NeedsRehash reports if hash was calculated with weaker parameters
+	// than the hasher is set up with.
+	NeedsRehash(hash string) bool
+	// MaxPasswordLength returns max password length the hasher supports.
+	MaxPasswordLength() int
+}
+
+// passwords hashes new passwords with the current hasher and verifies hashes
+// produced by any known one, so hashing algorithm can be changed without
+// resetting passwords.
+type passwords struct {
+	current Hasher
+	known   map[string]Hasher // by PHC id
+}
+
+func newPasswords(current Hasher) *passwords {
+	bc := NewBcrypt(bcrypt.DefaultCost)
+	p := &passwords{
+		current: current,
+		known: map[string]Hasher{
+			"argon2id": NewArgon2id(DefaultArgon2Params),
+			"2a":       bc,
+			"2b":       bc,
+			"2y":       bc,
+		},
+	}
+
+	// verify hashes of the current algorithm with its own settings
+	switch current.(type) {
+	case *argon2idHasher:
+		p.known["argon2id"] = current
+	case *bcryptHasher:
+		p.known["2a"], p.known["2b"], p.known["2y"] = current, current, current
+	}
+
+	return p
+}
+
+// hasherOf finds hasher the hash was calculated with. Hashes stored before
+// PHC format was introduced are hex-encoded bcrypt ones, legacy is true
+// for them.
+func (p *passwords) hasherOf(hash string) (h Hasher, legacy bool, err error) {
+	id, ok := phcID(hash)
+	if !ok {
+		return p.known["2a"], true, nil
+	}
+
+	h, ok = p.known[id]
+	if !ok {
+		return nil, false, ErrUnknownHash
+	}
+
+	return h, false, nil
+}
+
+func (p *passwords) Verify(hash, password string) error {
+	h, legacy, err := p.hasherOf(hash)
+	if err != nil {
+		return err
+	}
+
+	if legacy {
+		bytesHash, err := hex.DecodeString(hash)
+		if err != nil {
+			return ErrUnknownHash
+		}
+		hash = string(bytesHash)
+	}
+
+	return h.Verify(hash, password)
+}
+
+func (p *passwords) NeedsRehash(hash string) bool {
+	h, legacy, err := p.hasherOf(hash)
+	if err != nil || legacy || h != p.current {
+		return true
+	}
+
+	return h.NeedsRehash(hash)
+}
+
+// phcID extracts algorithm id from PHC formatted hash.
+func phcID(hash string) (id string, ok bool) {
+	rest, ok := strings.CutPrefix(hash, "$")
+	if !ok {
+		return "", false
+	}
+
+	id, _, ok = strings.Cut(rest, "$")
+
+	return id, ok && id != ""
+}
+
+// Argon2Params are argon2id cost parameters.
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params are the minimal parameters OWASP recommends.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type argon2idHasher struct {
+	params Argon2Params
+}
+
+func NewArgon2id(params Argon2Params) *argon2idHasher {
+	// argon2 panics on zero cost parameters
+	if params.Iterations == 0 {
+		params.Iterations = DefaultArgon2Params.Iterations
+	}
+
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultArgon2Params.Parallelism
+	}
+
+	if params.Memory < 8*uint32(params.Parallelism) {
+		params.Memory = max(DefaultArgon2Params.Memory, 8*uint32(params.Parallelism))
+	}
+
+	if params.SaltLength == 0 {
+		params.SaltLength = DefaultArgon2Params.SaltLength
+	}
+
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultArgon2Params.KeyLength
+	}
+
+	return &argon2idHasher{
+		params: params,
+	}
+}
+
+// argon2 has no real limit, it's here just to not hash megabytes
+const maxArgon2PasswordLength = 256
+
+func (a *argon2idHasher) MaxPasswordLength() int {
+	return maxArgon2PasswordLength
+}
+
+func (a *argon2idHasher) Hash(password string) (hash string, err error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err = rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := a.params
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *argon2idHasher) Verify(hash, password string) error {
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	if err = a.checkCost(p); err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (a *argon2idHasher) NeedsRehash(hash string) bool {
+	p, salt, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return p.Memory < a.params.Memory ||
+		p.Iterations < a.params.Iterations ||
+		p.Parallelism < a.params.Parallelism ||
+		p.KeyLength < a.params.KeyLength ||
+		uint32(len(salt)) < a.params.SaltLength
+}
+
+// maxArgon2CostFactor tells how many times parameters of the hash being
+// verified may exceed the hasher's ones. Hash parameters come from storage,
+// so corrupt or tampered hash must not make login allocate gigabytes or spin
+// the CPU.
+const maxArgon2CostFactor = 4
+
+// checkCost checks that hash parameters are within the limits argon2 can be
+// run with safely.
+func (a *argon2idHasher) checkCost(p Argon2Params) error {
+	// limits are calculated in uint64 to not overflow
+	exceeds := func(value, limit uint32) bool {
+		return uint64(value) > uint64(limit)*maxArgon2CostFactor
+	}
+
+	if p.Iterations < 1 || exceeds(p.Iterations, a.params.Iterations) ||
+		p.Parallelism < 1 || exceeds(uint32(p.Parallelism), uint32(a.params.Parallelism)) ||
+		p.Memory < 8*uint32(p.Parallelism) || exceeds(p.Memory, a.params.Memory) ||
+		exceeds(p.KeyLength, a.params.KeyLength) {
+		return ErrHashTooCostly
+	}
+
+	return nil
+}
+
+// decodeArgon2id parses $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func decodeArgon2id(hash string) (p Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownHash
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+// bcryptHasher produces modular crypt format hashes ($2a$<cost>$...), which
+// are compatible with PHC format.
+type bcryptHasher struct {
+	cost int
+}
+
+func NewBcrypt(cost int) *bcryptHasher {
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	return &bcryptHasher{
+		cost: cost,
+	}
+}
+
+const maxBcryptPasswordLength = 72 // limited by bcrypt
+
+func (b *bcryptHasher) MaxPasswordLength() int {
+	return maxBcryptPasswordLength
+}
+
+func (b *bcryptHasher) Hash(password string) (hash string, err error) {
+	bytesHash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytesHash), nil
+}
+
+func (b *bcryptHasher) Verify(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+
+	return err
+}
+
+func (b *bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.cost
+}
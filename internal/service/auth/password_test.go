@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap params to keep tests fast
+var testArgon2Params = Argon2Params{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := NewArgon2id(testArgon2Params)
+
+	hash, err := h.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatalf("can't parse own hash: %v", err)
+	}
+
+	want := testArgon2Params
+	want.SaltLength = DefaultArgon2Params.SaltLength
+	want.KeyLength = DefaultArgon2Params.KeyLength
+	if p != want || len(salt) != int(want.SaltLength) || len(key) != int(want.KeyLength) {
+		t.Errorf("parsed params %+v, want %+v", p, want)
+	}
+
+	if err = h.Verify(hash, "password"); err != nil {
+		t.Errorf("expected password to match, got %v", err)
+	}
+
+	if err = h.Verify(hash, "passw0rd"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+
+	if h.NeedsRehash(hash) {
+		t.Error("hash with the same params doesn't need rehash")
+	}
+}
+
+func TestDecodeArgon2idMalformed(t *testing.T) {
+	hash, err := NewArgon2id(testArgon2Params).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"empty":         "",
+		"not argon2id":  strings.Replace(hash, "argon2id", "argon2i", 1),
+		"other version": strings.Replace(hash, "v=19", "v=16", 1),
+		"bad params":    strings.Replace(hash, "m=64,t=1,p=1", "m=64", 1),
+		"bad salt":      strings.Replace(hash, "$m=64,t=1,p=1$", "$m=64,t=1,p=1$!", 1),
+		"no key":        hash[:strings.LastIndex(hash, "$")+1],
+		"truncated":     hash[:strings.LastIndex(hash, "$")],
+	}
+
+	for name, hash := range tests {
+		if _, _, _, err := decodeArgon2id(hash); !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("%s: expected ErrUnknownHash, got %v", name, err)
+		}
+	}
+}
+
+func TestArgon2idVerifyCostLimits(t *testing.T) {
+	h := NewArgon2id(testArgon2Params)
+
+	hash, err := h.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := "m=64,t=1,p=1"
+	key := hash[strings.LastIndex(hash, "$")+1:]
+
+	tests := []struct {
+		name string
+		hash string
+		want error
+	}{
+		{name: "huge memory", hash: strings.Replace(hash, params, "m=4194304,t=1,p=1", 1), want: ErrHashTooCostly},
+		{name: "many iterations", hash: strings.Replace(hash, params, "m=64,t=1000000,p=1", 1), want: ErrHashTooCostly},
+		{name: "many threads", hash: strings.Replace(hash, params, "m=4096,t=1,p=255", 1), want: ErrHashTooCostly},
+		{name: "zero iterations", hash: strings.Replace(hash, params, "m=64,t=0,p=1", 1), want: ErrHashTooCostly},
+		{name: "zero threads", hash: strings.Replace(hash, params, "m=64,t=1,p=0", 1), want: ErrHashTooCostly},
+		{name: "memory below threads", hash: strings.Replace(hash, params, "m=8,t=1,p=2", 1), want: ErrHashTooCostly},
+		{name: "long key", hash: strings.Replace(hash, key, strings.Repeat(key, 8), 1), want: ErrHashTooCostly},
+		// parameters within the limits are used as is, so the key differs
+		{name: "within limits", hash: strings.Replace(hash, params, "m=256,t=4,p=4", 1), want: ErrPasswordMismatch},
+		{name: "own hash", hash: hash, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.Verify(tt.hash, "password"); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestNewArgon2idZeroParams(t *testing.T) {
+	h := NewArgon2id(Argon2Params{})
+	if h.params != DefaultArgon2Params {
+		t.Errorf("zero params must fall back to defaults, got %+v", h.params)
+	}
+}
+
+func TestPasswordsVerifyLegacyBcrypt(t *testing.T) {
+	// hashes stored before PHC format were hex-encoded bcrypt ones
+	bytesHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := hex.EncodeToString(bytesHash)
+
+	p := newPasswords(NewArgon2id(testArgon2Params))
+
+	if err = p.Verify(legacy, "password"); err != nil {
+		t.Errorf("expected legacy hash to match, got %v", err)
+	}
+
+	if err = p.Verify(legacy, "passw0rd"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+
+	if err = p.Verify("not a hex", "password"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+
+	if !p.NeedsRehash(legacy) {
+		t.Error("legacy hash must be rehashed")
+	}
+}
+
+func TestPasswordsVerifyUnknownHash(t *testing.T) {
+	p := newPasswords(NewArgon2id(testArgon2Params))
+
+	if err := p.Verify("$scrypt$ln=16,r=8,p=1$c2FsdA$a2V5", "password"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+}
+
+// TestRehashOnLogin goes through what login does: verifies stored hash,
+// upgrades it when needed, and verifies the upgraded one next time.
+func TestRehashOnLogin(t *testing.T) {
+	weak := testArgon2Params
+
+	strong := testArgon2Params
+	strong.Iterations = 2
+
+	weakHash, err := NewArgon2id(weak).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, err := NewBcrypt(bcrypt.MinCost).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bytesHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash := hex.EncodeToString(bytesHash)
+
+	tests := map[string]string{
+		"weaker params":  weakHash,
+		"another hasher": bcryptHash,
+		"legacy hash":    legacyHash,
+	}
+
+	a := New(nil, NewArgon2id(strong), PasswordPolicy{}, 0, 0)
+
+	for name, stored := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := a.CheckPasswordHash(stored, "password"); err != nil {
+				t.Fatalf("stored hash must match, got %v", err)
+			}
+
+			if !a.NeedsRehash(stored) {
+				t.Fatal("stored hash must be rehashed")
+			}
+
+			upgraded, err := a.PasswordHash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = a.CheckPasswordHash(upgraded, "password"); err != nil {
+				t.Errorf("upgraded hash must match, got %v", err)
+			}
+
+			if a.NeedsRehash(upgraded) {
+				t.Error("upgraded hash doesn't need rehash")
+			}
+		})
+	}
+}
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	hash, err := NewBcrypt(bcrypt.MinCost).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if NewBcrypt(bcrypt.MinCost).NeedsRehash(hash) {
+		t.Error("hash with the same cost doesn't need rehash")
+	}
+
+	if !NewBcrypt(bcrypt.MinCost + 1).NeedsRehash(hash) {
+		t.Error("hash with lower cost must be rehashed")
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PolicyError describes password policy violation. It's safe to show it
+// to user.
+type PolicyError string
+
+func (e PolicyError) Error() string {
+	return string(e)
+}
+
+// PasswordPolicy is a set of requirements new passwords must meet.
+type PasswordPolicy struct {
+	MinLength int
+
+	// number of character classes (lowercase and uppercase letters, digits,
+	// other symbols) password must contain
+	MinClasses int
+
+	// password must not contain user's login
+	RejectLogin bool
+}
+
+// validate checks password against the policy. maxLength is limited by
+// the hasher.
+func (p PasswordPolicy) validate(login, password string, maxLength int) error {
+	if len(password) > maxLength {
+		return PolicyError(fmt.Sprintf("password must be at most %d bytes long", maxLength))
+	}
+
+	if n := len([]rune(password)); n < max(p.MinLength, 1) {
+		return PolicyError(fmt.Sprintf("password must be at least %d characters long", max(p.MinLength, 1)))
+	}
+
+	if p.MinClasses > 0 && passwordClasses(password) < p.MinClasses {
+		return PolicyError(fmt.Sprintf("password must contain at least %d of: lowercase letters, uppercase letters, digits, other symbols", p.MinClasses))
+	}
+
+	if p.RejectLogin && login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		return PolicyError("password must not contain login")
+	}
+
+	return nil
+}
+
+// passwordClasses counts character classes used in password.
+func passwordClasses(password string) (n int) {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,126 @@
+// Package campaign applies marketing campaigns to processed orders.
+//
+// Running campaigns are evaluated in priority order. Every eligible campaign
+// is applied, unless it's exclusive: exclusive campaign is applied only when
+// no campaign was applied before it and stops evaluation of the rest. Bonus
+// is cut down to the campaign's budget left, campaign with exhausted budget
+// is skipped.
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Engine credits campaigns' bonuses.
+type Engine struct {
+	now func() time.Time
+}
+
+func New() *Engine {
+	return &Engine{
+		now: time.Now,
+	}
+}
+
+// Apply credits bonuses of running campaigns the processed order is
+// eligible for. Must be called within transaction after the order's accrual
+// was credited, so concurrently processed orders of the same user are
+// already serialized and the first order is determined reliably.
+func (e *Engine) Apply(ctx context.Context, tx storage.Storage, order model.Order, tier string) (bonuses []model.OrderBonus, err error) {
+	campaigns, err := tx.Campaigns().Running(ctx, e.now())
+	if err != nil {
+		return nil, fmt.Errorf("error getting running campaigns: %w", err)
+	}
+
+	if len(campaigns) == 0 {
+		return nil, nil
+	}
+
+	first, err := e.isFirstOrder(ctx, tx, order, campaigns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range campaigns {
+		if c.Exclusive && len(bonuses) > 0 {
+			continue
+		}
+
+		if !c.Eligible(order, tier, first) {
+			continue
+		}
+
+		amount := c.Bonus(order.Accrual)
+		if amount <= 0 {
+			continue
+		}
+
+		if amount, err = tx.Campaigns().Spend(ctx, c.ID, amount); err != nil {
+			return nil, fmt.Errorf("error spending campaign %d budget: %w", c.ID, err)
+		}
+
+		if amount == 0 {
+			// budget was exhausted meanwhile
+			continue
+		}
+
+		bonus := model.OrderBonus{
+			Source: model.BonusCampaign,
+			Ref:    strconv.FormatInt(c.ID, 10),
+			Amount: amount,
+		}
+
+		if err = tx.Balance().AccrueBonus(ctx, order.ID, order.UserID, bonus); err != nil {
+			return nil, fmt.Errorf("error crediting campaign %d bonus: %w", c.ID, err)
+		}
+
+		logger.Log.Debug("Campaign bonus credited",
+			zap.Int64("campaign", c.ID),
+			zap.String("order", string(order.ID)),
+			zap.Stringer("amount", amount),
+		)
+
+		bonuses = append(bonuses, bonus)
+
+		if c.Exclusive {
+			break
+		}
+	}
+
+	return bonuses, nil
+}
+
+// isFirstOrder checks if the order is user's first processed one. Cancelled
+// orders were processed once too, so they count as well and the first order
+// bonus isn't granted again after cancellation. Storage is queried only when
+// some campaign cares about it.
+func (e *Engine) isFirstOrder(ctx context.Context, tx storage.Storage, order model.Order, campaigns []model.Campaign) (bool, error) {
+	for _, c := range campaigns {
+		if !c.FirstOrder {
+			continue
+		}
+
+		// the order itself is already saved with processed status
+		processed, err := tx.Orders().CountByUserID(ctx, order.UserID, model.OrderProcessed)
+		if err != nil {
+			return false, fmt.Errorf("error counting user's processed orders: %w", err)
+		}
+
+		cancelled, err := tx.Orders().CountByUserID(ctx, order.UserID, model.OrderCancelled)
+		if err != nil {
+			return false, fmt.Errorf("error counting user's cancelled orders: %w", err)
+		}
+
+		return processed+cancelled == 1, nil
+	}
+
+	return false, nil
+}
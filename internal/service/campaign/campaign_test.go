@@ -0,0 +1,251 @@
+package campaign
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+var testStart = time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func mustCreateUser(t *testing.T, s storage.Storage, login string) int64 {
+	t.Helper()
+
+	id, err := s.Users().Create(context.Background(), model.User{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func mustCreateCampaign(t *testing.T, s storage.Storage, c model.Campaign) int64 {
+	t.Helper()
+
+	if c.Name == "" {
+		c.Name = "promo"
+	}
+
+	if c.StartsAt.IsZero() {
+		c.StartsAt = testStart
+		c.EndsAt = testStart.Add(24 * time.Hour)
+	}
+
+	id, err := s.Campaigns().Create(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// processOrder saves processed order and credits its accrual, as poller
+// does before applying campaigns.
+func processOrder(t *testing.T, s storage.Storage, num string, userID int64, accrual model.Points) model.Order {
+	t.Helper()
+
+	ctx := context.Background()
+	order := model.Order{ID: model.OrderNumber(num), UserID: userID, Status: model.OrderProcessed, Accrual: accrual}
+
+	if _, err := s.Orders().Create(ctx, model.Order{ID: order.ID, UserID: userID, Status: "NEW"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Orders().SetProcessedStatus(ctx, order.ID, order.Status, accrual); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Balance().Accrue(ctx, order.ID, accrual, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func apply(t *testing.T, e *Engine, s storage.Storage, order model.Order, tier string) (bonuses []model.OrderBonus) {
+	t.Helper()
+
+	err := s.InTx(context.Background(), func(tx storage.Storage) (err error) {
+		bonuses, err = e.Apply(context.Background(), tx, order, tier)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bonuses
+}
+
+// engineAt creates engine with frozen clock.
+func engineAt(now time.Time) *Engine {
+	e := New()
+	e.now = func() time.Time { return now }
+
+	return e
+}
+
+func TestApplyDateWindow(t *testing.T) {
+	s := memory.New()
+	userID := mustCreateUser(t, s, "gopher")
+
+	mustCreateCampaign(t, s, model.Campaign{Points: points(t, "10")})
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		applied bool
+	}{
+		{name: "before start", now: testStart.Add(-time.Second)},
+		{name: "at start", now: testStart, applied: true},
+		{name: "before end", now: testStart.Add(24*time.Hour - time.Second), applied: true},
+		{name: "at end", now: testStart.Add(24 * time.Hour)}, // end is exclusive
+	}
+
+	for i, tt := range tests {
+		order := processOrder(t, s, strconv.Itoa(1000+i), userID, points(t, "100"))
+
+		bonuses := apply(t, engineAt(tt.now), s, order, "")
+		if applied := len(bonuses) == 1; applied != tt.applied {
+			t.Errorf("%s: expected applied %v, got bonuses %+v", tt.name, tt.applied, bonuses)
+		}
+	}
+}
+
+func TestApplyBudgetExhaustion(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	e := engineAt(testStart)
+
+	userID := mustCreateUser(t, s, "gopher")
+	id := mustCreateCampaign(t, s, model.Campaign{Points: points(t, "10"), Budget: points(t, "15")})
+
+	// bonus is cut down to the budget left
+	for i, want := range []string{"10", "5"} {
+		order := processOrder(t, s, strconv.Itoa(1000+i), userID, points(t, "100"))
+
+		bonuses := apply(t, e, s, order, "")
+		if len(bonuses) != 1 || bonuses[0].Amount != points(t, want) || bonuses[0].Ref != strconv.FormatInt(id, 10) {
+			t.Fatalf("order %d: expected %s points bonus, got %+v", i, want, bonuses)
+		}
+	}
+
+	// campaign with exhausted budget is skipped
+	order := processOrder(t, s, "1002", userID, points(t, "100"))
+	if bonuses := apply(t, e, s, order, ""); len(bonuses) != 0 {
+		t.Errorf("expected no bonus once budget is exhausted, got %+v", bonuses)
+	}
+
+	c, err := s.Campaigns().Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Spent != c.Budget {
+		t.Errorf("expected the whole budget to be spent, got %s of %s", c.Spent, c.Budget)
+	}
+
+	balance, err := s.Balance().Get(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance != points(t, "315") {
+		t.Errorf("expected accruals and 15 bonus points on balance, got %s", balance.Balance)
+	}
+}
+
+func TestApplyStacking(t *testing.T) {
+	s := memory.New()
+	e := engineAt(testStart)
+
+	userID := mustCreateUser(t, s, "gopher")
+
+	mustCreateCampaign(t, s, model.Campaign{Name: "double", Multiplier: points(t, "2"), Priority: 10})
+	mustCreateCampaign(t, s, model.Campaign{Name: "exclusive", Points: points(t, "50"), Priority: 5, Exclusive: true})
+	mustCreateCampaign(t, s, model.Campaign{Name: "fixed", Points: points(t, "1"), Priority: 1})
+	mustCreateCampaign(t, s, model.Campaign{Name: "gold only", Points: points(t, "7"), Tiers: []string{"gold"}})
+
+	// exclusive campaign isn't combined with ones applied before it
+	order := processOrder(t, s, "1000", userID, points(t, "100"))
+
+	bonuses := apply(t, e, s, order, "silver")
+	if len(bonuses) != 2 || bonuses[0].Amount != points(t, "100") || bonuses[1].Amount != points(t, "1") {
+		t.Errorf("unexpected bonuses: %+v", bonuses)
+	}
+
+	bonuses = apply(t, e, s, processOrder(t, s, "1001", userID, points(t, "100")), "gold")
+	if len(bonuses) != 3 || bonuses[2].Amount != points(t, "7") {
+		t.Errorf("unexpected gold tier bonuses: %+v", bonuses)
+	}
+}
+
+func TestApplyExclusiveStopsEvaluation(t *testing.T) {
+	s := memory.New()
+	e := engineAt(testStart)
+
+	userID := mustCreateUser(t, s, "gopher")
+
+	mustCreateCampaign(t, s, model.Campaign{Name: "exclusive", Points: points(t, "50"), Priority: 5, Exclusive: true})
+	mustCreateCampaign(t, s, model.Campaign{Name: "fixed", Points: points(t, "1"), Priority: 1})
+
+	bonuses := apply(t, e, s, processOrder(t, s, "1000", userID, points(t, "100")), "")
+	if len(bonuses) != 1 || bonuses[0].Amount != points(t, "50") {
+		t.Errorf("expected only exclusive bonus, got %+v", bonuses)
+	}
+}
+
+func TestApplyFirstOrder(t *testing.T) {
+	s := memory.New()
+	e := engineAt(testStart)
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateCampaign(t, s, model.Campaign{Points: points(t, "100"), FirstOrder: true})
+
+	first := processOrder(t, s, "1000", userID, points(t, "10"))
+	if bonuses := apply(t, e, s, first, ""); len(bonuses) != 1 {
+		t.Errorf("expected first order bonus, got %+v", bonuses)
+	}
+
+	second := processOrder(t, s, "1001", userID, points(t, "10"))
+	if bonuses := apply(t, e, s, second, ""); len(bonuses) != 0 {
+		t.Errorf("expected no bonus for the second order, got %+v", bonuses)
+	}
+}
+
+func TestApplyFirstOrderAfterCancellation(t *testing.T) {
+	s := memory.New()
+	e := engineAt(testStart)
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateCampaign(t, s, model.Campaign{Points: points(t, "100"), FirstOrder: true})
+
+	first := processOrder(t, s, "1000", userID, points(t, "10"))
+	if bonuses := apply(t, e, s, first, ""); len(bonuses) != 1 {
+		t.Fatalf("expected first order bonus, got %+v", bonuses)
+	}
+
+	_, err := s.Cancellations().Create(context.Background(), model.Cancellation{Order: first.ID}, model.ClawbackDebt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelled order was processed once, so the next one isn't the first
+	second := processOrder(t, s, "1001", userID, points(t, "10"))
+	if bonuses := apply(t, e, s, second, ""); len(bonuses) != 0 {
+		t.Errorf("expected no bonus after the first order was cancelled, got %+v", bonuses)
+	}
+}
@@ -0,0 +1,179 @@
+// Package expiry implements points expiration. Every credit is a lot which
+// expires after configured number of months, debits consume the oldest lots
+// first.
+package expiry
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"go.uber.org/zap"
+)
+
+// batchSize limits users processed by a single query.
+const batchSize = 100
+
+// Options sets up expiration. Zero Months disables it.
+type Options struct {
+	Months   int           // points lifetime since accrual
+	Soon     time.Duration // points expiring within it are shown on balance
+	Interval time.Duration // how often expired lots are looked for
+}
+
+// Expirer periodically expires lots older than points lifetime. It's safe
+// to be run by many app instances at once.
+type Expirer struct {
+	storage storage.Storage
+	opts    Options
+
+	stop context.CancelFunc
+	done chan struct{} // closed when run loop exits
+}
+
+func New(storage storage.Storage, opts Options) *Expirer {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+
+	return &Expirer{
+		storage: storage,
+		opts:    opts,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts expiration in background. It's stopped when ctx is done.
+// Does nothing when points never expire.
+func (e *Expirer) Start(ctx context.Context) error {
+	if e.opts.Months <= 0 {
+		return nil
+	}
+
+	logger.Log.Info("Starting points expirer",
+		zap.Int("months", e.opts.Months),
+		zap.Duration("interval", e.opts.Interval),
+	)
+
+	ctx, e.stop = context.WithCancel(ctx)
+	go e.run(ctx)
+
+	return nil
+}
+
+// Stop stops expiration and waits for the current run to finish until ctx
+// is done.
+func (e *Expirer) Stop(ctx context.Context) error {
+	if e.stop == nil {
+		// wasn't started
+		return nil
+	}
+
+	e.stop()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Expirer) run(ctx context.Context) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		e.expire(ctx, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expire expires all lots due by now.
+func (e *Expirer) expire(ctx context.Context, now time.Time) {
+	var (
+		users int
+		total model.Points
+	)
+
+	for ctx.Err() == nil {
+		userIDs, err := e.storage.Lots().UsersWithExpired(ctx, e.opts.Months, now, batchSize)
+		if err != nil {
+			logger.Log.Error("Error looking for expired points", zap.Error(err))
+			return
+		}
+
+		var expiredInBatch int
+		for _, userID := range userIDs {
+			expired, err := e.storage.Lots().Expire(ctx, userID, e.opts.Months, now)
+			if err != nil {
+				logger.Log.Error("Error expiring points", zap.Int64("user_id", userID), zap.Error(err))
+				continue
+			}
+
+			if expired > 0 {
+				expiredInBatch++
+				users++
+				total += expired
+			}
+		}
+
+		// nothing left or the rest keep failing
+		if len(userIDs) < batchSize || expiredInBatch == 0 {
+			break
+		}
+	}
+
+	if users > 0 {
+		logger.Log.Info("Points expired",
+			zap.Int("users", users),
+			zap.Stringer("total", total),
+		)
+	}
+}
+
+// Expiring returns user's points expiring soon grouped by date, the
+// earliest first. Nothing is returned when points never expire.
+func (e *Expirer) Expiring(ctx context.Context, userID int64) ([]model.ExpiringPoints, error) {
+	if e.opts.Months <= 0 {
+		return nil, nil
+	}
+
+	lots, err := e.storage.Lots().List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(e.opts.Soon)
+
+	var expiring []model.ExpiringPoints
+	for _, lot := range lots {
+		expiresAt := lot.ExpiresAt(e.opts.Months)
+		if expiresAt.After(deadline) {
+			// lots are the oldest first, so are their expiration dates
+			break
+		}
+
+		date := expiresAt.UTC().Format(model.LayoutDate)
+		if n := len(expiring); n > 0 && expiring[n-1].Date == date {
+			expiring[n-1].Sum += lot.Remaining
+			continue
+		}
+
+		expiring = append(expiring, model.ExpiringPoints{
+			Sum:  lot.Remaining,
+			Date: date,
+		})
+	}
+
+	return expiring, nil
+}
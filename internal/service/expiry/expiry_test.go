@@ -0,0 +1,248 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func mustCreateUser(t *testing.T, s storage.Storage, login string) int64 {
+	t.Helper()
+
+	id, err := s.Users().Create(context.Background(), model.User{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func mustAccrue(t *testing.T, s storage.Storage, order string, sum model.Points, userID int64) {
+	t.Helper()
+
+	if _, err := s.Balance().Accrue(context.Background(), model.OrderNumber(order), sum, userID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBalance(t *testing.T, s storage.Storage, userID int64, want model.Points) {
+	t.Helper()
+
+	balance, err := s.Balance().Get(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Balance != want {
+		t.Errorf("expected user %d balance %s, got %s", userID, want, balance.Balance)
+	}
+}
+
+// observeLogs replaces logger with the one recording errors until the test
+// ends.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+
+	core, logs := observer.New(zapcore.ErrorLevel)
+
+	log := logger.Log
+	logger.Log = zap.New(core)
+	t.Cleanup(func() { logger.Log = log })
+
+	return logs
+}
+
+// lotsStub fails or blocks chosen calls, the rest go to the storage.
+type lotsStub struct {
+	storage.LotsRepository
+
+	calls     atomic.Int64  // UsersWithExpired calls
+	block     chan struct{} // UsersWithExpired waits for it when set
+	failUser  int64         // Expire fails for this user
+	errLookup error         // UsersWithExpired fails with it
+}
+
+func (l *lotsStub) UsersWithExpired(ctx context.Context, months int, now time.Time, limit int) ([]int64, error) {
+	l.calls.Add(1)
+
+	if l.block != nil {
+		<-l.block
+	}
+
+	if l.errLookup != nil {
+		return nil, l.errLookup
+	}
+
+	return l.LotsRepository.UsersWithExpired(ctx, months, now, limit)
+}
+
+func (l *lotsStub) Expire(ctx context.Context, userID int64, months int, now time.Time) (model.Points, error) {
+	if userID == l.failUser {
+		return 0, errors.New("expire failed")
+	}
+
+	return l.LotsRepository.Expire(ctx, userID, months, now)
+}
+
+type storageStub struct {
+	storage.Storage
+	lots *lotsStub
+}
+
+func (s *storageStub) Lots() storage.LotsRepository {
+	return s.lots
+}
+
+func newStorageStub(s storage.Storage) *storageStub {
+	return &storageStub{
+		Storage: s,
+		lots:    &lotsStub{LotsRepository: s.Lots()},
+	}
+}
+
+func TestExpirePastLifetime(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	e := New(s, Options{Months: 1})
+
+	firstID := mustCreateUser(t, s, "first")
+	secondID := mustCreateUser(t, s, "second")
+	mustAccrue(t, s, "12345678903", points(t, "100"), firstID)
+	mustAccrue(t, s, "79927398713", points(t, "50"), secondID)
+
+	// lots aren't expired before the end of their lifetime
+	now := time.Now()
+	e.expire(ctx, now.AddDate(0, 1, -1))
+	assertBalance(t, s, firstID, points(t, "100"))
+	assertBalance(t, s, secondID, points(t, "50"))
+
+	e.expire(ctx, now.AddDate(0, 1, 1))
+	assertBalance(t, s, firstID, 0)
+	assertBalance(t, s, secondID, 0)
+}
+
+func TestExpireContinuesOnError(t *testing.T) {
+	ctx := context.Background()
+	logs := observeLogs(t)
+
+	mem := memory.New()
+	s := newStorageStub(mem)
+	e := New(s, Options{Months: 1})
+
+	failingID := mustCreateUser(t, mem, "failing")
+	okID := mustCreateUser(t, mem, "ok")
+	mustAccrue(t, mem, "12345678903", points(t, "100"), failingID)
+	mustAccrue(t, mem, "79927398713", points(t, "50"), okID)
+	s.lots.failUser = failingID
+
+	e.expire(ctx, time.Now().AddDate(0, 2, 0))
+
+	assertBalance(t, mem, failingID, points(t, "100"))
+	assertBalance(t, mem, okID, 0)
+
+	if n := logs.FilterMessage("Error expiring points").Len(); n != 1 {
+		t.Errorf("expected failed user to be logged once, got %d", n)
+	}
+
+	// lookup failure is logged and waits for the next tick
+	s.lots.errLookup = errors.New("lookup failed")
+	e.expire(ctx, time.Now().AddDate(0, 2, 0))
+
+	if n := logs.FilterMessage("Error looking for expired points").Len(); n != 1 {
+		t.Errorf("expected lookup error to be logged once, got %d", n)
+	}
+}
+
+func TestExpirerTicksUntilStopped(t *testing.T) {
+	s := newStorageStub(memory.New())
+	e := New(s, Options{Months: 1, Interval: time.Millisecond * 10})
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for s.lots.calls.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected expiration to run on every tick, ran %d times", s.lots.calls.Load())
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := e.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := s.lots.calls.Load()
+	time.Sleep(time.Millisecond * 50)
+	if n := s.lots.calls.Load(); n != calls {
+		t.Errorf("expected no runs after stop, got %d more", n-calls)
+	}
+}
+
+func TestExpirerStopDeadline(t *testing.T) {
+	s := newStorageStub(memory.New())
+	s.lots.block = make(chan struct{})
+	defer close(s.lots.block)
+
+	e := New(s, Options{Months: 1, Interval: time.Hour})
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// run is stuck in storage call
+	for s.lots.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	if err := e.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected stop to give up on deadline, got %v", err)
+	}
+}
+
+func TestExpirerDisabled(t *testing.T) {
+	s := newStorageStub(memory.New())
+	e := New(s, Options{Interval: time.Millisecond})
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 20)
+
+	if err := e.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.lots.calls.Load(); n != 0 {
+		t.Errorf("expected points to never expire, expiration ran %d times", n)
+	}
+}
@@ -0,0 +1,122 @@
+// Package hold releases points of withdrawal holds which were neither
+// captured nor voided in time.
+package hold
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"go.uber.org/zap"
+)
+
+// batchSize limits holds looked up by a single query.
+const batchSize = 100
+
+// Releaser periodically releases expired holds. It's safe to be run by many
+// app instances at once.
+type Releaser struct {
+	storage  storage.Storage
+	interval time.Duration
+
+	stop context.CancelFunc
+	done chan struct{} // closed when run loop exits
+}
+
+func New(storage storage.Storage, interval time.Duration) *Releaser {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &Releaser{
+		storage:  storage,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts releasing in background. It's stopped when ctx is done.
+func (r *Releaser) Start(ctx context.Context) error {
+	logger.Log.Info("Starting withdrawal holds releaser", zap.Duration("interval", r.interval))
+
+	ctx, r.stop = context.WithCancel(ctx)
+	go r.run(ctx)
+
+	return nil
+}
+
+// Stop stops releasing and waits for the current run to finish until ctx
+// is done.
+func (r *Releaser) Stop(ctx context.Context) error {
+	if r.stop == nil {
+		// wasn't started
+		return nil
+	}
+
+	r.stop()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Releaser) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.release(ctx, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// release releases all holds expired by now.
+func (r *Releaser) release(ctx context.Context, now time.Time) {
+	var released int
+
+	for ctx.Err() == nil {
+		ids, err := r.storage.Holds().Expired(ctx, now, batchSize)
+		if err != nil {
+			logger.Log.Error("Error looking for expired holds", zap.Error(err))
+			return
+		}
+
+		var releasedInBatch int
+		for _, id := range ids {
+			err := r.storage.Holds().Expire(ctx, id, now)
+			if errors.Is(err, storage.ErrStateConflict) {
+				// captured or voided concurrently
+				continue
+			}
+			if err != nil {
+				logger.Log.Error("Error releasing expired hold", zap.Stringer("withdrawal_id", id), zap.Error(err))
+				continue
+			}
+
+			releasedInBatch++
+		}
+
+		released += releasedInBatch
+
+		// nothing left or the rest keep failing
+		if len(ids) < batchSize || releasedInBatch == 0 {
+			break
+		}
+	}
+
+	if released > 0 {
+		logger.Log.Info("Expired withdrawal holds released", zap.Int("count", released))
+	}
+}
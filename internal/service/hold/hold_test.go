@@ -0,0 +1,263 @@
+package hold
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// mustCreateUser creates user having 100 points.
+func mustCreateUser(t *testing.T, s storage.Storage, login, order string) int64 {
+	t.Helper()
+
+	ctx := context.Background()
+
+	id, err := s.Users().Create(ctx, model.User{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Balance().Accrue(ctx, model.OrderNumber(order), points(t, "100"), id); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func mustHold(t *testing.T, s storage.Storage, userID int64, order string, expiresAt time.Time) uuid.UUID {
+	t.Helper()
+
+	wd, err := s.Holds().Create(context.Background(), points(t, "30"), userID, model.OrderNumber(order), expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wd.ID
+}
+
+func assertHeld(t *testing.T, s storage.Storage, userID int64, want model.Points) {
+	t.Helper()
+
+	balance, err := s.Balance().Get(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Held != want {
+		t.Errorf("expected user %d to have %s held, got %s", userID, want, balance.Held)
+	}
+}
+
+// observeLogs replaces logger with the one recording errors until the test
+// ends.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+
+	core, logs := observer.New(zapcore.ErrorLevel)
+
+	log := logger.Log
+	logger.Log = zap.New(core)
+	t.Cleanup(func() { logger.Log = log })
+
+	return logs
+}
+
+// holdsStub fails or blocks chosen calls, the rest go to the storage.
+type holdsStub struct {
+	storage.HoldsRepository
+
+	calls     atomic.Int64  // Expired calls
+	block     chan struct{} // Expired waits for it when set
+	errLookup error         // Expired fails with it
+	errExpire map[uuid.UUID]error
+}
+
+func (h *holdsStub) Expired(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	h.calls.Add(1)
+
+	if h.block != nil {
+		<-h.block
+	}
+
+	if h.errLookup != nil {
+		return nil, h.errLookup
+	}
+
+	return h.HoldsRepository.Expired(ctx, now, limit)
+}
+
+func (h *holdsStub) Expire(ctx context.Context, id uuid.UUID, now time.Time) error {
+	if err, ok := h.errExpire[id]; ok {
+		return err
+	}
+
+	return h.HoldsRepository.Expire(ctx, id, now)
+}
+
+type storageStub struct {
+	storage.Storage
+	holds *holdsStub
+}
+
+func (s *storageStub) Holds() storage.HoldsRepository {
+	return s.holds
+}
+
+func newStorageStub(s storage.Storage) *storageStub {
+	return &storageStub{
+		Storage: s,
+		holds:   &holdsStub{HoldsRepository: s.Holds()},
+	}
+}
+
+func TestReleaseHoldsPastDeadline(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	r := New(s, time.Minute)
+
+	userID := mustCreateUser(t, s, "user", "12345678903")
+
+	now := time.Now()
+	mustHold(t, s, userID, "79927398713", now.Add(time.Hour))
+	later := mustHold(t, s, userID, "4561261212345467", now.Add(time.Hour*2))
+
+	r.release(ctx, now.Add(time.Minute*90))
+	assertHeld(t, s, userID, points(t, "30"))
+
+	ids, err := s.Holds().Expired(ctx, now.Add(time.Hour*3), batchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != later {
+		t.Errorf("expected only the later hold to be left, got %v", ids)
+	}
+
+	r.release(ctx, now.Add(time.Hour*3))
+	assertHeld(t, s, userID, 0)
+}
+
+func TestReleaseContinuesOnError(t *testing.T) {
+	ctx := context.Background()
+	logs := observeLogs(t)
+
+	mem := memory.New()
+	s := newStorageStub(mem)
+	r := New(s, time.Minute)
+
+	failingID := mustCreateUser(t, mem, "failing", "12345678903")
+	capturedID := mustCreateUser(t, mem, "captured", "79927398713")
+	okID := mustCreateUser(t, mem, "ok", "4561261212345467")
+
+	now := time.Now()
+	failing := mustHold(t, mem, failingID, "5062821234567892", now)
+	captured := mustHold(t, mem, capturedID, "2377225624", now)
+	mustHold(t, mem, okID, "18", now)
+
+	s.holds.errExpire = map[uuid.UUID]error{
+		failing:  errors.New("release failed"),
+		captured: storage.ErrStateConflict,
+	}
+
+	r.release(ctx, now.Add(time.Minute))
+
+	assertHeld(t, mem, failingID, points(t, "30"))
+	assertHeld(t, mem, okID, 0)
+
+	// hold captured concurrently isn't an error
+	entries := logs.FilterMessage("Error releasing expired hold").All()
+	if len(entries) != 1 || entries[0].ContextMap()["withdrawal_id"] != failing.String() {
+		t.Errorf("expected only failed hold to be logged, got %+v", entries)
+	}
+
+	// lookup failure is logged and waits for the next tick
+	s.holds.errLookup = errors.New("lookup failed")
+	r.release(ctx, now.Add(time.Minute))
+
+	if n := logs.FilterMessage("Error looking for expired holds").Len(); n != 1 {
+		t.Errorf("expected lookup error to be logged once, got %d", n)
+	}
+}
+
+func TestReleaserTicksUntilStopped(t *testing.T) {
+	s := newStorageStub(memory.New())
+	r := New(s, time.Millisecond*10)
+
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for s.holds.calls.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected release to run on every tick, ran %d times", s.holds.calls.Load())
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := r.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := s.holds.calls.Load()
+	time.Sleep(time.Millisecond * 50)
+	if n := s.holds.calls.Load(); n != calls {
+		t.Errorf("expected no runs after stop, got %d more", n-calls)
+	}
+}
+
+func TestReleaserStopDeadline(t *testing.T) {
+	s := newStorageStub(memory.New())
+	s.holds.block = make(chan struct{})
+	defer close(s.holds.block)
+
+	r := New(s, time.Hour)
+
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// run is stuck in storage call
+	for s.holds.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	if err := r.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected stop to give up on deadline, got %v", err)
+	}
+}
+
+func TestReleaserStopWithoutStart(t *testing.T) {
+	r := New(memory.New(), time.Minute)
+
+	if err := r.Stop(context.Background()); err != nil {
+		t.Errorf("expected stop of not started releaser to do nothing, got %v", err)
+	}
+}
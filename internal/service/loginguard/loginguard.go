@@ -0,0 +1,238 @@
+// Package loginguard implements LoginGuard, which protects login from
+// passwords brute-force.
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Options sets up login attempts limits. Zero max failures disables
+// the check.
+type Options struct {
+	// failed attempts are counted within sliding window of this duration
+	Window time.Duration
+
+	// failures per login and per client ip allowed within the window
+	MaxLoginFailures int64
+	MaxIPFailures    int64
+
+	// the first lockout duration, each successive one lasts twice longer
+	// up to MaxLockout
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// Guard tracks failed login attempts and locks logins and client ips out
+// when there are too many of them. Attempts are persisted, so lockouts are
+// shared by all app instances.
+type Guard struct {
+	storage storage.LoginAttemptsRepository
+	opts    Options
+
+	// unix nanoseconds of the last purge of attempts fallen out of the window
+	purgedAt atomic.Int64
+}
+
+func New(storage storage.LoginAttemptsRepository, opts Options) *Guard {
+	if opts.MaxLockout < opts.Lockout {
+		opts.MaxLockout = opts.Lockout
+	}
+
+	return &Guard{
+		storage: storage,
+		opts:    opts,
+	}
+}
+
+// Check returns how long login attempts must be refused. Zero retryAfter
+// means attempt is allowed.
+func (g *Guard) Check(ctx context.Context, login, ip string) (retryAfter time.Duration, err error) {
+	now := time.Now()
+
+	for _, key := range []string{model.LoginLockoutKey(login), model.IPLockoutKey(ip)} {
+		lockout, err := g.lockout(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if lockout.Locked(now) {
+			retryAfter = max(retryAfter, lockout.LockedUntil.Sub(now))
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// Fail records failed attempt. Login and ip get locked out when there are
+// too many failures within the window, retryAfter is non-zero then.
+func (g *Guard) Fail(ctx context.Context, login, ip string) (retryAfter time.Duration, err error) {
+	now := time.Now()
+
+	err = g.storage.Add(ctx, model.LoginAttempt{
+		Login:     login,
+		IP:        ip,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	g.purge(ctx, now)
+
+	limits := []struct {
+		key   string
+		max   int64
+		count func(since time.Time) (int64, error)
+	}{
+		{
+			key: model.LoginLockoutKey(login),
+			max: g.opts.MaxLoginFailures,
+			count: func(since time.Time) (int64, error) {
+				return g.storage.CountByLogin(ctx, login, since)
+			},
+		},
+		{
+			key: model.IPLockoutKey(ip),
+			max: g.opts.MaxIPFailures,
+			count: func(since time.Time) (int64, error) {
+				return g.storage.CountByIP(ctx, ip, since)
+			},
+		},
+	}
+
+	for _, limit := range limits {
+		if limit.max <= 0 {
+			continue
+		}
+
+		lockout, err := g.lockout(ctx, limit.key)
+		if err != nil {
+			return retryAfter, err
+		}
+
+		count, err := limit.count(g.countSince(lockout, now))
+		if err != nil {
+			return retryAfter, err
+		}
+
+		if count < limit.max {
+			continue
+		}
+
+		g.lock(&lockout, now)
+		if err = g.storage.SetLockout(ctx, lockout); err != nil {
+			return retryAfter, err
+		}
+
+		logger.Log.Warn("Too many failed login attempts, locked out",
+			zap.String("key", lockout.Key),
+			zap.Int("level", lockout.Level),
+			zap.Time("until", lockout.LockedUntil),
+		)
+
+		retryAfter = max(retryAfter, lockout.LockedUntil.Sub(now))
+	}
+
+	return retryAfter, nil
+}
+
+// Succeed resets login's failures counter after successful login. Failures
+// from the ip are still counted, so an attacker can't reset them by logging
+// into their own account.
+func (g *Guard) Succeed(ctx context.Context, login string) error {
+	now := time.Now()
+
+	lockout, err := g.lockout(ctx, model.LoginLockoutKey(login))
+	if err != nil {
+		return err
+	}
+
+	// don't write on every login when there is nothing to reset
+	count, err := g.storage.CountByLogin(ctx, login, g.countSince(lockout, now))
+	if err != nil || (count == 0 && lockout.Level == 0) {
+		return err
+	}
+
+	lockout.Level = 0
+	lockout.LockedUntil = time.Time{}
+	lockout.ResetAt = now
+
+	return g.storage.SetLockout(ctx, lockout)
+}
+
+// purge removes attempts and lockouts which don't affect new attempts
+// anymore. It runs at most once per window, failure is only logged since
+// the attempt itself is already recorded.
+func (g *Guard) purge(ctx context.Context, now time.Time) {
+	if g.opts.Window <= 0 {
+		return
+	}
+
+	last := g.purgedAt.Load()
+	if now.Sub(time.Unix(0, last)) < g.opts.Window || !g.purgedAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	count, err := g.storage.Purge(ctx, now.Add(-g.opts.Window))
+	if err != nil {
+		logger.Log.Error("Failed to purge old login attempts", zap.Error(err))
+		return
+	}
+
+	if count > 0 {
+		logger.Log.Debug("Purged old login attempts", zap.Int64("count", count))
+	}
+}
+
+// lockout finds lockout by key. Zero lockout is returned when key was never
+// locked.
+func (g *Guard) lockout(ctx context.Context, key string) (lockout model.Lockout, err error) {
+	lockout, err = g.storage.Lockout(ctx, key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return lockout, err
+	}
+
+	lockout.Key = key
+
+	return lockout, nil
+}
+
+// countSince returns the moment failures are counted since. Failures which
+// already caused lockout or happened before the reset are not counted.
+func (g *Guard) countSince(lockout model.Lockout, now time.Time) time.Time {
+	since := now.Add(-g.opts.Window)
+
+	for _, t := range []time.Time{lockout.ResetAt, lockout.LockedUntil} {
+		if t.After(since) {
+			since = t
+		}
+	}
+
+	return since
+}
+
+// lock prolongs lockout. Its duration doubles on every successive lockout,
+// lockouts are not successive anymore when the last one ended longer than
+// the window ago.
+func (g *Guard) lock(lockout *model.Lockout, now time.Time) {
+	if now.Sub(lockout.LockedUntil) > g.opts.Window {
+		lockout.Level = 0
+	}
+
+	lockout.Level++
+
+	d := g.opts.Lockout
+	for i := 1; i < lockout.Level && d < g.opts.MaxLockout; i++ {
+		d *= 2
+	}
+
+	lockout.LockedUntil = now.Add(min(d, g.opts.MaxLockout))
+}
@@ -0,0 +1,226 @@
+package loginguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+var testOptions = Options{
+	Window:           time.Hour,
+	MaxLoginFailures: 3,
+	MaxIPFailures:    10,
+	Lockout:          time.Minute,
+	MaxLockout:       4 * time.Minute,
+}
+
+// failN makes n failed attempts and returns retryAfter of the last one.
+func failN(t *testing.T, g *Guard, n int, login, ip string) (retryAfter time.Duration) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		var err error
+		if retryAfter, err = g.Fail(context.Background(), login, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return retryAfter
+}
+
+func assertLocked(t *testing.T, g *Guard, login, ip string, want time.Duration) {
+	t.Helper()
+
+	retryAfter, err := g.Check(context.Background(), login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bit of time passes since the lockout
+	if retryAfter > want || retryAfter < want-time.Second {
+		t.Errorf("expected to be locked for %v, got %v", want, retryAfter)
+	}
+}
+
+// endLockout pretends the lockout has just ended.
+func endLockout(t *testing.T, repo storage.LoginAttemptsRepository, key string, ago time.Duration) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	lockout, err := repo.Lockout(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockout.LockedUntil = time.Now().Add(-ago)
+	if err = repo.SetLockout(ctx, lockout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailuresOutsideWindowAreNotCounted(t *testing.T) {
+	repo := memory.New().LoginAttempts()
+	g := New(repo, testOptions)
+
+	// failures made before the window
+	for i := 0; i < 5; i++ {
+		if err := repo.Add(context.Background(), model.LoginAttempt{
+			Login:     "gopher",
+			IP:        "10.0.0.1",
+			CreatedAt: time.Now().Add(-testOptions.Window - time.Minute),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if retryAfter := failN(t, g, 2, "gopher", "10.0.0.1"); retryAfter != 0 {
+		t.Fatalf("expected no lockout within limit, got %v", retryAfter)
+	}
+	assertLocked(t, g, "gopher", "10.0.0.1", 0)
+
+	if retryAfter := failN(t, g, 1, "gopher", "10.0.0.1"); retryAfter != testOptions.Lockout {
+		t.Fatalf("expected lockout for %v, got %v", testOptions.Lockout, retryAfter)
+	}
+	assertLocked(t, g, "gopher", "10.0.0.1", testOptions.Lockout)
+
+	// other logins from another ip aren't affected
+	assertLocked(t, g, "other", "10.0.0.2", 0)
+}
+
+func TestLockoutGrows(t *testing.T) {
+	repo := memory.New().LoginAttempts()
+
+	// only login's failures are limited here
+	opts := testOptions
+	opts.MaxIPFailures = 0
+	g := New(repo, opts)
+
+	key := model.LoginLockoutKey("gopher")
+
+	// each successive lockout lasts twice longer up to max lockout
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		if retryAfter := failN(t, g, 3, "gopher", "10.0.0.1"); retryAfter != want {
+			t.Fatalf("expected lockout for %v, got %v", want, retryAfter)
+		}
+		assertLocked(t, g, "gopher", "10.0.0.1", want)
+
+		// failures which caused lockout aren't counted again
+		endLockout(t, repo, key, 0)
+		assertLocked(t, g, "gopher", "10.0.0.1", 0)
+	}
+
+	lockout, err := repo.Lockout(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout.Level != 4 {
+		t.Errorf("expected lockout level 4, got %d", lockout.Level)
+	}
+
+	// lockouts aren't successive anymore when the last one ended longer
+	// than the window ago
+	endLockout(t, repo, key, testOptions.Window+time.Minute)
+	if retryAfter := failN(t, g, 1, "gopher", "10.0.0.2"); retryAfter != testOptions.Lockout {
+		t.Errorf("expected lockout to start over from %v, got %v", testOptions.Lockout, retryAfter)
+	}
+}
+
+func TestSucceedResetsLoginFailures(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New().LoginAttempts()
+
+	opts := testOptions
+	opts.MaxIPFailures = 4
+	g := New(repo, opts)
+
+	failN(t, g, 2, "gopher", "10.0.0.1")
+
+	if err := g.Succeed(ctx, "gopher"); err != nil {
+		t.Fatal(err)
+	}
+
+	// login's counter starts over
+	if retryAfter := failN(t, g, 1, "gopher", "10.0.0.1"); retryAfter != 0 {
+		t.Fatalf("expected login failures to be reset, got lockout for %v", retryAfter)
+	}
+
+	// but ip's one doesn't, so an attacker can't reset it by logging into
+	// their own account
+	if retryAfter := failN(t, g, 1, "gopher", "10.0.0.1"); retryAfter != opts.Lockout {
+		t.Fatalf("expected ip lockout for %v, got %v", opts.Lockout, retryAfter)
+	}
+	assertLocked(t, g, "other", "10.0.0.1", opts.Lockout)
+	assertLocked(t, g, "gopher", "10.0.0.2", 0)
+}
+
+func TestSucceedResetsLockoutLevel(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New().LoginAttempts()
+	g := New(repo, testOptions)
+
+	key := model.LoginLockoutKey("gopher")
+
+	failN(t, g, 3, "gopher", "10.0.0.1")
+	endLockout(t, repo, key, 0)
+
+	if err := g.Succeed(ctx, "gopher"); err != nil {
+		t.Fatal(err)
+	}
+
+	lockout, err := repo.Lockout(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout.Level != 0 || lockout.ResetAt.IsZero() {
+		t.Errorf("expected lockout to be reset, got %+v", lockout)
+	}
+
+	// the next lockout isn't a successive one
+	if retryAfter := failN(t, g, 3, "gopher", "10.0.0.1"); retryAfter != testOptions.Lockout {
+		t.Errorf("expected lockout for %v, got %v", testOptions.Lockout, retryAfter)
+	}
+}
+
+func TestFailPurgesOldAttempts(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New().LoginAttempts()
+	g := New(repo, testOptions)
+
+	old := model.LoginAttempt{
+		Login:     "gopher",
+		IP:        "10.0.0.1",
+		CreatedAt: time.Now().Add(-testOptions.Window - time.Minute),
+	}
+	if err := repo.Add(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	failN(t, g, 1, "other", "10.0.0.2")
+
+	attempts, err := repo.List(ctx, model.LoginAttemptsFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || attempts[0].Login != "other" {
+		t.Errorf("expected old attempts to be purged, got %+v", attempts)
+	}
+
+	// purge runs at most once per window
+	if err = repo.Add(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	failN(t, g, 1, "other", "10.0.0.2")
+
+	attempts, err = repo.List(ctx, model.LoginAttemptsFilter{Login: "gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 {
+		t.Errorf("expected no purge within the window, got %+v", attempts)
+	}
+}
@@ -0,0 +1,214 @@
+// Package referral runs referral program. Users invite others with personal
+// codes, both parties are rewarded once the first order of invited user is
+// processed.
+//
+// Fraud guards: referee registered from the address the code was issued to
+// is considered self-referral and is never rewarded. Optionally the same goes
+// for referee registered from the address another referee of the same
+// referrer registered from, it's off by default as households and offices
+// share addresses. Referrer's rewards are capped.
+package referral
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"go.uber.org/zap"
+)
+
+// codeAlphabet has no look-alike characters (0/O, 1/I/L).
+const codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+const (
+	codeLength = 8
+	// attempts to generate code not taken by others
+	codeAttempts = 5
+)
+
+// Options sets up referral program.
+type Options struct {
+	Rewards        model.ReferralRewards
+	MaxPerReferrer int  // rewarded referrals per referrer, 0 - unlimited
+	RejectSharedIP bool // reject referees sharing address with other referees
+}
+
+// Program runs referral program.
+type Program struct {
+	storage storage.Storage
+	opts    Options
+}
+
+func New(storage storage.Storage, opts Options) *Program {
+	return &Program{
+		storage: storage,
+		opts:    opts,
+	}
+}
+
+// Summary returns user's referral code along with referrals made with it.
+// Code is created on the first call, ip is the address it's issued to.
+func (p *Program) Summary(ctx context.Context, userID int64, ip string) (summary model.ReferralsSummary, err error) {
+	if summary.Code, err = p.code(ctx, userID, ip); err != nil {
+		return summary, err
+	}
+
+	if summary.Referrals, err = p.storage.Referrals().List(ctx, userID); err != nil {
+		return summary, err
+	}
+
+	for _, r := range summary.Referrals {
+		if r.Status == model.ReferralRewarded {
+			summary.Rewarded++
+			summary.Earned += r.ReferrerReward
+		}
+	}
+
+	return summary, nil
+}
+
+// code returns user's referral code creating it when there is none yet.
+func (p *Program) code(ctx context.Context, userID int64, ip string) (string, error) {
+	for i := 0; i < codeAttempts; i++ {
+		code, err := p.storage.Referrals().CodeByUser(ctx, userID)
+		if err == nil {
+			return code.Code, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return "", err
+		}
+
+		code = model.ReferralCode{
+			UserID: userID,
+			IP:     ip,
+		}
+		if code.Code, err = newCode(); err != nil {
+			return "", err
+		}
+
+		err = p.storage.Referrals().CreateCode(ctx, code)
+		if err == nil {
+			return code.Code, nil
+		}
+		if !errors.Is(err, storage.ErrDuplicateEntry) {
+			return "", err
+		}
+		// either code is taken or it was just created by concurrent call
+	}
+
+	return "", errors.New("failed to generate unique referral code")
+}
+
+// Refer links just registered referee to the owner of the code. Error
+// model.ErrReferralCode is returned when code doesn't exist. Self-referral
+// is saved as rejected, so referee isn't told about it.
+func (p *Program) Refer(ctx context.Context, tx storage.Storage, refereeID int64, code, ip string) error {
+	owner, err := tx.Referrals().FindCode(ctx, normalizeCode(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return model.ErrReferralCode
+		}
+		return err
+	}
+
+	referral := model.Referral{
+		RefereeID:  refereeID,
+		ReferrerID: owner.UserID,
+		Code:       owner.Code,
+		Status:     model.ReferralPending,
+		IP:         ip,
+	}
+
+	self, err := p.isSelfReferral(ctx, tx, owner, refereeID, ip)
+	if err != nil {
+		return err
+	}
+
+	if self {
+		referral.Status = model.ReferralRejected
+
+		logger.Log.Info("Self-referral rejected",
+			zap.Int64("referrer", owner.UserID),
+			zap.Int64("referee", refereeID),
+			zap.String("ip", ip),
+		)
+	}
+
+	return tx.Referrals().Create(ctx, referral)
+}
+
+func (p *Program) isSelfReferral(ctx context.Context, tx storage.Storage, owner model.ReferralCode, refereeID int64, ip string) (bool, error) {
+	if owner.UserID == refereeID {
+		return true, nil
+	}
+
+	if ip == "" {
+		return false, nil
+	}
+
+	if owner.IP == ip {
+		return true, nil
+	}
+
+	if !p.opts.RejectSharedIP {
+		return false, nil
+	}
+
+	referrals, err := tx.Referrals().List(ctx, owner.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range referrals {
+		if r.IP == ip {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Reward credits both parties of referee's pending referral. Must be called
+// within transaction when referee's order is processed, the first one
+// completes the referral.
+func (p *Program) Reward(ctx context.Context, tx storage.Storage, order model.Order) error {
+	referral, err := tx.Referrals().Reward(ctx, order.UserID, order.ID, p.opts.Rewards, p.opts.MaxPerReferrer)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// wasn't referred or already completed
+			return nil
+		}
+		return fmt.Errorf("error rewarding referral: %w", err)
+	}
+
+	logger.Log.Info("Referral completed",
+		zap.Int64("referrer", referral.ReferrerID),
+		zap.Int64("referee", referral.RefereeID),
+		zap.String("status", string(referral.Status)),
+	)
+
+	return nil
+}
+
+// normalizeCode makes code typed by user comparable with the issued ones.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func newCode() (string, error) {
+	b := make([]byte, codeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+
+	return string(b), nil
+}
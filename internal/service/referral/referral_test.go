@@ -0,0 +1,263 @@
+package referral
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/memory"
+)
+
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func mustCreateUser(t *testing.T, s storage.Storage, login string) int64 {
+	t.Helper()
+
+	id, err := s.Users().Create(context.Background(), model.User{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// mustCode issues referrer's code to the ip.
+func mustCode(t *testing.T, p *Program, referrerID int64, ip string) string {
+	t.Helper()
+
+	summary, err := p.Summary(context.Background(), referrerID, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return summary.Code
+}
+
+func refer(t *testing.T, p *Program, s storage.Storage, refereeID int64, code, ip string) error {
+	t.Helper()
+
+	return s.InTx(context.Background(), func(tx storage.Storage) error {
+		return p.Refer(context.Background(), tx, refereeID, code, ip)
+	})
+}
+
+// processOrder saves processed order and credits its accrual, as poller
+// does before rewarding referral.
+func processOrder(t *testing.T, s storage.Storage, num string, userID int64) model.Order {
+	t.Helper()
+
+	ctx := context.Background()
+	order := model.Order{ID: model.OrderNumber(num), UserID: userID, Status: model.OrderProcessed, Accrual: points(t, "10")}
+
+	if _, err := s.Orders().Create(ctx, model.Order{ID: order.ID, UserID: userID, Status: "NEW"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Orders().SetProcessedStatus(ctx, order.ID, order.Status, order.Accrual); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Balance().Accrue(ctx, order.ID, order.Accrual, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	return order
+}
+
+func reward(t *testing.T, p *Program, s storage.Storage, order model.Order) {
+	t.Helper()
+
+	err := s.InTx(context.Background(), func(tx storage.Storage) error {
+		return p.Reward(context.Background(), tx, order)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBalance(t *testing.T, s storage.Storage, userID int64, want model.Points) {
+	t.Helper()
+
+	balance, err := s.Balance().Get(context.Background(), userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		t.Fatal(err)
+	}
+
+	if balance.Balance != want {
+		t.Errorf("expected user %d balance %s, got %s", userID, want, balance.Balance)
+	}
+}
+
+func TestSelfReferral(t *testing.T) {
+	tests := []struct {
+		name   string
+		login  string
+		ip     string
+		status model.ReferralStatus
+		shared model.ReferralStatus // when addresses shared by referees are rejected
+	}{
+		{name: "address the code was issued to", login: "same-ip", ip: "10.0.0.1", status: model.ReferralRejected, shared: model.ReferralRejected},
+		{name: "another address", login: "first", ip: "10.0.0.2", status: model.ReferralPending, shared: model.ReferralPending},
+		{name: "address of another referee", login: "second", ip: "10.0.0.2", status: model.ReferralPending, shared: model.ReferralRejected},
+		{name: "unknown address", login: "third", ip: "", status: model.ReferralPending, shared: model.ReferralPending},
+	}
+
+	for _, rejectShared := range []bool{false, true} {
+		s := memory.New()
+		p := New(s, Options{RejectSharedIP: rejectShared})
+
+		referrerID := mustCreateUser(t, s, "referrer")
+		code := mustCode(t, p, referrerID, "10.0.0.1")
+
+		for _, tt := range tests {
+			want := tt.status
+			if rejectShared {
+				want = tt.shared
+			}
+
+			refereeID := mustCreateUser(t, s, tt.login)
+
+			if err := refer(t, p, s, refereeID, code, tt.ip); err != nil {
+				t.Fatalf("%s: self-referral must not be reported to referee, got %v", tt.name, err)
+			}
+
+			referrals, err := s.Referrals().List(context.Background(), referrerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var found bool
+			for _, r := range referrals {
+				if r.RefereeID == refereeID {
+					found = true
+					if r.Status != want {
+						t.Errorf("%s (reject shared: %t): expected referral %s, got %s", tt.name, rejectShared, want, r.Status)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("%s: referral wasn't saved", tt.name)
+			}
+		}
+
+		// user can't refer themselves even from another address
+		if err := refer(t, p, s, referrerID, code, "10.0.0.3"); err != nil {
+			t.Fatal(err)
+		}
+
+		referral, err := s.Referrals().Reward(context.Background(), referrerID, "12345678903", model.ReferralRewards{}, 0)
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected self-referral to be rejected, got %+v, %v", referral, err)
+		}
+	}
+}
+
+func TestReferUnknownCode(t *testing.T) {
+	s := memory.New()
+	p := New(s, Options{})
+
+	referrerID := mustCreateUser(t, s, "referrer")
+	refereeID := mustCreateUser(t, s, "referee")
+	code := mustCode(t, p, referrerID, "10.0.0.1")
+
+	if err := refer(t, p, s, refereeID, "NOSUCHCODE", "10.0.0.2"); !errors.Is(err, model.ErrReferralCode) {
+		t.Errorf("expected ErrReferralCode, got %v", err)
+	}
+
+	// code typed by user is normalized
+	if err := refer(t, p, s, refereeID, "  "+strings.ToLower(code)+" ", "10.0.0.2"); err != nil {
+		t.Errorf("expected lowercase code to be accepted, got %v", err)
+	}
+}
+
+func TestRewardOnce(t *testing.T) {
+	s := memory.New()
+	p := New(s, Options{
+		Rewards: model.ReferralRewards{Referrer: points(t, "50"), Referee: points(t, "20")},
+	})
+
+	referrerID := mustCreateUser(t, s, "referrer")
+	refereeID := mustCreateUser(t, s, "referee")
+
+	if err := refer(t, p, s, refereeID, mustCode(t, p, referrerID, "10.0.0.1"), "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first processed order completes the referral, the rest don't
+	// reward anyone again
+	reward(t, p, s, processOrder(t, s, "12345678903", refereeID))
+	reward(t, p, s, processOrder(t, s, "79927398713", refereeID))
+
+	assertBalance(t, s, referrerID, points(t, "50"))
+	assertBalance(t, s, refereeID, points(t, "40")) // two accruals and the reward
+
+	summary, err := p.Summary(context.Background(), referrerID, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Rewarded != 1 || summary.Earned != points(t, "50") ||
+		len(summary.Referrals) != 1 || summary.Referrals[0].Order != "12345678903" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestRewardRejectedAndCapped(t *testing.T) {
+	s := memory.New()
+	p := New(s, Options{
+		Rewards:        model.ReferralRewards{Referrer: points(t, "50"), Referee: points(t, "20")},
+		MaxPerReferrer: 1,
+	})
+
+	referrerID := mustCreateUser(t, s, "referrer")
+	code := mustCode(t, p, referrerID, "10.0.0.1")
+
+	selfID := mustCreateUser(t, s, "self")
+	firstID := mustCreateUser(t, s, "first")
+	secondID := mustCreateUser(t, s, "second")
+
+	for _, referee := range []struct {
+		id int64
+		ip string
+	}{{selfID, "10.0.0.1"}, {firstID, "10.0.0.2"}, {secondID, "10.0.0.3"}} {
+		if err := refer(t, p, s, referee.id, code, referee.ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reward(t, p, s, processOrder(t, s, "12345678903", selfID))
+	reward(t, p, s, processOrder(t, s, "79927398713", firstID))
+	reward(t, p, s, processOrder(t, s, "2377225624", secondID))
+
+	// rejected referee isn't rewarded, capped one isn't either
+	assertBalance(t, s, selfID, points(t, "10"))
+	assertBalance(t, s, firstID, points(t, "30"))
+	assertBalance(t, s, secondID, points(t, "10"))
+	assertBalance(t, s, referrerID, points(t, "50"))
+
+	referrals, err := s.Referrals().List(context.Background(), referrerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[int64]model.ReferralStatus)
+	for _, r := range referrals {
+		statuses[r.RefereeID] = r.Status
+	}
+
+	if statuses[selfID] != model.ReferralRejected || statuses[firstID] != model.ReferralRewarded ||
+		statuses[secondID] != model.ReferralCapped {
+		t.Errorf("unexpected referrals statuses: %v", statuses)
+	}
+}
@@ -1,29 +1,112 @@
 // Package service contains services interfaces definition.
 package service
 
-import "github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
 
 // AccrualService retrieves accrual info from external service.
 type AccrualService interface {
 	AccrualClient
 	Poller() AccrualPoller
+	// Status returns current state of the accrual service client.
+	Status() model.AccrualStatus
 }
 
 // AccrualClient retrieves accrual info from external service.
 type AccrualClient interface {
-	Order(id model.OrderNumber) (model.AccrualOrder, error)
+	Order(ctx context.Context, id model.OrderNumber) (model.AccrualOrder, error)
 }
 
 type AccrualPoller interface {
-	Start() error
-	RegisterNewOrder(orderNumber model.OrderNumber) error
+	// Start starts polling in background. Polling is stopped when ctx is done.
+	Start(ctx context.Context) error
+	// Stop stops polling and waits for jobs in flight to finish until ctx is
+	// done.
+	Stop(ctx context.Context) error
+	RegisterNewOrder(ctx context.Context, orderNumber model.OrderNumber) error
+	// Repoll makes stuck order to be polled right away.
+	Repoll(ctx context.Context, orderNumber model.OrderNumber) error
+}
+
+// TierLadder computes users' loyalty tiers.
+type TierLadder interface {
+	// Status returns user's current tier and progress to the next one.
+	// Storage is passed, so status can be computed within transaction.
+	Status(ctx context.Context, balance storage.BalanceRepository, userID int64) (model.TierStatus, error)
+}
+
+// CampaignEngine credits marketing campaigns' bonuses.
+type CampaignEngine interface {
+	// Apply credits bonuses of running campaigns the processed order is
+	// eligible for. Must be called within transaction after the order's
+	// accrual was credited.
+	Apply(ctx context.Context, tx storage.Storage, order model.Order, tier string) ([]model.OrderBonus, error)
+}
+
+// ReferralProgram rewards users for inviting others.
+type ReferralProgram interface {
+	// Summary returns user's referral code along with referrals made with
+	// it. Code is created on the first call, ip is the address it's issued to.
+	Summary(ctx context.Context, userID int64, ip string) (model.ReferralsSummary, error)
+	// Refer links just registered referee to the owner of the code. Error
+	// model.ErrReferralCode is returned when code doesn't exist.
+	Refer(ctx context.Context, tx storage.Storage, refereeID int64, code, ip string) error
+	// Reward credits both parties of referee's pending referral. Must be
+	// called within transaction when referee's order is processed.
+	Reward(ctx context.Context, tx storage.Storage, order model.Order) error
+}
+
+// PointsExpirer expires points which weren't spent in time.
+type PointsExpirer interface {
+	// Start starts expiration in background. It's stopped when ctx is done.
+	Start(ctx context.Context) error
+	// Stop stops expiration and waits for the current run to finish until
+	// ctx is done.
+	Stop(ctx context.Context) error
+	// Expiring returns user's points expiring soon grouped by date.
+	Expiring(ctx context.Context, userID int64) ([]model.ExpiringPoints, error)
+}
+
+// HoldsReleaser releases withdrawal holds which weren't captured in time.
+type HoldsReleaser interface {
+	// Start starts releasing in background. It's stopped when ctx is done.
+	Start(ctx context.Context) error
+	// Stop stops releasing and waits for the current run to finish until
+	// ctx is done.
+	Stop(ctx context.Context) error
 }
 
 type AuthTokenProvider interface {
-	// CreateToken creates new jwt token for user.
-	CreateToken(userID int64) (string, error)
+	// CreateToken creates new jwt access token for user's session.
+	CreateToken(ctx context.Context, p model.Principal) (string, error)
 	// ParseToken parses and validates the token.
-	ParseToken(tokenString string) (userID int64, err error)
+	ParseToken(ctx context.Context, tokenString string) (p model.Principal, err error)
+	// CreateActionToken creates short-lived token confirming user's
+	// intention to perform the action from the session.
+	CreateActionToken(ctx context.Context, userID int64, sessionID uuid.UUID, action string, ttl time.Duration) (string, error)
+	// ParseActionToken parses and validates the action confirmation token.
+	ParseActionToken(ctx context.Context, tokenString, action string) (userID int64, sessionID uuid.UUID, err error)
+	// JWKS returns public keys tokens can be verified with.
+	JWKS(ctx context.Context) (model.JWKSet, error)
+	// TokenLifetime returns access token lifetime.
+	TokenLifetime() time.Duration
+}
+
+type RefreshTokenProvider interface {
+	// NewRefreshToken creates new random refresh token for the session.
+	// Only its hash must be stored.
+	NewRefreshToken(sessionID uuid.UUID) (token, hash string, err error)
+	// ParseRefreshToken extracts session id from refresh token and calculates
+	// token's hash to be compared with the stored one.
+	ParseRefreshToken(token string) (sessionID uuid.UUID, hash string, err error)
+	// RefreshTokenLifetime returns session lifetime since the last refresh.
+	RefreshTokenLifetime() time.Duration
 }
 
 type PasswordHasher interface {
@@ -31,12 +114,30 @@ type PasswordHasher interface {
 	PasswordHash(password string) (hash string, err error)
 	// CheckPasswordHash compares password and its expected hash.
 	CheckPasswordHash(hash, password string) (err error)
+	// NeedsRehash reports if hash must be replaced, because it was
+	// calculated by another hasher or with weaker parameters.
+	NeedsRehash(hash string) bool
+	// ValidatePassword checks if new password meets the password policy.
+	ValidatePassword(login, password string) error
 	// MaxPasswordLength returns max password length, which sometimes can be
 	// limited (like in bcrypt).
 	MaxPasswordLength() int
 }
 
+// LoginGuard protects login from passwords brute-force.
+type LoginGuard interface {
+	// Check returns how long login attempts must be refused, zero when
+	// attempt is allowed.
+	Check(ctx context.Context, login, ip string) (retryAfter time.Duration, err error)
+	// Fail records failed attempt. Non-zero retryAfter is returned when it
+	// caused lockout.
+	Fail(ctx context.Context, login, ip string) (retryAfter time.Duration, err error)
+	// Succeed resets login's failures counter.
+	Succeed(ctx context.Context, login string) error
+}
+
 type AuthService interface {
 	AuthTokenProvider
+	RefreshTokenProvider
 	PasswordHasher
 }
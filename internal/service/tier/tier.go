@@ -0,0 +1,78 @@
+// Package tier computes users' loyalty tiers. Tier depends on points sum
+// accrued or spent within rolling window, higher tiers get their order
+// accruals multiplied.
+package tier
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+// Options sets up tiers ladder.
+type Options struct {
+	Tiers  []model.Tier // sorted by threshold, the first one starts from 0
+	Basis  string       // model.TierBasisAccrued or model.TierBasisSpent
+	Window time.Duration
+}
+
+// Ladder computes users' tiers.
+type Ladder struct {
+	opts Options
+}
+
+// New creates tiers ladder, tiers must be already validated (see
+// model.ParseTiers).
+func New(opts Options) *Ladder {
+	if opts.Basis != model.TierBasisSpent {
+		opts.Basis = model.TierBasisAccrued
+	}
+
+	return &Ladder{
+		opts: opts,
+	}
+}
+
+// Status returns user's current tier and progress to the next one.
+// Storage is passed, so status can be computed within transaction.
+func (l *Ladder) Status(ctx context.Context, balance storage.BalanceRepository, userID int64) (status model.TierStatus, err error) {
+	activity, err := balance.Activity(ctx, userID, time.Now().Add(-l.opts.Window))
+	if err != nil {
+		return status, err
+	}
+
+	value := activity.Accrued
+	if l.opts.Basis == model.TierBasisSpent {
+		value = activity.Spent
+	}
+
+	status = model.TierStatus{
+		Basis:      l.opts.Basis,
+		WindowDays: int(l.opts.Window / (time.Hour * 24)),
+		Value:      value,
+		Progress:   1,
+	}
+
+	current := 0
+	for i, t := range l.opts.Tiers {
+		if value >= t.Threshold {
+			current = i
+		}
+	}
+
+	tier := l.opts.Tiers[current]
+	status.Tier = tier.Name
+	status.Multiplier = tier.Multiplier
+
+	if current+1 < len(l.opts.Tiers) {
+		next := l.opts.Tiers[current+1]
+		status.Next = next.Name
+		status.NextThreshold = next.Threshold
+		status.Remaining = next.Threshold - value
+		status.Progress = float64(value-tier.Threshold) / float64(next.Threshold-tier.Threshold)
+	}
+
+	return status, nil
+}
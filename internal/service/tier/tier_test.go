@@ -0,0 +1,138 @@
+package tier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+// activityStub returns fixed activity and records the window start.
+type activityStub struct {
+	storage.BalanceRepository
+	activity model.PointsActivity
+	since    time.Time
+}
+
+func (s *activityStub) Activity(ctx context.Context, userID int64, since time.Time) (model.PointsActivity, error) {
+	s.since = since
+	return s.activity, nil
+}
+
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestLadderBoundaries(t *testing.T) {
+	tiers, err := model.ParseTiers("gold:5000:1.1,bronze:0:1,silver:1000:1.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := New(Options{Tiers: tiers, Window: 90 * 24 * time.Hour})
+
+	tests := []struct {
+		value      string
+		tier       string
+		multiplier string
+		next       string
+		remaining  string
+		progress   float64
+	}{
+		{value: "0", tier: "bronze", multiplier: "1", next: "silver", remaining: "1000", progress: 0},
+		{value: "500", tier: "bronze", multiplier: "1", next: "silver", remaining: "500", progress: 0.5},
+		{value: "999.9999", tier: "bronze", multiplier: "1", next: "silver", remaining: "0.0001", progress: 0.9999999},
+		{value: "1000", tier: "silver", multiplier: "1.05", next: "gold", remaining: "4000", progress: 0},
+		{value: "4999.9999", tier: "silver", multiplier: "1.05", next: "gold", remaining: "0.0001", progress: 0.99999997},
+		{value: "5000", tier: "gold", multiplier: "1.1", progress: 1},
+		{value: "100000", tier: "gold", multiplier: "1.1", progress: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			balance := &activityStub{activity: model.PointsActivity{Accrued: points(t, tt.value)}}
+
+			status, err := l.Status(context.Background(), balance, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status.Tier != tt.tier || status.Multiplier != points(t, tt.multiplier) || status.Next != tt.next {
+				t.Errorf("unexpected status: %+v", status)
+			}
+
+			if tt.next != "" && status.Remaining != points(t, tt.remaining) {
+				t.Errorf("expected %s points remaining, got %s", tt.remaining, status.Remaining)
+			}
+
+			if d := status.Progress - tt.progress; d > 1e-6 || d < -1e-6 {
+				t.Errorf("expected progress %v, got %v", tt.progress, status.Progress)
+			}
+		})
+	}
+}
+
+func TestLadderBasis(t *testing.T) {
+	tiers, err := model.ParseTiers("bronze:0:1,silver:100:1.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activity := model.PointsActivity{Accrued: points(t, "150"), Spent: points(t, "50")}
+
+	tests := []struct {
+		basis string
+		tier  string
+		value string
+	}{
+		{basis: model.TierBasisAccrued, tier: "silver", value: "150"},
+		{basis: model.TierBasisSpent, tier: "bronze", value: "50"},
+		{basis: "", tier: "silver", value: "150"}, // accrued by default
+	}
+
+	for _, tt := range tests {
+		l := New(Options{Tiers: tiers, Basis: tt.basis, Window: 30 * 24 * time.Hour})
+
+		balance := &activityStub{activity: activity}
+		status, err := l.Status(context.Background(), balance, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status.Tier != tt.tier || status.Value != points(t, tt.value) || status.WindowDays != 30 {
+			t.Errorf("basis %q: unexpected status %+v", tt.basis, status)
+		}
+
+		// activity is counted within rolling window
+		if d := time.Since(balance.since) - 30*24*time.Hour; d < 0 || d > time.Minute {
+			t.Errorf("basis %q: activity counted since %v", tt.basis, balance.since)
+		}
+	}
+}
+
+func TestLadderSingleTier(t *testing.T) {
+	tiers, err := model.ParseTiers("member:0:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance := &activityStub{activity: model.PointsActivity{Accrued: points(t, "10")}}
+
+	status, err := New(Options{Tiers: tiers}).Status(context.Background(), balance, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Tier != "member" || status.Next != "" || status.Remaining != 0 || status.Progress != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
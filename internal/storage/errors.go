@@ -11,8 +11,14 @@ var (
 	ErrDuplicateEntry = errors.New("duplicate entry") // or Unique Violation
 	ErrCheckViolation = errors.New("check violation") // check constraint failed
 
+	// entity's current state doesn't allow the change (e.g. already decided)
+	ErrStateConflict = errors.New("state conflict")
+
 	// insufficient funds or negative balance set attempt
 	ErrNegativeBalance = errors.New("points balance value can't be negative")
+
+	// operation would exceed configured limit (e.g. daily transfers limit)
+	ErrLimitExceeded = errors.New("limit exceeded")
 )
 
 func WrapCaller(err error) error {
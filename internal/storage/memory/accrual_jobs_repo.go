@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type AccrualJobsRepo struct {
+	s *Storage
+}
+
+func NewAccrualJobsRepo(s *Storage) *AccrualJobsRepo {
+	return &AccrualJobsRepo{
+		s: s,
+	}
+}
+
+// enqueue must be called with lock held.
+func (r *AccrualJobsRepo) enqueue(orderID model.OrderNumber) bool {
+	if _, ok := r.s.data.jobs[orderID]; ok {
+		return false
+	}
+
+	r.s.data.jobs[orderID] = model.AccrualJob{
+		OrderID:   orderID,
+		NextRunAt: time.Now(),
+	}
+
+	return true
+}
+
+// Enqueue adds order to the queue. Already queued order is left untouched.
+func (r *AccrualJobsRepo) Enqueue(ctx context.Context, orderID model.OrderNumber) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.orders[orderID]; !ok {
+		return storage.WrapCaller(fmt.Errorf("job's order %s does not exist", orderID))
+	}
+
+	r.enqueue(orderID)
+
+	return nil
+}
+
+// EnqueueByStatus adds all orders with provided status which are missing
+// from the queue. Returns number of newly queued orders.
+func (r *AccrualJobsRepo) EnqueueByStatus(ctx context.Context, status string) (count int64, err error) {
+	defer r.s.lock()()
+
+	for _, order := range r.s.data.orders {
+		if order.Status == status && r.enqueue(order.ID) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Claim leases up to limit due jobs to the worker for lease duration and
+// increases their attempts counter. Jobs leased by other workers are skipped.
+func (r *AccrualJobsRepo) Claim(ctx context.Context, workerID string, limit int, lease time.Duration) (jobs []model.AccrualJob, err error) {
+	defer r.s.lock()()
+
+	now := time.Now()
+
+	due := make([]model.AccrualJob, 0)
+	for _, job := range r.s.data.jobs {
+		if job.NextRunAt.After(now) || job.LockedUntil.After(now) {
+			continue
+		}
+		due = append(due, job)
+	}
+
+	slices.SortFunc(due, func(a, b model.AccrualJob) int {
+		return a.NextRunAt.Compare(b.NextRunAt)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	jobs = make([]model.AccrualJob, 0, len(due))
+	for _, job := range due {
+		job.LockedBy = workerID
+		job.LockedUntil = now.Add(lease)
+		job.Attempts++
+		r.s.data.jobs[job.OrderID] = job
+
+		job.UserID = r.s.data.orders[job.OrderID].UserID
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Retry releases job's lease and schedules next attempt after delay.
+// Nothing is changed when the lease was already lost by the worker.
+func (r *AccrualJobsRepo) Retry(ctx context.Context, orderID model.OrderNumber, workerID string, delay time.Duration, lastErr string) error {
+	defer r.s.lock()()
+
+	job, ok := r.s.data.jobs[orderID]
+	if !ok || job.LockedBy != workerID {
+		return nil
+	}
+
+	job.NextRunAt = time.Now().Add(delay)
+	job.LastError = lastErr
+	job.LockedBy = ""
+	job.LockedUntil = time.Time{}
+	r.s.data.jobs[orderID] = job
+
+	return nil
+}
+
+// Reschedule makes order's job due right away, job is queued when it's
+// missing. Meant for stuck orders.
+func (r *AccrualJobsRepo) Reschedule(ctx context.Context, orderID model.OrderNumber) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.orders[orderID]; !ok {
+		return storage.WrapCaller(fmt.Errorf("job's order %s does not exist", orderID))
+	}
+
+	if r.enqueue(orderID) {
+		return nil
+	}
+
+	// lease is kept, worker holding it might be processing the job right now
+	job := r.s.data.jobs[orderID]
+	job.NextRunAt = time.Now()
+	r.s.data.jobs[orderID] = job
+
+	return nil
+}
+
+// Complete removes job from the queue.
+func (r *AccrualJobsRepo) Complete(ctx context.Context, orderID model.OrderNumber) error {
+	defer r.s.lock()()
+
+	delete(r.s.data.jobs, orderID)
+
+	return nil
+}
+
+// Heartbeat marks worker as alive and extends leases of all jobs held by it.
+func (r *AccrualJobsRepo) Heartbeat(ctx context.Context, workerID string, lease time.Duration) error {
+	defer r.s.lock()()
+
+	now := time.Now()
+	r.s.data.workers[workerID] = now
+
+	for id, job := range r.s.data.jobs {
+		if job.LockedBy == workerID {
+			job.LockedUntil = now.Add(lease)
+			r.s.data.jobs[id] = job
+		}
+	}
+
+	return nil
+}
+
+// Release releases leases of all jobs held by the worker and unregisters it.
+// Returns order numbers of released jobs.
+func (r *AccrualJobsRepo) Release(ctx context.Context, workerID string) (orders []model.OrderNumber, err error) {
+	defer r.s.lock()()
+
+	orders = make([]model.OrderNumber, 0)
+	for id, job := range r.s.data.jobs {
+		if job.LockedBy == workerID {
+			job.LockedBy = ""
+			job.LockedUntil = time.Time{}
+			r.s.data.jobs[id] = job
+			orders = append(orders, id)
+		}
+	}
+
+	delete(r.s.data.workers, workerID)
+
+	return orders, nil
+}
@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type AdjustmentsRepo struct {
+	s *Storage
+}
+
+func NewAdjustmentsRepo(s *Storage) *AdjustmentsRepo {
+	return &AdjustmentsRepo{
+		s: s,
+	}
+}
+
+type adjustmentRow struct {
+	model.Adjustment
+	createdAt time.Time
+	decidedAt time.Time
+}
+
+func (row adjustmentRow) adjustment() model.Adjustment {
+	adj := row.Adjustment
+	adj.CreatedAt = row.createdAt.Format(model.LayoutTimestamps)
+	if !row.decidedAt.IsZero() {
+		adj.DecidedAt = row.decidedAt.Format(model.LayoutTimestamps)
+	}
+
+	return adj
+}
+
+// Create saves manual adjustment. Pending adjustment is only saved, any
+// other is applied to user's balance right away.
+func (r *AdjustmentsRepo) Create(ctx context.Context, adj model.Adjustment) (saved model.Adjustment, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		row := adjustmentRow{
+			Adjustment: adj,
+			createdAt:  time.Now(),
+		}
+		row.ID = id
+		row.ApproverID = 0
+
+		if row.Status != model.AdjustmentPending {
+			row.Status = model.AdjustmentApplied
+			row.decidedAt = row.createdAt
+
+			if err := tx.adjustments.apply(ctx, row.Adjustment); err != nil {
+				return err
+			}
+		}
+
+		tx.data.adjustments[id] = row
+		saved = row.adjustment()
+
+		return nil
+	})
+
+	return saved, err
+}
+
+// Decide approves (and applies) or rejects pending adjustment.
+func (r *AdjustmentsRepo) Decide(ctx context.Context, id uuid.UUID, approverID int64, approve bool) (adj model.Adjustment, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		row, ok := tx.data.adjustments[id]
+		if !ok {
+			return storage.WrapCaller(storage.ErrNotFound)
+		}
+
+		if row.Status != model.AdjustmentPending {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		row.Status = model.AdjustmentRejected
+		row.ApproverID = approverID
+		row.decidedAt = time.Now()
+
+		if approve {
+			row.Status = model.AdjustmentApplied
+			if err := tx.adjustments.apply(ctx, row.Adjustment); err != nil {
+				return err
+			}
+		}
+
+		tx.data.adjustments[id] = row
+		adj = row.adjustment()
+
+		return nil
+	})
+
+	return adj, err
+}
+
+// apply changes balance by adjustment's amount and writes it down
+// to the ledger. Must be called within transaction.
+func (r *AdjustmentsRepo) apply(ctx context.Context, adj model.Adjustment) error {
+	if err := r.s.balance.change(ctx, adj.Amount, adj.UserID); err != nil {
+		return err
+	}
+
+	return r.s.postUserEntry(model.LedgerEntry{
+		UserID:       adj.UserID,
+		Kind:         model.LedgerAdjustment,
+		Amount:       adj.Amount,
+		AdjustmentID: &adj.ID,
+		Comment:      adj.Comment,
+	})
+}
+
+// Get returns adjustment by id.
+func (r *AdjustmentsRepo) Get(ctx context.Context, id uuid.UUID) (adj model.Adjustment, err error) {
+	defer r.s.lock()()
+
+	row, ok := r.s.data.adjustments[id]
+	if !ok {
+		return adj, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return row.adjustment(), nil
+}
+
+// List returns adjustments matching filter, newest first.
+func (r *AdjustmentsRepo) List(ctx context.Context, filter model.AdjustmentsFilter) (adjustments []model.Adjustment, err error) {
+	defer r.s.lock()()
+
+	rows := make([]adjustmentRow, 0)
+	for _, row := range r.s.data.adjustments {
+		if filter.UserID != 0 && row.UserID != filter.UserID {
+			continue
+		}
+
+		if filter.Status != "" && row.Status != filter.Status {
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	// ids are time ordered, so they break ties
+	slices.SortFunc(rows, func(a, b adjustmentRow) int {
+		if c := b.createdAt.Compare(a.createdAt); c != 0 {
+			return c
+		}
+		return slices.Compare(b.ID[:], a.ID[:])
+	})
+
+	if filter.Limit > 0 && len(rows) > filter.Limit {
+		rows = rows[:filter.Limit]
+	}
+
+	adjustments = make([]model.Adjustment, 0, len(rows))
+	for _, row := range rows {
+		adjustments = append(adjustments, row.adjustment())
+	}
+
+	return adjustments, nil
+}
@@ -0,0 +1,427 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type BalanceRepo struct {
+	s *Storage
+}
+
+func NewBalanceRepo(s *Storage) *BalanceRepo {
+	return &BalanceRepo{
+		s: s,
+	}
+}
+
+// Get returns current balance with total withdrawn value derived from the
+// ledger, held points and debt.
+func (r *BalanceRepo) Get(ctx context.Context, userID int64) (balance model.Balance, err error) {
+	defer r.s.lock()()
+
+	row, ok := r.s.data.balances[userID]
+	if !ok {
+		return balance, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	for _, entry := range r.s.userEntries(userID) {
+		balance.Balance += entry.Amount
+		if entry.Kind == model.LedgerWithdrawal || entry.Kind == model.LedgerReversal {
+			balance.TotalWithdrawn -= entry.Amount
+		}
+	}
+
+	balance.UserID = userID
+	balance.Held = row.held
+	balance.Debt = row.debt
+	balance.Available = balance.Balance - balance.Held
+	balance.Updated = row.updated.Format(model.LayoutTimestamps)
+
+	return balance, nil
+}
+
+// change adds (possibly negative) delta to the cached balance counter.
+// Credit opens new lot, debit consumes the oldest ones. Must be called
+// within transaction along with the ledger posting.
+func (r *BalanceRepo) change(ctx context.Context, delta model.Points, userID int64) error {
+	if _, ok := r.s.data.users[userID]; !ok {
+		return storage.WrapCaller(fmt.Errorf("balance's user %d does not exist", userID))
+	}
+
+	row := r.s.data.balances[userID]
+	if row.balance+delta < row.held {
+		// new balance value can't be negative or less than held
+		return storage.WrapCaller(storage.ErrNegativeBalance)
+	}
+
+	row.balance += delta
+	row.updated = time.Now()
+	r.s.data.balances[userID] = row
+
+	switch {
+	case delta > 0:
+		r.s.data.lotSeq++
+		r.s.data.lots = append(r.s.data.lots, model.PointsLot{
+			ID:        r.s.data.lotSeq,
+			UserID:    userID,
+			Amount:    delta,
+			Remaining: delta,
+			CreatedAt: row.updated,
+		})
+	case delta < 0:
+		r.s.consumeLots(userID, -delta)
+	}
+
+	return nil
+}
+
+// collectDebt pays off user's debt by up to credit points, which were just
+// accrued for an order. Other credits (transfers, reversals, adjustments)
+// don't pay debt off. Must be called within transaction.
+func (r *BalanceRepo) collectDebt(credit model.Points, userID int64) error {
+	row := r.s.data.balances[userID]
+	if row.debt <= 0 || credit <= 0 {
+		return nil
+	}
+
+	paid := min(row.debt, credit)
+
+	row.debt -= paid
+	row.balance -= paid
+	r.s.data.balances[userID] = row
+
+	r.s.consumeLots(userID, paid)
+
+	return r.s.postUserEntry(model.LedgerEntry{
+		UserID:  userID,
+		Kind:    model.LedgerClawback,
+		Amount:  -paid,
+		Comment: "debt payment",
+	})
+}
+
+// Add adds new accrual sum to current balance.
+// Returns new updated balance and current total withdrawn value.
+// Sum is written to the ledger as an adjustment without any reference,
+// so Accrue must be preferred for orders.
+func (r *BalanceRepo) Add(ctx context.Context, accrual model.Points, userID int64) (balance model.Balance, err error) {
+	if accrual < 0 {
+		accrual = 0
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if err := tx.balance.change(ctx, accrual, userID); err != nil {
+			return err
+		}
+
+		if err := tx.postUserEntry(model.LedgerEntry{
+			UserID: userID,
+			Kind:   model.LedgerAdjustment,
+			Amount: accrual,
+		}); err != nil {
+			return err
+		}
+
+		balance, err = tx.balance.Get(ctx, userID)
+
+		return err
+	})
+
+	return balance, err
+}
+
+// Accrue adds order's accrual sum to user's balance. Every order can be
+// credited only once, storage.ErrDuplicateEntry is returned on repeated call.
+func (r *BalanceRepo) Accrue(ctx context.Context, orderID model.OrderNumber, accrual model.Points, userID int64) (balance model.Balance, err error) {
+	if accrual < 0 {
+		accrual = 0
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if err := tx.postUserEntry(model.LedgerEntry{
+			UserID: userID,
+			Kind:   model.LedgerAccrual,
+			Amount: accrual,
+			Order:  string(orderID),
+		}); err != nil {
+			return err
+		}
+
+		if err := tx.balance.change(ctx, accrual, userID); err != nil {
+			return err
+		}
+
+		// debt of cancelled orders is paid off by the accrual first
+		if err := tx.balance.collectDebt(accrual, userID); err != nil {
+			return err
+		}
+
+		balance, err = tx.balance.Get(ctx, userID)
+
+		return err
+	})
+
+	return balance, err
+}
+
+// Withdraw decreases curent balance and writes entry to history.
+// Parameter orderID is a hypothetical order number.
+func (r *BalanceRepo) Withdraw(ctx context.Context, sum model.Points, userID int64, orderID model.OrderNumber) (err error) {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		// 1. decrease balance
+		if err := tx.balance.change(ctx, -sum, userID); err != nil {
+			return err
+		}
+
+		// generate withdrawal id
+		wdID, err := uuid.NewV7()
+		if err != nil {
+			logger.Log.Error("uuid generator failed", zap.Error(err))
+			return storage.WrapCaller(err)
+		}
+
+		// 2. save withdrawal entry to history
+		tx.data.withdrawals = append(tx.data.withdrawals, withdrawalRow{
+			id:          wdID,
+			userID:      userID,
+			order:       string(orderID),
+			value:       sum,
+			processedAt: time.Now(),
+			status:      model.WithdrawalCaptured,
+		})
+
+		// 3. write it down to the ledger
+		return tx.postUserEntry(model.LedgerEntry{
+			UserID:       userID,
+			Kind:         model.LedgerWithdrawal,
+			Amount:       -sum,
+			WithdrawalID: &wdID,
+		})
+	})
+}
+
+// Withdrawals returns all withdrawal calls for user with their reversals
+// linked. Voided and expired withdrawals are skipped.
+func (r *BalanceRepo) Withdrawals(ctx context.Context, userID int64) (history []model.Withdrawal, err error) {
+	defer r.s.lock()()
+
+	rows := r.userWithdrawals(userID)
+
+	history = make([]model.Withdrawal, 0, len(rows))
+	for _, row := range rows {
+		wd := row.withdrawal()
+		wd.UserID = 0 // matches db history which doesn't load it
+		wd.Reversals = r.s.withdrawalReversals(row.id)
+		history = append(history, wd)
+	}
+
+	return history, nil
+}
+
+// withdrawalReversals returns reversals of the withdrawal, earliest first.
+func (s *Storage) withdrawalReversals(id uuid.UUID) []model.WithdrawalReversal {
+	var reversals []model.WithdrawalReversal
+	for _, row := range s.data.reversals {
+		if row.WithdrawalID == id {
+			reversals = append(reversals, model.WithdrawalReversal{
+				ID:           row.ID,
+				WithdrawalID: row.WithdrawalID,
+				Order:        row.order,
+				Value:        row.Amount,
+				ProcessedAt:  row.createdAt.Format(model.LayoutTimestamps),
+			})
+		}
+	}
+
+	return reversals
+}
+
+// userWithdrawals returns user's held and captured withdrawals, earliest
+// first.
+func (r *BalanceRepo) userWithdrawals(userID int64) []withdrawalRow {
+	rows := make([]withdrawalRow, 0)
+	for _, row := range r.s.data.withdrawals {
+		if row.userID == userID && (row.status == model.WithdrawalHeld || row.status == model.WithdrawalCaptured) {
+			rows = append(rows, row)
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b withdrawalRow) int {
+		return a.processedAt.Compare(b.processedAt)
+	})
+
+	return rows
+}
+
+// History returns all withdrawal calls for user along with their reversals,
+// applied adjustments, transfers and clawbacks. Voided and expired
+// withdrawals are skipped.
+func (r *BalanceRepo) History(ctx context.Context, userID int64) (history []model.HistoryEntry, err error) {
+	defer r.s.lock()()
+
+	type historyRow struct {
+		model.HistoryEntry
+		processedAt time.Time
+	}
+
+	rows := make([]historyRow, 0)
+	for _, row := range r.userWithdrawals(userID) {
+		entry := model.HistoryEntry{
+			ID:       row.id,
+			Kind:     model.LedgerWithdrawal,
+			Order:    row.order,
+			Value:    row.value,
+			Status:   row.status,
+			Reversed: row.reversed,
+		}
+
+		if !row.expiresAt.IsZero() {
+			entry.ExpiresAt = row.expiresAt.Format(model.LayoutTimestamps)
+		}
+
+		rows = append(rows, historyRow{
+			HistoryEntry: entry,
+			processedAt:  row.processedAt,
+		})
+	}
+
+	for _, row := range r.s.data.reversals {
+		if row.UserID == userID {
+			withdrawalID := row.WithdrawalID
+
+			rows = append(rows, historyRow{
+				HistoryEntry: model.HistoryEntry{
+					ID:           row.ID,
+					Kind:         model.LedgerReversal,
+					Order:        row.order,
+					Value:        -row.Amount,
+					Comment:      row.Comment,
+					WithdrawalID: &withdrawalID,
+				},
+				processedAt: row.createdAt,
+			})
+		}
+	}
+
+	for _, row := range r.s.data.adjustments {
+		if row.UserID == userID && row.Status == model.AdjustmentApplied {
+			rows = append(rows, historyRow{
+				HistoryEntry: model.HistoryEntry{
+					ID:      row.ID,
+					Kind:    model.LedgerAdjustment,
+					Value:   -row.Amount,
+					Reason:  row.Reason,
+					Comment: row.Comment,
+				},
+				processedAt: row.decidedAt,
+			})
+		}
+	}
+
+	for _, row := range r.s.data.transfers {
+		if row.FromUserID != userID && row.ToUserID != userID {
+			continue
+		}
+
+		// sent points are shown as spent, received ones as a credit
+		value, other := row.Amount, row.ToUserID
+		if row.ToUserID == userID {
+			value, other = -row.Amount, row.FromUserID
+		}
+
+		rows = append(rows, historyRow{
+			HistoryEntry: model.HistoryEntry{
+				ID:           row.ID,
+				Kind:         model.LedgerTransfer,
+				Value:        value,
+				Comment:      row.Comment,
+				Counterparty: r.s.data.users[other].Login,
+			},
+			processedAt: row.createdAt,
+		})
+	}
+
+	for _, row := range r.s.data.cancellations {
+		if row.UserID == userID {
+			rows = append(rows, historyRow{
+				HistoryEntry: model.HistoryEntry{
+					ID:      row.ID,
+					Kind:    model.LedgerClawback,
+					Order:   string(row.Order),
+					Value:   row.Clawed + row.Debt,
+					Comment: row.Comment,
+				},
+				processedAt: row.createdAt,
+			})
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b historyRow) int {
+		return a.processedAt.Compare(b.processedAt)
+	})
+
+	history = make([]model.HistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		entry := row.HistoryEntry
+		entry.ProcessedAt = row.processedAt.Format(model.LayoutTimestamps)
+		history = append(history, entry)
+	}
+
+	return history, nil
+}
+
+// Statement returns all user's ledger entries explaining current balance.
+func (r *BalanceRepo) Statement(ctx context.Context, userID int64) (entries []model.LedgerEntry, err error) {
+	defer r.s.lock()()
+
+	rows := r.s.userEntries(userID)
+
+	entries = make([]model.LedgerEntry, 0, len(rows))
+	for _, row := range rows {
+		entry := row.LedgerEntry
+		entry.CreatedAt = row.createdAt.Format(model.LayoutTimestamps)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Reconcile rebuilds cached balance counter from the ledger.
+// Returns difference between the ledger and the counter before rebuild.
+func (r *BalanceRepo) Reconcile(ctx context.Context, userID int64) (drift model.Points, err error) {
+	defer r.s.lock()()
+
+	row, ok := r.s.data.balances[userID]
+	if !ok {
+		return 0, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	var actual model.Points
+	for _, entry := range r.s.userEntries(userID) {
+		actual += entry.Amount
+	}
+
+	drift = actual - row.balance
+	if drift == 0 {
+		return 0, nil
+	}
+
+	if actual < row.held {
+		return drift, storage.WrapCaller(storage.ErrNegativeBalance)
+	}
+
+	row.balance = actual
+	row.updated = time.Now()
+	r.s.data.balances[userID] = row
+
+	return drift, nil
+}
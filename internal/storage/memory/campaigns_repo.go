@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type CampaignsRepo struct {
+	s *Storage
+}
+
+func NewCampaignsRepo(s *Storage) *CampaignsRepo {
+	return &CampaignsRepo{
+		s: s,
+	}
+}
+
+func (r *CampaignsRepo) Create(ctx context.Context, c model.Campaign) (id int64, err error) {
+	defer r.s.lock()()
+
+	r.s.data.campaignSeq++
+	c.ID = r.s.data.campaignSeq
+	c.Tiers = slices.Clone(c.Tiers)
+	c.Spent = 0
+	c.CreatedAt = time.Now()
+
+	r.s.data.campaigns[c.ID] = c
+
+	return c.ID, nil
+}
+
+// Get finds campaign by id.
+func (r *CampaignsRepo) Get(ctx context.Context, id int64) (c model.Campaign, err error) {
+	defer r.s.lock()()
+
+	c, ok := r.s.data.campaigns[id]
+	if !ok {
+		return c, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	c.Tiers = slices.Clone(c.Tiers)
+
+	return c, nil
+}
+
+// Update replaces campaign's settings, spent budget is kept.
+func (r *CampaignsRepo) Update(ctx context.Context, c model.Campaign) error {
+	defer r.s.lock()()
+
+	current, ok := r.s.data.campaigns[c.ID]
+	if !ok {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	c.Tiers = slices.Clone(c.Tiers)
+	c.Spent = current.Spent
+	c.CreatedAt = current.CreatedAt
+
+	r.s.data.campaigns[c.ID] = c
+
+	return nil
+}
+
+// List returns all campaigns, newest first.
+func (r *CampaignsRepo) List(ctx context.Context) (campaigns []model.Campaign, err error) {
+	defer r.s.lock()()
+
+	campaigns = r.filter(func(model.Campaign) bool { return true })
+
+	slices.SortFunc(campaigns, func(a, b model.Campaign) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	return campaigns, nil
+}
+
+// Running returns campaigns running at the moment now, higher priority
+// first.
+func (r *CampaignsRepo) Running(ctx context.Context, now time.Time) (campaigns []model.Campaign, err error) {
+	defer r.s.lock()()
+
+	campaigns = r.filter(func(c model.Campaign) bool {
+		return c.Running(now)
+	})
+
+	slices.SortFunc(campaigns, func(a, b model.Campaign) int {
+		if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return campaigns, nil
+}
+
+func (r *CampaignsRepo) filter(match func(model.Campaign) bool) []model.Campaign {
+	campaigns := make([]model.Campaign, 0)
+	for _, c := range r.s.data.campaigns {
+		if match(c) {
+			c.Tiers = slices.Clone(c.Tiers)
+			campaigns = append(campaigns, c)
+		}
+	}
+
+	return campaigns
+}
+
+// Spend takes up to amount from campaign's budget. Returns granted amount,
+// which is zero when the budget is exhausted.
+func (r *CampaignsRepo) Spend(ctx context.Context, id int64, amount model.Points) (granted model.Points, err error) {
+	defer r.s.lock()()
+
+	c, ok := r.s.data.campaigns[id]
+	if !ok {
+		return 0, nil
+	}
+
+	granted = amount
+	if c.Budget > 0 {
+		granted = min(amount, c.Budget-c.Spent)
+	}
+
+	if granted <= 0 {
+		return 0, nil
+	}
+
+	c.Spent += granted
+	r.s.data.campaigns[id] = c
+
+	return granted, nil
+}
@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type CancellationsRepo struct {
+	s *Storage
+}
+
+func NewCancellationsRepo(s *Storage) *CancellationsRepo {
+	return &CancellationsRepo{
+		s: s,
+	}
+}
+
+type cancellationRow struct {
+	model.Cancellation
+	createdAt time.Time
+}
+
+// Create cancels processed order and takes points credited for it back.
+func (r *CancellationsRepo) Create(ctx context.Context, c model.Cancellation, policy string) (saved model.Cancellation, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		order, ok := tx.data.orders[c.Order]
+		if !ok {
+			return storage.WrapCaller(storage.ErrNotFound)
+		}
+
+		if order.Status != model.OrderProcessed {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		// referrer's reward is credited to another user, see cancelReferral
+		c.UserID = order.UserID
+		for _, row := range tx.userEntries(c.UserID) {
+			if row.Order == string(c.Order) && (row.Kind == model.LedgerAccrual || row.Kind == model.LedgerBonus) {
+				c.Owed += row.Amount
+			}
+		}
+
+		c.Clawed, c.Debt, err = tx.cancellations.clawback(ctx, c.UserID, c.Owed, policy, model.LedgerEntry{
+			Kind:    model.LedgerClawback,
+			Order:   string(c.Order),
+			Comment: c.Comment,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err = tx.cancellations.cancelReferral(ctx, c.Order, policy); err != nil {
+			return err
+		}
+
+		tx.refundCampaigns(c.Order)
+
+		order.Status = model.OrderCancelled
+		tx.data.orders[c.Order] = order
+
+		row := cancellationRow{
+			Cancellation: c,
+			createdAt:    time.Now(),
+		}
+		row.ID = id
+
+		tx.data.cancellations = append(tx.data.cancellations, row)
+
+		saved = row.Cancellation
+		saved.CreatedAt = row.createdAt.Format(model.LayoutTimestamps)
+
+		return nil
+	})
+
+	return saved, err
+}
+
+// clawback takes owed points back from user's balance and writes entry down
+// to the ledger. Points user has already spent are left as debt or written
+// off according to policy. Must be called within transaction.
+func (r *CancellationsRepo) clawback(ctx context.Context, userID int64, owed model.Points, policy string, entry model.LedgerEntry) (clawed, debt model.Points, err error) {
+	balance := r.s.data.balances[userID]
+
+	clawed = max(min(owed, balance.balance-balance.held), 0)
+	if policy == model.ClawbackDebt {
+		debt = owed - clawed
+	}
+
+	if clawed > 0 {
+		if err = r.s.balance.change(ctx, -clawed, userID); err != nil {
+			return 0, 0, err
+		}
+
+		entry.UserID = userID
+		entry.Amount = -clawed
+		if err = r.s.postUserEntry(entry); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if debt > 0 {
+		balance = r.s.data.balances[userID]
+		balance.debt += debt
+		r.s.data.balances[userID] = balance
+	}
+
+	return clawed, debt, nil
+}
+
+// cancelReferral takes referrer's reward back when the order completed
+// the referral. Must be called within transaction.
+func (r *CancellationsRepo) cancelReferral(ctx context.Context, orderID model.OrderNumber, policy string) error {
+	for refereeID, row := range r.s.data.referrals {
+		if row.Order != string(orderID) || row.Status != model.ReferralRewarded {
+			continue
+		}
+
+		row.Status = model.ReferralCancelled
+		r.s.data.referrals[refereeID] = row
+
+		// negative referral entry offsets the reward's one
+		_, _, err := r.clawback(ctx, row.ReferrerID, row.ReferrerReward, policy, model.LedgerEntry{
+			Kind:    model.LedgerReferral,
+			Order:   string(orderID),
+			Comment: "referee:" + strconv.FormatInt(refereeID, 10),
+		})
+
+		return err
+	}
+
+	// the order didn't complete any referral
+	return nil
+}
+
+// refundCampaigns returns campaigns' bonuses for the order to their budgets.
+func (s *Storage) refundCampaigns(orderID model.OrderNumber) {
+	for _, row := range s.data.bonuses {
+		if row.orderID != orderID || row.Source != model.BonusCampaign {
+			continue
+		}
+
+		id, err := strconv.ParseInt(row.Ref, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		c, ok := s.data.campaigns[id]
+		if !ok {
+			continue
+		}
+
+		c.Spent = max(c.Spent-row.Amount, 0)
+		s.data.campaigns[id] = c
+	}
+}
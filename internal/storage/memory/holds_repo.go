@@ -0,0 +1,232 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type HoldsRepo struct {
+	s *Storage
+}
+
+func NewHoldsRepo(s *Storage) *HoldsRepo {
+	return &HoldsRepo{
+		s: s,
+	}
+}
+
+func (row withdrawalRow) withdrawal() model.Withdrawal {
+	wd := model.Withdrawal{
+		ID:          row.id,
+		Order:       row.order,
+		Value:       row.value,
+		ProcessedAt: row.processedAt.Format(model.LayoutTimestamps),
+		Status:      row.status,
+		Reversed:    row.reversed,
+		UserID:      row.userID,
+	}
+
+	if !row.expiresAt.IsZero() {
+		wd.ExpiresAt = row.expiresAt.Format(model.LayoutTimestamps)
+	}
+
+	return wd
+}
+
+// Create reserves points for the withdrawal until expiresAt.
+func (r *HoldsRepo) Create(ctx context.Context, sum model.Points, userID int64, orderID model.OrderNumber, expiresAt time.Time) (wd model.Withdrawal, err error) {
+	wdID, err := uuid.NewV7()
+	if err != nil {
+		logger.Log.Error("uuid generator failed", zap.Error(err))
+		return wd, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if err := tx.holds.hold(sum, userID); err != nil {
+			return err
+		}
+
+		row := withdrawalRow{
+			id:          wdID,
+			userID:      userID,
+			order:       string(orderID),
+			value:       sum,
+			processedAt: time.Now(),
+			status:      model.WithdrawalHeld,
+			expiresAt:   expiresAt,
+		}
+		tx.data.withdrawals = append(tx.data.withdrawals, row)
+
+		wd = row.withdrawal()
+
+		return nil
+	})
+
+	return wd, err
+}
+
+// hold changes user's held points by (possibly negative) delta. Must be
+// called within transaction.
+func (r *HoldsRepo) hold(delta model.Points, userID int64) error {
+	row, ok := r.s.data.balances[userID]
+	if !ok || row.held+delta < 0 || row.held+delta > row.balance {
+		// can't hold more than balance
+		return storage.WrapCaller(storage.ErrNegativeBalance)
+	}
+
+	row.held += delta
+	row.updated = time.Now()
+	r.s.data.balances[userID] = row
+
+	return nil
+}
+
+// findHeld finds held withdrawal of the user. Zero userID matches any user.
+func (r *HoldsRepo) findHeld(id uuid.UUID, userID int64) (i int, err error) {
+	i = slices.IndexFunc(r.s.data.withdrawals, func(row withdrawalRow) bool {
+		return row.id == id
+	})
+
+	if i < 0 || (userID != 0 && r.s.data.withdrawals[i].userID != userID) {
+		return i, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	if r.s.data.withdrawals[i].status != model.WithdrawalHeld {
+		return i, storage.WrapCaller(storage.ErrStateConflict)
+	}
+
+	return i, nil
+}
+
+// Capture debits held withdrawal.
+func (r *HoldsRepo) Capture(ctx context.Context, id uuid.UUID, userID int64, sum model.Points) (wd model.Withdrawal, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		i, err := tx.holds.findHeld(id, userID)
+		if err != nil {
+			return err
+		}
+
+		row := tx.data.withdrawals[i]
+		if !row.expiresAt.After(time.Now()) {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		if sum == 0 {
+			sum = row.value
+		}
+
+		if sum < 0 || sum > row.value {
+			return storage.WrapCaller(storage.ErrCheckViolation)
+		}
+
+		// release first, otherwise debit would cut into held points
+		if err = tx.holds.hold(-row.value, userID); err != nil {
+			return err
+		}
+
+		if err = tx.balance.change(ctx, -sum, userID); err != nil {
+			return err
+		}
+
+		row.status = model.WithdrawalCaptured
+		row.value = sum
+		row.processedAt = time.Now()
+		tx.data.withdrawals[i] = row
+
+		if err = tx.postUserEntry(model.LedgerEntry{
+			UserID:       userID,
+			Kind:         model.LedgerWithdrawal,
+			Amount:       -sum,
+			WithdrawalID: &id,
+		}); err != nil {
+			return err
+		}
+
+		wd = row.withdrawal()
+
+		return nil
+	})
+
+	return wd, err
+}
+
+// Void releases points held by the withdrawal.
+func (r *HoldsRepo) Void(ctx context.Context, id uuid.UUID, userID int64) (wd model.Withdrawal, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		i, err := tx.holds.findHeld(id, userID)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.holds.release(i, model.WithdrawalVoided); err != nil {
+			return err
+		}
+
+		wd = tx.data.withdrawals[i].withdrawal()
+
+		return nil
+	})
+
+	return wd, err
+}
+
+// Expired returns ids of held withdrawals expired by now.
+func (r *HoldsRepo) Expired(ctx context.Context, now time.Time, limit int) (ids []uuid.UUID, err error) {
+	defer r.s.lock()()
+
+	var rows []withdrawalRow
+	for _, row := range r.s.data.withdrawals {
+		if row.status == model.WithdrawalHeld && !row.expiresAt.After(now) {
+			rows = append(rows, row)
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b withdrawalRow) int {
+		return a.expiresAt.Compare(b.expiresAt)
+	})
+
+	ids = make([]uuid.UUID, 0, min(len(rows), limit))
+	for _, row := range rows[:min(len(rows), limit)] {
+		ids = append(ids, row.id)
+	}
+
+	return ids, nil
+}
+
+// Expire releases points of the hold expired by now.
+func (r *HoldsRepo) Expire(ctx context.Context, id uuid.UUID, now time.Time) error {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		i, err := tx.holds.findHeld(id, 0)
+		if err != nil {
+			return err
+		}
+
+		if tx.data.withdrawals[i].expiresAt.After(now) {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		return tx.holds.release(i, model.WithdrawalExpired)
+	})
+}
+
+// release returns points held by i-th withdrawal and sets its final status.
+// Must be called within transaction.
+func (r *HoldsRepo) release(i int, status model.WithdrawalStatus) error {
+	row := r.s.data.withdrawals[i]
+
+	if err := r.hold(-row.value, row.userID); err != nil {
+		return err
+	}
+
+	row.status = status
+	r.s.data.withdrawals[i] = row
+
+	return nil
+}
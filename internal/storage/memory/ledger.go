@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+// posting is a single side of ledger transaction.
+type posting struct {
+	account string
+	userID  int64
+	amount  model.Points
+}
+
+// postLedger writes balanced transaction to the ledger. Entry provides kind
+// and references shared by all postings. First posting is the primary one:
+// when it conflicts with already existing entry (e.g. the order was already
+// credited) nothing is written and storage.ErrDuplicateEntry is returned.
+// Must be called within transaction.
+func (s *Storage) postLedger(entry model.LedgerEntry, postings ...posting) error {
+	var sum model.Points
+	for _, p := range postings {
+		sum += p.amount
+	}
+
+	if len(postings) < 2 || sum != 0 {
+		return storage.WrapCaller(fmt.Errorf("unbalanced ledger transaction: %d postings, sum %v", len(postings), sum))
+	}
+
+	if s.ledgerConflicts(entry, postings[0]) {
+		return storage.WrapCaller(storage.ErrDuplicateEntry)
+	}
+
+	txnID, err := uuid.NewV7()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	ts := time.Now()
+	for _, p := range postings {
+		s.data.ledgerSeq++
+
+		row := ledgerRow{
+			LedgerEntry: entry,
+			account:     p.account,
+			createdAt:   ts,
+		}
+		row.ID = s.data.ledgerSeq
+		row.TxnID = txnID
+		row.UserID = p.userID
+		row.Amount = p.amount
+		row.CreatedAt = ""
+
+		s.data.ledger = append(s.data.ledger, row)
+	}
+
+	return nil
+}
+
+// ledgerConflicts mimics unique indexes of the ledger table.
+func (s *Storage) ledgerConflicts(entry model.LedgerEntry, p posting) bool {
+	if entry.Kind != model.LedgerAccrual || p.account != model.AccountUser {
+		return false
+	}
+
+	for _, row := range s.data.ledger {
+		if row.Kind == model.LedgerAccrual && row.account == model.AccountUser && row.Order == entry.Order {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postUserEntry writes user's entry to the ledger balanced with posting to
+// the system account matching entry's kind.
+func (s *Storage) postUserEntry(entry model.LedgerEntry) error {
+	return s.postLedger(entry,
+		posting{account: model.AccountUser, userID: entry.UserID, amount: entry.Amount},
+		posting{account: model.CounterAccount(entry.Kind), userID: entry.UserID, amount: -entry.Amount},
+	)
+}
+
+// userEntries returns user's account postings.
+func (s *Storage) userEntries(userID int64) []ledgerRow {
+	rows := make([]ledgerRow, 0)
+	for _, row := range s.data.ledger {
+		if row.UserID == userID && row.account == model.AccountUser {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type LoginAttemptsRepo struct {
+	s *Storage
+}
+
+func NewLoginAttemptsRepo(s *Storage) *LoginAttemptsRepo {
+	return &LoginAttemptsRepo{
+		s: s,
+	}
+}
+
+func (r *LoginAttemptsRepo) Add(ctx context.Context, attempt model.LoginAttempt) error {
+	defer r.s.lock()()
+
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+
+	r.s.data.loginAttemptSeq++
+	attempt.ID = r.s.data.loginAttemptSeq
+	attempt.Login = strings.ToLower(attempt.Login)
+
+	r.s.data.loginAttempts = append(r.s.data.loginAttempts, attempt)
+
+	return nil
+}
+
+func (r *LoginAttemptsRepo) CountByLogin(ctx context.Context, login string, since time.Time) (count int64, err error) {
+	return r.count(model.LoginAttemptsFilter{Login: login}, since), nil
+}
+
+func (r *LoginAttemptsRepo) CountByIP(ctx context.Context, ip string, since time.Time) (count int64, err error) {
+	return r.count(model.LoginAttemptsFilter{IP: ip}, since), nil
+}
+
+func (r *LoginAttemptsRepo) count(filter model.LoginAttemptsFilter, since time.Time) (count int64) {
+	defer r.s.lock()()
+
+	for _, a := range r.s.data.loginAttempts {
+		if matchAttempt(a, filter) && !a.CreatedAt.Before(since) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// List returns failed attempts matching filter, newest first.
+func (r *LoginAttemptsRepo) List(ctx context.Context, filter model.LoginAttemptsFilter) (attempts []model.LoginAttempt, err error) {
+	defer r.s.lock()()
+
+	attempts = make([]model.LoginAttempt, 0)
+	for i := len(r.s.data.loginAttempts) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(attempts) == filter.Limit {
+			break
+		}
+
+		if a := r.s.data.loginAttempts[i]; matchAttempt(a, filter) {
+			attempts = append(attempts, a)
+		}
+	}
+
+	return attempts, nil
+}
+
+// Clear removes failed attempts matching filter along with lockouts of the
+// filter's login and ip. Returns number of removed attempts.
+func (r *LoginAttemptsRepo) Clear(ctx context.Context, filter model.LoginAttemptsFilter) (count int64, err error) {
+	defer r.s.lock()()
+
+	d := r.s.data
+
+	n := len(d.loginAttempts)
+	d.loginAttempts = slices.DeleteFunc(d.loginAttempts, func(a model.LoginAttempt) bool {
+		return matchAttempt(a, filter)
+	})
+
+	if filter.Login != "" {
+		delete(d.lockouts, model.LoginLockoutKey(filter.Login))
+	}
+
+	if filter.IP != "" {
+		delete(d.lockouts, model.IPLockoutKey(filter.IP))
+	}
+
+	return int64(n - len(d.loginAttempts)), nil
+}
+
+// Purge removes attempts made before the moment along with lockouts which
+// ended and were reset before it. Returns number of removed attempts.
+func (r *LoginAttemptsRepo) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	defer r.s.lock()()
+
+	d := r.s.data
+
+	n := len(d.loginAttempts)
+	d.loginAttempts = slices.DeleteFunc(d.loginAttempts, func(a model.LoginAttempt) bool {
+		return a.CreatedAt.Before(before)
+	})
+
+	for key, l := range d.lockouts {
+		if l.LockedUntil.Before(before) && l.ResetAt.Before(before) {
+			delete(d.lockouts, key)
+		}
+	}
+
+	return int64(n - len(d.loginAttempts)), nil
+}
+
+func matchAttempt(a model.LoginAttempt, filter model.LoginAttemptsFilter) bool {
+	if filter.Login != "" && a.Login != strings.ToLower(filter.Login) {
+		return false
+	}
+
+	return filter.IP == "" || a.IP == filter.IP
+}
+
+// Lockout finds lockout by key. When key was never locked
+// storage.ErrNotFound error is returned.
+func (r *LoginAttemptsRepo) Lockout(ctx context.Context, key string) (lockout model.Lockout, err error) {
+	defer r.s.lock()()
+
+	lockout, ok := r.s.data.lockouts[key]
+	if !ok {
+		return lockout, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return lockout, nil
+}
+
+// SetLockout creates or replaces lockout.
+func (r *LoginAttemptsRepo) SetLockout(ctx context.Context, lockout model.Lockout) error {
+	defer r.s.lock()()
+
+	r.s.data.lockouts[lockout.Key] = lockout
+
+	return nil
+}
+
+// Lockouts returns lockouts which are in effect at the moment now.
+func (r *LoginAttemptsRepo) Lockouts(ctx context.Context, now time.Time) (lockouts []model.Lockout, err error) {
+	defer r.s.lock()()
+
+	lockouts = make([]model.Lockout, 0)
+	for _, l := range r.s.data.lockouts {
+		if l.Locked(now) {
+			lockouts = append(lockouts, l)
+		}
+	}
+
+	slices.SortFunc(lockouts, func(a, b model.Lockout) int {
+		return b.LockedUntil.Compare(a.LockedUntil)
+	})
+
+	return lockouts, nil
+}
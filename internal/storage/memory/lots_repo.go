@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type LotsRepo struct {
+	s *Storage
+}
+
+func NewLotsRepo(s *Storage) *LotsRepo {
+	return &LotsRepo{
+		s: s,
+	}
+}
+
+// consumeLots takes sum from user's lots, the oldest lots are consumed
+// first. Lots are appended in creation order, so it's the slice order.
+func (s *Storage) consumeLots(userID int64, sum model.Points) {
+	for i := range s.data.lots {
+		if sum <= 0 {
+			return
+		}
+
+		lot := &s.data.lots[i]
+		if lot.UserID != userID || lot.Remaining <= 0 {
+			continue
+		}
+
+		taken := min(lot.Remaining, sum)
+		lot.Remaining -= taken
+		sum -= taken
+	}
+}
+
+// List returns user's lots having points left, the oldest first.
+func (r *LotsRepo) List(ctx context.Context, userID int64) (lots []model.PointsLot, err error) {
+	defer r.s.lock()()
+
+	lots = make([]model.PointsLot, 0)
+	for _, lot := range r.s.data.lots {
+		if lot.UserID == userID && lot.Remaining > 0 {
+			lots = append(lots, lot)
+		}
+	}
+
+	return lots, nil
+}
+
+// UsersWithExpired returns ids of users having points left in lots
+// older than months at the moment now. Users whose available points are all
+// held are skipped.
+func (r *LotsRepo) UsersWithExpired(ctx context.Context, months int, now time.Time, limit int) (userIDs []int64, err error) {
+	defer r.s.lock()()
+
+	userIDs = make([]int64, 0)
+	for _, lot := range r.s.data.lots {
+		if lot.Remaining <= 0 || lot.ExpiresAt(months).After(now) || slices.Contains(userIDs, lot.UserID) {
+			continue
+		}
+
+		// held points don't expire until released, such users would be
+		// picked on every run otherwise
+		if balance := r.s.data.balances[lot.UserID]; balance.balance > balance.held {
+			userIDs = append(userIDs, lot.UserID)
+		}
+	}
+
+	slices.Sort(userIDs)
+
+	if len(userIDs) > limit {
+		userIDs = userIDs[:limit]
+	}
+
+	return userIDs, nil
+}
+
+// Expire takes remaining points of user's lots older than months away
+// and writes expiry entry to the ledger. Returns expired amount.
+func (r *LotsRepo) Expire(ctx context.Context, userID int64, months int, now time.Time) (expired model.Points, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		row, ok := tx.data.balances[userID]
+		if !ok {
+			return storage.WrapCaller(storage.ErrNotFound)
+		}
+
+		for _, lot := range tx.data.lots {
+			if lot.UserID == userID && !lot.ExpiresAt(months).After(now) {
+				expired += lot.Remaining
+			}
+		}
+
+		// expired lots are the oldest ones, so consuming debit takes exactly
+		// them; balance can only be less when counter drifted from the ledger
+		// or points are held (they don't expire until released)
+		expired = min(expired, row.balance-row.held)
+		if expired <= 0 {
+			return nil
+		}
+
+		if err := tx.balance.change(ctx, -expired, userID); err != nil {
+			return err
+		}
+
+		return tx.postUserEntry(model.LedgerEntry{
+			UserID: userID,
+			Kind:   model.LedgerExpiry,
+			Amount: -expired,
+		})
+	})
+
+	return expired, err
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type bonusRow struct {
+	model.OrderBonus
+	orderID model.OrderNumber
+	userID  int64
+}
+
+// AccrueBonus adds bonus for the order to user's balance. Every bonus can be
+// credited only once, storage.ErrDuplicateEntry is returned on repeated call.
+func (r *BalanceRepo) AccrueBonus(ctx context.Context, orderID model.OrderNumber, userID int64, bonus model.OrderBonus) (err error) {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		if _, ok := tx.data.orders[orderID]; !ok {
+			return storage.WrapCaller(fmt.Errorf("bonus order %s does not exist", orderID))
+		}
+
+		for _, row := range tx.data.bonuses {
+			if row.orderID == orderID && row.Source == bonus.Source && row.Ref == bonus.Ref {
+				return storage.WrapCaller(storage.ErrDuplicateEntry)
+			}
+		}
+
+		tx.data.bonuses = append(tx.data.bonuses, bonusRow{
+			OrderBonus: bonus,
+			orderID:    orderID,
+			userID:     userID,
+		})
+
+		if err := tx.balance.change(ctx, bonus.Amount, userID); err != nil {
+			return err
+		}
+
+		if err := tx.postUserEntry(model.LedgerEntry{
+			UserID:  userID,
+			Kind:    model.LedgerBonus,
+			Amount:  bonus.Amount,
+			Order:   string(orderID),
+			Comment: bonus.Source + ":" + bonus.Ref,
+		}); err != nil {
+			return err
+		}
+
+		// debt of cancelled orders is paid off by the bonus first
+		return tx.balance.collectDebt(bonus.Amount, userID)
+	})
+}
+
+// Activity returns sums of points user was credited for orders and spent
+// since the moment. Clawbacks of cancelled orders reduce credited sum.
+func (r *BalanceRepo) Activity(ctx context.Context, userID int64, since time.Time) (activity model.PointsActivity, err error) {
+	defer r.s.lock()()
+
+	for _, row := range r.s.userEntries(userID) {
+		if row.createdAt.Before(since) {
+			continue
+		}
+
+		switch row.Kind {
+		case model.LedgerAccrual, model.LedgerBonus, model.LedgerClawback:
+			activity.Accrued += row.Amount
+		case model.LedgerWithdrawal, model.LedgerReversal:
+			activity.Spent -= row.Amount
+		}
+	}
+
+	return activity, nil
+}
+
+// orderBonuses returns bonuses credited for the order.
+func (s *Storage) orderBonuses(orderID model.OrderNumber) []model.OrderBonus {
+	var bonuses []model.OrderBonus
+	for _, row := range s.data.bonuses {
+		if row.orderID == orderID {
+			bonuses = append(bonuses, row.OrderBonus)
+		}
+	}
+
+	return bonuses
+}
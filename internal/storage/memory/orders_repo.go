@@ -0,0 +1,178 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/util/generator"
+)
+
+type OrdersRepo struct {
+	s      *Storage
+	numgen *generator.OrderNumberGenerator
+}
+
+func NewOrdersRepo(s *Storage) *OrdersRepo {
+	// storage is always empty on start, so generator doesn't need a seed
+	numgen, _ := generator.NewOrderNumberGenerator()
+
+	return &OrdersRepo{
+		s:      s,
+		numgen: numgen,
+	}
+}
+
+// toOrder fills formatted timestamps.
+func (row orderRow) toOrder() model.Order {
+	order := row.Order
+	order.UploadedAt = row.uploadedAt.Format(model.LayoutTimestamps)
+	if !row.processedAt.IsZero() {
+		order.ProcessedAt = row.processedAt.Format(model.LayoutTimestamps)
+	}
+
+	return order
+}
+
+// Get returns nil order when wasn't found and storage.ErrNotFound error.
+func (r *OrdersRepo) Get(ctx context.Context, id model.OrderNumber) (order *model.Order, err error) {
+	defer r.s.lock()()
+
+	row, ok := r.s.data.orders[id]
+	if !ok {
+		return nil, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	o := row.toOrder()
+	o.Bonuses = r.s.orderBonuses(id)
+
+	return &o, nil
+}
+
+// filter returns orders matching f sorted by upload time.
+func (r *OrdersRepo) filter(f func(row orderRow) bool) []model.Order {
+	rows := make([]orderRow, 0)
+	for _, row := range r.s.data.orders {
+		if f(row) {
+			rows = append(rows, row)
+		}
+	}
+
+	slices.SortFunc(rows, func(a, b orderRow) int {
+		if c := a.uploadedAt.Compare(b.uploadedAt); c != 0 {
+			return c
+		}
+		return int(a.seq - b.seq)
+	})
+
+	orders := make([]model.Order, 0, len(rows))
+	for _, row := range rows {
+		orders = append(orders, row.toOrder())
+	}
+
+	return orders
+}
+
+func (r *OrdersRepo) GetByUserID(ctx context.Context, userID int64) (orders []model.Order, err error) {
+	defer r.s.lock()()
+
+	orders = r.filter(func(row orderRow) bool {
+		return row.UserID == userID
+	})
+
+	for i := range orders {
+		orders[i].Bonuses = r.s.orderBonuses(orders[i].ID)
+	}
+
+	return orders, nil
+}
+
+func (r *OrdersRepo) GetByStatus(ctx context.Context, status string) (orders []model.Order, err error) {
+	defer r.s.lock()()
+
+	return r.filter(func(row orderRow) bool {
+		return row.Status == status
+	}), nil
+}
+
+// CountByUserID returns number of user's orders having the status.
+func (r *OrdersRepo) CountByUserID(ctx context.Context, userID int64, status string) (count int64, err error) {
+	defer r.s.lock()()
+
+	for _, row := range r.s.data.orders {
+		if row.UserID == userID && row.Status == status {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *OrdersRepo) Create(ctx context.Context, order model.Order) (id string, err error) {
+	if order.ID == "" {
+		num, err := r.numgen.New()
+		if err != nil && !errors.Is(err, generator.ErrOrderGeneratorLimitReached) {
+			return id, storage.WrapCaller(err)
+		}
+		order.ID = model.OrderNumber(num)
+	}
+
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.orders[order.ID]; ok {
+		return id, storage.WrapCaller(storage.ErrDuplicateEntry)
+	}
+
+	if _, ok := r.s.data.users[order.UserID]; !ok {
+		return id, storage.WrapCaller(fmt.Errorf("order's user %d does not exist", order.UserID))
+	}
+
+	r.s.data.orderSeq++
+	r.s.data.orders[order.ID] = orderRow{
+		Order:      order,
+		seq:        r.s.data.orderSeq,
+		uploadedAt: time.Now(),
+	}
+
+	return string(order.ID), nil
+}
+
+func (r *OrdersRepo) SetProcessedStatus(ctx context.Context, orderID model.OrderNumber, status string, accrual model.Points) (processedAt time.Time, err error) {
+	processedAt = time.Now()
+
+	defer r.s.lock()()
+
+	row, ok := r.s.data.orders[orderID]
+	if !ok {
+		// the same as UPDATE of nothing
+		return processedAt, nil
+	}
+
+	row.Status = status
+	row.Accrual = accrual
+	row.processedAt = processedAt
+	r.s.data.orders[orderID] = row
+
+	return processedAt, nil
+}
+
+func (r *OrdersRepo) LastOrderNumber(ctx context.Context) (orderNumber model.OrderNumber, err error) {
+	defer r.s.lock()()
+
+	var last orderRow
+	for _, row := range r.s.data.orders {
+		if row.seq > last.seq {
+			last = row
+		}
+	}
+
+	if last.seq == 0 {
+		return "0", nil
+	}
+
+	return last.ID, nil
+}
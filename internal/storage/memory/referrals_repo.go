@@ -0,0 +1,215 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type ReferralsRepo struct {
+	s *Storage
+}
+
+func NewReferralsRepo(s *Storage) *ReferralsRepo {
+	return &ReferralsRepo{
+		s: s,
+	}
+}
+
+type referralRow struct {
+	model.Referral
+	createdAt time.Time
+	decidedAt time.Time
+}
+
+func (s *Storage) referral(row referralRow) model.Referral {
+	referral := row.Referral
+	referral.Referee = s.data.users[row.RefereeID].Login
+	referral.CreatedAt = row.createdAt.Format(model.LayoutTimestamps)
+	if !row.decidedAt.IsZero() {
+		referral.DecidedAt = row.decidedAt.Format(model.LayoutTimestamps)
+	}
+
+	return referral
+}
+
+// CreateCode saves user's referral code.
+func (r *ReferralsRepo) CreateCode(ctx context.Context, code model.ReferralCode) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.users[code.UserID]; !ok {
+		return storage.WrapCaller(fmt.Errorf("referral code's user %d does not exist", code.UserID))
+	}
+
+	if _, ok := r.s.data.referralCodes[code.UserID]; ok {
+		return storage.WrapCaller(storage.ErrDuplicateEntry)
+	}
+
+	for _, c := range r.s.data.referralCodes {
+		if c.Code == code.Code {
+			return storage.WrapCaller(storage.ErrDuplicateEntry)
+		}
+	}
+
+	r.s.data.referralCodes[code.UserID] = code
+
+	return nil
+}
+
+// CodeByUser returns user's referral code.
+func (r *ReferralsRepo) CodeByUser(ctx context.Context, userID int64) (code model.ReferralCode, err error) {
+	defer r.s.lock()()
+
+	code, ok := r.s.data.referralCodes[userID]
+	if !ok {
+		return code, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return code, nil
+}
+
+// FindCode finds referral code.
+func (r *ReferralsRepo) FindCode(ctx context.Context, code string) (found model.ReferralCode, err error) {
+	defer r.s.lock()()
+
+	for _, c := range r.s.data.referralCodes {
+		if c.Code == code {
+			return c, nil
+		}
+	}
+
+	return found, storage.WrapCaller(storage.ErrNotFound)
+}
+
+// Create saves referral.
+func (r *ReferralsRepo) Create(ctx context.Context, referral model.Referral) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.referrals[referral.RefereeID]; ok {
+		return storage.WrapCaller(storage.ErrDuplicateEntry)
+	}
+
+	for _, id := range []int64{referral.RefereeID, referral.ReferrerID} {
+		if _, ok := r.s.data.users[id]; !ok {
+			return storage.WrapCaller(fmt.Errorf("referral's user %d does not exist", id))
+		}
+	}
+
+	row := referralRow{
+		Referral:  referral,
+		createdAt: time.Now(),
+	}
+	row.Order = ""
+	row.ReferrerReward, row.RefereeReward = 0, 0
+
+	// referral rejected by fraud guards is decided right away
+	if row.Status != model.ReferralPending {
+		row.decidedAt = row.createdAt
+	}
+
+	r.s.data.referrals[referral.RefereeID] = row
+
+	return nil
+}
+
+// List returns user's referrals, newest first.
+func (r *ReferralsRepo) List(ctx context.Context, referrerID int64) (referrals []model.Referral, err error) {
+	defer r.s.lock()()
+
+	var rows []referralRow
+	for _, row := range r.s.data.referrals {
+		if row.ReferrerID == referrerID {
+			rows = append(rows, row)
+		}
+	}
+
+	slices.SortFunc(rows, func(a, b referralRow) int {
+		if c := b.createdAt.Compare(a.createdAt); c != 0 {
+			return c
+		}
+		return int(b.RefereeID - a.RefereeID)
+	})
+
+	referrals = make([]model.Referral, 0, len(rows))
+	for _, row := range rows {
+		referrals = append(referrals, r.s.referral(row))
+	}
+
+	return referrals, nil
+}
+
+// Reward completes referee's pending referral after the first order of the
+// referee was processed.
+func (r *ReferralsRepo) Reward(ctx context.Context, refereeID int64, orderID model.OrderNumber, rewards model.ReferralRewards, maxPerReferrer int) (referral model.Referral, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		row, ok := tx.data.referrals[refereeID]
+		if !ok || row.Status != model.ReferralPending {
+			return storage.WrapCaller(storage.ErrNotFound)
+		}
+
+		// cancelled referrals were rewarded once, so they use the cap up
+		rewarded := 0
+		for _, other := range tx.data.referrals {
+			if other.ReferrerID == row.ReferrerID &&
+				(other.Status == model.ReferralRewarded || other.Status == model.ReferralCancelled) {
+				rewarded++
+			}
+		}
+
+		row.Order = string(orderID)
+		row.decidedAt = time.Now()
+		row.Status = model.ReferralCapped
+
+		if maxPerReferrer == 0 || rewarded < maxPerReferrer {
+			row.Status = model.ReferralRewarded
+			row.ReferrerReward = rewards.Referrer
+			row.RefereeReward = rewards.Referee
+
+			if err := tx.referrals.credit(ctx, row.Referral); err != nil {
+				return err
+			}
+		}
+
+		tx.data.referrals[refereeID] = row
+		referral = tx.referral(row)
+
+		return nil
+	})
+
+	return referral, err
+}
+
+// credit credits rewards of both referral parties. Referee's reward is
+// credited as the order's bonus. Must be called within transaction.
+func (r *ReferralsRepo) credit(ctx context.Context, referral model.Referral) error {
+	if referral.RefereeReward > 0 {
+		err := r.s.balance.AccrueBonus(ctx, model.OrderNumber(referral.Order), referral.RefereeID, model.OrderBonus{
+			Source: model.BonusReferral,
+			Ref:    referral.Code,
+			Amount: referral.RefereeReward,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if referral.ReferrerReward <= 0 {
+		return nil
+	}
+
+	if err := r.s.balance.change(ctx, referral.ReferrerReward, referral.ReferrerID); err != nil {
+		return err
+	}
+
+	return r.s.postUserEntry(model.LedgerEntry{
+		UserID:  referral.ReferrerID,
+		Kind:    model.LedgerReferral,
+		Amount:  referral.ReferrerReward,
+		Comment: "referee:" + strconv.FormatInt(referral.RefereeID, 10),
+	})
+}
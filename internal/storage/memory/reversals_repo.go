@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type reversalRow struct {
+	model.Reversal
+	order     string // reversed withdrawal's order
+	createdAt time.Time
+}
+
+type ReversalsRepo struct {
+	s *Storage
+}
+
+func NewReversalsRepo(s *Storage) *ReversalsRepo {
+	return &ReversalsRepo{
+		s: s,
+	}
+}
+
+// Create returns points of captured withdrawal back to user's balance.
+func (r *ReversalsRepo) Create(ctx context.Context, rev model.Reversal) (saved model.Reversal, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		i := slices.IndexFunc(tx.data.withdrawals, func(row withdrawalRow) bool {
+			return row.id == rev.WithdrawalID
+		})
+		if i < 0 {
+			return storage.WrapCaller(storage.ErrNotFound)
+		}
+
+		wd := tx.data.withdrawals[i]
+
+		rest := wd.value - wd.reversed
+		if wd.status != model.WithdrawalCaptured || rest <= 0 {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		if rev.Amount == 0 {
+			rev.Amount = rest
+		}
+
+		if rev.Amount < 0 || rev.Amount > rest {
+			return storage.WrapCaller(storage.ErrCheckViolation)
+		}
+
+		wd.reversed += rev.Amount
+		tx.data.withdrawals[i] = wd
+
+		if err := tx.balance.change(ctx, rev.Amount, wd.userID); err != nil {
+			return err
+		}
+
+		row := reversalRow{
+			Reversal:  rev,
+			order:     wd.order,
+			createdAt: time.Now(),
+		}
+		row.ID = id
+		row.UserID = wd.userID
+
+		tx.data.reversals = append(tx.data.reversals, row)
+
+		saved = row.Reversal
+		saved.CreatedAt = row.createdAt.Format(model.LayoutTimestamps)
+
+		return tx.postUserEntry(model.LedgerEntry{
+			UserID:       wd.userID,
+			Kind:         model.LedgerReversal,
+			Amount:       rev.Amount,
+			WithdrawalID: &wd.id,
+			Comment:      rev.Comment,
+		})
+	})
+
+	return saved, err
+}
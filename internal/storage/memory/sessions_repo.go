@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type SessionsRepo struct {
+	s *Storage
+}
+
+func NewSessionsRepo(s *Storage) *SessionsRepo {
+	return &SessionsRepo{
+		s: s,
+	}
+}
+
+func (r *SessionsRepo) Create(ctx context.Context, session model.Session) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.users[session.UserID]; !ok {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	if _, ok := r.s.data.sessions[session.ID]; ok {
+		return storage.WrapCaller(storage.ErrDuplicateEntry)
+	}
+
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	session.RefreshedAt = session.CreatedAt
+	session.RevokedAt = time.Time{}
+
+	r.s.data.sessions[session.ID] = session
+
+	return nil
+}
+
+// Get finds session by id. When requested session doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *SessionsRepo) Get(ctx context.Context, id uuid.UUID) (session model.Session, err error) {
+	defer r.s.lock()()
+
+	session, ok := r.s.data.sessions[id]
+	if !ok {
+		return session, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return session, nil
+}
+
+// Rotate replaces refresh token hash of active session when current hash
+// equals oldHash and prolongs the session till expiresAt. Otherwise
+// storage.ErrNotFound is returned.
+func (r *SessionsRepo) Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash string, expiresAt time.Time) error {
+	defer r.s.lock()()
+
+	now := time.Now()
+
+	session, ok := r.s.data.sessions[id]
+	if !ok || session.RefreshHash != oldHash || !session.Active(now) {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	session.RefreshHash = newHash
+	session.RefreshedAt = now
+	session.ExpiresAt = expiresAt
+	r.s.data.sessions[id] = session
+
+	return nil
+}
+
+// Revoke revokes session. Already revoked session is left untouched.
+func (r *SessionsRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	defer r.s.lock()()
+
+	session, ok := r.s.data.sessions[id]
+	if !ok || !session.RevokedAt.IsZero() {
+		return nil
+	}
+
+	session.RevokedAt = time.Now()
+	r.s.data.sessions[id] = session
+
+	return nil
+}
+
+// RevokeAll revokes all active user's sessions. Returns number of revoked
+// sessions.
+func (r *SessionsRepo) RevokeAll(ctx context.Context, userID int64) (count int64, err error) {
+	return r.RevokeOthers(ctx, userID, uuid.Nil)
+}
+
+// RevokeOthers revokes all active user's sessions except the kept one.
+// Returns number of revoked sessions.
+func (r *SessionsRepo) RevokeOthers(ctx context.Context, userID int64, keep uuid.UUID) (count int64, err error) {
+	defer r.s.lock()()
+
+	now := time.Now()
+	for id, session := range r.s.data.sessions {
+		if session.UserID != userID || id == keep || !session.Active(now) {
+			continue
+		}
+
+		session.RevokedAt = now
+		r.s.data.sessions[id] = session
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type SigningKeysRepo struct {
+	s *Storage
+}
+
+func NewSigningKeysRepo(s *Storage) *SigningKeysRepo {
+	return &SigningKeysRepo{
+		s: s,
+	}
+}
+
+func (r *SigningKeysRepo) Create(ctx context.Context, key model.SigningKey) error {
+	defer r.s.lock()()
+
+	if _, ok := r.s.data.keys[key.ID]; ok {
+		return storage.WrapCaller(storage.ErrDuplicateEntry)
+	}
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	r.s.data.keys[key.ID] = key
+
+	return nil
+}
+
+// List returns keys which are not expired by now, newest first.
+func (r *SigningKeysRepo) List(ctx context.Context, now time.Time) (keys []model.SigningKey, err error) {
+	defer r.s.lock()()
+
+	keys = make([]model.SigningKey, 0, len(r.s.data.keys))
+	for _, key := range r.s.data.keys {
+		if key.ExpiresAt.IsZero() || key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b model.SigningKey) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// DeleteExpired removes keys expired by now. Returns number of removed keys.
+func (r *SigningKeysRepo) DeleteExpired(ctx context.Context, now time.Time) (count int64, err error) {
+	defer r.s.lock()()
+
+	for id, key := range r.s.data.keys {
+		if !key.ExpiresAt.IsZero() && !key.ExpiresAt.After(now) {
+			delete(r.s.data.keys, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,304 @@
+// Package memory implements storage repository keeping all data in memory.
+// Meant to be used in tests and local demos, data is lost on app restart.
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+// data is a set of "tables". Values are stored (not pointers), so the whole
+// set can be cheaply cloned for transaction rollback.
+type data struct {
+	users   map[int64]model.User
+	userSeq int64
+
+	orders   map[model.OrderNumber]orderRow
+	orderSeq int64
+
+	balances    map[int64]balanceRow
+	withdrawals []withdrawalRow
+	reversals   []reversalRow
+	ledger      []ledgerRow
+	ledgerSeq   int64
+	adjustments map[uuid.UUID]adjustmentRow
+	lots        []model.PointsLot
+	bonuses     []bonusRow
+	lotSeq      int64
+	transfers   []transferRow
+
+	cancellations []cancellationRow // of processed orders
+
+	jobs    map[model.OrderNumber]model.AccrualJob
+	workers map[string]time.Time
+
+	sessions map[uuid.UUID]model.Session
+	keys     map[string]model.SigningKey
+
+	loginAttempts   []model.LoginAttempt
+	loginAttemptSeq int64
+	lockouts        map[string]model.Lockout
+
+	campaigns   map[int64]model.Campaign
+	campaignSeq int64
+
+	referralCodes map[int64]model.ReferralCode // by owner's id
+	referrals     map[int64]referralRow        // by referee's id
+}
+
+type orderRow struct {
+	model.Order
+	seq         int64 // keeps insertion order for rows created at the same time
+	uploadedAt  time.Time
+	processedAt time.Time
+}
+
+type balanceRow struct {
+	balance model.Points
+	held    model.Points
+	debt    model.Points
+	updated time.Time
+}
+
+type withdrawalRow struct {
+	id          uuid.UUID
+	userID      int64
+	order       string
+	value       model.Points
+	processedAt time.Time
+	status      model.WithdrawalStatus
+	expiresAt   time.Time // zero unless made by hold
+	reversed    model.Points
+}
+
+type ledgerRow struct {
+	model.LedgerEntry
+	account   string
+	createdAt time.Time
+}
+
+func newData() *data {
+	return &data{
+		users:       make(map[int64]model.User),
+		orders:      make(map[model.OrderNumber]orderRow),
+		balances:    make(map[int64]balanceRow),
+		adjustments: make(map[uuid.UUID]adjustmentRow),
+		jobs:        make(map[model.OrderNumber]model.AccrualJob),
+		workers:     make(map[string]time.Time),
+		sessions:    make(map[uuid.UUID]model.Session),
+		keys:        make(map[string]model.SigningKey),
+		lockouts:    make(map[string]model.Lockout),
+		campaigns:   make(map[int64]model.Campaign),
+
+		referralCodes: make(map[int64]model.ReferralCode),
+		referrals:     make(map[int64]referralRow),
+	}
+}
+
+func (d *data) clone() *data {
+	c := *d
+
+	c.users = maps.Clone(d.users)
+	c.orders = maps.Clone(d.orders)
+	c.balances = maps.Clone(d.balances)
+	c.withdrawals = slices.Clone(d.withdrawals)
+	c.reversals = slices.Clone(d.reversals)
+	c.ledger = slices.Clone(d.ledger)
+	c.adjustments = maps.Clone(d.adjustments)
+	c.lots = slices.Clone(d.lots)
+	c.bonuses = slices.Clone(d.bonuses)
+	c.transfers = slices.Clone(d.transfers)
+	c.cancellations = slices.Clone(d.cancellations)
+	c.jobs = maps.Clone(d.jobs)
+	c.workers = maps.Clone(d.workers)
+	c.sessions = maps.Clone(d.sessions)
+	c.keys = maps.Clone(d.keys)
+	c.loginAttempts = slices.Clone(d.loginAttempts)
+	c.lockouts = maps.Clone(d.lockouts)
+	c.campaigns = maps.Clone(d.campaigns)
+	c.referralCodes = maps.Clone(d.referralCodes)
+	c.referrals = maps.Clone(d.referrals)
+
+	return &c
+}
+
+// Storage is safe for concurrent use. A single mutex guards all the data,
+// transactions hold it until they are finished.
+type Storage struct {
+	mu   *sync.Mutex
+	data *data
+	tx   bool // storage is bound to transaction, the mutex is already held
+
+	users         *UsersRepo
+	orders        *OrdersRepo
+	balance       *BalanceRepo
+	holds         *HoldsRepo
+	reversals     *ReversalsRepo
+	transfers     *TransfersRepo
+	adjustments   *AdjustmentsRepo
+	cancellations *CancellationsRepo
+	lots          *LotsRepo
+	jobs          *AccrualJobsRepo
+	sessions      *SessionsRepo
+	keys          *SigningKeysRepo
+	attempts      *LoginAttemptsRepo
+	campaigns     *CampaignsRepo
+	referrals     *ReferralsRepo
+}
+
+func New() *Storage {
+	s := &Storage{
+		mu:   &sync.Mutex{},
+		data: newData(),
+	}
+
+	s.init()
+
+	return s
+}
+
+func (s *Storage) init() {
+	s.users = NewUsersRepo(s)
+	s.orders = NewOrdersRepo(s)
+	s.balance = NewBalanceRepo(s)
+	s.holds = NewHoldsRepo(s)
+	s.reversals = NewReversalsRepo(s)
+	s.transfers = NewTransfersRepo(s)
+	s.adjustments = NewAdjustmentsRepo(s)
+	s.cancellations = NewCancellationsRepo(s)
+	s.lots = NewLotsRepo(s)
+	s.jobs = NewAccrualJobsRepo(s)
+	s.sessions = NewSessionsRepo(s)
+	s.keys = NewSigningKeysRepo(s)
+	s.attempts = NewLoginAttemptsRepo(s)
+	s.campaigns = NewCampaignsRepo(s)
+	s.referrals = NewReferralsRepo(s)
+}
+
+// lock acquires the mutex unless storage is bound to transaction.
+// Returned func releases it.
+func (s *Storage) lock() (unlock func()) {
+	if s.tx {
+		return func() {}
+	}
+
+	s.mu.Lock()
+
+	return s.mu.Unlock
+}
+
+// InTx runs f within a single transaction shared by all repositories of the
+// storage passed to f. Changes are discarded when f returns error. Nested
+// calls join the outer transaction.
+func (s *Storage) InTx(ctx context.Context, f func(tx storage.Storage) error) error {
+	return s.inTx(ctx, func(tx *Storage) error {
+		return f(tx)
+	})
+}
+
+// inTx is the same as InTx, but provides concrete storage type to f.
+func (s *Storage) inTx(ctx context.Context, f func(tx *Storage) error) error {
+	if s.tx {
+		return f(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the same as the db would refuse to begin transaction
+	if err := ctx.Err(); err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	snapshot := s.data.clone()
+
+	txs := &Storage{
+		mu:   s.mu,
+		data: s.data,
+		tx:   true,
+	}
+	txs.init()
+
+	// order numbers generator must be shared, so don't reinitialize it
+	txs.orders.numgen = s.orders.numgen
+
+	if err := f(txs); err != nil {
+		// rollback
+		*s.data = *snapshot
+		return err
+	}
+
+	return nil
+}
+
+// Close does nothing, it is here to implement storage.Storage interface.
+func (s *Storage) Close() error {
+	return nil
+}
+
+func (s *Storage) Users() storage.UsersRepository {
+	return s.users
+}
+
+func (s *Storage) Orders() storage.OrdersRepository {
+	return s.orders
+}
+
+func (s *Storage) Balance() storage.BalanceRepository {
+	return s.balance
+}
+
+func (s *Storage) Holds() storage.HoldsRepository {
+	return s.holds
+}
+
+func (s *Storage) Reversals() storage.ReversalsRepository {
+	return s.reversals
+}
+
+func (s *Storage) Transfers() storage.TransfersRepository {
+	return s.transfers
+}
+
+func (s *Storage) Adjustments() storage.AdjustmentsRepository {
+	return s.adjustments
+}
+
+func (s *Storage) Cancellations() storage.CancellationsRepository {
+	return s.cancellations
+}
+
+func (s *Storage) Lots() storage.LotsRepository {
+	return s.lots
+}
+
+func (s *Storage) AccrualJobs() storage.AccrualJobsRepository {
+	return s.jobs
+}
+
+func (s *Storage) Sessions() storage.SessionsRepository {
+	return s.sessions
+}
+
+func (s *Storage) SigningKeys() storage.SigningKeysRepository {
+	return s.keys
+}
+
+func (s *Storage) LoginAttempts() storage.LoginAttemptsRepository {
+	return s.attempts
+}
+
+func (s *Storage) Campaigns() storage.CampaignsRepository {
+	return s.campaigns
+}
+
+func (s *Storage) Referrals() storage.ReferralsRepository {
+	return s.referrals
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type TransfersRepo struct {
+	s *Storage
+}
+
+func NewTransfersRepo(s *Storage) *TransfersRepo {
+	return &TransfersRepo{
+		s: s,
+	}
+}
+
+// transferRow keeps transfer after deletion of any party, deleted party's
+// id is zeroed.
+type transferRow struct {
+	model.Transfer
+	createdAt time.Time
+}
+
+// Create moves points from one user's balance to another's. Limits are
+// checked against transfers sent within the last 24 hours.
+func (r *TransfersRepo) Create(ctx context.Context, transfer model.Transfer, limits model.TransferLimits) (saved model.Transfer, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		now := time.Now()
+		since := now.Add(-24 * time.Hour)
+
+		var (
+			count int
+			sent  model.Points
+		)
+
+		for _, row := range tx.data.transfers {
+			if row.FromUserID == transfer.FromUserID && row.createdAt.After(since) {
+				count++
+				sent += row.Amount
+			}
+		}
+
+		if (limits.Count > 0 && count >= limits.Count) || (limits.Amount > 0 && sent+transfer.Amount > limits.Amount) {
+			return storage.WrapCaller(storage.ErrLimitExceeded)
+		}
+
+		if err := tx.balance.change(ctx, -transfer.Amount, transfer.FromUserID); err != nil {
+			return err
+		}
+
+		if err := tx.balance.change(ctx, transfer.Amount, transfer.ToUserID); err != nil {
+			return err
+		}
+
+		row := transferRow{
+			Transfer:  transfer,
+			createdAt: now,
+		}
+		row.ID = id
+		row.To = ""
+
+		tx.data.transfers = append(tx.data.transfers, row)
+
+		saved = transfer
+		saved.ID = id
+		saved.CreatedAt = now.Format(model.LayoutTimestamps)
+
+		return tx.postLedger(
+			model.LedgerEntry{
+				Kind:       model.LedgerTransfer,
+				TransferID: &id,
+				Comment:    transfer.Comment,
+			},
+			posting{account: model.AccountUser, userID: transfer.FromUserID, amount: -transfer.Amount},
+			posting{account: model.AccountUser, userID: transfer.ToUserID, amount: transfer.Amount},
+		)
+	})
+
+	return saved, err
+}
@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type UsersRepo struct {
+	s *Storage
+}
+
+func NewUsersRepo(s *Storage) *UsersRepo {
+	return &UsersRepo{
+		s: s,
+	}
+}
+
+// Get finds user by id. When requested user doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *UsersRepo) Get(ctx context.Context, id int64) (user model.User, err error) {
+	defer r.s.lock()()
+
+	user, ok := r.s.data.users[id]
+	if !ok {
+		return user, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return user, nil
+}
+
+// FindByLogin finds user by login. When requested user doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *UsersRepo) FindByLogin(ctx context.Context, login string) (user model.User, err error) {
+	login = strings.ToLower(login)
+
+	defer r.s.lock()()
+
+	for _, user = range r.s.data.users {
+		if user.Login == login {
+			return user, nil
+		}
+	}
+
+	return model.User{}, storage.WrapCaller(storage.ErrNotFound)
+}
+
+func (r *UsersRepo) Create(ctx context.Context, user model.User) (id int64, err error) {
+	user.Login = strings.ToLower(user.Login)
+
+	defer r.s.lock()()
+
+	for _, u := range r.s.data.users {
+		if u.Login == user.Login {
+			return id, storage.WrapCaller(storage.ErrDuplicateEntry)
+		}
+	}
+
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+
+	r.s.data.userSeq++
+	user.ID = r.s.data.userSeq
+	r.s.data.users[user.ID] = user
+
+	return user.ID, nil
+}
+
+// SetPasswordHash replaces user's password hash. When requested user
+// doesn't exist storage.ErrNotFound error is returned.
+func (r *UsersRepo) SetPasswordHash(ctx context.Context, id int64, hash string) error {
+	defer r.s.lock()()
+
+	user, ok := r.s.data.users[id]
+	if !ok {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	user.PasswordHash = hash
+	r.s.data.users[id] = user
+
+	return nil
+}
+
+// SetRole changes user's role. When requested user doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *UsersRepo) SetRole(ctx context.Context, id int64, role model.Role) error {
+	defer r.s.lock()()
+
+	user, ok := r.s.data.users[id]
+	if !ok {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	user.Role = role
+	r.s.data.users[id] = user
+
+	return nil
+}
+
+// Search returns users matching filter ordered by id. Password hashes are
+// not loaded.
+func (r *UsersRepo) Search(ctx context.Context, filter model.UsersFilter) (users []model.User, err error) {
+	defer r.s.lock()()
+
+	login := strings.ToLower(filter.Login)
+
+	users = make([]model.User, 0)
+	for _, user := range r.s.data.users {
+		if !strings.Contains(user.Login, login) || (filter.Role != "" && user.Role != filter.Role) {
+			continue
+		}
+
+		user.PasswordHash = ""
+		users = append(users, user)
+	}
+
+	slices.SortFunc(users, func(a, b model.User) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	users = users[min(filter.Offset, len(users)):]
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+
+	return users, nil
+}
+
+// Delete removes user along with all the related data
+// (the same as ON DELETE CASCADE does in sql).
+func (r *UsersRepo) Delete(ctx context.Context, id int64) error {
+	defer r.s.lock()()
+
+	d := r.s.data
+
+	delete(d.users, id)
+	delete(d.balances, id)
+
+	for num, order := range d.orders {
+		if order.UserID == id {
+			delete(d.orders, num)
+			delete(d.jobs, num)
+		}
+	}
+
+	d.withdrawals = slices.DeleteFunc(d.withdrawals, func(w withdrawalRow) bool { return w.userID == id })
+	d.reversals = slices.DeleteFunc(d.reversals, func(r reversalRow) bool { return r.UserID == id })
+	d.cancellations = slices.DeleteFunc(d.cancellations, func(c cancellationRow) bool { return c.UserID == id })
+	d.ledger = slices.DeleteFunc(d.ledger, func(l ledgerRow) bool { return l.UserID == id })
+	d.lots = slices.DeleteFunc(d.lots, func(l model.PointsLot) bool { return l.UserID == id })
+	d.bonuses = slices.DeleteFunc(d.bonuses, func(b bonusRow) bool { return b.userID == id })
+
+	maps.DeleteFunc(d.adjustments, func(_ uuid.UUID, a adjustmentRow) bool { return a.UserID == id })
+
+	// the other party keeps the transfer in history
+	for i, t := range d.transfers {
+		if t.FromUserID == id {
+			d.transfers[i].FromUserID = 0
+		}
+		if t.ToUserID == id {
+			d.transfers[i].ToUserID = 0
+		}
+	}
+
+	delete(d.referralCodes, id)
+	maps.DeleteFunc(d.referrals, func(_ int64, r referralRow) bool { return r.RefereeID == id || r.ReferrerID == id })
+
+	maps.DeleteFunc(d.sessions, func(_ uuid.UUID, s model.Session) bool { return s.UserID == id })
+
+	return nil
+}
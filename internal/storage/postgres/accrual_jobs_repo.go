@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type AccrualJobsRepo struct {
+	s *Storage
+}
+
+func NewAccrualJobsRepo(s *Storage) *AccrualJobsRepo {
+	return &AccrualJobsRepo{
+		s: s,
+	}
+}
+
+const queryEnqueueAccrualJob = `
+	INSERT INTO accrual_jobs (order_id)
+	VALUES ($1)
+	ON CONFLICT (order_id) DO NOTHING;
+`
+
+// Enqueue adds order to the queue. Already queued order is left untouched.
+func (r *AccrualJobsRepo) Enqueue(ctx context.Context, orderID model.OrderNumber) error {
+	_, err := r.s.q.ExecContext(ctx, queryEnqueueAccrualJob, orderID)
+	return storage.WrapCaller(err)
+}
+
+const queryEnqueueAccrualJobsByStatus = `
+	INSERT INTO accrual_jobs (order_id)
+	SELECT id FROM orders WHERE status = $1
+	ON CONFLICT (order_id) DO NOTHING;
+`
+
+// EnqueueByStatus adds all orders with provided status which are missing
+// from the queue. Returns number of newly queued orders.
+func (r *AccrualJobsRepo) EnqueueByStatus(ctx context.Context, status string) (count int64, err error) {
+	res, err := r.s.q.ExecContext(ctx, queryEnqueueAccrualJobsByStatus, status)
+	if err != nil {
+		return 0, storage.WrapCaller(err)
+	}
+
+	count, err = res.RowsAffected()
+
+	return count, storage.WrapCaller(err)
+}
+
+// Due jobs are locked with SKIP LOCKED, so concurrent workers never claim
+// the same job. Jobs with expired lease are considered free again.
+const queryClaimAccrualJobs = `
+	WITH due AS (
+		SELECT order_id
+		FROM accrual_jobs
+		WHERE next_run_at <= now()
+			AND (locked_until IS NULL OR locked_until < now())
+		ORDER BY next_run_at ASC
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE accrual_jobs AS j
+	SET
+		locked_by = $1,
+		locked_until = now() + make_interval(secs => $3),
+		attempts = j.attempts + 1,
+		updated_at = now()
+	FROM due, orders AS o
+	WHERE j.order_id = due.order_id AND o.id = j.order_id
+	RETURNING
+		j.order_id,
+		o.user_id,
+		j.attempts,
+		j.next_run_at,
+		j.locked_until,
+		j.last_error;
+`
+
+// Claim leases up to limit due jobs to the worker for lease duration and
+// increases their attempts counter. Jobs leased by other workers are skipped.
+func (r *AccrualJobsRepo) Claim(ctx context.Context, workerID string, limit int, lease time.Duration) (jobs []model.AccrualJob, err error) {
+	jobs = make([]model.AccrualJob, 0)
+
+	stmt, err := r.s.q.PrepareContext(ctx, queryClaimAccrualJobs)
+	if err != nil {
+		return jobs, storage.WrapCaller(err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, workerID, limit, lease.Seconds())
+	if err != nil {
+		return jobs, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		job := model.AccrualJob{LockedBy: workerID}
+		if err = rows.Scan(
+			&job.OrderID,
+			&job.UserID,
+			&job.Attempts,
+			&job.NextRunAt,
+			&job.LockedUntil,
+			&job.LastError,
+		); err != nil {
+			return jobs, storage.WrapCaller(err)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, storage.WrapCaller(rows.Err())
+}
+
+const queryRetryAccrualJob = `
+	UPDATE accrual_jobs
+	SET
+		next_run_at = now() + make_interval(secs => $3),
+		last_error = $4,
+		locked_by = NULL,
+		locked_until = NULL,
+		updated_at = now()
+	WHERE order_id = $1 AND locked_by = $2;
+`
+
+// Retry releases job's lease and schedules next attempt after delay.
+// Nothing is changed when the lease was already lost by the worker.
+func (r *AccrualJobsRepo) Retry(ctx context.Context, orderID model.OrderNumber, workerID string, delay time.Duration, lastErr string) error {
+	_, err := r.s.q.ExecContext(ctx, queryRetryAccrualJob,
+		orderID,
+		workerID,
+		delay.Seconds(),
+		lastErr,
+	)
+
+	return storage.WrapCaller(err)
+}
+
+// lease is kept, worker holding it might be processing the job right now
+const queryRescheduleAccrualJob = `
+	INSERT INTO accrual_jobs (order_id)
+	VALUES ($1)
+	ON CONFLICT (order_id) DO UPDATE SET
+		next_run_at = now(),
+		updated_at = now();
+`
+
+// Reschedule makes order's job due right away, job is queued when it's
+// missing. Meant for stuck orders.
+func (r *AccrualJobsRepo) Reschedule(ctx context.Context, orderID model.OrderNumber) error {
+	_, err := r.s.q.ExecContext(ctx, queryRescheduleAccrualJob, orderID)
+	return storage.WrapCaller(err)
+}
+
+const queryCompleteAccrualJob = `DELETE FROM accrual_jobs WHERE order_id = $1;`
+
+// Complete removes job from the queue.
+func (r *AccrualJobsRepo) Complete(ctx context.Context, orderID model.OrderNumber) error {
+	_, err := r.s.q.ExecContext(ctx, queryCompleteAccrualJob, orderID)
+	return storage.WrapCaller(err)
+}
+
+const queryWorkerHeartbeat = `
+	INSERT INTO accrual_workers (id, started_at, heartbeat_at)
+	VALUES ($1, now(), now())
+	ON CONFLICT (id)
+	DO UPDATE SET heartbeat_at = now();
+`
+
+const queryExtendAccrualJobsLeases = `
+	UPDATE accrual_jobs
+	SET locked_until = now() + make_interval(secs => $2)
+	WHERE locked_by = $1;
+`
+
+// Heartbeat marks worker as alive and extends leases of all jobs held by it.
+func (r *AccrualJobsRepo) Heartbeat(ctx context.Context, workerID string, lease time.Duration) error {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		if _, err := tx.q.ExecContext(ctx, queryWorkerHeartbeat, workerID); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		_, err := tx.q.ExecContext(ctx, queryExtendAccrualJobsLeases, workerID, lease.Seconds())
+
+		return storage.WrapCaller(err)
+	})
+}
+
+const queryReleaseAccrualJobs = `
+	UPDATE accrual_jobs
+	SET
+		locked_by = NULL,
+		locked_until = NULL,
+		updated_at = now()
+	WHERE locked_by = $1
+	RETURNING order_id;
+`
+
+const queryDeleteWorker = `DELETE FROM accrual_workers WHERE id = $1;`
+
+// Release releases leases of all jobs held by the worker and unregisters it.
+// Returns order numbers of released jobs.
+func (r *AccrualJobsRepo) Release(ctx context.Context, workerID string) (orders []model.OrderNumber, err error) {
+	orders = make([]model.OrderNumber, 0)
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		rows, err := tx.q.QueryContext(ctx, queryReleaseAccrualJobs, workerID)
+		if err != nil {
+			return storage.WrapCaller(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var orderID model.OrderNumber
+			if err = rows.Scan(&orderID); err != nil {
+				return storage.WrapCaller(err)
+			}
+			orders = append(orders, orderID)
+		}
+
+		if err = rows.Err(); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		_, err = tx.q.ExecContext(ctx, queryDeleteWorker, workerID)
+
+		return storage.WrapCaller(err)
+	})
+
+	return orders, err
+}
@@ -0,0 +1,232 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type AdjustmentsRepo struct {
+	s *Storage
+}
+
+func NewAdjustmentsRepo(s *Storage) *AdjustmentsRepo {
+	return &AdjustmentsRepo{
+		s: s,
+	}
+}
+
+// status parameter is cast explicitly, otherwise its type is deduced
+// differently for the column and for the comparison
+const queryAddAdjustment = `
+	INSERT INTO balance_adjustments (
+		id,
+		user_id,
+		amount,
+		reason,
+		comment,
+		operator_id,
+		status,
+		created_at,
+		decided_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7::varchar, now(), CASE WHEN $7::varchar = 'pending' THEN NULL ELSE now() END);
+`
+
+// Create saves manual adjustment. Pending adjustment is only saved, any
+// other is applied to user's balance right away.
+func (r *AdjustmentsRepo) Create(ctx context.Context, adj model.Adjustment) (saved model.Adjustment, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	if adj.Status != model.AdjustmentPending {
+		adj.Status = model.AdjustmentApplied
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		_, err := tx.q.ExecContext(ctx, queryAddAdjustment,
+			id,
+			adj.UserID,
+			adj.Amount,
+			adj.Reason,
+			adj.Comment,
+			adj.OperatorID,
+			adj.Status,
+		)
+		if err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		if adj.Status == model.AdjustmentApplied {
+			if err = tx.adjustments.apply(ctx, id, adj); err != nil {
+				return err
+			}
+		}
+
+		saved, err = tx.adjustments.Get(ctx, id)
+
+		return err
+	})
+
+	return saved, err
+}
+
+const queryLockAdjustment = `SELECT status FROM balance_adjustments WHERE id = $1 FOR UPDATE;`
+
+const queryDecideAdjustment = `
+	UPDATE balance_adjustments
+	SET
+		status = $2,
+		approver_id = $3,
+		decided_at = now()
+	WHERE id = $1;
+`
+
+// Decide approves (and applies) or rejects pending adjustment.
+func (r *AdjustmentsRepo) Decide(ctx context.Context, id uuid.UUID, approverID int64, approve bool) (adj model.Adjustment, err error) {
+	status := model.AdjustmentRejected
+	if approve {
+		status = model.AdjustmentApplied
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		var current model.AdjustmentStatus
+		if err := tx.q.QueryRowContext(ctx, queryLockAdjustment, id).Scan(&current); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = storage.ErrNotFound
+			}
+			return storage.WrapCaller(err)
+		}
+
+		if current != model.AdjustmentPending {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		if _, err := tx.q.ExecContext(ctx, queryDecideAdjustment, id, status, approverID); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		adj, err = tx.adjustments.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if approve {
+			return tx.adjustments.apply(ctx, id, adj)
+		}
+
+		return nil
+	})
+
+	return adj, err
+}
+
+// apply changes balance by adjustment's amount and writes it down
+// to the ledger. Must be called within transaction.
+func (r *AdjustmentsRepo) apply(ctx context.Context, id uuid.UUID, adj model.Adjustment) error {
+	if err := r.s.balance.change(ctx, adj.Amount, adj.UserID); err != nil {
+		return err
+	}
+
+	return r.s.postUserEntry(ctx, model.LedgerEntry{
+		UserID:       adj.UserID,
+		Kind:         model.LedgerAdjustment,
+		Amount:       adj.Amount,
+		AdjustmentID: &id,
+		Comment:      adj.Comment,
+	})
+}
+
+const selectAdjustments = `
+	SELECT
+		id,
+		user_id,
+		amount,
+		reason,
+		comment,
+		operator_id,
+		COALESCE(approver_id, 0),
+		status,
+		created_at,
+		decided_at
+	FROM balance_adjustments
+`
+
+const queryGetAdjustment = selectAdjustments + `WHERE id = $1;`
+
+// Get returns adjustment by id.
+func (r *AdjustmentsRepo) Get(ctx context.Context, id uuid.UUID) (adj model.Adjustment, err error) {
+	adj, err = scanAdjustment(r.s.q.QueryRowContext(ctx, queryGetAdjustment, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return adj, storage.WrapCaller(err)
+	}
+
+	return adj, nil
+}
+
+const queryListAdjustments = selectAdjustments + `
+	WHERE ($1 = 0 OR user_id = $1) AND ($2 = '' OR status = $2)
+	ORDER BY created_at DESC, id DESC
+	LIMIT NULLIF($3, 0);
+`
+
+// List returns adjustments matching filter, newest first.
+func (r *AdjustmentsRepo) List(ctx context.Context, filter model.AdjustmentsFilter) (adjustments []model.Adjustment, err error) {
+	adjustments = make([]model.Adjustment, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryListAdjustments, filter.UserID, filter.Status, filter.Limit)
+	if err != nil {
+		return adjustments, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		adj, err := scanAdjustment(rows)
+		if err != nil {
+			return adjustments, storage.WrapCaller(err)
+		}
+
+		adjustments = append(adjustments, adj)
+	}
+
+	return adjustments, storage.WrapCaller(rows.Err())
+}
+
+func scanAdjustment(row scanner) (adj model.Adjustment, err error) {
+	var (
+		tsCreatedAt time.Time
+		tsDecidedAt sql.NullTime
+	)
+
+	if err = row.Scan(
+		&adj.ID,
+		&adj.UserID,
+		&adj.Amount,
+		&adj.Reason,
+		&adj.Comment,
+		&adj.OperatorID,
+		&adj.ApproverID,
+		&adj.Status,
+		&tsCreatedAt,
+		&tsDecidedAt,
+	); err != nil {
+		return adj, err
+	}
+
+	adj.CreatedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+	if tsDecidedAt.Valid {
+		adj.DecidedAt = tsDecidedAt.Time.Format(model.LayoutTimestamps)
+	}
+
+	return adj, nil
+}
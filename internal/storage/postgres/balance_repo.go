@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -24,22 +25,27 @@ func NewBalanceRepo(s *Storage) *BalanceRepo {
 	}
 }
 
+// Balance is derived from the ledger, loyalty_points row only tells that
+// user's account exists.
 const queryGetBalance = `
-	SELECT 
-		balance,
-		updated, 
-		(
-			SELECT COALESCE(SUM(w.value),0)
-			FROM withdrawals w
-			WHERE w.user_id = $1
-		) AS total
-	FROM loyalty_points 
-	WHERE user_id=$1;
+	SELECT
+		b.updated,
+		b.held,
+		b.debt,
+		COALESCE(SUM(l.amount), 0) AS balance,
+		COALESCE(-SUM(l.amount) FILTER (
+			WHERE l.kind IN ('withdrawal', 'reversal')
+		), 0) AS withdrawn
+	FROM loyalty_points b
+	LEFT JOIN points_ledger l ON l.user_id = b.user_id AND l.account = 'user'
+	WHERE b.user_id = $1
+	GROUP BY b.user_id, b.updated, b.held, b.debt;
 `
 
-// Get returns current balance with total withdrawn value.
-func (r *BalanceRepo) Get(userID int64) (balance model.Balance, err error) {
-	stmt, err := r.s.db.Prepare(queryGetBalance)
+// Get returns current balance with total withdrawn value, held points and
+// debt.
+func (r *BalanceRepo) Get(ctx context.Context, userID int64) (balance model.Balance, err error) {
+	stmt, err := r.s.q.PrepareContext(ctx, queryGetBalance)
 	if err != nil {
 		return balance, storage.WrapCaller(err)
 	}
@@ -47,9 +53,11 @@ func (r *BalanceRepo) Get(userID int64) (balance model.Balance, err error) {
 
 	var tsUpdated time.Time
 
-	if err = stmt.QueryRow(userID).Scan(
-		&balance.Balance,
+	if err = stmt.QueryRowContext(ctx, userID).Scan(
 		&tsUpdated,
+		&balance.Held,
+		&balance.Debt,
+		&balance.Balance,
 		&balance.TotalWithdrawn,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -59,81 +67,228 @@ func (r *BalanceRepo) Get(userID int64) (balance model.Balance, err error) {
 	}
 
 	balance.UserID = userID
+	balance.Available = balance.Balance - balance.Held
 	balance.Updated = tsUpdated.Format(model.LayoutTimestamps)
 
 	return balance, nil
 }
 
-const querySetOrUpdateBalance = `
-	WITH new_balance AS (
-		INSERT INTO loyalty_points (
-			user_id,
-			balance,
-			updated
-		)
-		VALUES ($1, $2, now())
-		ON CONFLICT(user_id)
-		DO UPDATE SET 
-			balance=loyalty_points.balance + $2,
-			updated=now()
-		RETURNING balance, updated
+const queryChangeBalance = `
+	INSERT INTO loyalty_points (
+		user_id,
+		balance,
+		updated
 	)
-	SELECT 
-		balance, 
-		updated, 
-		(
-			SELECT COALESCE(SUM(w.value),0)
-			FROM withdrawals w
-			WHERE w.user_id = $1
-		) AS total
-	FROM new_balance;
+	VALUES ($1, $2, now())
+	ON CONFLICT(user_id)
+	DO UPDATE SET
+		balance=loyalty_points.balance + $2,
+		updated=now();
 `
 
-// Add adds new accrual sum to current balance.
-// Returns new updated balance and current total withdrawn value.
-func (r *BalanceRepo) Add(accrual float64, userID int64) (balance model.Balance, err error) {
-	if accrual < 0 {
-		accrual = 0
-	}
+const queryAddLot = `
+	INSERT INTO points_lots (user_id, amount, remaining, created_at)
+	VALUES ($1, $2, $2, now());
+`
 
-	stmt, err := r.s.db.Prepare(querySetOrUpdateBalance)
-	if err != nil {
-		return balance, storage.WrapCaller(err)
-	}
-	defer stmt.Close()
+// queryConsumeLots takes $2 points from user's lots, the oldest lots are
+// consumed first.
+const queryConsumeLots = `
+	WITH open AS (
+		SELECT
+			id,
+			remaining,
+			SUM(remaining) OVER (ORDER BY created_at, id) - remaining AS before
+		FROM points_lots
+		WHERE user_id = $1 AND remaining > 0
+	)
+	UPDATE points_lots l
+	SET remaining = l.remaining - LEAST(o.remaining, $2 - o.before)
+	FROM open o
+	WHERE l.id = o.id AND o.before < $2;
+`
 
-	if err = stmt.QueryRow(
-		userID,
-		accrual,
-	).Scan(
-		&balance.Balance,
-		&balance.Updated,
-		&balance.TotalWithdrawn,
-	); err != nil {
+// change adds (possibly negative) delta to the cached balance counter.
+// Credit opens new lot, debit consumes the oldest ones. Must be called
+// within transaction along with the ledger posting.
+func (r *BalanceRepo) change(ctx context.Context, delta model.Points, userID int64) error {
+	// balance row lock also serializes lots changes of the user
+	_, err := r.s.q.ExecContext(ctx, queryChangeBalance, userID, delta)
+	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgErr.Code == pgerrcode.CheckViolation {
-				// new balance value can't be negative
-				return balance, storage.WrapCaller(storage.ErrNegativeBalance)
+				// new balance value can't be negative or less than held
+				return storage.WrapCaller(storage.ErrNegativeBalance)
 			}
 		}
 
-		return balance, storage.WrapCaller(err)
+		return storage.WrapCaller(err)
 	}
 
-	balance.UserID = userID
+	switch {
+	case delta > 0:
+		_, err = r.s.q.ExecContext(ctx, queryAddLot, userID, delta)
+	case delta < 0:
+		_, err = r.s.q.ExecContext(ctx, queryConsumeLots, userID, -delta)
+	}
 
-	return balance, storage.WrapCaller(err)
+	return storage.WrapCaller(err)
 }
 
-const queryWithdraw = `
-	UPDATE loyalty_points as b
-	SET 
-		balance=b.balance - $1,
-		updated=now()
-	WHERE user_id = $2;
+// queryCollectDebt pays off user's debt by up to $2 points of the credit
+// and returns paid off amount.
+const queryCollectDebt = `
+	WITH d AS (
+		SELECT user_id, LEAST(debt, $2) AS paid
+		FROM loyalty_points
+		WHERE user_id = $1 AND debt > 0
+	)
+	UPDATE loyalty_points b
+	SET
+		debt = b.debt - d.paid,
+		balance = b.balance - d.paid
+	FROM d
+	WHERE b.user_id = d.user_id
+	RETURNING d.paid;
+`
+
+// collectDebt pays off user's debt by up to credit points, which were just
+// accrued for an order. Other credits (transfers, reversals, adjustments)
+// don't pay debt off. Must be called within transaction.
+func (r *BalanceRepo) collectDebt(ctx context.Context, credit model.Points, userID int64) error {
+	if credit <= 0 {
+		return nil
+	}
+
+	var paid model.Points
+	if err := r.s.q.QueryRowContext(ctx, queryCollectDebt, userID, credit).Scan(&paid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// no debt
+			return nil
+		}
+		return storage.WrapCaller(err)
+	}
+
+	if _, err := r.s.q.ExecContext(ctx, queryConsumeLots, userID, paid); err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	return r.s.postUserEntry(ctx, model.LedgerEntry{
+		UserID:  userID,
+		Kind:    model.LedgerClawback,
+		Amount:  -paid,
+		Comment: "debt payment",
+	})
+}
+
+const selectWithdrawal = `
+	SELECT id, user_id, order_number, value, processed_at, status, expires_at, reversed
+	FROM withdrawals
+	WHERE id = $1
 `
 
+const queryGetWithdrawal = selectWithdrawal + `;`
+
+const queryLockWithdrawal = selectWithdrawal + ` FOR UPDATE;`
+
+// withdrawal returns withdrawal along with hold's expiration, which is zero
+// for withdrawals captured right away.
+func (r *BalanceRepo) withdrawal(ctx context.Context, query string, id uuid.UUID) (wd model.Withdrawal, expiresAt time.Time, err error) {
+	var (
+		tsProcessedAt time.Time
+		tsExpiresAt   sql.NullTime
+	)
+
+	if err = r.s.q.QueryRowContext(ctx, query, id).Scan(
+		&wd.ID,
+		&wd.UserID,
+		&wd.Order,
+		&wd.Value,
+		&tsProcessedAt,
+		&wd.Status,
+		&tsExpiresAt,
+		&wd.Reversed,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return wd, expiresAt, storage.WrapCaller(err)
+	}
+
+	wd.ProcessedAt = tsProcessedAt.Format(model.LayoutTimestamps)
+	if tsExpiresAt.Valid {
+		expiresAt = tsExpiresAt.Time
+		wd.ExpiresAt = expiresAt.Format(model.LayoutTimestamps)
+	}
+
+	return wd, expiresAt, nil
+}
+
+// Add adds new accrual sum to current balance.
+// Returns new updated balance and current total withdrawn value.
+// Sum is written to the ledger as an adjustment without any reference,
+// so Accrue must be preferred for orders.
+func (r *BalanceRepo) Add(ctx context.Context, accrual model.Points, userID int64) (balance model.Balance, err error) {
+	if accrual < 0 {
+		accrual = 0
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if err := tx.balance.change(ctx, accrual, userID); err != nil {
+			return err
+		}
+
+		if err := tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID: userID,
+			Kind:   model.LedgerAdjustment,
+			Amount: accrual,
+		}); err != nil {
+			return err
+		}
+
+		balance, err = tx.balance.Get(ctx, userID)
+
+		return err
+	})
+
+	return balance, err
+}
+
+// Accrue adds order's accrual sum to user's balance. Every order can be
+// credited only once, storage.ErrDuplicateEntry is returned on repeated call.
+func (r *BalanceRepo) Accrue(ctx context.Context, orderID model.OrderNumber, accrual model.Points, userID int64) (balance model.Balance, err error) {
+	if accrual < 0 {
+		accrual = 0
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if err := tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID: userID,
+			Kind:   model.LedgerAccrual,
+			Amount: accrual,
+			Order:  string(orderID),
+		}); err != nil {
+			return err
+		}
+
+		if err := tx.balance.change(ctx, accrual, userID); err != nil {
+			return err
+		}
+
+		// debt of cancelled orders is paid off by the accrual first
+		if err := tx.balance.collectDebt(ctx, accrual, userID); err != nil {
+			return err
+		}
+
+		balance, err = tx.balance.Get(ctx, userID)
+
+		return err
+	})
+
+	return balance, err
+}
+
 const queryAddWithdrawHistory = `
 	INSERT INTO withdrawals (
 		id,
@@ -147,93 +302,448 @@ const queryAddWithdrawHistory = `
 
 // Withdraw decreases curent balance and writes entry to history.
 // Parameter orderID is a hypothetical order number.
-func (r *BalanceRepo) Withdraw(sum float64, userID int64, orderID model.OrderNumber) (err error) {
-	tx, err := r.s.db.Begin()
+func (r *BalanceRepo) Withdraw(ctx context.Context, sum model.Points, userID int64, orderID model.OrderNumber) (err error) {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		// 1. decrease balance
+		if err := tx.balance.change(ctx, -sum, userID); err != nil {
+			return err
+		}
+
+		// generate withdrawal id
+		wdID, err := uuid.NewV7()
+		if err != nil {
+			logger.Log.Error("uuid generator failed", zap.Error(err))
+			return storage.WrapCaller(err)
+		}
+
+		// 2. save withdrawal entry to history
+		_, err = tx.q.ExecContext(ctx, queryAddWithdrawHistory, wdID, userID, orderID, sum)
+		if err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		// 3. write it down to the ledger
+		return tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID:       userID,
+			Kind:         model.LedgerWithdrawal,
+			Amount:       -sum,
+			WithdrawalID: &wdID,
+		})
+	})
+}
+
+const queryWithdrawalsHistory = `
+	SELECT
+		id,
+		order_number,
+		value,
+		processed_at,
+		status,
+		expires_at,
+		reversed
+	FROM withdrawals WHERE user_id=$1 AND status IN ('held', 'captured')
+	ORDER BY processed_at ASC;
+`
+
+// Withdrawals returns all withdrawal calls for user with their reversals
+// linked. Withdrawals which were voided or expired are skipped.
+func (r *BalanceRepo) Withdrawals(ctx context.Context, userID int64) (history []model.Withdrawal, err error) {
+	history = make([]model.Withdrawal, 0)
+
+	stmt, err := r.s.q.PrepareContext(ctx, queryWithdrawalsHistory)
 	if err != nil {
-		return storage.WrapCaller(err)
+		return history, storage.WrapCaller(err)
 	}
+	defer stmt.Close()
 
-	defer func() {
-		_ = tx.Rollback()
-	}()
+	var (
+		tsProcessedAt time.Time
+		tsExpiresAt   sql.NullTime
+	)
 
-	// 1. decrease balance
-	_, err = tx.Exec(queryWithdraw, sum, userID)
+	rows, err := stmt.QueryContext(ctx, userID)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == pgerrcode.CheckViolation {
-				// new balance value can't be negative
-				return storage.WrapCaller(storage.ErrNegativeBalance)
-			}
+		return history, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wd model.Withdrawal
+		if err = rows.Scan(
+			&wd.ID,
+			&wd.Order,
+			&wd.Value,
+			&tsProcessedAt,
+			&wd.Status,
+			&tsExpiresAt,
+			&wd.Reversed,
+		); err != nil {
+			return history, storage.WrapCaller(err)
 		}
 
-		return storage.WrapCaller(err)
+		wd.ProcessedAt = tsProcessedAt.Format(model.LayoutTimestamps)
+		if tsExpiresAt.Valid {
+			wd.ExpiresAt = tsExpiresAt.Time.Format(model.LayoutTimestamps)
+		}
+
+		history = append(history, wd)
 	}
 
-	// generate withdrawal id
-	wdID, err := uuid.NewV7()
-	if err != nil {
-		logger.Log.Error("uuid generator failed", zap.Error(err))
-		return storage.WrapCaller(err)
+	if err = rows.Err(); err != nil {
+		return history, storage.WrapCaller(err)
 	}
 
-	// 2. save withdrawal entry to history
-	_, err = tx.Exec(queryAddWithdrawHistory, wdID, userID, orderID, sum)
+	return history, r.linkReversals(ctx, userID, history)
+}
+
+const queryWithdrawalReversals = `
+	SELECT
+		r.id,
+		r.withdrawal_id,
+		w.order_number,
+		r.amount,
+		r.created_at
+	FROM withdrawal_reversals r
+	JOIN withdrawals w ON w.id = r.withdrawal_id
+	WHERE r.user_id=$1
+	ORDER BY r.created_at ASC;
+`
+
+// linkReversals sets reversals of user's withdrawals in the history.
+func (r *BalanceRepo) linkReversals(ctx context.Context, userID int64, history []model.Withdrawal) error {
+	byID := make(map[uuid.UUID]int, len(history))
+	for i, wd := range history {
+		if wd.Reversed > 0 {
+			byID[wd.ID] = i
+		}
+	}
+
+	if len(byID) == 0 {
+		return nil
+	}
+
+	rows, err := r.s.q.QueryContext(ctx, queryWithdrawalReversals, userID)
 	if err != nil {
 		return storage.WrapCaller(err)
 	}
+	defer rows.Close()
 
-	if err = tx.Commit(); err != nil {
-		return storage.WrapCaller(err)
+	var tsCreatedAt time.Time
+
+	for rows.Next() {
+		var rev model.WithdrawalReversal
+		if err = rows.Scan(
+			&rev.ID,
+			&rev.WithdrawalID,
+			&rev.Order,
+			&rev.Value,
+			&tsCreatedAt,
+		); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		i, ok := byID[rev.WithdrawalID]
+		if !ok {
+			continue
+		}
+
+		rev.ProcessedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+		history[i].Reversals = append(history[i].Reversals, rev)
 	}
 
-	return nil
+	return storage.WrapCaller(rows.Err())
 }
 
-const queryWithdrawalsHistory = `
+const queryHistory = `
 	SELECT
-	 id,
-	 order_number,
-	 value,
-	 processed_at
-	FROM withdrawals WHERE user_id=$1
+		id,
+		kind,
+		order_number,
+		value,
+		processed_at,
+		reason,
+		comment,
+		counterparty,
+		status,
+		expires_at,
+		reversed,
+		withdrawal_id
+	FROM (
+		SELECT
+			id,
+			'withdrawal' AS kind,
+			order_number,
+			value,
+			processed_at,
+			'' AS reason,
+			'' AS comment,
+			'' AS counterparty,
+			status,
+			expires_at,
+			reversed,
+			NULL::uuid AS withdrawal_id
+		FROM withdrawals WHERE user_id=$1 AND status IN ('held', 'captured')
+		UNION ALL
+		SELECT
+			r.id,
+			'reversal',
+			w.order_number,
+			-r.amount,
+			r.created_at,
+			'',
+			r.comment,
+			'',
+			'',
+			NULL,
+			0,
+			r.withdrawal_id
+		FROM withdrawal_reversals r
+		JOIN withdrawals w ON w.id = r.withdrawal_id
+		WHERE r.user_id=$1
+		UNION ALL
+		SELECT
+			id,
+			'adjustment',
+			'',
+			-amount,
+			decided_at,
+			reason,
+			comment,
+			'',
+			'',
+			NULL,
+			0,
+			NULL
+		FROM balance_adjustments WHERE user_id=$1 AND status='applied'
+		UNION ALL
+		SELECT
+			t.id,
+			'transfer',
+			'',
+			CASE WHEN t.from_user_id=$1 THEN t.amount ELSE -t.amount END,
+			t.created_at,
+			'',
+			t.comment,
+			COALESCE(u.login, ''),
+			'',
+			NULL,
+			0,
+			NULL
+		FROM balance_transfers t
+		LEFT JOIN users u ON u.id = CASE WHEN t.from_user_id=$1 THEN t.to_user_id ELSE t.from_user_id END
+		WHERE t.from_user_id=$1 OR t.to_user_id=$1
+		UNION ALL
+		SELECT
+			id,
+			'clawback',
+			order_id,
+			clawed + debt,
+			created_at,
+			'',
+			comment,
+			'',
+			'',
+			NULL,
+			0,
+			NULL
+		FROM order_cancellations WHERE user_id=$1
+	) h
 	ORDER BY processed_at ASC;
 `
 
-// Withdrawals returns all withdrawal calls for user.
-func (r *BalanceRepo) Withdrawals(userID int64) (history []model.Withdrawal, err error) {
-	history = make([]model.Withdrawal, 0)
+// History returns all withdrawal calls for user along with their reversals,
+// applied adjustments, transfers and clawbacks. Reversal is shown as a credit
+// linked to the withdrawal. Sent transfer is shown as spent points, received
+// one as a credit. Clawback of cancelled order is shown as spent points
+// including the debt. Withdrawals which were voided or expired are skipped.
+func (r *BalanceRepo) History(ctx context.Context, userID int64) (history []model.HistoryEntry, err error) {
+	history = make([]model.HistoryEntry, 0)
 
-	stmt, err := r.s.db.Prepare(queryWithdrawalsHistory)
+	stmt, err := r.s.q.PrepareContext(ctx, queryHistory)
 	if err != nil {
 		return history, storage.WrapCaller(err)
 	}
 	defer stmt.Close()
 
-	var tsProcessedAt time.Time
+	var (
+		tsProcessedAt time.Time
+		tsExpiresAt   sql.NullTime
+	)
 
-	rows, err := stmt.Query(userID)
+	rows, err := stmt.QueryContext(ctx, userID)
 	if err != nil {
 		return history, storage.WrapCaller(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var wd model.Withdrawal
+		var (
+			entry        model.HistoryEntry
+			withdrawalID uuid.NullUUID
+		)
+
 		if err = rows.Scan(
-			&wd.ID,
-			&wd.Order,
-			&wd.Value,
+			&entry.ID,
+			&entry.Kind,
+			&entry.Order,
+			&entry.Value,
 			&tsProcessedAt,
+			&entry.Reason,
+			&entry.Comment,
+			&entry.Counterparty,
+			&entry.Status,
+			&tsExpiresAt,
+			&entry.Reversed,
+			&withdrawalID,
 		); err != nil {
 			return history, storage.WrapCaller(err)
 		}
 
-		wd.ProcessedAt = tsProcessedAt.Format(model.LayoutTimestamps)
+		entry.ProcessedAt = tsProcessedAt.Format(model.LayoutTimestamps)
+		if tsExpiresAt.Valid {
+			entry.ExpiresAt = tsExpiresAt.Time.Format(model.LayoutTimestamps)
+		}
 
-		history = append(history, wd)
+		if withdrawalID.Valid {
+			entry.WithdrawalID = &withdrawalID.UUID
+		}
+
+		history = append(history, entry)
 	}
 
 	return history, storage.WrapCaller(rows.Err())
 }
+
+const queryStatement = `
+	SELECT
+		id,
+		txn_id,
+		kind,
+		amount,
+		COALESCE(order_number, ''),
+		withdrawal_id,
+		adjustment_id,
+		transfer_id,
+		comment,
+		created_at
+	FROM points_ledger
+	WHERE user_id = $1 AND account = 'user'
+	ORDER BY id ASC;
+`
+
+// Statement returns all user's ledger entries explaining current balance.
+func (r *BalanceRepo) Statement(ctx context.Context, userID int64) (entries []model.LedgerEntry, err error) {
+	entries = make([]model.LedgerEntry, 0)
+
+	stmt, err := r.s.q.PrepareContext(ctx, queryStatement)
+	if err != nil {
+		return entries, storage.WrapCaller(err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return entries, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			entry        model.LedgerEntry
+			withdrawalID uuid.NullUUID
+			adjustmentID uuid.NullUUID
+			transferID   uuid.NullUUID
+			tsCreatedAt  time.Time
+		)
+
+		if err = rows.Scan(
+			&entry.ID,
+			&entry.TxnID,
+			&entry.Kind,
+			&entry.Amount,
+			&entry.Order,
+			&withdrawalID,
+			&adjustmentID,
+			&transferID,
+			&entry.Comment,
+			&tsCreatedAt,
+		); err != nil {
+			return entries, storage.WrapCaller(err)
+		}
+
+		if withdrawalID.Valid {
+			entry.WithdrawalID = &withdrawalID.UUID
+		}
+
+		if adjustmentID.Valid {
+			entry.AdjustmentID = &adjustmentID.UUID
+		}
+
+		if transferID.Valid {
+			entry.TransferID = &transferID.UUID
+		}
+
+		entry.UserID = userID
+		entry.CreatedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+
+		entries = append(entries, entry)
+	}
+
+	return entries, storage.WrapCaller(rows.Err())
+}
+
+const queryLockBalance = `SELECT balance FROM loyalty_points WHERE user_id = $1 FOR UPDATE;`
+
+const queryLedgerBalance = `
+	SELECT COALESCE(SUM(amount), 0)
+	FROM points_ledger
+	WHERE user_id = $1 AND account = 'user';
+`
+
+const queryRebuildBalance = `
+	UPDATE loyalty_points
+	SET
+		balance = $2,
+		updated = now()
+	WHERE user_id = $1;
+`
+
+// Reconcile rebuilds cached balance counter from the ledger.
+// Returns difference between the ledger and the counter before rebuild.
+func (r *BalanceRepo) Reconcile(ctx context.Context, userID int64) (drift model.Points, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		var cached, actual model.Points
+
+		if err := tx.q.QueryRowContext(ctx, queryLockBalance, userID).Scan(&cached); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = storage.ErrNotFound
+			}
+			return storage.WrapCaller(err)
+		}
+
+		if err := tx.q.QueryRowContext(ctx, queryLedgerBalance, userID).Scan(&actual); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		drift = actual - cached
+		if drift == 0 {
+			return nil
+		}
+
+		if _, err := tx.q.ExecContext(ctx, queryRebuildBalance, userID, actual); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) {
+				if pgErr.Code == pgerrcode.CheckViolation {
+					// ledger itself went below zero
+					return storage.WrapCaller(storage.ErrNegativeBalance)
+				}
+			}
+
+			return storage.WrapCaller(err)
+		}
+
+		return nil
+	})
+
+	return drift, err
+}
@@ -0,0 +1,250 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type CampaignsRepo struct {
+	s *Storage
+}
+
+func NewCampaignsRepo(s *Storage) *CampaignsRepo {
+	return &CampaignsRepo{
+		s: s,
+	}
+}
+
+const queryCreateCampaign = `
+	INSERT INTO campaigns (
+		name,
+		starts_at,
+		ends_at,
+		points,
+		multiplier,
+		first_order,
+		tiers,
+		order_prefix,
+		priority,
+		exclusive,
+		budget,
+		disabled,
+		created_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, now())
+	RETURNING id;
+`
+
+func (r *CampaignsRepo) Create(ctx context.Context, c model.Campaign) (id int64, err error) {
+	err = r.s.q.QueryRowContext(ctx, queryCreateCampaign,
+		c.Name,
+		c.StartsAt,
+		c.EndsAt,
+		c.Points,
+		c.Multiplier,
+		c.FirstOrder,
+		strings.Join(c.Tiers, ","),
+		c.OrderPrefix,
+		c.Priority,
+		c.Exclusive,
+		c.Budget,
+		c.Disabled,
+	).Scan(&id)
+
+	return id, storage.WrapCaller(err)
+}
+
+const selectCampaigns = `
+	SELECT
+		id,
+		name,
+		starts_at,
+		ends_at,
+		points,
+		multiplier,
+		first_order,
+		tiers,
+		order_prefix,
+		priority,
+		exclusive,
+		budget,
+		spent,
+		disabled,
+		created_at
+	FROM campaigns
+`
+
+const queryGetCampaign = selectCampaigns + `WHERE id = $1;`
+
+// Get finds campaign by id.
+func (r *CampaignsRepo) Get(ctx context.Context, id int64) (c model.Campaign, err error) {
+	c, err = scanCampaign(r.s.q.QueryRowContext(ctx, queryGetCampaign, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return c, storage.WrapCaller(err)
+	}
+
+	return c, nil
+}
+
+const queryUpdateCampaign = `
+	UPDATE campaigns
+	SET
+		name = $2,
+		starts_at = $3,
+		ends_at = $4,
+		points = $5,
+		multiplier = $6,
+		first_order = $7,
+		tiers = $8,
+		order_prefix = $9,
+		priority = $10,
+		exclusive = $11,
+		budget = $12,
+		disabled = $13
+	WHERE id = $1;
+`
+
+// Update replaces campaign's settings, spent budget is kept.
+func (r *CampaignsRepo) Update(ctx context.Context, c model.Campaign) error {
+	res, err := r.s.q.ExecContext(ctx, queryUpdateCampaign,
+		c.ID,
+		c.Name,
+		c.StartsAt,
+		c.EndsAt,
+		c.Points,
+		c.Multiplier,
+		c.FirstOrder,
+		strings.Join(c.Tiers, ","),
+		c.OrderPrefix,
+		c.Priority,
+		c.Exclusive,
+		c.Budget,
+		c.Disabled,
+	)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	if affected == 0 {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+const queryListCampaigns = selectCampaigns + `ORDER BY created_at DESC, id DESC;`
+
+// List returns all campaigns, newest first.
+func (r *CampaignsRepo) List(ctx context.Context) (campaigns []model.Campaign, err error) {
+	return r.list(ctx, queryListCampaigns)
+}
+
+const queryRunningCampaigns = selectCampaigns + `
+	WHERE NOT disabled
+		AND starts_at <= $1 AND ends_at > $1
+		AND (budget = 0 OR spent < budget)
+	ORDER BY priority DESC, id;
+`
+
+// Running returns campaigns running at the moment now, higher priority
+// first.
+func (r *CampaignsRepo) Running(ctx context.Context, now time.Time) (campaigns []model.Campaign, err error) {
+	return r.list(ctx, queryRunningCampaigns, now)
+}
+
+func (r *CampaignsRepo) list(ctx context.Context, query string, args ...any) (campaigns []model.Campaign, err error) {
+	campaigns = make([]model.Campaign, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return campaigns, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return campaigns, storage.WrapCaller(err)
+		}
+
+		campaigns = append(campaigns, c)
+	}
+
+	return campaigns, storage.WrapCaller(rows.Err())
+}
+
+// Concurrent spendings are serialized by the row lock, so the budget is
+// never overspent.
+const querySpendCampaignBudget = `
+	WITH c AS (
+		SELECT
+			id,
+			CASE WHEN budget = 0 THEN $2::numeric ELSE LEAST($2::numeric, budget - spent) END AS granted
+		FROM campaigns
+		WHERE id = $1
+		FOR UPDATE
+	)
+	UPDATE campaigns
+	SET spent = spent + c.granted
+	FROM c
+	WHERE campaigns.id = c.id AND c.granted > 0
+	RETURNING c.granted;
+`
+
+// Spend takes up to amount from campaign's budget. Returns granted amount,
+// which is zero when the budget is exhausted.
+func (r *CampaignsRepo) Spend(ctx context.Context, id int64, amount model.Points) (granted model.Points, err error) {
+	err = r.s.q.QueryRowContext(ctx, querySpendCampaignBudget, id, amount).Scan(&granted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, storage.WrapCaller(err)
+	}
+
+	return granted, nil
+}
+
+func scanCampaign(row scanner) (c model.Campaign, err error) {
+	var tiers string
+
+	if err = row.Scan(
+		&c.ID,
+		&c.Name,
+		&c.StartsAt,
+		&c.EndsAt,
+		&c.Points,
+		&c.Multiplier,
+		&c.FirstOrder,
+		&tiers,
+		&c.OrderPrefix,
+		&c.Priority,
+		&c.Exclusive,
+		&c.Budget,
+		&c.Spent,
+		&c.Disabled,
+		&c.CreatedAt,
+	); err != nil {
+		return c, err
+	}
+
+	if tiers != "" {
+		c.Tiers = strings.Split(tiers, ",")
+	}
+
+	return c, nil
+}
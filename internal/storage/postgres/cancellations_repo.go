@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type CancellationsRepo struct {
+	s *Storage
+}
+
+func NewCancellationsRepo(s *Storage) *CancellationsRepo {
+	return &CancellationsRepo{
+		s: s,
+	}
+}
+
+const queryLockOrder = `SELECT user_id, status FROM orders WHERE id = $1 FOR UPDATE;`
+
+// points credited to the user for the order, referrer's reward is taken
+// back separately by cancelReferral
+const queryOrderCredited = `
+	SELECT COALESCE(SUM(amount), 0)
+	FROM points_ledger
+	WHERE user_id = $1 AND account = 'user' AND order_number = $2 AND kind IN ('accrual', 'bonus');
+`
+
+const queryAddDebt = `UPDATE loyalty_points SET debt = debt + $2 WHERE user_id = $1;`
+
+const queryCancelOrder = `UPDATE orders SET status = $2 WHERE id = $1;`
+
+const queryAddCancellation = `
+	INSERT INTO order_cancellations (
+		id,
+		order_id,
+		user_id,
+		owed,
+		clawed,
+		debt,
+		comment,
+		operator_id,
+		created_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now())
+	RETURNING created_at;
+`
+
+// Create cancels processed order and takes points credited for it back.
+func (r *CancellationsRepo) Create(ctx context.Context, c model.Cancellation, policy string) (saved model.Cancellation, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		// row lock serializes cancellation with repeated ones
+		var status string
+		if err := tx.q.QueryRowContext(ctx, queryLockOrder, c.Order).Scan(&c.UserID, &status); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = storage.ErrNotFound
+			}
+			return storage.WrapCaller(err)
+		}
+
+		if status != model.OrderProcessed {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		if err := tx.q.QueryRowContext(ctx, queryOrderCredited, c.UserID, c.Order).Scan(&c.Owed); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		var err error
+		c.Clawed, c.Debt, err = tx.cancellations.clawback(ctx, c.UserID, c.Owed, policy, model.LedgerEntry{
+			Kind:    model.LedgerClawback,
+			Order:   string(c.Order),
+			Comment: c.Comment,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err = tx.cancellations.cancelReferral(ctx, c.Order, policy); err != nil {
+			return err
+		}
+
+		if _, err = tx.q.ExecContext(ctx, queryRefundCampaigns, c.Order); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		if _, err := tx.q.ExecContext(ctx, queryCancelOrder, c.Order, model.OrderCancelled); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		var tsCreatedAt time.Time
+		if err := tx.q.QueryRowContext(ctx, queryAddCancellation,
+			id,
+			c.Order,
+			c.UserID,
+			c.Owed,
+			c.Clawed,
+			c.Debt,
+			c.Comment,
+			c.OperatorID,
+		).Scan(&tsCreatedAt); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		saved = c
+		saved.ID = id
+		saved.CreatedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+
+		return nil
+	})
+
+	return saved, err
+}
+
+// clawback takes owed points back from user's balance and writes entry down
+// to the ledger. Points user has already spent are left as debt or written
+// off according to policy. Must be called within transaction.
+func (r *CancellationsRepo) clawback(ctx context.Context, userID int64, owed model.Points, policy string, entry model.LedgerEntry) (clawed, debt model.Points, err error) {
+	// user without balance row was never credited, so owes nothing
+	var available model.Points
+	err = r.s.q.QueryRowContext(ctx, queryLockAvailableBalance, userID).Scan(&available)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, storage.WrapCaller(err)
+	}
+
+	clawed = max(min(owed, available), 0)
+	if policy == model.ClawbackDebt {
+		debt = owed - clawed
+	}
+
+	if clawed > 0 {
+		if err = r.s.balance.change(ctx, -clawed, userID); err != nil {
+			return 0, 0, err
+		}
+
+		entry.UserID = userID
+		entry.Amount = -clawed
+		if err = r.s.postUserEntry(ctx, entry); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if debt > 0 {
+		if _, err = r.s.q.ExecContext(ctx, queryAddDebt, userID, debt); err != nil {
+			return 0, 0, storage.WrapCaller(err)
+		}
+	}
+
+	return clawed, debt, nil
+}
+
+// referee's reward is the order's bonus, so only referrer's one is left
+const queryCancelReferral = `
+	UPDATE referrals
+	SET status = 'cancelled'
+	WHERE order_id = $1 AND status = 'rewarded'
+	RETURNING referrer_id, referee_id, referrer_reward;
+`
+
+// cancelReferral takes referrer's reward back when the order completed
+// the referral. Must be called within transaction.
+func (r *CancellationsRepo) cancelReferral(ctx context.Context, orderID model.OrderNumber, policy string) error {
+	var (
+		referrerID, refereeID int64
+		reward                model.Points
+	)
+
+	err := r.s.q.QueryRowContext(ctx, queryCancelReferral, orderID).Scan(&referrerID, &refereeID, &reward)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the order didn't complete any referral
+			return nil
+		}
+		return storage.WrapCaller(err)
+	}
+
+	// negative referral entry offsets the reward's one
+	_, _, err = r.clawback(ctx, referrerID, reward, policy, model.LedgerEntry{
+		Kind:    model.LedgerReferral,
+		Order:   string(orderID),
+		Comment: "referee:" + strconv.FormatInt(refereeID, 10),
+	})
+
+	return err
+}
+
+// campaigns' bonuses for cancelled order return to their budgets
+const queryRefundCampaigns = `
+	UPDATE campaigns c
+	SET spent = GREATEST(c.spent - b.amount, 0)
+	FROM (
+		SELECT ref::bigint AS campaign_id, SUM(amount) AS amount
+		FROM order_bonuses
+		WHERE order_id = $1 AND source = 'campaign'
+		GROUP BY ref
+	) b
+	WHERE c.id = b.campaign_id;
+`
@@ -0,0 +1,259 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/logger"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+type HoldsRepo struct {
+	s *Storage
+}
+
+func NewHoldsRepo(s *Storage) *HoldsRepo {
+	return &HoldsRepo{
+		s: s,
+	}
+}
+
+// queryHoldPoints changes held points by $2, which is negative on release.
+const queryHoldPoints = `
+	UPDATE loyalty_points
+	SET
+		held = held + $2,
+		updated = now()
+	WHERE user_id = $1;
+`
+
+const queryAddHeldWithdrawal = `
+	INSERT INTO withdrawals (
+		id,
+		user_id,
+		order_number,
+		value,
+		processed_at,
+		status,
+		expires_at
+	)
+	VALUES ($1, $2, $3, $4, now(), 'held', $5);
+`
+
+// Create reserves points for the withdrawal until expiresAt.
+func (r *HoldsRepo) Create(ctx context.Context, sum model.Points, userID int64, orderID model.OrderNumber, expiresAt time.Time) (wd model.Withdrawal, err error) {
+	wdID, err := uuid.NewV7()
+	if err != nil {
+		logger.Log.Error("uuid generator failed", zap.Error(err))
+		return wd, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if err := tx.holds.hold(ctx, sum, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.q.ExecContext(ctx, queryAddHeldWithdrawal, wdID, userID, orderID, sum, expiresAt)
+		if err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		wd, _, err = tx.balance.withdrawal(ctx, queryGetWithdrawal, wdID)
+
+		return err
+	})
+
+	return wd, err
+}
+
+// hold changes user's held points by (possibly negative) delta. Must be
+// called within transaction.
+func (r *HoldsRepo) hold(ctx context.Context, delta model.Points, userID int64) error {
+	res, err := r.s.q.ExecContext(ctx, queryHoldPoints, userID, delta)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			// can't hold more than balance
+			return storage.WrapCaller(storage.ErrNegativeBalance)
+		}
+		return storage.WrapCaller(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	if affected == 0 {
+		// user has never had any points
+		return storage.WrapCaller(storage.ErrNegativeBalance)
+	}
+
+	return nil
+}
+
+// lockHeld locks held withdrawal of the user. Zero userID matches any user.
+// Returns hold's expiration along with the withdrawal.
+func (r *HoldsRepo) lockHeld(ctx context.Context, id uuid.UUID, userID int64) (wd model.Withdrawal, expiresAt time.Time, err error) {
+	wd, expiresAt, err = r.s.balance.withdrawal(ctx, queryLockWithdrawal, id)
+	if err != nil {
+		return wd, expiresAt, err
+	}
+
+	if userID != 0 && wd.UserID != userID {
+		return wd, expiresAt, storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	if wd.Status != model.WithdrawalHeld {
+		return wd, expiresAt, storage.WrapCaller(storage.ErrStateConflict)
+	}
+
+	return wd, expiresAt, nil
+}
+
+const queryCaptureWithdrawal = `
+	UPDATE withdrawals
+	SET
+		status = 'captured',
+		value = $2,
+		processed_at = now()
+	WHERE id = $1;
+`
+
+// Capture debits held withdrawal.
+func (r *HoldsRepo) Capture(ctx context.Context, id uuid.UUID, userID int64, sum model.Points) (wd model.Withdrawal, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		held, expiresAt, err := tx.holds.lockHeld(ctx, id, userID)
+		if err != nil {
+			return err
+		}
+
+		if !expiresAt.After(time.Now()) {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		if sum == 0 {
+			sum = held.Value
+		}
+
+		if sum < 0 || sum > held.Value {
+			return storage.WrapCaller(storage.ErrCheckViolation)
+		}
+
+		// release first, otherwise debit would cut into held points
+		if err = tx.holds.hold(ctx, -held.Value, userID); err != nil {
+			return err
+		}
+
+		if err = tx.balance.change(ctx, -sum, userID); err != nil {
+			return err
+		}
+
+		if _, err = tx.q.ExecContext(ctx, queryCaptureWithdrawal, id, sum); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		if err = tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID:       userID,
+			Kind:         model.LedgerWithdrawal,
+			Amount:       -sum,
+			WithdrawalID: &id,
+		}); err != nil {
+			return err
+		}
+
+		wd, _, err = tx.balance.withdrawal(ctx, queryGetWithdrawal, id)
+
+		return err
+	})
+
+	return wd, err
+}
+
+const querySetWithdrawalStatus = `UPDATE withdrawals SET status = $2 WHERE id = $1;`
+
+// Void releases points held by the withdrawal.
+func (r *HoldsRepo) Void(ctx context.Context, id uuid.UUID, userID int64) (wd model.Withdrawal, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		held, _, err := tx.holds.lockHeld(ctx, id, userID)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.holds.release(ctx, held, model.WithdrawalVoided); err != nil {
+			return err
+		}
+
+		wd, _, err = tx.balance.withdrawal(ctx, queryGetWithdrawal, id)
+
+		return err
+	})
+
+	return wd, err
+}
+
+const queryExpiredHolds = `
+	SELECT id
+	FROM withdrawals
+	WHERE status = 'held' AND expires_at <= $1
+	ORDER BY expires_at ASC
+	LIMIT $2;
+`
+
+// Expired returns ids of held withdrawals expired by now.
+func (r *HoldsRepo) Expired(ctx context.Context, now time.Time, limit int) (ids []uuid.UUID, err error) {
+	ids = make([]uuid.UUID, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryExpiredHolds, now, limit)
+	if err != nil {
+		return ids, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err = rows.Scan(&id); err != nil {
+			return ids, storage.WrapCaller(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, storage.WrapCaller(rows.Err())
+}
+
+// Expire releases points of the hold expired by now. Every hold is
+// released in its own transaction, so the job doesn't lock many balances
+// at once.
+func (r *HoldsRepo) Expire(ctx context.Context, id uuid.UUID, now time.Time) error {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		held, expiresAt, err := tx.holds.lockHeld(ctx, id, 0)
+		if err != nil {
+			return err
+		}
+
+		if expiresAt.After(now) {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		return tx.holds.release(ctx, held, model.WithdrawalExpired)
+	})
+}
+
+// release returns points held by the withdrawal and sets its final status.
+// Must be called within transaction.
+func (r *HoldsRepo) release(ctx context.Context, held model.Withdrawal, status model.WithdrawalStatus) error {
+	if err := r.hold(ctx, -held.Value, held.UserID); err != nil {
+		return err
+	}
+
+	_, err := r.s.q.ExecContext(ctx, querySetWithdrawalStatus, held.ID, status)
+
+	return storage.WrapCaller(err)
+}
@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+// posting is a single side of ledger transaction.
+type posting struct {
+	account string
+	userID  int64
+	amount  model.Points
+}
+
+const queryPostLedger = `
+	INSERT INTO points_ledger (
+		txn_id,
+		account,
+		user_id,
+		kind,
+		amount,
+		order_number,
+		withdrawal_id,
+		adjustment_id,
+		transfer_id,
+		comment,
+		created_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, now())
+	ON CONFLICT DO NOTHING
+	RETURNING id;
+`
+
+// postLedger writes balanced transaction to the ledger. Entry provides kind
+// and references shared by all postings. First posting is the primary one:
+// when it conflicts with already existing entry (e.g. the order was already
+// credited) nothing is written and storage.ErrDuplicateEntry is returned.
+// Must be called within transaction.
+func (s *Storage) postLedger(ctx context.Context, entry model.LedgerEntry, postings ...posting) error {
+	var sum model.Points
+	for _, p := range postings {
+		sum += p.amount
+	}
+
+	if len(postings) < 2 || sum != 0 {
+		return storage.WrapCaller(fmt.Errorf("unbalanced ledger transaction: %d postings, sum %v", len(postings), sum))
+	}
+
+	txnID, err := uuid.NewV7()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	var order sql.NullString
+	if entry.Order != "" {
+		order = sql.NullString{String: entry.Order, Valid: true}
+	}
+
+	stmt, err := s.q.PrepareContext(ctx, queryPostLedger)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+	defer stmt.Close()
+
+	for i, p := range postings {
+		var id int64
+		err = stmt.QueryRowContext(ctx,
+			txnID,
+			p.account,
+			p.userID,
+			entry.Kind,
+			p.amount,
+			order,
+			entry.WithdrawalID,
+			entry.AdjustmentID,
+			entry.TransferID,
+			entry.Comment,
+		).Scan(&id)
+		if err != nil {
+			if i == 0 && errors.Is(err, sql.ErrNoRows) {
+				return storage.WrapCaller(storage.ErrDuplicateEntry)
+			}
+			return storage.WrapCaller(err)
+		}
+	}
+
+	return nil
+}
+
+// postUserEntry writes user's entry to the ledger balanced with posting to
+// the system account matching entry's kind.
+func (s *Storage) postUserEntry(ctx context.Context, entry model.LedgerEntry) error {
+	return s.postLedger(ctx, entry,
+		posting{account: model.AccountUser, userID: entry.UserID, amount: entry.Amount},
+		posting{account: model.CounterAccount(entry.Kind), userID: entry.UserID, amount: -entry.Amount},
+	)
+}
@@ -0,0 +1,249 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type LoginAttemptsRepo struct {
+	s *Storage
+}
+
+func NewLoginAttemptsRepo(s *Storage) *LoginAttemptsRepo {
+	return &LoginAttemptsRepo{
+		s: s,
+	}
+}
+
+const queryAddLoginAttempt = `
+	INSERT INTO login_attempts (login, ip, created_at) VALUES ($1, $2, $3);
+`
+
+func (r *LoginAttemptsRepo) Add(ctx context.Context, attempt model.LoginAttempt) error {
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+
+	_, err := r.s.q.ExecContext(ctx, queryAddLoginAttempt,
+		strings.ToLower(attempt.Login),
+		attempt.IP,
+		attempt.CreatedAt,
+	)
+
+	return storage.WrapCaller(err)
+}
+
+const queryCountLoginAttemptsByLogin = `
+	SELECT count(*) FROM login_attempts WHERE login = $1 AND created_at >= $2;
+`
+
+func (r *LoginAttemptsRepo) CountByLogin(ctx context.Context, login string, since time.Time) (count int64, err error) {
+	err = r.s.q.QueryRowContext(ctx, queryCountLoginAttemptsByLogin, strings.ToLower(login), since).Scan(&count)
+	return count, storage.WrapCaller(err)
+}
+
+const queryCountLoginAttemptsByIP = `
+	SELECT count(*) FROM login_attempts WHERE ip = $1 AND created_at >= $2;
+`
+
+func (r *LoginAttemptsRepo) CountByIP(ctx context.Context, ip string, since time.Time) (count int64, err error) {
+	err = r.s.q.QueryRowContext(ctx, queryCountLoginAttemptsByIP, ip, since).Scan(&count)
+	return count, storage.WrapCaller(err)
+}
+
+// empty filter fields match everything, 0 limit means no limit
+const queryListLoginAttempts = `
+	SELECT id, login, ip, created_at
+	FROM login_attempts
+	WHERE ($1 = '' OR login = $1) AND ($2 = '' OR ip = $2)
+	ORDER BY id DESC
+	LIMIT NULLIF($3, 0);
+`
+
+// List returns failed attempts matching filter, newest first.
+func (r *LoginAttemptsRepo) List(ctx context.Context, filter model.LoginAttemptsFilter) (attempts []model.LoginAttempt, err error) {
+	attempts = make([]model.LoginAttempt, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryListLoginAttempts,
+		strings.ToLower(filter.Login),
+		filter.IP,
+		filter.Limit,
+	)
+	if err != nil {
+		return attempts, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a model.LoginAttempt
+		if err = rows.Scan(&a.ID, &a.Login, &a.IP, &a.CreatedAt); err != nil {
+			return attempts, storage.WrapCaller(err)
+		}
+
+		attempts = append(attempts, a)
+	}
+
+	return attempts, storage.WrapCaller(rows.Err())
+}
+
+const queryClearLoginAttempts = `
+	DELETE FROM login_attempts
+	WHERE ($1 = '' OR login = $1) AND ($2 = '' OR ip = $2);
+`
+
+const queryDeleteLockout = `DELETE FROM login_lockouts WHERE key = $1;`
+
+// Clear removes failed attempts matching filter along with lockouts of the
+// filter's login and ip. Returns number of removed attempts.
+func (r *LoginAttemptsRepo) Clear(ctx context.Context, filter model.LoginAttemptsFilter) (count int64, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		res, err := tx.q.ExecContext(ctx, queryClearLoginAttempts, strings.ToLower(filter.Login), filter.IP)
+		if err != nil {
+			return err
+		}
+
+		if count, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		if filter.Login != "" {
+			if _, err = tx.q.ExecContext(ctx, queryDeleteLockout, model.LoginLockoutKey(filter.Login)); err != nil {
+				return err
+			}
+		}
+
+		if filter.IP != "" {
+			if _, err = tx.q.ExecContext(ctx, queryDeleteLockout, model.IPLockoutKey(filter.IP)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return count, storage.WrapCaller(err)
+}
+
+const queryPurgeLoginAttempts = `DELETE FROM login_attempts WHERE created_at < $1;`
+
+const queryPurgeLockouts = `
+	DELETE FROM login_lockouts
+	WHERE COALESCE(locked_until, '-infinity') < $1 AND COALESCE(reset_at, '-infinity') < $1;
+`
+
+// Purge removes attempts made before the moment along with lockouts which
+// ended and were reset before it. Returns number of removed attempts.
+func (r *LoginAttemptsRepo) Purge(ctx context.Context, before time.Time) (count int64, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		res, err := tx.q.ExecContext(ctx, queryPurgeLoginAttempts, before)
+		if err != nil {
+			return err
+		}
+
+		if count, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, queryPurgeLockouts, before)
+
+		return err
+	})
+
+	return count, storage.WrapCaller(err)
+}
+
+const queryGetLockout = `
+	SELECT key, level, locked_until, reset_at FROM login_lockouts WHERE key = $1;
+`
+
+// Lockout finds lockout by key. When key was never locked
+// storage.ErrNotFound error is returned.
+func (r *LoginAttemptsRepo) Lockout(ctx context.Context, key string) (lockout model.Lockout, err error) {
+	row := r.s.q.QueryRowContext(ctx, queryGetLockout, key)
+	if lockout, err = scanLockout(row); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return lockout, storage.WrapCaller(err)
+	}
+
+	return lockout, nil
+}
+
+const querySetLockout = `
+	INSERT INTO login_lockouts (key, level, locked_until, reset_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (key) DO UPDATE SET
+		level = EXCLUDED.level,
+		locked_until = EXCLUDED.locked_until,
+		reset_at = EXCLUDED.reset_at;
+`
+
+// SetLockout creates or replaces lockout.
+func (r *LoginAttemptsRepo) SetLockout(ctx context.Context, lockout model.Lockout) error {
+	_, err := r.s.q.ExecContext(ctx, querySetLockout,
+		lockout.Key,
+		lockout.Level,
+		nullTime(lockout.LockedUntil),
+		nullTime(lockout.ResetAt),
+	)
+
+	return storage.WrapCaller(err)
+}
+
+const queryActiveLockouts = `
+	SELECT key, level, locked_until, reset_at
+	FROM login_lockouts
+	WHERE locked_until > $1
+	ORDER BY locked_until DESC;
+`
+
+// Lockouts returns lockouts which are in effect at the moment now.
+func (r *LoginAttemptsRepo) Lockouts(ctx context.Context, now time.Time) (lockouts []model.Lockout, err error) {
+	lockouts = make([]model.Lockout, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryActiveLockouts, now)
+	if err != nil {
+		return lockouts, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		lockout, err := scanLockout(rows)
+		if err != nil {
+			return lockouts, storage.WrapCaller(err)
+		}
+
+		lockouts = append(lockouts, lockout)
+	}
+
+	return lockouts, storage.WrapCaller(rows.Err())
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLockout(row scanner) (lockout model.Lockout, err error) {
+	var nsLockedUntil, nsResetAt sql.NullTime
+
+	if err = row.Scan(&lockout.Key, &lockout.Level, &nsLockedUntil, &nsResetAt); err != nil {
+		return lockout, err
+	}
+
+	lockout.LockedUntil = nsLockedUntil.Time
+	lockout.ResetAt = nsResetAt.Time
+
+	return lockout, nil
+}
+
+// nullTime converts zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+type LotsRepo struct {
+	s *Storage
+}
+
+func NewLotsRepo(s *Storage) *LotsRepo {
+	return &LotsRepo{
+		s: s,
+	}
+}
+
+const queryOpenLots = `
+	SELECT id, amount, remaining, created_at
+	FROM points_lots
+	WHERE user_id = $1 AND remaining > 0
+	ORDER BY created_at, id;
+`
+
+// List returns user's lots having points left, the oldest first.
+func (r *LotsRepo) List(ctx context.Context, userID int64) (lots []model.PointsLot, err error) {
+	lots = make([]model.PointsLot, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryOpenLots, userID)
+	if err != nil {
+		return lots, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		lot := model.PointsLot{UserID: userID}
+		if err = rows.Scan(&lot.ID, &lot.Amount, &lot.Remaining, &lot.CreatedAt); err != nil {
+			return lots, storage.WrapCaller(err)
+		}
+
+		lots = append(lots, lot)
+	}
+
+	return lots, storage.WrapCaller(rows.Err())
+}
+
+// held points don't expire until released, so users having nothing but
+// them available are skipped, otherwise they'd be picked on every run
+const queryUsersWithExpiredLots = `
+	SELECT DISTINCT l.user_id
+	FROM points_lots l
+	JOIN loyalty_points b ON b.user_id = l.user_id
+	WHERE l.remaining > 0 AND l.created_at + make_interval(months => $1) <= $2 AND b.balance > b.held
+	ORDER BY l.user_id
+	LIMIT $3;
+`
+
+// UsersWithExpired returns ids of users having points left in lots
+// older than months at the moment now. Users whose available points are all
+// held are skipped.
+func (r *LotsRepo) UsersWithExpired(ctx context.Context, months int, now time.Time, limit int) (userIDs []int64, err error) {
+	userIDs = make([]int64, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryUsersWithExpiredLots, months, now, limit)
+	if err != nil {
+		return userIDs, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return userIDs, storage.WrapCaller(err)
+		}
+
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, storage.WrapCaller(rows.Err())
+}
+
+const queryExpiredLotsSum = `
+	SELECT COALESCE(SUM(remaining), 0)
+	FROM points_lots
+	WHERE user_id = $1 AND remaining > 0 AND created_at + make_interval(months => $2) <= $3;
+`
+
+const queryLockAvailableBalance = `SELECT balance - held FROM loyalty_points WHERE user_id = $1 FOR UPDATE;`
+
+// Expire takes remaining points of user's lots older than months away
+// and writes expiry entry to the ledger. Returns expired amount.
+func (r *LotsRepo) Expire(ctx context.Context, userID int64, months int, now time.Time) (expired model.Points, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		var balance model.Points
+		if err := tx.q.QueryRowContext(ctx, queryLockAvailableBalance, userID).Scan(&balance); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = storage.ErrNotFound
+			}
+			return storage.WrapCaller(err)
+		}
+
+		if err := tx.q.QueryRowContext(ctx, queryExpiredLotsSum, userID, months, now).Scan(&expired); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		// expired lots are the oldest ones, so consuming debit takes exactly
+		// them; balance can only be less when counter drifted from the ledger
+		// or points are held (they don't expire until released)
+		expired = min(expired, balance)
+		if expired <= 0 {
+			return nil
+		}
+
+		if err := tx.balance.change(ctx, -expired, userID); err != nil {
+			return err
+		}
+
+		return tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID: userID,
+			Kind:   model.LedgerExpiry,
+			Amount: -expired,
+		})
+	})
+
+	return expired, err
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS accrual_workers;
+DROP TABLE IF EXISTS accrual_jobs;
@@ -0,0 +1,23 @@
+
+-- accrual polling jobs queue shared between all running app instances
+CREATE TABLE IF NOT EXISTS accrual_jobs(
+   order_id VARCHAR(100) PRIMARY KEY,
+   attempts integer NOT NULL DEFAULT 0,
+   next_run_at timestamptz NOT NULL DEFAULT now(),
+   locked_by VARCHAR(100) NULL, -- id of the worker holding the lease
+   locked_until timestamptz NULL, -- lease expiration, job can be claimed again after it
+   last_error text NOT NULL DEFAULT '',
+   created_at timestamptz NOT NULL DEFAULT now(),
+   updated_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_order_id
+      FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_run_at_idx ON accrual_jobs(next_run_at);
+
+-- accrual poller workers heartbeats
+CREATE TABLE IF NOT EXISTS accrual_workers(
+   id VARCHAR(100) PRIMARY KEY,
+   started_at timestamptz NOT NULL DEFAULT now(),
+   heartbeat_at timestamptz NOT NULL DEFAULT now()
+);
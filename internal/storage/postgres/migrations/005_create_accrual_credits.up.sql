@@ -0,0 +1,20 @@
+
+-- orders which accrual was already added to users' balance,
+-- guards against crediting the same order twice
+CREATE TABLE IF NOT EXISTS accrual_credits(
+   order_id VARCHAR(100) PRIMARY KEY,
+   user_id bigint NOT NULL,
+   value numeric(20,4) NOT NULL DEFAULT 0,
+   credited_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_order_id
+      FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- orders processed before this migration were already credited
+INSERT INTO accrual_credits (order_id, user_id, value, credited_at)
+SELECT id, user_id, accrual, COALESCE(processed_at, now())
+FROM orders
+WHERE status = 'PROCESSED'
+ON CONFLICT (order_id) DO NOTHING;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS accrual_credits(
+   order_id VARCHAR(100) PRIMARY KEY,
+   user_id bigint NOT NULL,
+   value numeric(20,4) NOT NULL DEFAULT 0,
+   credited_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_order_id
+      FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO accrual_credits (order_id, user_id, value, credited_at)
+SELECT order_number, user_id, amount, created_at
+FROM points_ledger
+WHERE kind = 'accrual' AND account = 'user'
+ON CONFLICT (order_id) DO NOTHING;
+
+DROP TABLE IF EXISTS points_ledger;
+DROP FUNCTION IF EXISTS points_ledger_append_only();
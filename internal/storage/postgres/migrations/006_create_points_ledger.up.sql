@@ -0,0 +1,73 @@
+
+-- Double-entry points ledger. Every balance change is a transaction of
+-- postings sharing txn_id, amounts of the postings always sum up to zero.
+-- Postings to 'user' account make up user's balance, others are system
+-- accounts (where points come from or go to). The table is append-only.
+CREATE TABLE IF NOT EXISTS points_ledger(
+   id bigserial PRIMARY KEY,
+   txn_id UUID NOT NULL,
+   account VARCHAR(50) NOT NULL,
+   user_id bigint NOT NULL, -- account owner for 'user' account, related user otherwise
+   kind VARCHAR(20) NOT NULL, -- accrual, withdrawal, adjustment, reversal
+   amount numeric(20,4) NOT NULL, -- positive amount increases account balance
+   order_number VARCHAR(100) NULL, -- reference to the accrued order
+   withdrawal_id UUID NULL, -- reference to the withdrawal
+   comment text NOT NULL DEFAULT '',
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS points_ledger_user_id_idx ON points_ledger(user_id, account);
+CREATE INDEX IF NOT EXISTS points_ledger_txn_id_idx ON points_ledger(txn_id);
+
+-- every order can be credited only once
+CREATE UNIQUE INDEX IF NOT EXISTS points_ledger_accrual_order_uidx
+ON points_ledger(order_number) WHERE kind = 'accrual' AND account = 'user';
+
+CREATE OR REPLACE FUNCTION points_ledger_append_only() RETURNS trigger AS $$
+BEGIN
+   RAISE EXCEPTION 'points_ledger is append-only, entries can not be updated';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER points_ledger_no_update
+BEFORE UPDATE ON points_ledger
+FOR EACH ROW EXECUTE FUNCTION points_ledger_append_only();
+
+-- move already credited accruals to the ledger
+WITH c AS (
+   SELECT order_id, user_id, value, credited_at, gen_random_uuid() AS txn_id
+   FROM accrual_credits
+)
+INSERT INTO points_ledger (txn_id, account, user_id, kind, amount, order_number, created_at)
+SELECT c.txn_id, a.account, c.user_id, 'accrual', a.sign * c.value, c.order_id, c.credited_at
+FROM c CROSS JOIN (VALUES ('user', 1), ('accrual', -1)) AS a(account, sign);
+
+-- move withdrawals history to the ledger
+WITH w AS (
+   SELECT id, user_id, value, processed_at, gen_random_uuid() AS txn_id
+   FROM withdrawals
+)
+INSERT INTO points_ledger (txn_id, account, user_id, kind, amount, withdrawal_id, created_at)
+SELECT w.txn_id, a.account, w.user_id, 'withdrawal', a.sign * w.value, w.id, w.processed_at
+FROM w CROSS JOIN (VALUES ('user', -1), ('redemption', 1)) AS a(account, sign);
+
+-- whatever can't be explained by history becomes an opening balance adjustment
+WITH d AS (
+   SELECT
+      b.user_id,
+      b.balance - COALESCE((
+         SELECT SUM(l.amount)
+         FROM points_ledger l
+         WHERE l.user_id = b.user_id AND l.account = 'user'
+      ), 0) AS drift,
+      gen_random_uuid() AS txn_id
+   FROM loyalty_points b
+)
+INSERT INTO points_ledger (txn_id, account, user_id, kind, amount, comment)
+SELECT d.txn_id, a.account, d.user_id, 'adjustment', a.sign * d.drift, 'opening balance'
+FROM d CROSS JOIN (VALUES ('user', 1), ('adjustment', -1)) AS a(account, sign)
+WHERE d.drift <> 0;
+
+DROP TABLE IF EXISTS accrual_credits;
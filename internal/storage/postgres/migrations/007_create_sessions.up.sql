@@ -0,0 +1,14 @@
+-- users' login sessions, access tokens are bound to them
+CREATE TABLE IF NOT EXISTS sessions(
+   id UUID PRIMARY KEY,
+   user_id bigint NOT NULL,
+   refresh_hash VARCHAR(100) NOT NULL, -- hash of the current refresh token
+   created_at timestamptz NOT NULL DEFAULT now(),
+   refreshed_at timestamptz NOT NULL DEFAULT now(),
+   expires_at timestamptz NOT NULL,
+   revoked_at timestamptz NULL,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions(user_id);
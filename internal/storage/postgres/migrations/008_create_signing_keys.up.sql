@@ -0,0 +1,10 @@
+-- auth tokens signing keys shared by all app instances
+CREATE TABLE IF NOT EXISTS signing_keys(
+   id VARCHAR(100) PRIMARY KEY, -- kid
+   alg VARCHAR(20) NOT NULL,
+   sealed bytea NOT NULL, -- private key encrypted with app secret
+   created_at timestamptz NOT NULL DEFAULT now(),
+   expires_at timestamptz NULL -- NULL - never expires
+);
+
+CREATE INDEX IF NOT EXISTS signing_keys_created_at_idx ON signing_keys(created_at);
@@ -0,0 +1,18 @@
+-- failed login attempts, login may belong to no user
+CREATE TABLE IF NOT EXISTS login_attempts(
+   id bigserial PRIMARY KEY,
+   login VARCHAR(50) NOT NULL,
+   ip VARCHAR(64) NOT NULL,
+   created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_login_idx ON login_attempts(login, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts(ip, created_at);
+
+-- lockouts of logins and ip addresses issued after too many failed attempts
+CREATE TABLE IF NOT EXISTS login_lockouts(
+   key VARCHAR(100) PRIMARY KEY, -- login:<login> or ip:<ip>
+   level int NOT NULL DEFAULT 0,
+   locked_until timestamptz NULL,
+   reset_at timestamptz NULL
+);
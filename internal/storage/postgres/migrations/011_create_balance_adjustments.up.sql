@@ -0,0 +1,23 @@
+-- manual balance corrections made by support staff, large ones wait for
+-- another admin's approval. Operator and approver are kept without foreign
+-- keys, so the audit trail survives staff accounts deletion.
+CREATE TABLE IF NOT EXISTS balance_adjustments(
+   id UUID PRIMARY KEY,
+   user_id bigint NOT NULL,
+   amount numeric(20,4) NOT NULL, -- positive amount credits balance
+   reason VARCHAR(50) NOT NULL,
+   comment text NOT NULL DEFAULT '',
+   operator_id bigint NOT NULL,
+   approver_id bigint NULL,
+   status VARCHAR(20) NOT NULL, -- pending, applied, rejected
+   created_at timestamptz NOT NULL DEFAULT now(),
+   decided_at timestamptz NULL,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_id_idx ON balance_adjustments(user_id);
+CREATE INDEX IF NOT EXISTS balance_adjustments_pending_idx ON balance_adjustments(created_at) WHERE status = 'pending';
+
+-- reference to the adjustment
+ALTER TABLE points_ledger ADD COLUMN IF NOT EXISTS adjustment_id UUID NULL;
@@ -0,0 +1,22 @@
+-- Credited points are tracked in lots, debits consume the oldest lots first.
+-- Sum of remaining points of user's lots equals to user's balance, lots tell
+-- when the points expire.
+CREATE TABLE IF NOT EXISTS points_lots(
+   id bigserial PRIMARY KEY,
+   user_id bigint NOT NULL,
+   amount numeric(20,4) NOT NULL,
+   remaining numeric(20,4) NOT NULL,
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT points_lots_remaining_check CHECK (remaining >= 0 AND remaining <= amount),
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS points_lots_open_idx ON points_lots(user_id, created_at, id) WHERE remaining > 0;
+
+-- history can't tell which points were spent, so current balances become
+-- lots credited at migration time
+INSERT INTO points_lots (user_id, amount, remaining)
+SELECT user_id, balance, balance
+FROM loyalty_points
+WHERE balance > 0;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS points_ledger_user_created_idx;
+
+DROP TABLE IF EXISTS order_bonuses;
@@ -0,0 +1,21 @@
+-- points credited for orders on top of accruals, e.g. by tier multiplier
+CREATE TABLE IF NOT EXISTS order_bonuses(
+   id bigserial PRIMARY KEY,
+   order_id VARCHAR(100) NOT NULL,
+   user_id bigint NOT NULL,
+   source VARCHAR(20) NOT NULL,
+   ref VARCHAR(100) NOT NULL DEFAULT '',
+   amount numeric(20,4) NOT NULL,
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_order_id
+      FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- every bonus can be credited for the order only once
+CREATE UNIQUE INDEX IF NOT EXISTS order_bonuses_uidx ON order_bonuses(order_id, source, ref);
+CREATE INDEX IF NOT EXISTS order_bonuses_user_id_idx ON order_bonuses(user_id);
+
+-- rolling sums for tiers
+CREATE INDEX IF NOT EXISTS points_ledger_user_created_idx ON points_ledger(user_id, created_at) WHERE account = 'user';
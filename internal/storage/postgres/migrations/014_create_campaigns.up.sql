@@ -0,0 +1,21 @@
+-- marketing campaigns crediting bonus points for processed orders
+CREATE TABLE IF NOT EXISTS campaigns(
+   id bigserial PRIMARY KEY,
+   name VARCHAR(200) NOT NULL,
+   starts_at timestamptz NOT NULL,
+   ends_at timestamptz NOT NULL,
+   points numeric(20,4) NOT NULL DEFAULT 0,
+   multiplier numeric(20,4) NOT NULL DEFAULT 0,
+   first_order boolean NOT NULL DEFAULT false,
+   tiers text NOT NULL DEFAULT '', -- comma separated, empty matches any tier
+   order_prefix VARCHAR(100) NOT NULL DEFAULT '',
+   priority integer NOT NULL DEFAULT 0,
+   exclusive boolean NOT NULL DEFAULT false,
+   budget numeric(20,4) NOT NULL DEFAULT 0, -- 0 - unlimited
+   spent numeric(20,4) NOT NULL DEFAULT 0,
+   disabled boolean NOT NULL DEFAULT false,
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT campaign_budget_check CHECK (budget = 0 OR spent <= budget)
+);
+
+CREATE INDEX IF NOT EXISTS campaigns_running_idx ON campaigns(ends_at) WHERE NOT disabled;
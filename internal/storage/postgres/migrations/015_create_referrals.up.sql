@@ -0,0 +1,30 @@
+-- personal codes users invite others with, created on demand
+CREATE TABLE IF NOT EXISTS referral_codes(
+   user_id bigint PRIMARY KEY,
+   code VARCHAR(20) NOT NULL UNIQUE,
+   ip VARCHAR(100) NOT NULL DEFAULT '', -- address code was issued to
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- users registered with referral codes, both parties are rewarded once
+-- referee's first order is processed
+CREATE TABLE IF NOT EXISTS referrals(
+   referee_id bigint PRIMARY KEY,
+   referrer_id bigint NOT NULL,
+   code VARCHAR(20) NOT NULL,
+   status VARCHAR(20) NOT NULL, -- pending, rewarded, rejected, capped
+   ip VARCHAR(100) NOT NULL DEFAULT '', -- referee's registration address
+   order_id VARCHAR(100) NULL, -- referee's first processed order
+   referrer_reward numeric(20,4) NOT NULL DEFAULT 0,
+   referee_reward numeric(20,4) NOT NULL DEFAULT 0,
+   created_at timestamptz NOT NULL DEFAULT now(),
+   decided_at timestamptz NULL,
+   CONSTRAINT fk_referee_id
+      FOREIGN KEY(referee_id) REFERENCES users(id) ON DELETE CASCADE,
+   CONSTRAINT fk_referrer_id
+      FOREIGN KEY(referrer_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS referrals_referrer_id_idx ON referrals(referrer_id);
@@ -0,0 +1,22 @@
+-- points sent by users to each other. Parties are set to NULL when their
+-- account is deleted, so the other party keeps the transfer in history.
+CREATE TABLE IF NOT EXISTS balance_transfers(
+   id UUID PRIMARY KEY,
+   from_user_id bigint NULL,
+   to_user_id bigint NULL,
+   amount numeric(20,4) NOT NULL CHECK (amount > 0),
+   comment text NOT NULL DEFAULT '',
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT balance_transfers_parties_check CHECK (from_user_id <> to_user_id),
+   CONSTRAINT fk_from_user_id
+      FOREIGN KEY(from_user_id) REFERENCES users(id) ON DELETE SET NULL,
+   CONSTRAINT fk_to_user_id
+      FOREIGN KEY(to_user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- daily limits are counted over sender's recent transfers
+CREATE INDEX IF NOT EXISTS balance_transfers_from_idx ON balance_transfers(from_user_id, created_at);
+CREATE INDEX IF NOT EXISTS balance_transfers_to_idx ON balance_transfers(to_user_id);
+
+-- reference to the transfer
+ALTER TABLE points_ledger ADD COLUMN IF NOT EXISTS transfer_id UUID NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS withdrawals_held_idx;
+
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS status;
+
+ALTER TABLE loyalty_points DROP CONSTRAINT IF EXISTS held_within_balance_check;
+ALTER TABLE loyalty_points DROP COLUMN IF EXISTS held;
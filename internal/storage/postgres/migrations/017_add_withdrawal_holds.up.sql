@@ -0,0 +1,13 @@
+-- points reserved by withdrawal holds, they can't be spent otherwise
+ALTER TABLE loyalty_points ADD COLUMN IF NOT EXISTS held numeric(20,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE loyalty_points
+ADD CONSTRAINT held_within_balance_check
+CHECK (held >= 0 AND held <= balance);
+
+-- held withdrawals wait for capture until expires_at, existing ones were
+-- debited right away
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'captured'; -- held, captured, voided, expired
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS expires_at timestamptz NULL;
+
+CREATE INDEX IF NOT EXISTS withdrawals_held_idx ON withdrawals(expires_at) WHERE status = 'held';
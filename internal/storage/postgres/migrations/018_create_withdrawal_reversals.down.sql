@@ -0,0 +1,4 @@
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_reversed_check;
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS reversed;
+
+DROP TABLE IF EXISTS withdrawal_reversals;
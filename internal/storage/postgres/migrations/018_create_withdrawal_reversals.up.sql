@@ -0,0 +1,25 @@
+-- points of withdrawals returned back to users. Operator is kept without
+-- foreign key, so the audit trail survives staff accounts deletion.
+CREATE TABLE IF NOT EXISTS withdrawal_reversals(
+   id UUID PRIMARY KEY,
+   withdrawal_id UUID NOT NULL,
+   user_id bigint NOT NULL,
+   amount numeric(20,4) NOT NULL CHECK (amount > 0),
+   comment text NOT NULL DEFAULT '',
+   operator_id bigint NOT NULL,
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_withdrawal_id
+      FOREIGN KEY(withdrawal_id) REFERENCES withdrawals(id) ON DELETE CASCADE,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS withdrawal_reversals_withdrawal_id_idx ON withdrawal_reversals(withdrawal_id);
+CREATE INDEX IF NOT EXISTS withdrawal_reversals_user_id_idx ON withdrawal_reversals(user_id);
+
+-- sum of withdrawal's reversals, it can't exceed withdrawn value
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversed numeric(20,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE withdrawals
+ADD CONSTRAINT withdrawals_reversed_check
+CHECK (reversed >= 0 AND reversed <= value);
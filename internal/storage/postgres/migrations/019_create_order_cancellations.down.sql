@@ -0,0 +1,4 @@
+ALTER TABLE loyalty_points DROP CONSTRAINT IF EXISTS debt_non_negative_check;
+ALTER TABLE loyalty_points DROP COLUMN IF EXISTS debt;
+
+DROP TABLE IF EXISTS order_cancellations;
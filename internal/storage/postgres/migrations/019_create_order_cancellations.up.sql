@@ -0,0 +1,28 @@
+-- processed orders cancelled by merchants, points credited for them are
+-- taken back. Operator is kept without foreign key the same as for reversals.
+CREATE TABLE IF NOT EXISTS order_cancellations(
+   id UUID PRIMARY KEY,
+   order_id VARCHAR(100) NOT NULL,
+   user_id bigint NOT NULL,
+   owed numeric(20,4) NOT NULL,
+   clawed numeric(20,4) NOT NULL,
+   debt numeric(20,4) NOT NULL,
+   comment text NOT NULL DEFAULT '',
+   operator_id bigint NOT NULL,
+   created_at timestamptz NOT NULL DEFAULT now(),
+   CONSTRAINT fk_order_id
+      FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+   CONSTRAINT fk_user_id
+      FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- every order can be cancelled only once
+CREATE UNIQUE INDEX IF NOT EXISTS order_cancellations_order_id_uidx ON order_cancellations(order_id);
+CREATE INDEX IF NOT EXISTS order_cancellations_user_id_idx ON order_cancellations(user_id);
+
+-- points of cancelled orders user had already spent, paid off by future accruals
+ALTER TABLE loyalty_points ADD COLUMN IF NOT EXISTS debt numeric(20,4) NOT NULL DEFAULT 0;
+
+ALTER TABLE loyalty_points
+ADD CONSTRAINT debt_non_negative_check
+CHECK (debt >= 0);
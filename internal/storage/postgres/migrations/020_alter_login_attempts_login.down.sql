@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS login_attempts_created_at_idx;
+
+ALTER TABLE login_lockouts ALTER COLUMN key TYPE VARCHAR(100) USING left(key, 100);
+ALTER TABLE login_attempts ALTER COLUMN login TYPE VARCHAR(50) USING left(login, 50);
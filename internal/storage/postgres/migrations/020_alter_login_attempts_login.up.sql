@@ -0,0 +1,7 @@
+-- attempts are recorded for any login submitted, not only registered ones,
+-- so it's not limited by users.login length
+ALTER TABLE login_attempts ALTER COLUMN login TYPE TEXT;
+ALTER TABLE login_lockouts ALTER COLUMN key TYPE TEXT;
+
+-- attempts which fell out of the counting window are purged
+CREATE INDEX IF NOT EXISTS login_attempts_created_at_idx ON login_attempts(created_at);
@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+)
+
+const queryAddOrderBonus = `
+	INSERT INTO order_bonuses (order_id, user_id, source, ref, amount, created_at)
+	VALUES ($1, $2, $3, $4, $5, now())
+	ON CONFLICT DO NOTHING
+	RETURNING id;
+`
+
+// AccrueBonus adds bonus for the order to user's balance. Every bonus can be
+// credited only once, storage.ErrDuplicateEntry is returned on repeated call.
+func (r *BalanceRepo) AccrueBonus(ctx context.Context, orderID model.OrderNumber, userID int64, bonus model.OrderBonus) (err error) {
+	return r.s.inTx(ctx, func(tx *Storage) error {
+		var id int64
+		err := tx.q.QueryRowContext(ctx, queryAddOrderBonus,
+			orderID,
+			userID,
+			bonus.Source,
+			bonus.Ref,
+			bonus.Amount,
+		).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = storage.ErrDuplicateEntry
+			}
+			return storage.WrapCaller(err)
+		}
+
+		if err := tx.balance.change(ctx, bonus.Amount, userID); err != nil {
+			return err
+		}
+
+		if err := tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID:  userID,
+			Kind:    model.LedgerBonus,
+			Amount:  bonus.Amount,
+			Order:   string(orderID),
+			Comment: bonus.Source + ":" + bonus.Ref,
+		}); err != nil {
+			return err
+		}
+
+		// debt of cancelled orders is paid off by the bonus first
+		return tx.balance.collectDebt(ctx, bonus.Amount, userID)
+	})
+}
+
+const queryActivity = `
+	SELECT
+		COALESCE(SUM(amount) FILTER (WHERE kind IN ('accrual', 'bonus', 'clawback')), 0),
+		COALESCE(-SUM(amount) FILTER (WHERE kind IN ('withdrawal', 'reversal')), 0)
+	FROM points_ledger
+	WHERE user_id = $1 AND account = 'user' AND created_at >= $2;
+`
+
+// Activity returns sums of points user was credited for orders and spent
+// since the moment. Clawbacks of cancelled orders reduce credited sum.
+func (r *BalanceRepo) Activity(ctx context.Context, userID int64, since time.Time) (activity model.PointsActivity, err error) {
+	err = r.s.q.QueryRowContext(ctx, queryActivity, userID, since).Scan(
+		&activity.Accrued,
+		&activity.Spent,
+	)
+
+	return activity, storage.WrapCaller(err)
+}
+
+const queryOrderBonuses = `
+	SELECT order_id, source, ref, amount
+	FROM order_bonuses
+	WHERE ($1 = '' OR order_id = $1) AND ($2 = 0 OR user_id = $2)
+	ORDER BY id;
+`
+
+// attachBonuses loads bonuses of the orders, which must belong to the user
+// or be the only one.
+func (r *OrdersRepo) attachBonuses(ctx context.Context, orders []model.Order, orderID model.OrderNumber, userID int64) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	rows, err := r.s.q.QueryContext(ctx, queryOrderBonuses, orderID, userID)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	bonuses := make(map[model.OrderNumber][]model.OrderBonus)
+	for rows.Next() {
+		var (
+			id    model.OrderNumber
+			bonus model.OrderBonus
+		)
+
+		if err = rows.Scan(&id, &bonus.Source, &bonus.Ref, &bonus.Amount); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		bonuses[id] = append(bonuses[id], bonus)
+	}
+
+	if err = rows.Err(); err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	for i := range orders {
+		orders[i].Bonuses = bonuses[orders[i].ID]
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -34,7 +35,7 @@ func NewOrdersRepo(s *Storage) (repo *OrdersRepo) {
 }
 
 func initOrderNumbersGenerator(repo *OrdersRepo) (err error) {
-	lastNum, err := repo.LastOrderNumber()
+	lastNum, err := repo.LastOrderNumber(context.Background())
 	if err != nil {
 		return storage.WrapCaller(err)
 	}
@@ -59,8 +60,8 @@ const fieldsOrders = `
 const queryGetOrder = `SELECT ` + fieldsOrders + `FROM orders WHERE id=$1;`
 
 // Get returns nil order when wasn't found and storage.ErrNotFound error.
-func (r *OrdersRepo) Get(id model.OrderNumber) (order *model.Order, err error) {
-	stmt, err := r.s.db.Prepare(queryGetOrder)
+func (r *OrdersRepo) Get(ctx context.Context, id model.OrderNumber) (order *model.Order, err error) {
+	stmt, err := r.s.q.PrepareContext(ctx, queryGetOrder)
 	if err != nil {
 		return nil, storage.WrapCaller(err)
 	}
@@ -71,7 +72,7 @@ func (r *OrdersRepo) Get(id model.OrderNumber) (order *model.Order, err error) {
 	var tsUploadedAt time.Time
 
 	order = new(model.Order)
-	if err = stmt.QueryRow(id).Scan(
+	if err = stmt.QueryRowContext(ctx, id).Scan(
 		&order.ID,
 		&order.UserID,
 		&tsUploadedAt,
@@ -91,6 +92,13 @@ func (r *OrdersRepo) Get(id model.OrderNumber) (order *model.Order, err error) {
 
 	order.UploadedAt = tsUploadedAt.Format(model.LayoutTimestamps)
 
+	orders := []model.Order{*order}
+	if err = r.attachBonuses(ctx, orders, order.ID, 0); err != nil {
+		return nil, err
+	}
+
+	order.Bonuses = orders[0].Bonuses
+
 	return order, nil
 }
 
@@ -100,10 +108,10 @@ const queryGetOrdersByUserID = `SELECT ` +
 	ORDER BY uploaded_at ASC;
 `
 
-func (r *OrdersRepo) GetByUserID(userID int64) (orders []model.Order, err error) {
+func (r *OrdersRepo) GetByUserID(ctx context.Context, userID int64) (orders []model.Order, err error) {
 	orders = make([]model.Order, 0)
 
-	stmt, err := r.s.db.Prepare(queryGetOrdersByUserID)
+	stmt, err := r.s.q.PrepareContext(ctx, queryGetOrdersByUserID)
 	if err != nil {
 		return orders, storage.WrapCaller(err)
 	}
@@ -113,7 +121,7 @@ func (r *OrdersRepo) GetByUserID(userID int64) (orders []model.Order, err error)
 	var nsProcessedAt sql.NullTime
 	var tsUploadedAt time.Time
 
-	rows, err := stmt.Query(userID)
+	rows, err := stmt.QueryContext(ctx, userID)
 	if err != nil {
 		return orders, storage.WrapCaller(err)
 	}
@@ -141,7 +149,11 @@ func (r *OrdersRepo) GetByUserID(userID int64) (orders []model.Order, err error)
 		orders = append(orders, order)
 	}
 
-	return orders, storage.WrapCaller(rows.Err())
+	if err = rows.Err(); err != nil {
+		return orders, storage.WrapCaller(err)
+	}
+
+	return orders, r.attachBonuses(ctx, orders, "", userID)
 }
 
 const queryGetOrdersByStatus = `SELECT ` +
@@ -150,10 +162,10 @@ const queryGetOrdersByStatus = `SELECT ` +
 	ORDER BY uploaded_at ASC;
 `
 
-func (r *OrdersRepo) GetByStatus(status string) (orders []model.Order, err error) {
+func (r *OrdersRepo) GetByStatus(ctx context.Context, status string) (orders []model.Order, err error) {
 	orders = make([]model.Order, 0)
 
-	stmt, err := r.s.db.Prepare(queryGetOrdersByStatus)
+	stmt, err := r.s.q.PrepareContext(ctx, queryGetOrdersByStatus)
 	if err != nil {
 		return orders, storage.WrapCaller(err)
 	}
@@ -163,7 +175,7 @@ func (r *OrdersRepo) GetByStatus(status string) (orders []model.Order, err error
 	var nsProcessedAt sql.NullTime
 	var tsUploadedAt time.Time
 
-	rows, err := stmt.Query(status)
+	rows, err := stmt.QueryContext(ctx, status)
 	if err != nil {
 		return orders, storage.WrapCaller(err)
 	}
@@ -223,7 +235,7 @@ const queryCreateOrder = `
 	VALUES ($1, $2, $3) RETURNING id;
 `
 
-func (r *OrdersRepo) Create(order model.Order) (id string, err error) {
+func (r *OrdersRepo) Create(ctx context.Context, order model.Order) (id string, err error) {
 	if order.ID == "" {
 		// Почему-то сначала подумал, что номер заказа надо генерить самому.
 		// Не нужно, но пока оставил.
@@ -233,13 +245,13 @@ func (r *OrdersRepo) Create(order model.Order) (id string, err error) {
 		}
 	}
 
-	stmt, err := r.s.db.Prepare(queryCreateOrder)
+	stmt, err := r.s.q.PrepareContext(ctx, queryCreateOrder)
 	if err != nil {
 		return id, storage.WrapCaller(err)
 	}
 	defer stmt.Close()
 
-	if err = stmt.QueryRow(
+	if err = stmt.QueryRowContext(ctx,
 		order.ID,
 		order.UserID,
 		order.Status,
@@ -268,10 +280,10 @@ const querySetProcessedOrder = `
 	WHERE id = $1;
 `
 
-func (r *OrdersRepo) SetProcessedStatus(orderID model.OrderNumber, status string, accrual float64) (processedAt time.Time, err error) {
+func (r *OrdersRepo) SetProcessedStatus(ctx context.Context, orderID model.OrderNumber, status string, accrual model.Points) (processedAt time.Time, err error) {
 	processedAt = time.Now()
 
-	_, err = r.s.db.Exec(querySetProcessedOrder,
+	_, err = r.s.q.ExecContext(ctx, querySetProcessedOrder,
 		orderID,
 		status,
 		accrual,
@@ -284,10 +296,19 @@ func (r *OrdersRepo) SetProcessedStatus(orderID model.OrderNumber, status string
 	return
 }
 
+const queryCountUserOrders = `SELECT count(*) FROM orders WHERE user_id = $1 AND status = $2;`
+
+// CountByUserID returns number of user's orders having the status.
+func (r *OrdersRepo) CountByUserID(ctx context.Context, userID int64, status string) (count int64, err error) {
+	err = r.s.q.QueryRowContext(ctx, queryCountUserOrders, userID, status).Scan(&count)
+
+	return count, storage.WrapCaller(err)
+}
+
 const queryGetLastOrderNum = `SELECT id FROM orders ORDER BY uploaded_at DESC LIMIT 1;`
 
-func (r *OrdersRepo) LastOrderNumber() (orderNumber model.OrderNumber, err error) {
-	if err = r.s.db.QueryRow(queryGetLastOrderNum).Scan(
+func (r *OrdersRepo) LastOrderNumber(ctx context.Context) (orderNumber model.OrderNumber, err error) {
+	if err = r.s.q.QueryRowContext(ctx, queryGetLastOrderNum).Scan(
 		&orderNumber,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type ReferralsRepo struct {
+	s *Storage
+}
+
+func NewReferralsRepo(s *Storage) *ReferralsRepo {
+	return &ReferralsRepo{
+		s: s,
+	}
+}
+
+const queryCreateReferralCode = `
+	INSERT INTO referral_codes (user_id, code, ip, created_at)
+	VALUES ($1, $2, $3, now());
+`
+
+// CreateCode saves user's referral code.
+func (r *ReferralsRepo) CreateCode(ctx context.Context, code model.ReferralCode) error {
+	_, err := r.s.q.ExecContext(ctx, queryCreateReferralCode, code.UserID, code.Code, code.IP)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return storage.WrapCaller(storage.ErrDuplicateEntry)
+		}
+		return storage.WrapCaller(err)
+	}
+
+	return nil
+}
+
+const queryReferralCodeByUser = `SELECT code, user_id, ip FROM referral_codes WHERE user_id = $1;`
+
+// CodeByUser returns user's referral code.
+func (r *ReferralsRepo) CodeByUser(ctx context.Context, userID int64) (code model.ReferralCode, err error) {
+	return r.code(ctx, queryReferralCodeByUser, userID)
+}
+
+const queryFindReferralCode = `SELECT code, user_id, ip FROM referral_codes WHERE code = $1;`
+
+// FindCode finds referral code.
+func (r *ReferralsRepo) FindCode(ctx context.Context, code string) (found model.ReferralCode, err error) {
+	return r.code(ctx, queryFindReferralCode, code)
+}
+
+func (r *ReferralsRepo) code(ctx context.Context, query string, arg any) (code model.ReferralCode, err error) {
+	err = r.s.q.QueryRowContext(ctx, query, arg).Scan(&code.Code, &code.UserID, &code.IP)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return code, storage.WrapCaller(err)
+	}
+
+	return code, nil
+}
+
+// $4 is used twice, so it's cast to keep its deduced type the same
+const queryCreateReferral = `
+	INSERT INTO referrals (
+		referee_id,
+		referrer_id,
+		code,
+		status,
+		ip,
+		created_at,
+		decided_at
+	)
+	VALUES ($1, $2, $3, $4::varchar, $5, now(), CASE WHEN $4::varchar = 'pending' THEN NULL ELSE now() END);
+`
+
+// Create saves referral. Referral rejected by fraud guards is decided right
+// away.
+func (r *ReferralsRepo) Create(ctx context.Context, referral model.Referral) error {
+	_, err := r.s.q.ExecContext(ctx, queryCreateReferral,
+		referral.RefereeID,
+		referral.ReferrerID,
+		referral.Code,
+		referral.Status,
+		referral.IP,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return storage.WrapCaller(storage.ErrDuplicateEntry)
+		}
+		return storage.WrapCaller(err)
+	}
+
+	return nil
+}
+
+const selectReferrals = `
+	SELECT
+		r.referee_id,
+		u.login,
+		r.referrer_id,
+		r.code,
+		r.status,
+		r.ip,
+		COALESCE(r.order_id, ''),
+		r.referrer_reward,
+		r.referee_reward,
+		r.created_at,
+		r.decided_at
+	FROM referrals r
+	JOIN users u ON u.id = r.referee_id
+`
+
+const queryListReferrals = selectReferrals + `
+	WHERE r.referrer_id = $1
+	ORDER BY r.created_at DESC, r.referee_id DESC;
+`
+
+// List returns user's referrals, newest first.
+func (r *ReferralsRepo) List(ctx context.Context, referrerID int64) (referrals []model.Referral, err error) {
+	referrals = make([]model.Referral, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryListReferrals, referrerID)
+	if err != nil {
+		return referrals, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		referral, err := scanReferral(rows)
+		if err != nil {
+			return referrals, storage.WrapCaller(err)
+		}
+
+		referrals = append(referrals, referral)
+	}
+
+	return referrals, storage.WrapCaller(rows.Err())
+}
+
+const queryLockPendingReferral = `
+	SELECT referrer_id, code
+	FROM referrals
+	WHERE referee_id = $1 AND status = 'pending'
+	FOR UPDATE;
+`
+
+// referrer's code row lock serializes rewards counting
+const queryLockReferrer = `SELECT user_id FROM referral_codes WHERE user_id = $1 FOR UPDATE;`
+
+// cancelled referrals were rewarded once, so they use the cap up
+const queryCountRewardedReferrals = `
+	SELECT count(*) FROM referrals WHERE referrer_id = $1 AND status IN ('rewarded', 'cancelled');
+`
+
+const queryDecideReferral = `
+	UPDATE referrals
+	SET
+		status = $2,
+		order_id = $3,
+		referrer_reward = $4,
+		referee_reward = $5,
+		decided_at = now()
+	WHERE referee_id = $1;
+`
+
+const queryGetReferral = selectReferrals + `WHERE r.referee_id = $1;`
+
+// Reward completes referee's pending referral after the first order of the
+// referee was processed.
+func (r *ReferralsRepo) Reward(ctx context.Context, refereeID int64, orderID model.OrderNumber, rewards model.ReferralRewards, maxPerReferrer int) (referral model.Referral, err error) {
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		referral.RefereeID = refereeID
+		referral.Order = string(orderID)
+
+		err := tx.q.QueryRowContext(ctx, queryLockPendingReferral, refereeID).Scan(
+			&referral.ReferrerID,
+			&referral.Code,
+		)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = storage.ErrNotFound
+			}
+			return storage.WrapCaller(err)
+		}
+
+		var lockedID int64
+		if err = tx.q.QueryRowContext(ctx, queryLockReferrer, referral.ReferrerID).Scan(&lockedID); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		var rewarded int
+		if err = tx.q.QueryRowContext(ctx, queryCountRewardedReferrals, referral.ReferrerID).Scan(&rewarded); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		referral.Status = model.ReferralCapped
+		if maxPerReferrer == 0 || rewarded < maxPerReferrer {
+			referral.Status = model.ReferralRewarded
+			referral.ReferrerReward = rewards.Referrer
+			referral.RefereeReward = rewards.Referee
+		}
+
+		_, err = tx.q.ExecContext(ctx, queryDecideReferral,
+			refereeID,
+			referral.Status,
+			referral.Order,
+			referral.ReferrerReward,
+			referral.RefereeReward,
+		)
+		if err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		if referral.Status == model.ReferralRewarded {
+			if err = tx.referrals.credit(ctx, referral); err != nil {
+				return err
+			}
+		}
+
+		referral, err = scanReferral(tx.q.QueryRowContext(ctx, queryGetReferral, refereeID))
+
+		return storage.WrapCaller(err)
+	})
+
+	return referral, err
+}
+
+// credit credits rewards of both referral parties. Referee's reward is
+// credited as the order's bonus. Must be called within transaction.
+func (r *ReferralsRepo) credit(ctx context.Context, referral model.Referral) error {
+	if referral.RefereeReward > 0 {
+		err := r.s.balance.AccrueBonus(ctx, model.OrderNumber(referral.Order), referral.RefereeID, model.OrderBonus{
+			Source: model.BonusReferral,
+			Ref:    referral.Code,
+			Amount: referral.RefereeReward,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if referral.ReferrerReward <= 0 {
+		return nil
+	}
+
+	if err := r.s.balance.change(ctx, referral.ReferrerReward, referral.ReferrerID); err != nil {
+		return err
+	}
+
+	return r.s.postUserEntry(ctx, model.LedgerEntry{
+		UserID:  referral.ReferrerID,
+		Kind:    model.LedgerReferral,
+		Amount:  referral.ReferrerReward,
+		Comment: "referee:" + strconv.FormatInt(referral.RefereeID, 10),
+	})
+}
+
+func scanReferral(row scanner) (referral model.Referral, err error) {
+	var (
+		tsCreatedAt time.Time
+		tsDecidedAt sql.NullTime
+	)
+
+	if err = row.Scan(
+		&referral.RefereeID,
+		&referral.Referee,
+		&referral.ReferrerID,
+		&referral.Code,
+		&referral.Status,
+		&referral.IP,
+		&referral.Order,
+		&referral.ReferrerReward,
+		&referral.RefereeReward,
+		&tsCreatedAt,
+		&tsDecidedAt,
+	); err != nil {
+		return referral, err
+	}
+
+	referral.CreatedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+	if tsDecidedAt.Valid {
+		referral.DecidedAt = tsDecidedAt.Time.Format(model.LayoutTimestamps)
+	}
+
+	return referral, nil
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type ReversalsRepo struct {
+	s *Storage
+}
+
+func NewReversalsRepo(s *Storage) *ReversalsRepo {
+	return &ReversalsRepo{
+		s: s,
+	}
+}
+
+const queryAddReversed = `UPDATE withdrawals SET reversed = reversed + $2 WHERE id = $1;`
+
+const queryAddReversal = `
+	INSERT INTO withdrawal_reversals (
+		id,
+		withdrawal_id,
+		user_id,
+		amount,
+		comment,
+		operator_id,
+		created_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, now())
+	RETURNING created_at;
+`
+
+// Create returns points of captured withdrawal back to user's balance.
+func (r *ReversalsRepo) Create(ctx context.Context, rev model.Reversal) (saved model.Reversal, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		// row lock serializes reversals of the withdrawal
+		wd, _, err := tx.balance.withdrawal(ctx, queryLockWithdrawal, rev.WithdrawalID)
+		if err != nil {
+			return err
+		}
+
+		rest := wd.Value - wd.Reversed
+		if wd.Status != model.WithdrawalCaptured || rest <= 0 {
+			return storage.WrapCaller(storage.ErrStateConflict)
+		}
+
+		if rev.Amount == 0 {
+			rev.Amount = rest
+		}
+
+		if rev.Amount < 0 || rev.Amount > rest {
+			return storage.WrapCaller(storage.ErrCheckViolation)
+		}
+
+		if _, err = tx.q.ExecContext(ctx, queryAddReversed, wd.ID, rev.Amount); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		var tsCreatedAt time.Time
+		if err = tx.q.QueryRowContext(ctx, queryAddReversal,
+			id,
+			wd.ID,
+			wd.UserID,
+			rev.Amount,
+			rev.Comment,
+			rev.OperatorID,
+		).Scan(&tsCreatedAt); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		if err = tx.balance.change(ctx, rev.Amount, wd.UserID); err != nil {
+			return err
+		}
+
+		saved = rev
+		saved.ID = id
+		saved.UserID = wd.UserID
+		saved.CreatedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+
+		return tx.postUserEntry(ctx, model.LedgerEntry{
+			UserID:       wd.UserID,
+			Kind:         model.LedgerReversal,
+			Amount:       rev.Amount,
+			WithdrawalID: &wd.ID,
+			Comment:      rev.Comment,
+		})
+	})
+
+	return saved, err
+}
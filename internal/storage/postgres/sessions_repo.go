@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type SessionsRepo struct {
+	s *Storage
+}
+
+func NewSessionsRepo(s *Storage) *SessionsRepo {
+	return &SessionsRepo{
+		s: s,
+	}
+}
+
+const queryCreateSession = `
+	INSERT INTO sessions (
+		id,
+		user_id,
+		refresh_hash,
+		created_at,
+		refreshed_at,
+		expires_at
+	)
+	VALUES ($1, $2, $3, $4, $4, $5);
+`
+
+func (r *SessionsRepo) Create(ctx context.Context, session model.Session) error {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+
+	_, err := r.s.q.ExecContext(ctx, queryCreateSession,
+		session.ID,
+		session.UserID,
+		session.RefreshHash,
+		session.CreatedAt,
+		session.ExpiresAt,
+	)
+
+	return storage.WrapCaller(err)
+}
+
+const queryGetSession = `
+	SELECT
+		id,
+		user_id,
+		refresh_hash,
+		created_at,
+		refreshed_at,
+		expires_at,
+		revoked_at
+	FROM sessions WHERE id = $1;
+`
+
+// Get finds session by id. When requested session doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *SessionsRepo) Get(ctx context.Context, id uuid.UUID) (session model.Session, err error) {
+	// session.RevokedAt is nullable
+	var nsRevokedAt sql.NullTime
+
+	if err = r.s.q.QueryRowContext(ctx, queryGetSession, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.RefreshHash,
+		&session.CreatedAt,
+		&session.RefreshedAt,
+		&session.ExpiresAt,
+		&nsRevokedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = storage.ErrNotFound
+		}
+		return session, storage.WrapCaller(err)
+	}
+
+	if nsRevokedAt.Valid {
+		session.RevokedAt = nsRevokedAt.Time
+	}
+
+	return session, nil
+}
+
+const queryRotateSession = `
+	UPDATE sessions
+	SET
+		refresh_hash = $3,
+		refreshed_at = now(),
+		expires_at = $4
+	WHERE id = $1
+		AND refresh_hash = $2
+		AND revoked_at IS NULL
+		AND expires_at > now();
+`
+
+// Rotate replaces refresh token hash of active session when current hash
+// equals oldHash and prolongs the session till expiresAt. Otherwise
+// storage.ErrNotFound is returned.
+func (r *SessionsRepo) Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash string, expiresAt time.Time) error {
+	res, err := r.s.q.ExecContext(ctx, queryRotateSession, id, oldHash, newHash, expiresAt)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	if n == 0 {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+const queryRevokeSession = `
+	UPDATE sessions SET revoked_at = now()
+	WHERE id = $1 AND revoked_at IS NULL;
+`
+
+// Revoke revokes session. Already revoked session is left untouched.
+func (r *SessionsRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	_, err := r.s.q.ExecContext(ctx, queryRevokeSession, id)
+	return storage.WrapCaller(err)
+}
+
+const queryRevokeUserSessions = `
+	UPDATE sessions SET revoked_at = now()
+	WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now();
+`
+
+// RevokeAll revokes all active user's sessions. Returns number of revoked
+// sessions.
+func (r *SessionsRepo) RevokeAll(ctx context.Context, userID int64) (count int64, err error) {
+	res, err := r.s.q.ExecContext(ctx, queryRevokeUserSessions, userID)
+	if err != nil {
+		return 0, storage.WrapCaller(err)
+	}
+
+	count, err = res.RowsAffected()
+
+	return count, storage.WrapCaller(err)
+}
+
+const queryRevokeOtherUserSessions = `
+	UPDATE sessions SET revoked_at = now()
+	WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > now();
+`
+
+// RevokeOthers revokes all active user's sessions except the kept one.
+// Returns number of revoked sessions.
+func (r *SessionsRepo) RevokeOthers(ctx context.Context, userID int64, keep uuid.UUID) (count int64, err error) {
+	res, err := r.s.q.ExecContext(ctx, queryRevokeOtherUserSessions, userID, keep)
+	if err != nil {
+		return 0, storage.WrapCaller(err)
+	}
+
+	count, err = res.RowsAffected()
+
+	return count, storage.WrapCaller(err)
+}
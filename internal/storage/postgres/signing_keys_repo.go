@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type SigningKeysRepo struct {
+	s *Storage
+}
+
+func NewSigningKeysRepo(s *Storage) *SigningKeysRepo {
+	return &SigningKeysRepo{
+		s: s,
+	}
+}
+
+const queryCreateSigningKey = `
+	INSERT INTO signing_keys (
+		id,
+		alg,
+		sealed,
+		created_at,
+		expires_at
+	)
+	VALUES ($1, $2, $3, $4, $5);
+`
+
+func (r *SigningKeysRepo) Create(ctx context.Context, key model.SigningKey) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	// zero expiration means the key never expires
+	var nsExpiresAt sql.NullTime
+	if !key.ExpiresAt.IsZero() {
+		nsExpiresAt = sql.NullTime{Time: key.ExpiresAt, Valid: true}
+	}
+
+	_, err := r.s.q.ExecContext(ctx, queryCreateSigningKey,
+		key.ID,
+		key.Alg,
+		key.Sealed,
+		key.CreatedAt,
+		nsExpiresAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == pgerrcode.UniqueViolation {
+				return storage.WrapCaller(storage.ErrDuplicateEntry)
+			}
+		}
+
+		return storage.WrapCaller(err)
+	}
+
+	return nil
+}
+
+const queryListSigningKeys = `
+	SELECT
+		id,
+		alg,
+		sealed,
+		created_at,
+		expires_at
+	FROM signing_keys
+	WHERE expires_at IS NULL OR expires_at > $1
+	ORDER BY created_at DESC;
+`
+
+// List returns keys which are not expired by now, newest first.
+func (r *SigningKeysRepo) List(ctx context.Context, now time.Time) (keys []model.SigningKey, err error) {
+	keys = make([]model.SigningKey, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, queryListSigningKeys, now)
+	if err != nil {
+		return keys, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key         model.SigningKey
+			nsExpiresAt sql.NullTime
+		)
+
+		if err = rows.Scan(
+			&key.ID,
+			&key.Alg,
+			&key.Sealed,
+			&key.CreatedAt,
+			&nsExpiresAt,
+		); err != nil {
+			return keys, storage.WrapCaller(err)
+		}
+
+		if nsExpiresAt.Valid {
+			key.ExpiresAt = nsExpiresAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, storage.WrapCaller(rows.Err())
+}
+
+const queryDeleteExpiredSigningKeys = `DELETE FROM signing_keys WHERE expires_at <= $1;`
+
+// DeleteExpired removes keys expired by now. Returns number of removed keys.
+func (r *SigningKeysRepo) DeleteExpired(ctx context.Context, now time.Time) (count int64, err error) {
+	res, err := r.s.q.ExecContext(ctx, queryDeleteExpiredSigningKeys, now)
+	if err != nil {
+		return 0, storage.WrapCaller(err)
+	}
+
+	count, err = res.RowsAffected()
+
+	return count, storage.WrapCaller(err)
+}
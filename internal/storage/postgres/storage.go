@@ -2,31 +2,139 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
 )
 
+// querier is a set of methods shared by both *sql.DB and *sql.Tx, so
+// repositories don't care if they are run within transaction or not.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
 type Storage struct {
-	db      *sql.DB
-	users   *UsersRepo
-	orders  *OrdersRepo
-	balance *BalanceRepo
+	db *sql.DB
+	q  querier // either db or tx
+	tx *sql.Tx // not nil when storage is bound to transaction
+
+	users         *UsersRepo
+	orders        *OrdersRepo
+	balance       *BalanceRepo
+	holds         *HoldsRepo
+	reversals     *ReversalsRepo
+	transfers     *TransfersRepo
+	adjustments   *AdjustmentsRepo
+	cancellations *CancellationsRepo
+	lots          *LotsRepo
+	jobs          *AccrualJobsRepo
+	sessions      *SessionsRepo
+	keys          *SigningKeysRepo
+	attempts      *LoginAttemptsRepo
+	campaigns     *CampaignsRepo
+	referrals     *ReferralsRepo
 }
 
 func New(db *sql.DB) *Storage {
 	s := &Storage{
 		db: db,
+		q:  db,
 	}
 
 	// initialize all repos once before they will be used
 	s.users = NewUsersRepo(s)
 	s.orders = NewOrdersRepo(s)
 	s.balance = NewBalanceRepo(s)
+	s.holds = NewHoldsRepo(s)
+	s.reversals = NewReversalsRepo(s)
+	s.transfers = NewTransfersRepo(s)
+	s.adjustments = NewAdjustmentsRepo(s)
+	s.cancellations = NewCancellationsRepo(s)
+	s.lots = NewLotsRepo(s)
+	s.jobs = NewAccrualJobsRepo(s)
+	s.sessions = NewSessionsRepo(s)
+	s.keys = NewSigningKeysRepo(s)
+	s.attempts = NewLoginAttemptsRepo(s)
+	s.campaigns = NewCampaignsRepo(s)
+	s.referrals = NewReferralsRepo(s)
 
 	return s
 }
 
+// withTx returns copy of the storage bound to transaction.
+func (s *Storage) withTx(tx *sql.Tx) *Storage {
+	txs := &Storage{
+		db: s.db,
+		q:  tx,
+		tx: tx,
+	}
+
+	txs.users = NewUsersRepo(txs)
+	txs.balance = NewBalanceRepo(txs)
+	txs.holds = NewHoldsRepo(txs)
+	txs.reversals = NewReversalsRepo(txs)
+	txs.transfers = NewTransfersRepo(txs)
+	txs.adjustments = NewAdjustmentsRepo(txs)
+	txs.cancellations = NewCancellationsRepo(txs)
+	txs.lots = NewLotsRepo(txs)
+	txs.jobs = NewAccrualJobsRepo(txs)
+	txs.sessions = NewSessionsRepo(txs)
+	txs.keys = NewSigningKeysRepo(txs)
+	txs.attempts = NewLoginAttemptsRepo(txs)
+	txs.campaigns = NewCampaignsRepo(txs)
+	txs.referrals = NewReferralsRepo(txs)
+
+	// order numbers generator must be shared, so don't reinitialize it
+	txs.orders = &OrdersRepo{
+		s:      txs,
+		numgen: s.orders.numgen,
+	}
+
+	return txs
+}
+
+// InTx runs f within a single transaction shared by all repositories of the
+// storage passed to f. Transaction is committed when f returns nil and rolled
+// back otherwise. Nested calls join the outer transaction.
+func (s *Storage) InTx(ctx context.Context, f func(tx storage.Storage) error) error {
+	if s.tx != nil {
+		return f(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err = f(s.withTx(tx)); err != nil {
+		return err
+	}
+
+	return storage.WrapCaller(tx.Commit())
+}
+
+// inTx is the same as InTx, but provides concrete storage type to f. Used by
+// repositories which need several statements to be run atomically.
+func (s *Storage) inTx(ctx context.Context, f func(tx *Storage) error) error {
+	return s.InTx(ctx, func(tx storage.Storage) error {
+		return f(tx.(*Storage))
+	})
+}
+
+// Close closes db connection pool. Storage bound to transaction must not
+// be closed.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
 func (s *Storage) Users() storage.UsersRepository {
 	// if s.users == nil {
 	// 	s.users = NewUsersRepo(s)
@@ -42,3 +150,51 @@ func (s *Storage) Orders() storage.OrdersRepository {
 func (s *Storage) Balance() storage.BalanceRepository {
 	return s.balance
 }
+
+func (s *Storage) Holds() storage.HoldsRepository {
+	return s.holds
+}
+
+func (s *Storage) Reversals() storage.ReversalsRepository {
+	return s.reversals
+}
+
+func (s *Storage) Transfers() storage.TransfersRepository {
+	return s.transfers
+}
+
+func (s *Storage) Adjustments() storage.AdjustmentsRepository {
+	return s.adjustments
+}
+
+func (s *Storage) Cancellations() storage.CancellationsRepository {
+	return s.cancellations
+}
+
+func (s *Storage) Lots() storage.LotsRepository {
+	return s.lots
+}
+
+func (s *Storage) AccrualJobs() storage.AccrualJobsRepository {
+	return s.jobs
+}
+
+func (s *Storage) Sessions() storage.SessionsRepository {
+	return s.sessions
+}
+
+func (s *Storage) SigningKeys() storage.SigningKeysRepository {
+	return s.keys
+}
+
+func (s *Storage) LoginAttempts() storage.LoginAttemptsRepository {
+	return s.attempts
+}
+
+func (s *Storage) Campaigns() storage.CampaignsRepository {
+	return s.campaigns
+}
+
+func (s *Storage) Referrals() storage.ReferralsRepository {
+	return s.referrals
+}
@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage/storagetest"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// envTestDSN points to the database the tests can freely wipe out.
+const envTestDSN = "TEST_DATABASE_URI"
+
+const queryTruncateAll = `
+	TRUNCATE users, orders, loyalty_points, withdrawals, points_ledger,
+		accrual_jobs, accrual_workers, sessions, signing_keys,
+		login_attempts, login_lockouts, balance_adjustments, points_lots,
+		order_bonuses, campaigns, referral_codes, referrals, balance_transfers,
+		withdrawal_reversals, order_cancellations
+	RESTART IDENTITY CASCADE;
+`
+
+func TestStorageConformance(t *testing.T) {
+	dsn := os.Getenv(envTestDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", envTestDSN)
+	}
+
+	if err := RunMigrations(dsn, false); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		if _, err := db.Exec(queryTruncateAll); err != nil {
+			t.Fatal(err)
+		}
+
+		return New(db)
+	})
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+type TransfersRepo struct {
+	s *Storage
+}
+
+func NewTransfersRepo(s *Storage) *TransfersRepo {
+	return &TransfersRepo{
+		s: s,
+	}
+}
+
+// every transfer locks balance rows of both parties in the same order, so
+// opposite transfers between the same users don't deadlock
+const queryLockTransferParties = `
+	SELECT user_id FROM loyalty_points
+	WHERE user_id IN ($1, $2)
+	ORDER BY user_id
+	FOR UPDATE;
+`
+
+const querySentTransfers = `
+	SELECT count(*), COALESCE(SUM(amount), 0)
+	FROM balance_transfers
+	WHERE from_user_id = $1 AND created_at > now() - interval '1 day';
+`
+
+const queryAddTransfer = `
+	INSERT INTO balance_transfers (
+		id,
+		from_user_id,
+		to_user_id,
+		amount,
+		comment,
+		created_at
+	)
+	VALUES ($1, $2, $3, $4, $5, now())
+	RETURNING created_at;
+`
+
+// Create moves points from one user's balance to another's. Limits are
+// checked against transfers sent within the last 24 hours.
+func (r *TransfersRepo) Create(ctx context.Context, transfer model.Transfer, limits model.TransferLimits) (saved model.Transfer, err error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return saved, storage.WrapCaller(err)
+	}
+
+	err = r.s.inTx(ctx, func(tx *Storage) error {
+		if _, err := tx.q.ExecContext(ctx, queryLockTransferParties, transfer.FromUserID, transfer.ToUserID); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		var (
+			count int
+			sent  model.Points
+		)
+
+		if err := tx.q.QueryRowContext(ctx, querySentTransfers, transfer.FromUserID).Scan(&count, &sent); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		if (limits.Count > 0 && count >= limits.Count) || (limits.Amount > 0 && sent+transfer.Amount > limits.Amount) {
+			return storage.WrapCaller(storage.ErrLimitExceeded)
+		}
+
+		if err := tx.balance.change(ctx, -transfer.Amount, transfer.FromUserID); err != nil {
+			return err
+		}
+
+		if err := tx.balance.change(ctx, transfer.Amount, transfer.ToUserID); err != nil {
+			return err
+		}
+
+		var tsCreatedAt time.Time
+		if err := tx.q.QueryRowContext(ctx, queryAddTransfer,
+			id,
+			transfer.FromUserID,
+			transfer.ToUserID,
+			transfer.Amount,
+			transfer.Comment,
+		).Scan(&tsCreatedAt); err != nil {
+			return storage.WrapCaller(err)
+		}
+
+		saved = transfer
+		saved.ID = id
+		saved.CreatedAt = tsCreatedAt.Format(model.LayoutTimestamps)
+
+		return tx.postLedger(ctx,
+			model.LedgerEntry{
+				Kind:       model.LedgerTransfer,
+				TransferID: &id,
+				Comment:    transfer.Comment,
+			},
+			posting{account: model.AccountUser, userID: transfer.FromUserID, amount: -transfer.Amount},
+			posting{account: model.AccountUser, userID: transfer.ToUserID, amount: transfer.Amount},
+		)
+	})
+
+	return saved, err
+}
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
@@ -21,20 +22,21 @@ func NewUsersRepo(s *Storage) *UsersRepo {
 	}
 }
 
-const queryGetUser = `SELECT id, login, password FROM users WHERE id=$1;`
+const queryGetUser = `SELECT id, login, role, password FROM users WHERE id=$1;`
 
 // Get finds user by id. When requested user doesn't exist
 // storage.ErrNotFound error is returned.
-func (r *UsersRepo) Get(id int64) (user model.User, err error) {
-	stmt, err := r.s.db.Prepare(queryGetUser)
+func (r *UsersRepo) Get(ctx context.Context, id int64) (user model.User, err error) {
+	stmt, err := r.s.q.PrepareContext(ctx, queryGetUser)
 	if err != nil {
 		return user, storage.WrapCaller(err)
 	}
 	defer stmt.Close()
 
-	if err = stmt.QueryRow(id).Scan(
+	if err = stmt.QueryRowContext(ctx, id).Scan(
 		&user.ID,
 		&user.Login,
+		&user.Role,
 		&user.PasswordHash,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -46,22 +48,23 @@ func (r *UsersRepo) Get(id int64) (user model.User, err error) {
 	return user, nil
 }
 
-const queryFindUserByLogin = `SELECT id, login, password FROM users WHERE login=$1;`
+const queryFindUserByLogin = `SELECT id, login, role, password FROM users WHERE login=$1;`
 
 // FindByLogin finds user by login. When requested user doesn't exist
 // storage.ErrNotFound error is returned.
-func (r *UsersRepo) FindByLogin(login string) (user model.User, err error) {
+func (r *UsersRepo) FindByLogin(ctx context.Context, login string) (user model.User, err error) {
 	login = strings.ToLower(login)
 
-	stmt, err := r.s.db.Prepare(queryFindUserByLogin)
+	stmt, err := r.s.q.PrepareContext(ctx, queryFindUserByLogin)
 	if err != nil {
 		return user, storage.WrapCaller(err)
 	}
 	defer stmt.Close()
 
-	if err = stmt.QueryRow(login).Scan(
+	if err = stmt.QueryRowContext(ctx, login).Scan(
 		&user.ID,
 		&user.Login,
+		&user.Role,
 		&user.PasswordHash,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -74,19 +77,22 @@ func (r *UsersRepo) FindByLogin(login string) (user model.User, err error) {
 }
 
 const queryCreateUser = `
-	INSERT INTO users (login, password) VALUES ($1, $2) RETURNING id;
+	INSERT INTO users (login, role, password) VALUES ($1, $2, $3) RETURNING id;
 `
 
-func (r *UsersRepo) Create(user model.User) (id int64, err error) {
+func (r *UsersRepo) Create(ctx context.Context, user model.User) (id int64, err error) {
 	user.Login = strings.ToLower(user.Login)
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
 
-	stmt, err := r.s.db.Prepare(queryCreateUser)
+	stmt, err := r.s.q.PrepareContext(ctx, queryCreateUser)
 	if err != nil {
 		return id, storage.WrapCaller(err)
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(user.Login, user.PasswordHash).Scan(&id)
+	err = stmt.QueryRowContext(ctx, user.Login, user.Role, user.PasswordHash).Scan(&id)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -103,16 +109,97 @@ func (r *UsersRepo) Create(user model.User) (id int64, err error) {
 	return id, storage.WrapCaller(err)
 }
 
+const querySetUserPassword = `UPDATE users SET password=$2 WHERE id=$1;`
+
+// SetPasswordHash replaces user's password hash. When requested user
+// doesn't exist storage.ErrNotFound error is returned.
+func (r *UsersRepo) SetPasswordHash(ctx context.Context, id int64, hash string) error {
+	res, err := r.s.q.ExecContext(ctx, querySetUserPassword, id, hash)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	if n == 0 {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+const querySetUserRole = `UPDATE users SET role=$2 WHERE id=$1;`
+
+// SetRole changes user's role. When requested user doesn't exist
+// storage.ErrNotFound error is returned.
+func (r *UsersRepo) SetRole(ctx context.Context, id int64, role model.Role) error {
+	res, err := r.s.q.ExecContext(ctx, querySetUserRole, id, role)
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storage.WrapCaller(err)
+	}
+
+	if n == 0 {
+		return storage.WrapCaller(storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+// empty filter fields match everything, 0 limit means no limit
+const querySearchUsers = `
+	SELECT id, login, role
+	FROM users
+	WHERE ($1 = '' OR strpos(login, $1) > 0) AND ($2 = '' OR role = $2)
+	ORDER BY id
+	LIMIT NULLIF($3, 0) OFFSET $4;
+`
+
+// Search returns users matching filter ordered by id. Password hashes are
+// not loaded.
+func (r *UsersRepo) Search(ctx context.Context, filter model.UsersFilter) (users []model.User, err error) {
+	users = make([]model.User, 0)
+
+	rows, err := r.s.q.QueryContext(ctx, querySearchUsers,
+		strings.ToLower(filter.Login),
+		filter.Role,
+		filter.Limit,
+		filter.Offset,
+	)
+	if err != nil {
+		return users, storage.WrapCaller(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user model.User
+		if err = rows.Scan(&user.ID, &user.Login, &user.Role); err != nil {
+			return users, storage.WrapCaller(err)
+		}
+
+		users = append(users, user)
+	}
+
+	return users, storage.WrapCaller(rows.Err())
+}
+
 const queryDeleteUser = `DELETE FROM users WHERE id=$1;`
 
-func (r *UsersRepo) Delete(id int64) error {
-	stmt, err := r.s.db.Prepare(queryDeleteUser)
+func (r *UsersRepo) Delete(ctx context.Context, id int64) error {
+	stmt, err := r.s.q.PrepareContext(ctx, queryDeleteUser)
 	if err != nil {
 		return storage.WrapCaller(err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.ExecContext(ctx, id)
 
 	return storage.WrapCaller(err)
 }
@@ -2,16 +2,39 @@
 package storage
 
 import (
+	"context"
 	"time"
 
 	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/google/uuid"
 )
 
 // Storage is a set of repositories.
 type Storage interface {
 	Users() UsersRepository
 	Balance() BalanceRepository
+	Holds() HoldsRepository
+	Reversals() ReversalsRepository
+	Transfers() TransfersRepository
+	Adjustments() AdjustmentsRepository
+	Cancellations() CancellationsRepository
+	Lots() LotsRepository
 	Orders() OrdersRepository
+	AccrualJobs() AccrualJobsRepository
+	Sessions() SessionsRepository
+	SigningKeys() SigningKeysRepository
+	LoginAttempts() LoginAttemptsRepository
+	Campaigns() CampaignsRepository
+	Referrals() ReferralsRepository
+
+	// InTx runs f within a single transaction (unit of work) shared by all
+	// repositories of the storage passed to f. Transaction is committed when
+	// f returns nil and rolled back otherwise. Nested calls join the outer
+	// transaction.
+	InTx(ctx context.Context, f func(tx Storage) error) error
+
+	// Close releases resources held by the storage (e.g. db connection pool).
+	Close() error
 }
 
 // UsersRepository is a set of methods to manipulate users' accounts.
@@ -20,36 +43,293 @@ type Storage interface {
 type UsersRepository interface {
 	// Get finds user by id. When requested user doesn't exist
 	// storage.ErrNotFound error is returned.
-	Get(id int64) (user model.User, err error)
+	Get(ctx context.Context, id int64) (user model.User, err error)
 	// FindByLogin finds user by login. When requested user doesn't exist
 	// storage.ErrNotFound error is returned.
-	FindByLogin(login string) (user model.User, err error)
-	Create(user model.User) (id int64, err error)
-	Delete(id int64) error
+	FindByLogin(ctx context.Context, login string) (user model.User, err error)
+	Create(ctx context.Context, user model.User) (id int64, err error)
+	// SetPasswordHash replaces user's password hash. When requested user
+	// doesn't exist storage.ErrNotFound error is returned.
+	SetPasswordHash(ctx context.Context, id int64, hash string) error
+	// SetRole changes user's role. When requested user doesn't exist
+	// storage.ErrNotFound error is returned.
+	SetRole(ctx context.Context, id int64, role model.Role) error
+	// Search returns users matching filter ordered by id. Password hashes
+	// are not loaded.
+	Search(ctx context.Context, filter model.UsersFilter) (users []model.User, err error)
+	Delete(ctx context.Context, id int64) error
 }
 
 // OrdersRepository is a set of methods to manipulate users' orders.
 type OrdersRepository interface {
 	// Get returns nil order when wasn't found and storage.ErrNotFound error.
-	Get(id model.OrderNumber) (order *model.Order, err error)
-	GetByUserID(userID int64) (order []model.Order, err error)
-	GetByStatus(status string) (order []model.Order, err error)
-	LastOrderNumber() (orderNumber model.OrderNumber, err error)
-	Create(order model.Order) (id string, err error)
-	SetProcessedStatus(orderID model.OrderNumber, status string, accrual float64) (processedAt time.Time, err error)
+	// Order's bonuses are loaded by Get and GetByUserID.
+	Get(ctx context.Context, id model.OrderNumber) (order *model.Order, err error)
+	GetByUserID(ctx context.Context, userID int64) (order []model.Order, err error)
+	GetByStatus(ctx context.Context, status string) (order []model.Order, err error)
+	// CountByUserID returns number of user's orders having the status.
+	CountByUserID(ctx context.Context, userID int64, status string) (count int64, err error)
+	LastOrderNumber(ctx context.Context) (orderNumber model.OrderNumber, err error)
+	Create(ctx context.Context, order model.Order) (id string, err error)
+	SetProcessedStatus(ctx context.Context, orderID model.OrderNumber, status string, accrual model.Points) (processedAt time.Time, err error)
 }
 
 // BalanceRepository is a set of methods to manipulate users' loyalty points.
+//
+// Every balance change is written to the append-only points ledger, which is
+// the source of truth for balances. Cached balance counter is kept in sync
+// within the same transaction.
 type BalanceRepository interface {
-	// Get returns current balance with total withdrawn value.
+	// Get returns current balance with total withdrawn value derived from the
+	// ledger along with held points and debt.
 	// Error storage.ErrNotFound is returned when no data found.
-	Get(userID int64) (balance model.Balance, err error)
+	Get(ctx context.Context, userID int64) (balance model.Balance, err error)
 	// Add adds new accrual sum to current balance.
 	// Returns new updated balance and current total withdrawn value.
-	Add(accrual float64, userID int64) (balance model.Balance, err error)
+	Add(ctx context.Context, accrual model.Points, userID int64) (balance model.Balance, err error)
+	// Accrue adds order's accrual sum to user's balance. Every order can be
+	// credited only once, storage.ErrDuplicateEntry is returned on repeated call.
+	Accrue(ctx context.Context, orderID model.OrderNumber, accrual model.Points, userID int64) (balance model.Balance, err error)
 	// Withdraw decreases curent balance and writes entry to history.
 	// Parameter orderID is a hypothetical order number.
-	Withdraw(sum float64, userID int64, orderID model.OrderNumber) (err error)
-	// Withdrawals returns all withdrawal calls for user.
-	Withdrawals(userID int64) (history []model.Withdrawal, err error)
+	Withdraw(ctx context.Context, sum model.Points, userID int64, orderID model.OrderNumber) (err error)
+	// Withdrawals returns all withdrawal calls for user with their reversals
+	// linked.
+	Withdrawals(ctx context.Context, userID int64) (history []model.Withdrawal, err error)
+	// History returns user's full balance history: withdrawals, their
+	// reversals, applied adjustments, transfers sent or received and
+	// clawbacks of cancelled orders.
+	History(ctx context.Context, userID int64) (history []model.HistoryEntry, err error)
+	// Statement returns all user's ledger entries explaining current balance.
+	Statement(ctx context.Context, userID int64) (entries []model.LedgerEntry, err error)
+	// AccrueBonus adds bonus for the order to user's balance. Every bonus can
+	// be credited only once, storage.ErrDuplicateEntry is returned on
+	// repeated call.
+	AccrueBonus(ctx context.Context, orderID model.OrderNumber, userID int64, bonus model.OrderBonus) (err error)
+	// Activity returns sums of points user was credited for orders and spent
+	// since the moment.
+	Activity(ctx context.Context, userID int64, since time.Time) (activity model.PointsActivity, err error)
+	// Reconcile rebuilds cached balance counter from the ledger.
+	// Returns difference between the ledger and the counter before rebuild.
+	Reconcile(ctx context.Context, userID int64) (drift model.Points, err error)
+}
+
+// HoldsRepository is a set of methods to manipulate withdrawal holds. Held
+// points stay on balance until the withdrawal is captured.
+type HoldsRepository interface {
+	// Create reserves points for the withdrawal until expiresAt. Balance isn't
+	// changed until the withdrawal is captured, but held points can't be
+	// spent otherwise. Error storage.ErrNegativeBalance is returned when
+	// available points are insufficient.
+	Create(ctx context.Context, sum model.Points, userID int64, orderID model.OrderNumber, expiresAt time.Time) (wd model.Withdrawal, err error)
+	// Capture debits held withdrawal. Lesser sum releases the rest of held
+	// points, zero sum captures all of them. Error storage.ErrNotFound is
+	// returned when user has no such withdrawal, storage.ErrStateConflict -
+	// when it isn't held or the hold has expired, storage.ErrCheckViolation -
+	// when sum exceeds held one.
+	Capture(ctx context.Context, id uuid.UUID, userID int64, sum model.Points) (wd model.Withdrawal, err error)
+	// Void releases points held by the withdrawal. Errors are the same as
+	// Capture's ones, expired but not yet released hold can be voided.
+	Void(ctx context.Context, id uuid.UUID, userID int64) (wd model.Withdrawal, err error)
+	// Expired returns ids of held withdrawals expired by now, the
+	// earliest first.
+	Expired(ctx context.Context, now time.Time, limit int) (ids []uuid.UUID, err error)
+	// Expire releases points of the hold expired by now.
+	// Error storage.ErrStateConflict is returned when it's no longer held.
+	Expire(ctx context.Context, id uuid.UUID, now time.Time) error
+}
+
+// ReversalsRepository is a set of methods to return withdrawn points back.
+type ReversalsRepository interface {
+	// Create returns points of captured withdrawal back to user's balance,
+	// zero amount reverses all the rest of it. Error storage.ErrNotFound is
+	// returned when there is no such withdrawal, storage.ErrStateConflict -
+	// when it isn't captured or is already fully reversed,
+	// storage.ErrCheckViolation - when amount exceeds the rest.
+	Create(ctx context.Context, rev model.Reversal) (saved model.Reversal, err error)
+}
+
+// TransfersRepository is a set of methods to move points between users.
+type TransfersRepository interface {
+	// Create moves points from one user's balance to another's. Both
+	// balances and the ledger are changed in a single transaction.
+	// Error storage.ErrNegativeBalance is returned when sender lacks points,
+	// storage.ErrLimitExceeded - when transfer exceeds sender's limits.
+	Create(ctx context.Context, transfer model.Transfer, limits model.TransferLimits) (saved model.Transfer, err error)
+}
+
+// AdjustmentsRepository is a set of methods to manipulate manual balance
+// adjustments made by staff.
+type AdjustmentsRepository interface {
+	// Create saves manual adjustment. Pending adjustment is only saved,
+	// any other is applied to user's balance right away.
+	// Error storage.ErrNegativeBalance is returned when debit exceeds balance.
+	Create(ctx context.Context, adj model.Adjustment) (saved model.Adjustment, err error)
+	// Decide approves (and applies) or rejects pending adjustment.
+	// Error storage.ErrStateConflict is returned when it's not pending.
+	Decide(ctx context.Context, id uuid.UUID, approverID int64, approve bool) (adj model.Adjustment, err error)
+	// Get returns adjustment by id.
+	// Error storage.ErrNotFound is returned when no data found.
+	Get(ctx context.Context, id uuid.UUID) (adj model.Adjustment, err error)
+	// List returns adjustments matching filter, newest first.
+	List(ctx context.Context, filter model.AdjustmentsFilter) (adjustments []model.Adjustment, err error)
+}
+
+// CancellationsRepository is a set of methods to cancel processed orders.
+type CancellationsRepository interface {
+	// Create cancels processed order and takes points credited for it
+	// back. Points user has already spent are left as debt or written off
+	// according to policy (model.ClawbackDebt or model.ClawbackPartial). Debt
+	// is paid off by future accruals and order bonuses first, other credits
+	// don't touch it. Referrer's reward for the order is taken back by the
+	// same policy and campaigns' bonuses return to their budgets. Error
+	// storage.ErrNotFound is returned when there is no such order,
+	// storage.ErrStateConflict - when it isn't processed.
+	Create(ctx context.Context, c model.Cancellation, policy string) (saved model.Cancellation, err error)
+}
+
+// LotsRepository is a set of methods to manipulate points lots. Every credit
+// is a lot, debits consume the oldest lots first.
+type LotsRepository interface {
+	// List returns user's lots having points left, the oldest first. Debits
+	// consume them in this order.
+	List(ctx context.Context, userID int64) (lots []model.PointsLot, err error)
+	// UsersWithExpired returns ids of users having points left in lots
+	// older than months at the moment now. Users whose available points are
+	// all held are skipped, held points don't expire until released.
+	UsersWithExpired(ctx context.Context, months int, now time.Time, limit int) (userIDs []int64, err error)
+	// Expire takes remaining points of user's lots older than months
+	// away and writes expiry entry to the ledger. Returns expired amount.
+	Expire(ctx context.Context, userID int64, months int, now time.Time) (expired model.Points, err error)
+}
+
+// AccrualJobsRepository is a set of methods to manipulate accrual polling jobs
+// queue. The queue is shared between all running app instances, so every job
+// must be claimed (leased) by a worker before being processed.
+type AccrualJobsRepository interface {
+	// Enqueue adds order to the queue. Already queued order is left untouched.
+	Enqueue(ctx context.Context, orderID model.OrderNumber) error
+	// EnqueueByStatus adds all orders with provided status which are missing
+	// from the queue. Returns number of newly queued orders.
+	EnqueueByStatus(ctx context.Context, status string) (count int64, err error)
+	// Claim leases up to limit due jobs to the worker for lease duration and
+	// increases their attempts counter. Jobs leased by other workers are skipped.
+	Claim(ctx context.Context, workerID string, limit int, lease time.Duration) (jobs []model.AccrualJob, err error)
+	// Retry releases job's lease and schedules next attempt after delay.
+	Retry(ctx context.Context, orderID model.OrderNumber, workerID string, delay time.Duration, lastErr string) error
+	// Reschedule makes order's job due right away, job is queued when it's
+	// missing. Meant for stuck orders.
+	Reschedule(ctx context.Context, orderID model.OrderNumber) error
+	// Complete removes job from the queue.
+	Complete(ctx context.Context, orderID model.OrderNumber) error
+	// Heartbeat marks worker as alive and extends leases of all jobs held by it.
+	Heartbeat(ctx context.Context, workerID string, lease time.Duration) error
+	// Release releases leases of all jobs held by the worker, so they can be
+	// claimed by others right away, and unregisters the worker. Returns order
+	// numbers of released jobs.
+	Release(ctx context.Context, workerID string) (orders []model.OrderNumber, err error)
+}
+
+// SessionsRepository is a set of methods to manipulate users' login sessions.
+type SessionsRepository interface {
+	Create(ctx context.Context, session model.Session) error
+	// Get finds session by id. When requested session doesn't exist
+	// storage.ErrNotFound error is returned.
+	Get(ctx context.Context, id uuid.UUID) (session model.Session, err error)
+	// Rotate replaces refresh token hash of active session when current hash
+	// equals oldHash and prolongs the session till expiresAt. Otherwise
+	// storage.ErrNotFound is returned.
+	Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash string, expiresAt time.Time) error
+	// Revoke revokes session. Already revoked session is left untouched.
+	Revoke(ctx context.Context, id uuid.UUID) error
+	// RevokeAll revokes all active user's sessions. Returns number of revoked
+	// sessions.
+	RevokeAll(ctx context.Context, userID int64) (count int64, err error)
+	// RevokeOthers revokes all active user's sessions except the kept one.
+	// Returns number of revoked sessions.
+	RevokeOthers(ctx context.Context, userID int64, keep uuid.UUID) (count int64, err error)
+}
+
+// SigningKeysRepository is a set of methods to manipulate auth tokens signing
+// keys shared by all app instances.
+type SigningKeysRepository interface {
+	Create(ctx context.Context, key model.SigningKey) error
+	// List returns keys which are not expired by now, newest first.
+	List(ctx context.Context, now time.Time) (keys []model.SigningKey, err error)
+	// DeleteExpired removes keys expired by now. Returns number of removed keys.
+	DeleteExpired(ctx context.Context, now time.Time) (count int64, err error)
+}
+
+// LoginAttemptsRepository is a set of methods to manipulate failed login
+// attempts and lockouts issued because of them.
+type LoginAttemptsRepository interface {
+	Add(ctx context.Context, attempt model.LoginAttempt) error
+	// CountByLogin returns number of failed attempts for the login made
+	// since the moment.
+	CountByLogin(ctx context.Context, login string, since time.Time) (count int64, err error)
+	// CountByIP returns number of failed attempts from the ip made since the
+	// moment.
+	CountByIP(ctx context.Context, ip string, since time.Time) (count int64, err error)
+	// List returns failed attempts matching filter, newest first.
+	List(ctx context.Context, filter model.LoginAttemptsFilter) (attempts []model.LoginAttempt, err error)
+	// Clear removes failed attempts matching filter along with lockouts of
+	// the filter's login and ip. Returns number of removed attempts.
+	Clear(ctx context.Context, filter model.LoginAttemptsFilter) (count int64, err error)
+	// Purge removes attempts made before the moment along with lockouts
+	// which ended and were reset before it. Returns number of removed
+	// attempts.
+	Purge(ctx context.Context, before time.Time) (count int64, err error)
+
+	// Lockout finds lockout by key. When key was never locked
+	// storage.ErrNotFound error is returned.
+	Lockout(ctx context.Context, key string) (lockout model.Lockout, err error)
+	// SetLockout creates or replaces lockout.
+	SetLockout(ctx context.Context, lockout model.Lockout) error
+	// Lockouts returns lockouts which are in effect at the moment now.
+	Lockouts(ctx context.Context, now time.Time) (lockouts []model.Lockout, err error)
+}
+
+// CampaignsRepository is a set of methods to manipulate marketing campaigns.
+type CampaignsRepository interface {
+	Create(ctx context.Context, campaign model.Campaign) (id int64, err error)
+	// Get finds campaign by id. When requested campaign doesn't exist
+	// storage.ErrNotFound error is returned.
+	Get(ctx context.Context, id int64) (campaign model.Campaign, err error)
+	// Update replaces campaign's settings, spent budget is kept. When
+	// requested campaign doesn't exist storage.ErrNotFound error is returned.
+	Update(ctx context.Context, campaign model.Campaign) error
+	// List returns all campaigns, newest first.
+	List(ctx context.Context) (campaigns []model.Campaign, err error)
+	// Running returns campaigns running at the moment now, higher priority
+	// first.
+	Running(ctx context.Context, now time.Time) (campaigns []model.Campaign, err error)
+	// Spend takes up to amount from campaign's budget. Returns granted
+	// amount, which is zero when the budget is exhausted.
+	Spend(ctx context.Context, id int64, amount model.Points) (granted model.Points, err error)
+}
+
+// ReferralsRepository is a set of methods to manipulate referral codes and
+// referrals made with them.
+type ReferralsRepository interface {
+	// CreateCode saves user's referral code. Error storage.ErrDuplicateEntry
+	// is returned when the code is taken or the user already has one.
+	CreateCode(ctx context.Context, code model.ReferralCode) error
+	// CodeByUser returns user's referral code. When user has no code
+	// storage.ErrNotFound error is returned.
+	CodeByUser(ctx context.Context, userID int64) (code model.ReferralCode, err error)
+	// FindCode finds referral code. When requested code doesn't exist
+	// storage.ErrNotFound error is returned.
+	FindCode(ctx context.Context, code string) (found model.ReferralCode, err error)
+	// Create saves referral. Error storage.ErrDuplicateEntry is returned when
+	// referee was already referred.
+	Create(ctx context.Context, referral model.Referral) error
+	// List returns user's referrals, newest first.
+	List(ctx context.Context, referrerID int64) (referrals []model.Referral, err error)
+	// Reward completes referee's pending referral after the first order of
+	// the referee was processed. Both parties are credited, unless referrer
+	// already has maxPerReferrer rewarded referrals (0 - no cap), then the
+	// referral is capped. Referrals cancelled after being rewarded count
+	// toward the cap too. When referee has no pending referral
+	// storage.ErrNotFound error is returned.
+	Reward(ctx context.Context, refereeID int64, orderID model.OrderNumber, rewards model.ReferralRewards, maxPerReferrer int) (referral model.Referral, err error)
 }
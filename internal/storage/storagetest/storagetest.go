@@ -0,0 +1,1724 @@
+// Package storagetest contains conformance tests every storage.Storage
+// implementation must pass, so backends can be used interchangeably.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/model"
+	"github.com/Dmitrevicz/yp-gophermart-loyalty/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Factory must return new empty storage for every call.
+type Factory func(t *testing.T) storage.Storage
+
+// Run runs all conformance tests against storage created by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"Users", testUsers},
+		{"UsersDeleteCascade", testUsersDeleteCascade},
+		{"Orders", testOrders},
+		{"BalanceAccrue", testBalanceAccrue},
+		{"BalanceWithdraw", testBalanceWithdraw},
+		{"BalanceReconcile", testBalanceReconcile},
+		{"BalanceAdjustments", testBalanceAdjustments},
+		{"BalanceTransfers", testBalanceTransfers},
+		{"WithdrawalHolds", testWithdrawalHolds},
+		{"WithdrawalReversals", testWithdrawalReversals},
+		{"OrderCancellations", testOrderCancellations},
+		{"OrderCancellationRewards", testOrderCancellationRewards},
+		{"PointsLots", testPointsLots},
+		{"PointsExpiryWithHold", testPointsExpiryWithHold},
+		{"OrderBonuses", testOrderBonuses},
+		{"Campaigns", testCampaigns},
+		{"Referrals", testReferrals},
+		{"TxRollback", testTxRollback},
+		{"AccrualJobs", testAccrualJobs},
+		{"Sessions", testSessions},
+		{"SigningKeys", testSigningKeys},
+		{"LoginAttempts", testLoginAttempts},
+		{"LoginAttemptsPurge", testLoginAttemptsPurge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+// points parses test points value.
+func points(t *testing.T, s string) model.Points {
+	t.Helper()
+
+	p, err := model.ParsePoints(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func mustCreateUser(t *testing.T, s storage.Storage, login string) int64 {
+	t.Helper()
+
+	ctx := context.Background()
+
+	id, err := s.Users().Create(ctx, model.User{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	return id
+}
+
+func mustCreateOrder(t *testing.T, s storage.Storage, num model.OrderNumber, userID int64) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	_, err := s.Orders().Create(ctx, model.Order{ID: num, UserID: userID, Status: "NEW"})
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+}
+
+func assertErrorIs(t *testing.T, err, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Fatalf("expected error %q, got: %v", target, err)
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func testUsers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id := mustCreateUser(t, s, "Gopher")
+
+	user, err := s.Users().Get(ctx, id)
+	assertNoError(t, err)
+	if user.Login != "gopher" || user.PasswordHash != "hash" || user.Role != model.RoleUser {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	user, err = s.Users().FindByLogin(ctx, "GOPHER")
+	assertNoError(t, err)
+	if user.ID != id {
+		t.Errorf("expected user %d, got %d", id, user.ID)
+	}
+
+	_, err = s.Users().Create(ctx, model.User{Login: "gopher", PasswordHash: "hash"})
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	_, err = s.Users().FindByLogin(ctx, "nobody")
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	assertNoError(t, s.Users().SetPasswordHash(ctx, id, "new-hash"))
+	user, err = s.Users().Get(ctx, id)
+	assertNoError(t, err)
+	if user.PasswordHash != "new-hash" {
+		t.Errorf("password hash wasn't replaced: %+v", user)
+	}
+
+	err = s.Users().SetPasswordHash(ctx, id+1000, "new-hash")
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	assertNoError(t, s.Users().SetRole(ctx, id, model.RoleSupport))
+	err = s.Users().SetRole(ctx, id+1000, model.RoleSupport)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	mustCreateUser(t, s, "gophers-friend")
+	mustCreateUser(t, s, "stranger")
+
+	found, err := s.Users().Search(ctx, model.UsersFilter{Login: "GOPHER"})
+	assertNoError(t, err)
+	if len(found) != 2 || found[0].ID != id || found[0].Role != model.RoleSupport || found[0].PasswordHash != "" {
+		t.Fatalf("unexpected users found: %+v", found)
+	}
+
+	found, err = s.Users().Search(ctx, model.UsersFilter{Role: model.RoleUser, Limit: 1, Offset: 1})
+	assertNoError(t, err)
+	if len(found) != 1 || found[0].Login != "stranger" {
+		t.Fatalf("unexpected users page: %+v", found)
+	}
+
+	assertNoError(t, s.Users().Delete(ctx, id))
+
+	_, err = s.Users().Get(ctx, id)
+	assertErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testUsersDeleteCascade(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id := mustCreateUser(t, s, "gopher")
+	mustCreateOrder(t, s, "12345678903", id)
+
+	_, err := s.Balance().Accrue(ctx, "12345678903", points(t, "10"), id)
+	assertNoError(t, err)
+
+	adj, err := s.Adjustments().Create(ctx, model.Adjustment{
+		UserID:     id,
+		Amount:     points(t, "1"),
+		Reason:     model.ReasonGoodwill,
+		OperatorID: id,
+	})
+	assertNoError(t, err)
+
+	assertNoError(t, s.Users().Delete(ctx, id))
+
+	_, err = s.Adjustments().Get(ctx, adj.ID)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	lots, err := s.Lots().List(ctx, id)
+	assertNoError(t, err)
+	if len(lots) != 0 {
+		t.Errorf("expected lots to be cleaned up, got %+v", lots)
+	}
+
+	_, err = s.Orders().Get(ctx, "12345678903")
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.Balance().Get(ctx, id)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	entries, err := s.Balance().Statement(ctx, id)
+	assertNoError(t, err)
+	if len(entries) != 0 {
+		t.Errorf("expected ledger to be cleaned up, got %d entries", len(entries))
+	}
+}
+
+func testOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	otherID := mustCreateUser(t, s, "other")
+
+	order, err := s.Orders().Get(ctx, "12345678903")
+	assertErrorIs(t, err, storage.ErrNotFound)
+	if order != nil {
+		t.Errorf("expected nil order, got %+v", order)
+	}
+
+	mustCreateOrder(t, s, "12345678903", userID)
+	mustCreateOrder(t, s, "79927398713", userID)
+	mustCreateOrder(t, s, "4561261212345467", otherID)
+
+	_, err = s.Orders().Create(ctx, model.Order{ID: "12345678903", UserID: otherID, Status: "NEW"})
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	orders, err := s.Orders().GetByUserID(ctx, userID)
+	assertNoError(t, err)
+	if len(orders) != 2 || orders[0].ID != "12345678903" || orders[1].ID != "79927398713" {
+		t.Fatalf("unexpected user's orders: %+v", orders)
+	}
+
+	if _, err = time.Parse(model.LayoutTimestamps, orders[0].UploadedAt); err != nil {
+		t.Errorf("bad uploaded_at format: %v", err)
+	}
+
+	accrual := points(t, "729.98")
+	_, err = s.Orders().SetProcessedStatus(ctx, "79927398713", "PROCESSED", accrual)
+	assertNoError(t, err)
+
+	order, err = s.Orders().Get(ctx, "79927398713")
+	assertNoError(t, err)
+	if order.Status != "PROCESSED" || order.Accrual != accrual || order.ProcessedAt == "" {
+		t.Errorf("unexpected processed order: %+v", order)
+	}
+
+	orders, err = s.Orders().GetByStatus(ctx, "NEW")
+	assertNoError(t, err)
+	if len(orders) != 2 {
+		t.Errorf("expected 2 new orders, got %+v", orders)
+	}
+
+	count, err := s.Orders().CountByUserID(ctx, userID, "NEW")
+	assertNoError(t, err)
+	if count != 1 {
+		t.Errorf("expected 1 new user's order, got %d", count)
+	}
+
+	last, err := s.Orders().LastOrderNumber(ctx)
+	assertNoError(t, err)
+	if last != "4561261212345467" {
+		t.Errorf("unexpected last order number: %s", last)
+	}
+}
+
+func testBalanceAccrue(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateOrder(t, s, "12345678903", userID)
+	mustCreateOrder(t, s, "79927398713", userID)
+
+	_, err := s.Balance().Get(ctx, userID)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.Balance().Accrue(ctx, "12345678903", points(t, "0.1"), userID)
+	assertNoError(t, err)
+
+	balance, err := s.Balance().Accrue(ctx, "79927398713", points(t, "0.2"), userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "0.3") {
+		t.Errorf("expected balance 0.3, got %s", balance.Balance)
+	}
+
+	// the same order can't be credited twice
+	_, err = s.Balance().Accrue(ctx, "79927398713", points(t, "0.2"), userID)
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	balance, err = s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "0.3") || balance.TotalWithdrawn != 0 {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	entries, err := s.Balance().Statement(ctx, userID)
+	assertNoError(t, err)
+	if len(entries) != 2 || entries[0].Kind != model.LedgerAccrual || entries[0].Order != "12345678903" {
+		t.Errorf("unexpected statement: %+v", entries)
+	}
+}
+
+func testBalanceWithdraw(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+
+	err := s.Balance().Withdraw(ctx, points(t, "1"), userID, "2377225624")
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	_, err = s.Balance().Add(ctx, points(t, "100"), userID)
+	assertNoError(t, err)
+
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "99.9999"), userID, "2377225624"))
+
+	err = s.Balance().Withdraw(ctx, points(t, "0.0002"), userID, "2377225624")
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "0.0001") || balance.TotalWithdrawn != points(t, "99.9999") {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	history, err := s.Balance().Withdrawals(ctx, userID)
+	assertNoError(t, err)
+	if len(history) != 1 || history[0].Value != points(t, "99.9999") || history[0].Order != "2377225624" {
+		t.Fatalf("unexpected withdrawals: %+v", history)
+	}
+
+	entries, err := s.Balance().Statement(ctx, userID)
+	assertNoError(t, err)
+
+	var sum model.Points
+	for _, e := range entries {
+		sum += e.Amount
+	}
+
+	if sum != balance.Balance {
+		t.Errorf("statement sum %s doesn't match balance %s", sum, balance.Balance)
+	}
+
+	last := entries[len(entries)-1]
+	if last.Kind != model.LedgerWithdrawal || last.WithdrawalID == nil || *last.WithdrawalID != history[0].ID {
+		t.Errorf("unexpected withdrawal ledger entry: %+v", last)
+	}
+}
+
+func testBalanceReconcile(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+
+	_, err := s.Balance().Reconcile(ctx, userID)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.Balance().Add(ctx, points(t, "5"), userID)
+	assertNoError(t, err)
+
+	drift, err := s.Balance().Reconcile(ctx, userID)
+	assertNoError(t, err)
+	if drift != 0 {
+		t.Errorf("expected no drift, got %s", drift)
+	}
+}
+
+func testBalanceAdjustments(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	operatorID := mustCreateUser(t, s, "operator")
+	approverID := mustCreateUser(t, s, "approver")
+
+	_, err := s.Adjustments().Create(ctx, model.Adjustment{
+		UserID:     userID,
+		Amount:     points(t, "-1"),
+		Reason:     model.ReasonCorrection,
+		OperatorID: operatorID,
+	})
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	applied, err := s.Adjustments().Create(ctx, model.Adjustment{
+		UserID:     userID,
+		Amount:     points(t, "10"),
+		Reason:     model.ReasonGoodwill,
+		Comment:    "sorry for the delay",
+		OperatorID: operatorID,
+	})
+	assertNoError(t, err)
+	if applied.Status != model.AdjustmentApplied || applied.DecidedAt == "" || applied.OperatorID != operatorID {
+		t.Errorf("unexpected applied adjustment: %+v", applied)
+	}
+
+	pending, err := s.Adjustments().Create(ctx, model.Adjustment{
+		UserID:     userID,
+		Amount:     points(t, "-4"),
+		Reason:     model.ReasonFraud,
+		OperatorID: operatorID,
+		Status:     model.AdjustmentPending,
+	})
+	assertNoError(t, err)
+	if pending.Status != model.AdjustmentPending || pending.DecidedAt != "" {
+		t.Errorf("unexpected pending adjustment: %+v", pending)
+	}
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "10") {
+		t.Errorf("pending adjustment must not change balance, got %s", balance.Balance)
+	}
+
+	list, err := s.Adjustments().List(ctx, model.AdjustmentsFilter{Status: model.AdjustmentPending})
+	assertNoError(t, err)
+	if len(list) != 1 || list[0].ID != pending.ID {
+		t.Errorf("unexpected pending adjustments: %+v", list)
+	}
+
+	decided, err := s.Adjustments().Decide(ctx, pending.ID, approverID, true)
+	assertNoError(t, err)
+	if decided.Status != model.AdjustmentApplied || decided.ApproverID != approverID {
+		t.Errorf("unexpected approved adjustment: %+v", decided)
+	}
+
+	_, err = s.Adjustments().Decide(ctx, pending.ID, approverID, false)
+	assertErrorIs(t, err, storage.ErrStateConflict)
+
+	_, err = s.Adjustments().Decide(ctx, uuid.New(), approverID, true)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	// rejected one is kept, but doesn't affect balance
+	rejected, err := s.Adjustments().Create(ctx, model.Adjustment{
+		UserID:     userID,
+		Amount:     points(t, "100"),
+		Reason:     model.ReasonOther,
+		OperatorID: operatorID,
+		Status:     model.AdjustmentPending,
+	})
+	assertNoError(t, err)
+
+	rejected, err = s.Adjustments().Decide(ctx, rejected.ID, approverID, false)
+	assertNoError(t, err)
+	if rejected.Status != model.AdjustmentRejected {
+		t.Errorf("unexpected rejected adjustment: %+v", rejected)
+	}
+
+	list, err = s.Adjustments().List(ctx, model.AdjustmentsFilter{UserID: userID, Limit: 2})
+	assertNoError(t, err)
+	if len(list) != 2 || list[0].ID != rejected.ID || list[1].ID != pending.ID {
+		t.Errorf("unexpected user's adjustments: %+v", list)
+	}
+
+	balance, err = s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "6") || balance.TotalWithdrawn != 0 {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	history, err := s.Balance().History(ctx, userID)
+	assertNoError(t, err)
+	if len(history) != 2 ||
+		history[0].Kind != model.LedgerAdjustment || history[0].Value != points(t, "-10") || history[0].Reason != model.ReasonGoodwill ||
+		history[1].ID != pending.ID || history[1].Value != points(t, "4") {
+		t.Errorf("unexpected history: %+v", history)
+	}
+
+	withdrawals, err := s.Balance().Withdrawals(ctx, userID)
+	assertNoError(t, err)
+	if len(withdrawals) != 0 {
+		t.Errorf("adjustments must not be listed as withdrawals: %+v", withdrawals)
+	}
+
+	entries, err := s.Balance().Statement(ctx, userID)
+	assertNoError(t, err)
+	if len(entries) != 2 || entries[0].AdjustmentID == nil || *entries[0].AdjustmentID != applied.ID ||
+		entries[0].Comment != "sorry for the delay" {
+		t.Errorf("unexpected statement: %+v", entries)
+	}
+}
+
+func testBalanceTransfers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	fromID := mustCreateUser(t, s, "gopher")
+	toID := mustCreateUser(t, s, "gopher-jr")
+
+	limits := model.TransferLimits{Amount: points(t, "50"), Count: 2}
+	transfer := model.Transfer{
+		FromUserID: fromID,
+		ToUserID:   toID,
+		Amount:     points(t, "30"),
+		Comment:    "for lunch",
+	}
+
+	_, err := s.Transfers().Create(ctx, transfer, limits)
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	_, err = s.Balance().Add(ctx, points(t, "100"), fromID)
+	assertNoError(t, err)
+
+	saved, err := s.Transfers().Create(ctx, transfer, limits)
+	assertNoError(t, err)
+	if saved.ID == uuid.Nil || saved.Amount != transfer.Amount || saved.CreatedAt == "" {
+		t.Errorf("unexpected transfer: %+v", saved)
+	}
+
+	// 30 + 30 exceeds daily amount
+	_, err = s.Transfers().Create(ctx, transfer, limits)
+	assertErrorIs(t, err, storage.ErrLimitExceeded)
+
+	transfer.Amount = points(t, "20")
+	_, err = s.Transfers().Create(ctx, transfer, limits)
+	assertNoError(t, err)
+
+	// count is exceeded as well
+	transfer.Amount = points(t, "0.0001")
+	_, err = s.Transfers().Create(ctx, transfer, limits)
+	assertErrorIs(t, err, storage.ErrLimitExceeded)
+
+	for userID, expected := range map[int64]model.Points{fromID: points(t, "50"), toID: points(t, "50")} {
+		balance, err := s.Balance().Get(ctx, userID)
+		assertNoError(t, err)
+		if balance.Balance != expected || balance.TotalWithdrawn != 0 {
+			t.Errorf("unexpected balance of user %d: %+v", userID, balance)
+		}
+	}
+
+	sent, err := s.Balance().History(ctx, fromID)
+	assertNoError(t, err)
+	if len(sent) != 2 || sent[0].Kind != model.LedgerTransfer || sent[0].ID != saved.ID ||
+		sent[0].Value != points(t, "30") || sent[0].Counterparty != "gopher-jr" || sent[0].Comment != "for lunch" {
+		t.Fatalf("unexpected sender's history: %+v", sent)
+	}
+
+	received, err := s.Balance().History(ctx, toID)
+	assertNoError(t, err)
+	if len(received) != 2 || received[0].ID != saved.ID || received[0].Value != -points(t, "30") || received[0].Counterparty != "gopher" {
+		t.Fatalf("unexpected recipient's history: %+v", received)
+	}
+
+	withdrawals, err := s.Balance().Withdrawals(ctx, fromID)
+	assertNoError(t, err)
+	if len(withdrawals) != 0 {
+		t.Errorf("transfers must not be listed as withdrawals: %+v", withdrawals)
+	}
+
+	entries, err := s.Balance().Statement(ctx, toID)
+	assertNoError(t, err)
+	if len(entries) != 2 || entries[0].Kind != model.LedgerTransfer || entries[0].TransferID == nil || *entries[0].TransferID != saved.ID {
+		t.Errorf("unexpected recipient's statement: %+v", entries)
+	}
+
+	// the recipient keeps the transfer after sender's account deletion
+	assertNoError(t, s.Users().Delete(ctx, fromID))
+
+	received, err = s.Balance().History(ctx, toID)
+	assertNoError(t, err)
+	if len(received) != 2 || received[0].Counterparty != "" {
+		t.Fatalf("unexpected recipient's history after sender deletion: %+v", received)
+	}
+
+	balance, err := s.Balance().Get(ctx, toID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "50") {
+		t.Errorf("unexpected recipient's balance after sender deletion: %+v", balance)
+	}
+}
+
+func testWithdrawalHolds(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	otherID := mustCreateUser(t, s, "gopher-jr")
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, err := s.Holds().Create(ctx, points(t, "1"), userID, "2377225624", expiresAt)
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	_, err = s.Balance().Add(ctx, points(t, "100"), userID)
+	assertNoError(t, err)
+
+	held, err := s.Holds().Create(ctx, points(t, "60"), userID, "2377225624", expiresAt)
+	assertNoError(t, err)
+	if held.Status != model.WithdrawalHeld || held.Value != points(t, "60") || held.ExpiresAt == "" {
+		t.Fatalf("unexpected hold: %+v", held)
+	}
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "100") || balance.Held != points(t, "60") || balance.Available != points(t, "40") {
+		t.Errorf("unexpected balance with hold: %+v", balance)
+	}
+
+	// held points can't be spent otherwise
+	err = s.Balance().Withdraw(ctx, points(t, "41"), userID, "2377225624")
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	_, err = s.Holds().Create(ctx, points(t, "41"), userID, "2377225624", expiresAt)
+	assertErrorIs(t, err, storage.ErrNegativeBalance)
+
+	_, err = s.Holds().Capture(ctx, held.ID, otherID, 0)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.Holds().Capture(ctx, held.ID, userID, points(t, "61"))
+	assertErrorIs(t, err, storage.ErrCheckViolation)
+
+	captured, err := s.Holds().Capture(ctx, held.ID, userID, points(t, "50"))
+	assertNoError(t, err)
+	if captured.Status != model.WithdrawalCaptured || captured.Value != points(t, "50") {
+		t.Errorf("unexpected captured withdrawal: %+v", captured)
+	}
+
+	_, err = s.Holds().Void(ctx, held.ID, userID)
+	assertErrorIs(t, err, storage.ErrStateConflict)
+
+	balance, err = s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "50") || balance.Held != 0 || balance.TotalWithdrawn != points(t, "50") {
+		t.Errorf("unexpected balance after capture: %+v", balance)
+	}
+
+	voided, err := s.Holds().Create(ctx, points(t, "10"), userID, "2377225624", expiresAt)
+	assertNoError(t, err)
+
+	voided, err = s.Holds().Void(ctx, voided.ID, userID)
+	assertNoError(t, err)
+	if voided.Status != model.WithdrawalVoided {
+		t.Errorf("unexpected voided withdrawal: %+v", voided)
+	}
+
+	expired, err := s.Holds().Create(ctx, points(t, "20"), userID, "2377225624", time.Now().Add(-time.Second))
+	assertNoError(t, err)
+
+	_, err = s.Holds().Capture(ctx, expired.ID, userID, 0)
+	assertErrorIs(t, err, storage.ErrStateConflict)
+
+	ids, err := s.Holds().Expired(ctx, time.Now(), 10)
+	assertNoError(t, err)
+	if len(ids) != 1 || ids[0] != expired.ID {
+		t.Fatalf("unexpected expired holds: %v", ids)
+	}
+
+	assertNoError(t, s.Holds().Expire(ctx, expired.ID, time.Now()))
+	assertErrorIs(t, s.Holds().Expire(ctx, expired.ID, time.Now()), storage.ErrStateConflict)
+
+	balance, err = s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "50") || balance.Held != 0 || balance.Available != points(t, "50") {
+		t.Errorf("unexpected balance after release: %+v", balance)
+	}
+
+	// voided and expired withdrawals aren't shown
+	history, err := s.Balance().Withdrawals(ctx, userID)
+	assertNoError(t, err)
+	if len(history) != 1 || history[0].ID != held.ID || history[0].Status != model.WithdrawalCaptured {
+		t.Errorf("unexpected withdrawals: %+v", history)
+	}
+}
+
+func testWithdrawalReversals(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	operatorID := mustCreateUser(t, s, "support")
+
+	_, err := s.Balance().Add(ctx, points(t, "100"), userID)
+	assertNoError(t, err)
+
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "80"), userID, "2377225624"))
+
+	history, err := s.Balance().Withdrawals(ctx, userID)
+	assertNoError(t, err)
+	wdID := history[0].ID
+
+	_, err = s.Reversals().Create(ctx, model.Reversal{WithdrawalID: uuid.New(), OperatorID: operatorID})
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.Reversals().Create(ctx, model.Reversal{WithdrawalID: wdID, Amount: points(t, "80.0001"), OperatorID: operatorID})
+	assertErrorIs(t, err, storage.ErrCheckViolation)
+
+	rev, err := s.Reversals().Create(ctx, model.Reversal{
+		WithdrawalID: wdID,
+		Amount:       points(t, "30"),
+		Comment:      "item returned",
+		OperatorID:   operatorID,
+	})
+	assertNoError(t, err)
+	if rev.ID == uuid.Nil || rev.UserID != userID || rev.Amount != points(t, "30") || rev.CreatedAt == "" {
+		t.Errorf("unexpected reversal: %+v", rev)
+	}
+
+	// zero amount reverses the rest
+	rest, err := s.Reversals().Create(ctx, model.Reversal{WithdrawalID: wdID, OperatorID: operatorID})
+	assertNoError(t, err)
+	if rest.Amount != points(t, "50") {
+		t.Errorf("unexpected reversal of the rest: %+v", rest)
+	}
+
+	_, err = s.Reversals().Create(ctx, model.Reversal{WithdrawalID: wdID, Amount: points(t, "1"), OperatorID: operatorID})
+	assertErrorIs(t, err, storage.ErrStateConflict)
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "100") || balance.TotalWithdrawn != 0 {
+		t.Errorf("unexpected balance after reversals: %+v", balance)
+	}
+
+	history, err = s.Balance().Withdrawals(ctx, userID)
+	assertNoError(t, err)
+	if len(history) != 1 || history[0].Reversed != points(t, "80") || len(history[0].Reversals) != 2 {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	linked := history[0].Reversals[0]
+	if linked.ID != rev.ID || linked.WithdrawalID != wdID || linked.Order != "2377225624" ||
+		linked.Value != points(t, "30") || linked.ProcessedAt == "" {
+		t.Errorf("unexpected linked reversal: %+v", linked)
+	}
+
+	if history[0].Reversals[1].ID != rest.ID {
+		t.Errorf("expected reversals to be listed earliest first: %+v", history[0].Reversals)
+	}
+
+	entries, err := s.Balance().History(ctx, userID)
+	assertNoError(t, err)
+	if len(entries) != 3 || entries[1].Kind != model.LedgerReversal || entries[1].ID != rev.ID ||
+		entries[1].Value != -points(t, "30") || entries[1].WithdrawalID == nil || *entries[1].WithdrawalID != wdID {
+		t.Errorf("unexpected reversal in full history: %+v", entries)
+	}
+
+	// held withdrawal can't be reversed
+	held, err := s.Holds().Create(ctx, points(t, "10"), userID, "2377225624", time.Now().Add(time.Hour))
+	assertNoError(t, err)
+
+	_, err = s.Reversals().Create(ctx, model.Reversal{WithdrawalID: held.ID, OperatorID: operatorID})
+	assertErrorIs(t, err, storage.ErrStateConflict)
+}
+
+func testOrderCancellations(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	operatorID := mustCreateUser(t, s, "support")
+	mustCreateOrder(t, s, "12345678903", userID)
+
+	_, err := s.Cancellations().Create(ctx, model.Cancellation{Order: "79927398713", OperatorID: operatorID}, model.ClawbackDebt)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	// accrual wasn't credited yet
+	_, err = s.Cancellations().Create(ctx, model.Cancellation{Order: "12345678903", OperatorID: operatorID}, model.ClawbackDebt)
+	assertErrorIs(t, err, storage.ErrStateConflict)
+
+	_, err = s.Orders().SetProcessedStatus(ctx, "12345678903", model.OrderProcessed, points(t, "100"))
+	assertNoError(t, err)
+	_, err = s.Balance().Accrue(ctx, "12345678903", points(t, "100"), userID)
+	assertNoError(t, err)
+	assertNoError(t, s.Balance().AccrueBonus(ctx, "12345678903", userID, model.OrderBonus{
+		Source: model.BonusTier,
+		Ref:    "silver",
+		Amount: points(t, "10"),
+	}))
+
+	// some points are already spent, some are held
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "70"), userID, "2377225624"))
+	_, err = s.Holds().Create(ctx, points(t, "10"), userID, "2377225624", time.Now().Add(time.Hour))
+	assertNoError(t, err)
+
+	c, err := s.Cancellations().Create(ctx, model.Cancellation{
+		Order:      "12345678903",
+		Comment:    "order refunded",
+		OperatorID: operatorID,
+	}, model.ClawbackDebt)
+	assertNoError(t, err)
+	if c.ID == uuid.Nil || c.UserID != userID || c.Owed != points(t, "110") || c.Clawed != points(t, "30") ||
+		c.Debt != points(t, "80") || c.CreatedAt == "" {
+		t.Errorf("unexpected cancellation: %+v", c)
+	}
+
+	_, err = s.Cancellations().Create(ctx, model.Cancellation{Order: "12345678903", OperatorID: operatorID}, model.ClawbackDebt)
+	assertErrorIs(t, err, storage.ErrStateConflict)
+
+	order, err := s.Orders().Get(ctx, "12345678903")
+	assertNoError(t, err)
+	if order.Status != model.OrderCancelled {
+		t.Errorf("expected order to be cancelled, got status %q", order.Status)
+	}
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "10") || balance.Held != points(t, "10") || balance.Debt != points(t, "80") {
+		t.Errorf("unexpected balance after cancellation: %+v", balance)
+	}
+
+	// adjustments don't pay debt off
+	_, err = s.Balance().Add(ctx, points(t, "50"), userID)
+	assertNoError(t, err)
+
+	balance, err = s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "60") || balance.Debt != points(t, "80") {
+		t.Errorf("unexpected balance after adjustment: %+v", balance)
+	}
+
+	// debt is paid off by the next accrual
+	mustCreateOrder(t, s, "4561261212345467", userID)
+	_, err = s.Balance().Accrue(ctx, "4561261212345467", points(t, "50"), userID)
+	assertNoError(t, err)
+
+	balance, err = s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "60") || balance.Debt != points(t, "30") {
+		t.Errorf("unexpected balance after debt payment: %+v", balance)
+	}
+
+	drift, err := s.Balance().Reconcile(ctx, userID)
+	assertNoError(t, err)
+	if drift != 0 {
+		t.Errorf("expected counter to match the ledger, got drift %s", drift)
+	}
+
+	withdrawals, err := s.Balance().Withdrawals(ctx, userID)
+	assertNoError(t, err)
+	for _, wd := range withdrawals {
+		if wd.Order != "2377225624" {
+			t.Errorf("clawbacks must not be listed as withdrawals: %+v", withdrawals)
+		}
+	}
+
+	history, err := s.Balance().History(ctx, userID)
+	assertNoError(t, err)
+
+	var found bool
+	for _, entry := range history {
+		if entry.Kind == model.LedgerClawback {
+			found = entry.ID == c.ID && entry.Order == "12345678903" && entry.Value == points(t, "110") && entry.Comment == "order refunded"
+		}
+	}
+	if !found {
+		t.Errorf("cancellation is missing from history: %+v", history)
+	}
+
+	// the rest is written off by partial policy
+	otherID := mustCreateUser(t, s, "other")
+	mustCreateOrder(t, s, "79927398713", otherID)
+
+	_, err = s.Orders().SetProcessedStatus(ctx, "79927398713", model.OrderProcessed, points(t, "20"))
+	assertNoError(t, err)
+	_, err = s.Balance().Accrue(ctx, "79927398713", points(t, "20"), otherID)
+	assertNoError(t, err)
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "15"), otherID, "2377225624"))
+
+	c, err = s.Cancellations().Create(ctx, model.Cancellation{Order: "79927398713", OperatorID: operatorID}, model.ClawbackPartial)
+	assertNoError(t, err)
+	if c.Owed != points(t, "20") || c.Clawed != points(t, "5") || c.Debt != 0 {
+		t.Errorf("unexpected partial cancellation: %+v", c)
+	}
+
+	balance, err = s.Balance().Get(ctx, otherID)
+	assertNoError(t, err)
+	if balance.Balance != 0 || balance.Debt != 0 {
+		t.Errorf("unexpected balance after partial cancellation: %+v", balance)
+	}
+}
+
+func testOrderCancellationRewards(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	referrerID := mustCreateUser(t, s, "referrer")
+	refereeID := mustCreateUser(t, s, "referee")
+	operatorID := mustCreateUser(t, s, "support")
+	mustCreateOrder(t, s, "12345678903", refereeID)
+
+	now := time.Now()
+	campaignID, err := s.Campaigns().Create(ctx, model.Campaign{
+		Name:     "welcome",
+		StartsAt: now.Add(-time.Hour),
+		EndsAt:   now.Add(time.Hour),
+		Points:   points(t, "30"),
+		Budget:   points(t, "100"),
+	})
+	assertNoError(t, err)
+
+	code := model.ReferralCode{Code: "ABCD2345", UserID: referrerID}
+	assertNoError(t, s.Referrals().CreateCode(ctx, code))
+	assertNoError(t, s.Referrals().Create(ctx, model.Referral{
+		RefereeID:  refereeID,
+		ReferrerID: referrerID,
+		Code:       code.Code,
+		Status:     model.ReferralPending,
+	}))
+
+	_, err = s.Orders().SetProcessedStatus(ctx, "12345678903", model.OrderProcessed, points(t, "100"))
+	assertNoError(t, err)
+	_, err = s.Balance().Accrue(ctx, "12345678903", points(t, "100"), refereeID)
+	assertNoError(t, err)
+
+	granted, err := s.Campaigns().Spend(ctx, campaignID, points(t, "30"))
+	assertNoError(t, err)
+	assertNoError(t, s.Balance().AccrueBonus(ctx, "12345678903", refereeID, model.OrderBonus{
+		Source: model.BonusCampaign,
+		Ref:    strconv.FormatInt(campaignID, 10),
+		Amount: granted,
+	}))
+
+	_, err = s.Referrals().Reward(ctx, refereeID, "12345678903", model.ReferralRewards{
+		Referrer: points(t, "100"),
+		Referee:  points(t, "50"),
+	}, 0)
+	assertNoError(t, err)
+
+	// referrer has already spent some of the reward
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "60"), referrerID, "2377225624"))
+
+	c, err := s.Cancellations().Create(ctx, model.Cancellation{Order: "12345678903", OperatorID: operatorID}, model.ClawbackDebt)
+	assertNoError(t, err)
+	if c.Owed != points(t, "180") || c.Clawed != points(t, "180") || c.Debt != 0 {
+		t.Errorf("unexpected cancellation: %+v", c)
+	}
+
+	balance, err := s.Balance().Get(ctx, referrerID)
+	assertNoError(t, err)
+	if balance.Balance != 0 || balance.Debt != points(t, "60") {
+		t.Errorf("unexpected referrer's balance after cancellation: %+v", balance)
+	}
+
+	referrals, err := s.Referrals().List(ctx, referrerID)
+	assertNoError(t, err)
+	if len(referrals) != 1 || referrals[0].Status != model.ReferralCancelled {
+		t.Errorf("expected referral to be cancelled, got %+v", referrals)
+	}
+
+	// cancelled referral still counts toward the cap, otherwise cancelling
+	// orders would let referrer be rewarded over it
+	nextID := mustCreateUser(t, s, "next")
+	mustCreateOrder(t, s, "4561261212345467", nextID)
+	assertNoError(t, s.Referrals().Create(ctx, model.Referral{
+		RefereeID:  nextID,
+		ReferrerID: referrerID,
+		Code:       code.Code,
+		Status:     model.ReferralPending,
+	}))
+
+	referral, err := s.Referrals().Reward(ctx, nextID, "4561261212345467", model.ReferralRewards{
+		Referrer: points(t, "100"),
+	}, 1)
+	assertNoError(t, err)
+	if referral.Status != model.ReferralCapped {
+		t.Errorf("expected referral to be capped, got %+v", referral)
+	}
+
+	campaign, err := s.Campaigns().Get(ctx, campaignID)
+	assertNoError(t, err)
+	if campaign.Spent != 0 {
+		t.Errorf("expected campaign's bonus to return to the budget, got spent %s", campaign.Spent)
+	}
+
+	for _, id := range []int64{referrerID, refereeID} {
+		drift, err := s.Balance().Reconcile(ctx, id)
+		assertNoError(t, err)
+		if drift != 0 {
+			t.Errorf("expected user %d counter to match the ledger, got drift %s", id, drift)
+		}
+	}
+}
+
+func testPointsLots(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateOrder(t, s, "12345678903", userID)
+
+	_, err := s.Balance().Add(ctx, points(t, "10"), userID)
+	assertNoError(t, err)
+	_, err = s.Balance().Accrue(ctx, "12345678903", points(t, "5"), userID)
+	assertNoError(t, err)
+
+	// the oldest lot is consumed first
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "12"), userID, "2377225624"))
+
+	_, err = s.Balance().Add(ctx, points(t, "4"), userID)
+	assertNoError(t, err)
+
+	lots, err := s.Lots().List(ctx, userID)
+	assertNoError(t, err)
+	if len(lots) != 2 || lots[0].Amount != points(t, "5") || lots[0].Remaining != points(t, "3") ||
+		lots[1].Remaining != points(t, "4") {
+		t.Fatalf("unexpected lots: %+v", lots)
+	}
+
+	users, err := s.Lots().UsersWithExpired(ctx, 1, time.Now(), 10)
+	assertNoError(t, err)
+	if len(users) != 0 {
+		t.Errorf("expected no expired lots yet, got users %v", users)
+	}
+
+	// only the first lot is old enough by then
+	now := lots[0].ExpiresAt(1)
+
+	users, err = s.Lots().UsersWithExpired(ctx, 1, now, 10)
+	assertNoError(t, err)
+	if len(users) != 1 || users[0] != userID {
+		t.Errorf("unexpected users with expired lots: %v", users)
+	}
+
+	expired, err := s.Lots().Expire(ctx, userID, 1, now)
+	assertNoError(t, err)
+	if expired != points(t, "3") {
+		t.Errorf("expected 3 points to expire, got %s", expired)
+	}
+
+	expired, err = s.Lots().Expire(ctx, userID, 1, now)
+	assertNoError(t, err)
+	if expired != 0 {
+		t.Errorf("expected nothing to expire again, got %s", expired)
+	}
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "4") || balance.TotalWithdrawn != points(t, "12") {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	lots, err = s.Lots().List(ctx, userID)
+	assertNoError(t, err)
+	if len(lots) != 1 || lots[0].Remaining != points(t, "4") {
+		t.Errorf("unexpected lots after expiry: %+v", lots)
+	}
+
+	entries, err := s.Balance().Statement(ctx, userID)
+	assertNoError(t, err)
+	if last := entries[len(entries)-1]; last.Kind != model.LedgerExpiry || last.Amount != points(t, "-3") {
+		t.Errorf("unexpected expiry ledger entry: %+v", last)
+	}
+}
+
+func testPointsExpiryWithHold(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	otherID := mustCreateUser(t, s, "other")
+
+	for _, id := range []int64{userID, otherID} {
+		_, err := s.Balance().Add(ctx, points(t, "100"), id)
+		assertNoError(t, err)
+	}
+
+	held, err := s.Holds().Create(ctx, points(t, "80"), userID, "2377225624", time.Now().Add(time.Hour))
+	assertNoError(t, err)
+
+	lots, err := s.Lots().List(ctx, userID)
+	assertNoError(t, err)
+	now := lots[0].ExpiresAt(1).Add(time.Minute)
+
+	// held points don't expire until released
+	expired, err := s.Lots().Expire(ctx, userID, 1, now)
+	assertNoError(t, err)
+	if expired != points(t, "20") {
+		t.Errorf("expected 20 points to expire, got %s", expired)
+	}
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "80") || balance.Held != points(t, "80") || balance.Available != 0 {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	// user whose points are all held isn't picked again, so others aren't
+	// starved of expiry
+	users, err := s.Lots().UsersWithExpired(ctx, 1, now, 1)
+	assertNoError(t, err)
+	if len(users) != 1 || users[0] != otherID {
+		t.Errorf("expected only the other user to be picked, got %v", users)
+	}
+
+	// released points expire on the next run
+	_, err = s.Holds().Void(ctx, held.ID, userID)
+	assertNoError(t, err)
+
+	users, err = s.Lots().UsersWithExpired(ctx, 1, now, 10)
+	assertNoError(t, err)
+	if len(users) != 2 || users[0] != userID {
+		t.Errorf("expected user to be picked after release, got %v", users)
+	}
+
+	expired, err = s.Lots().Expire(ctx, userID, 1, now)
+	assertNoError(t, err)
+	if expired != points(t, "80") {
+		t.Errorf("expected released 80 points to expire, got %s", expired)
+	}
+
+	lots, err = s.Lots().List(ctx, userID)
+	assertNoError(t, err)
+	if len(lots) != 0 {
+		t.Errorf("expected all lots to be expired, got %+v", lots)
+	}
+}
+
+func testOrderBonuses(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateOrder(t, s, "12345678903", userID)
+	mustCreateOrder(t, s, "79927398713", userID)
+
+	since := time.Now().Add(-time.Minute)
+
+	_, err := s.Balance().Accrue(ctx, "12345678903", points(t, "100"), userID)
+	assertNoError(t, err)
+
+	bonus := model.OrderBonus{Source: model.BonusTier, Ref: "gold", Amount: points(t, "10")}
+	assertNoError(t, s.Balance().AccrueBonus(ctx, "12345678903", userID, bonus))
+
+	err = s.Balance().AccrueBonus(ctx, "12345678903", userID, bonus)
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	assertNoError(t, s.Balance().Withdraw(ctx, points(t, "30"), userID, "2377225624"))
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "80") {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	activity, err := s.Balance().Activity(ctx, userID, since)
+	assertNoError(t, err)
+	if activity.Accrued != points(t, "110") || activity.Spent != points(t, "30") {
+		t.Errorf("unexpected activity: %+v", activity)
+	}
+
+	activity, err = s.Balance().Activity(ctx, userID, time.Now().Add(time.Minute))
+	assertNoError(t, err)
+	if activity.Accrued != 0 || activity.Spent != 0 {
+		t.Errorf("expected no activity in the future, got %+v", activity)
+	}
+
+	order, err := s.Orders().Get(ctx, "12345678903")
+	assertNoError(t, err)
+	if len(order.Bonuses) != 1 || order.Bonuses[0] != bonus {
+		t.Errorf("unexpected order bonuses: %+v", order.Bonuses)
+	}
+
+	orders, err := s.Orders().GetByUserID(ctx, userID)
+	assertNoError(t, err)
+	if len(orders) != 2 || len(orders[0].Bonuses) != 1 || len(orders[1].Bonuses) != 0 {
+		t.Errorf("unexpected orders bonuses: %+v", orders)
+	}
+}
+
+func testCampaigns(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Second)
+
+	weekend := model.Campaign{
+		Name:       "double points",
+		StartsAt:   now.Add(-time.Hour),
+		EndsAt:     now.Add(time.Hour),
+		Multiplier: points(t, "2"),
+		Tiers:      []string{"silver", "gold"},
+		Budget:     points(t, "150"),
+	}
+	first := model.Campaign{
+		Name:       "first order",
+		StartsAt:   now.Add(-time.Hour),
+		EndsAt:     now.Add(time.Hour),
+		Points:     points(t, "100"),
+		FirstOrder: true,
+		Priority:   10,
+		Exclusive:  true,
+	}
+	future := model.Campaign{
+		Name:     "next week",
+		StartsAt: now.Add(time.Hour),
+		EndsAt:   now.Add(2 * time.Hour),
+		Points:   points(t, "1"),
+	}
+
+	var err error
+	for _, c := range []*model.Campaign{&weekend, &first, &future} {
+		c.ID, err = s.Campaigns().Create(ctx, *c)
+		assertNoError(t, err)
+	}
+
+	got, err := s.Campaigns().Get(ctx, weekend.ID)
+	assertNoError(t, err)
+	if got.Name != weekend.Name || !got.EndsAt.Equal(weekend.EndsAt) ||
+		got.Multiplier != weekend.Multiplier || !slices.Equal(got.Tiers, weekend.Tiers) {
+		t.Errorf("unexpected campaign: %+v", got)
+	}
+
+	_, err = s.Campaigns().Get(ctx, 100500)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	list, err := s.Campaigns().List(ctx)
+	assertNoError(t, err)
+	if len(list) != 3 {
+		t.Fatalf("expected 3 campaigns, got %d", len(list))
+	}
+
+	running, err := s.Campaigns().Running(ctx, now)
+	assertNoError(t, err)
+	if len(running) != 2 || running[0].ID != first.ID || running[1].ID != weekend.ID {
+		t.Fatalf("expected running campaigns ordered by priority, got %+v", running)
+	}
+
+	// budget is never overspent
+	granted, err := s.Campaigns().Spend(ctx, weekend.ID, points(t, "100"))
+	assertNoError(t, err)
+	if granted != points(t, "100") {
+		t.Errorf("expected whole amount granted, got %s", granted)
+	}
+
+	granted, err = s.Campaigns().Spend(ctx, weekend.ID, points(t, "100"))
+	assertNoError(t, err)
+	if granted != points(t, "50") {
+		t.Errorf("expected budget left granted, got %s", granted)
+	}
+
+	granted, err = s.Campaigns().Spend(ctx, weekend.ID, points(t, "100"))
+	assertNoError(t, err)
+	if granted != 0 {
+		t.Errorf("expected nothing granted from exhausted budget, got %s", granted)
+	}
+
+	granted, err = s.Campaigns().Spend(ctx, first.ID, points(t, "100500"))
+	assertNoError(t, err)
+	if granted != points(t, "100500") {
+		t.Errorf("expected unlimited budget, got %s", granted)
+	}
+
+	running, err = s.Campaigns().Running(ctx, now)
+	assertNoError(t, err)
+	if len(running) != 1 || running[0].ID != first.ID {
+		t.Errorf("expected campaign with exhausted budget to stop, got %+v", running)
+	}
+
+	// update keeps spent budget
+	weekend.Budget = points(t, "1000")
+	weekend.Tiers = nil
+	assertNoError(t, s.Campaigns().Update(ctx, weekend))
+
+	got, err = s.Campaigns().Get(ctx, weekend.ID)
+	assertNoError(t, err)
+	if got.Spent != points(t, "150") || got.Budget != points(t, "1000") || len(got.Tiers) != 0 {
+		t.Errorf("unexpected updated campaign: %+v", got)
+	}
+
+	first.Disabled = true
+	assertNoError(t, s.Campaigns().Update(ctx, first))
+
+	running, err = s.Campaigns().Running(ctx, now)
+	assertNoError(t, err)
+	if len(running) != 1 || running[0].ID != weekend.ID {
+		t.Errorf("expected disabled campaign to stop, got %+v", running)
+	}
+
+	err = s.Campaigns().Update(ctx, model.Campaign{ID: 100500, Name: "missing"})
+	assertErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testReferrals(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	referrerID := mustCreateUser(t, s, "referrer")
+	firstID := mustCreateUser(t, s, "first")
+	secondID := mustCreateUser(t, s, "second")
+	mustCreateOrder(t, s, "12345678903", firstID)
+	mustCreateOrder(t, s, "79927398713", secondID)
+
+	_, err := s.Referrals().CodeByUser(ctx, referrerID)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	code := model.ReferralCode{Code: "ABCD2345", UserID: referrerID, IP: "10.0.0.1"}
+	assertNoError(t, s.Referrals().CreateCode(ctx, code))
+
+	err = s.Referrals().CreateCode(ctx, model.ReferralCode{Code: "ABCD2345", UserID: firstID})
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	err = s.Referrals().CreateCode(ctx, model.ReferralCode{Code: "OTHER234", UserID: referrerID})
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	got, err := s.Referrals().CodeByUser(ctx, referrerID)
+	assertNoError(t, err)
+	if got != code {
+		t.Errorf("unexpected referral code: %+v", got)
+	}
+
+	got, err = s.Referrals().FindCode(ctx, "ABCD2345")
+	assertNoError(t, err)
+	if got != code {
+		t.Errorf("unexpected found referral code: %+v", got)
+	}
+
+	_, err = s.Referrals().FindCode(ctx, "MISSING2")
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	for _, id := range []int64{firstID, secondID} {
+		assertNoError(t, s.Referrals().Create(ctx, model.Referral{
+			RefereeID:  id,
+			ReferrerID: referrerID,
+			Code:       code.Code,
+			Status:     model.ReferralPending,
+			IP:         "10.0.0.2",
+		}))
+	}
+
+	err = s.Referrals().Create(ctx, model.Referral{RefereeID: firstID, ReferrerID: referrerID, Code: code.Code, Status: model.ReferralPending})
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	referrals, err := s.Referrals().List(ctx, referrerID)
+	assertNoError(t, err)
+	if len(referrals) != 2 || referrals[0].Referee != "second" || referrals[1].Referee != "first" ||
+		referrals[0].Status != model.ReferralPending || referrals[0].DecidedAt != "" {
+		t.Fatalf("unexpected referrals: %+v", referrals)
+	}
+
+	_, err = s.Referrals().Reward(ctx, referrerID, "12345678903", model.ReferralRewards{}, 0)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	rewards := model.ReferralRewards{Referrer: points(t, "100"), Referee: points(t, "50")}
+
+	referral, err := s.Referrals().Reward(ctx, firstID, "12345678903", rewards, 1)
+	assertNoError(t, err)
+	if referral.Status != model.ReferralRewarded || referral.Order != "12345678903" ||
+		referral.ReferrerReward != rewards.Referrer || referral.DecidedAt == "" {
+		t.Errorf("unexpected rewarded referral: %+v", referral)
+	}
+
+	// referral is completed only once
+	_, err = s.Referrals().Reward(ctx, firstID, "12345678903", rewards, 1)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	// referrer has reached the cap
+	referral, err = s.Referrals().Reward(ctx, secondID, "79927398713", rewards, 1)
+	assertNoError(t, err)
+	if referral.Status != model.ReferralCapped || referral.ReferrerReward != 0 {
+		t.Errorf("unexpected capped referral: %+v", referral)
+	}
+
+	for id, want := range map[int64]string{referrerID: "100", firstID: "50"} {
+		balance, err := s.Balance().Get(ctx, id)
+		assertNoError(t, err)
+		if balance.Balance != points(t, want) {
+			t.Errorf("unexpected user %d balance: %s", id, balance.Balance)
+		}
+	}
+
+	_, err = s.Balance().Get(ctx, secondID)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	order, err := s.Orders().Get(ctx, "12345678903")
+	assertNoError(t, err)
+	if len(order.Bonuses) != 1 || order.Bonuses[0].Source != model.BonusReferral || order.Bonuses[0].Amount != rewards.Referee {
+		t.Errorf("expected referee's reward among order bonuses, got %+v", order.Bonuses)
+	}
+
+	// referrals go away with referrer
+	assertNoError(t, s.Users().Delete(ctx, referrerID))
+
+	_, err = s.Referrals().FindCode(ctx, "ABCD2345")
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	referrals, err = s.Referrals().List(ctx, referrerID)
+	assertNoError(t, err)
+	if len(referrals) != 0 {
+		t.Errorf("expected referrals to be cleaned up, got %+v", referrals)
+	}
+}
+
+func testTxRollback(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateOrder(t, s, "12345678903", userID)
+
+	errAbort := errors.New("abort")
+
+	err := s.InTx(ctx, func(tx storage.Storage) error {
+		if _, err := tx.Orders().SetProcessedStatus(ctx, "12345678903", "PROCESSED", points(t, "10")); err != nil {
+			return err
+		}
+
+		if _, err := tx.Balance().Accrue(ctx, "12345678903", points(t, "10"), userID); err != nil {
+			return err
+		}
+
+		// nested call joins the transaction
+		return tx.InTx(ctx, func(tx storage.Storage) error {
+			return errAbort
+		})
+	})
+	assertErrorIs(t, err, errAbort)
+
+	order, err := s.Orders().Get(ctx, "12345678903")
+	assertNoError(t, err)
+	if order.Status != "NEW" {
+		t.Errorf("order status change wasn't rolled back: %+v", order)
+	}
+
+	_, err = s.Balance().Get(ctx, userID)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	// now commit
+	err = s.InTx(ctx, func(tx storage.Storage) error {
+		_, err := tx.Balance().Accrue(ctx, "12345678903", points(t, "10"), userID)
+		return err
+	})
+	assertNoError(t, err)
+
+	balance, err := s.Balance().Get(ctx, userID)
+	assertNoError(t, err)
+	if balance.Balance != points(t, "10") {
+		t.Errorf("unexpected balance after commit: %+v", balance)
+	}
+}
+
+func testAccrualJobs(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	const lease = time.Minute
+
+	userID := mustCreateUser(t, s, "gopher")
+	mustCreateOrder(t, s, "12345678903", userID)
+	mustCreateOrder(t, s, "79927398713", userID)
+
+	jobs := s.AccrualJobs()
+
+	assertNoError(t, jobs.Enqueue(ctx, "12345678903"))
+	assertNoError(t, jobs.Enqueue(ctx, "12345678903"))
+
+	count, err := jobs.EnqueueByStatus(ctx, "NEW")
+	assertNoError(t, err)
+	if count != 1 {
+		t.Errorf("expected 1 newly enqueued order, got %d", count)
+	}
+
+	claimed, err := jobs.Claim(ctx, "worker-1", 1, lease)
+	assertNoError(t, err)
+	if len(claimed) != 1 || claimed[0].Attempts != 1 || claimed[0].UserID != userID {
+		t.Fatalf("unexpected claimed jobs: %+v", claimed)
+	}
+
+	// leased job must be skipped by other workers
+	other, err := jobs.Claim(ctx, "worker-2", 10, lease)
+	assertNoError(t, err)
+	if len(other) != 1 || other[0].OrderID == claimed[0].OrderID {
+		t.Fatalf("unexpected jobs claimed by other worker: %+v", other)
+	}
+
+	// only lease owner can reschedule the job
+	assertNoError(t, jobs.Retry(ctx, claimed[0].OrderID, "worker-2", 0, "not mine"))
+	none, err := jobs.Claim(ctx, "worker-2", 10, lease)
+	assertNoError(t, err)
+	if len(none) != 0 {
+		t.Fatalf("expected nothing to claim, got %+v", none)
+	}
+
+	assertNoError(t, jobs.Heartbeat(ctx, "worker-1", lease))
+	assertNoError(t, jobs.Retry(ctx, claimed[0].OrderID, "worker-1", 0, "retriable"))
+
+	again, err := jobs.Claim(ctx, "worker-2", 10, lease)
+	assertNoError(t, err)
+	if len(again) != 1 || again[0].OrderID != claimed[0].OrderID || again[0].Attempts != 2 || again[0].LastError != "retriable" {
+		t.Fatalf("unexpected reclaimed job: %+v", again)
+	}
+
+	// released jobs can be claimed by others right away
+	released, err := jobs.Release(ctx, "worker-2")
+	assertNoError(t, err)
+	if len(released) != 2 {
+		t.Fatalf("expected 2 released jobs, got %v", released)
+	}
+
+	assertNoError(t, jobs.Complete(ctx, again[0].OrderID))
+
+	last, err := jobs.Claim(ctx, "worker-1", 10, lease)
+	assertNoError(t, err)
+	if len(last) != 1 || last[0].OrderID != other[0].OrderID {
+		t.Fatalf("unexpected jobs claimed after release: %+v", last)
+	}
+
+	assertNoError(t, jobs.Retry(ctx, other[0].OrderID, "worker-1", time.Hour, "later"))
+
+	none, err = jobs.Claim(ctx, "worker-1", 10, lease)
+	assertNoError(t, err)
+	if len(none) != 0 {
+		t.Fatalf("expected nothing to claim, got %+v", none)
+	}
+
+	// stuck jobs are due right away after reschedule, missing ones are queued
+	assertNoError(t, jobs.Reschedule(ctx, other[0].OrderID))
+	assertNoError(t, jobs.Reschedule(ctx, again[0].OrderID))
+
+	rescheduled, err := jobs.Claim(ctx, "worker-1", 10, lease)
+	assertNoError(t, err)
+	if len(rescheduled) != 2 {
+		t.Fatalf("expected 2 rescheduled jobs, got %+v", rescheduled)
+	}
+}
+
+func testSessions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID := mustCreateUser(t, s, "gopher")
+
+	session := model.Session{
+		ID:          uuid.New(),
+		UserID:      userID,
+		RefreshHash: "hash-1",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	assertNoError(t, s.Sessions().Create(ctx, session))
+
+	got, err := s.Sessions().Get(ctx, session.ID)
+	assertNoError(t, err)
+	if got.UserID != userID || got.RefreshHash != "hash-1" || !got.Active(time.Now()) {
+		t.Fatalf("unexpected session: %+v", got)
+	}
+
+	_, err = s.Sessions().Get(ctx, uuid.New())
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	// refresh token can be used only once
+	assertNoError(t, s.Sessions().Rotate(ctx, session.ID, "hash-1", "hash-2", time.Now().Add(time.Hour)))
+	err = s.Sessions().Rotate(ctx, session.ID, "hash-1", "hash-3", time.Now().Add(time.Hour))
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	other := session
+	other.ID = uuid.New()
+	assertNoError(t, s.Sessions().Create(ctx, other))
+
+	assertNoError(t, s.Sessions().Revoke(ctx, session.ID))
+	got, err = s.Sessions().Get(ctx, session.ID)
+	assertNoError(t, err)
+	if got.Active(time.Now()) {
+		t.Fatalf("session wasn't revoked: %+v", got)
+	}
+
+	err = s.Sessions().Rotate(ctx, session.ID, "hash-2", "hash-3", time.Now().Add(time.Hour))
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	third := session
+	third.ID = uuid.New()
+	assertNoError(t, s.Sessions().Create(ctx, third))
+
+	count, err := s.Sessions().RevokeOthers(ctx, userID, third.ID)
+	assertNoError(t, err)
+	if count != 1 {
+		t.Errorf("expected 1 revoked session, got %d", count)
+	}
+
+	got, err = s.Sessions().Get(ctx, third.ID)
+	assertNoError(t, err)
+	if !got.Active(time.Now()) {
+		t.Fatalf("kept session was revoked: %+v", got)
+	}
+
+	count, err = s.Sessions().RevokeAll(ctx, userID)
+	assertNoError(t, err)
+	if count != 1 {
+		t.Errorf("expected 1 revoked session, got %d", count)
+	}
+}
+
+func testSigningKeys(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+
+	keys := []model.SigningKey{
+		{ID: "old", Alg: model.AlgHS256, Sealed: []byte("a"), CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{ID: "prev", Alg: model.AlgRS256, Sealed: []byte("b"), CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: "last", Alg: model.AlgEdDSA, Sealed: []byte("c"), CreatedAt: now},
+	}
+	for _, key := range keys {
+		assertNoError(t, s.SigningKeys().Create(ctx, key))
+	}
+
+	err := s.SigningKeys().Create(ctx, keys[0])
+	assertErrorIs(t, err, storage.ErrDuplicateEntry)
+
+	// expired keys are not listed, newest key goes first
+	got, err := s.SigningKeys().List(ctx, now)
+	assertNoError(t, err)
+	if len(got) != 2 || got[0].ID != "last" || got[1].ID != "prev" {
+		t.Fatalf("unexpected keys: %+v", got)
+	}
+	if string(got[1].Sealed) != "b" || got[1].Alg != model.AlgRS256 || !got[0].ExpiresAt.IsZero() {
+		t.Errorf("unexpected key fields: %+v", got)
+	}
+
+	count, err := s.SigningKeys().DeleteExpired(ctx, now)
+	assertNoError(t, err)
+	if count != 1 {
+		t.Errorf("expected 1 deleted key, got %d", count)
+	}
+}
+
+func testLoginAttempts(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+
+	attempts := []model.LoginAttempt{
+		{Login: "Gopher", IP: "10.0.0.1", CreatedAt: now.Add(-time.Hour)},
+		{Login: "gopher", IP: "10.0.0.1", CreatedAt: now.Add(-time.Minute)},
+		{Login: "gopher", IP: "10.0.0.2", CreatedAt: now},
+		{Login: "other", IP: "10.0.0.1", CreatedAt: now},
+	}
+	for _, a := range attempts {
+		assertNoError(t, s.LoginAttempts().Add(ctx, a))
+	}
+
+	count, err := s.LoginAttempts().CountByLogin(ctx, "GOPHER", now.Add(-time.Minute))
+	assertNoError(t, err)
+	if count != 2 {
+		t.Errorf("expected 2 attempts by login, got %d", count)
+	}
+
+	count, err = s.LoginAttempts().CountByIP(ctx, "10.0.0.1", now.Add(-2*time.Hour))
+	assertNoError(t, err)
+	if count != 3 {
+		t.Errorf("expected 3 attempts by ip, got %d", count)
+	}
+
+	list, err := s.LoginAttempts().List(ctx, model.LoginAttemptsFilter{Login: "gopher", Limit: 2})
+	assertNoError(t, err)
+	if len(list) != 2 || list[0].IP != "10.0.0.2" || list[1].Login != "gopher" {
+		t.Fatalf("unexpected attempts: %+v", list)
+	}
+
+	_, err = s.LoginAttempts().Lockout(ctx, model.LoginLockoutKey("gopher"))
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	lockout := model.Lockout{
+		Key:         model.LoginLockoutKey("gopher"),
+		Level:       2,
+		LockedUntil: now.Add(time.Minute),
+	}
+	assertNoError(t, s.LoginAttempts().SetLockout(ctx, lockout))
+	assertNoError(t, s.LoginAttempts().SetLockout(ctx, model.Lockout{
+		Key:         model.IPLockoutKey("10.0.0.1"),
+		Level:       1,
+		LockedUntil: now.Add(-time.Minute),
+	}))
+
+	got, err := s.LoginAttempts().Lockout(ctx, lockout.Key)
+	assertNoError(t, err)
+	if got.Level != 2 || !got.Locked(now) || !got.ResetAt.IsZero() {
+		t.Errorf("unexpected lockout: %+v", got)
+	}
+
+	lockouts, err := s.LoginAttempts().Lockouts(ctx, now)
+	assertNoError(t, err)
+	if len(lockouts) != 1 || lockouts[0].Key != lockout.Key {
+		t.Errorf("unexpected active lockouts: %+v", lockouts)
+	}
+
+	count, err = s.LoginAttempts().Clear(ctx, model.LoginAttemptsFilter{Login: "gopher"})
+	assertNoError(t, err)
+	if count != 3 {
+		t.Errorf("expected 3 cleared attempts, got %d", count)
+	}
+
+	_, err = s.LoginAttempts().Lockout(ctx, lockout.Key)
+	assertErrorIs(t, err, storage.ErrNotFound)
+
+	list, err = s.LoginAttempts().List(ctx, model.LoginAttemptsFilter{})
+	assertNoError(t, err)
+	if len(list) != 1 || list[0].Login != "other" {
+		t.Errorf("unexpected attempts after clear: %+v", list)
+	}
+}
+
+func testLoginAttemptsPurge(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// attempts are recorded for any login submitted, however long it is
+	long := strings.Repeat("g", 300)
+
+	attempts := []model.LoginAttempt{
+		{Login: long, IP: "10.0.0.1", CreatedAt: now.Add(-2 * time.Hour)},
+		{Login: long, IP: "10.0.0.1", CreatedAt: now.Add(-time.Minute)},
+		{Login: "gopher", IP: "10.0.0.2", CreatedAt: now.Add(-2 * time.Hour)},
+	}
+	for _, a := range attempts {
+		assertNoError(t, s.LoginAttempts().Add(ctx, a))
+	}
+
+	lockouts := []model.Lockout{
+		// ended long ago
+		{Key: model.LoginLockoutKey(long), Level: 1, LockedUntil: now.Add(-2 * time.Hour)},
+		// ended long ago, but reset recently
+		{Key: model.LoginLockoutKey("gopher"), Level: 0, LockedUntil: now.Add(-2 * time.Hour), ResetAt: now.Add(-time.Minute)},
+		// still in effect
+		{Key: model.IPLockoutKey("10.0.0.1"), Level: 1, LockedUntil: now.Add(time.Minute)},
+		// never locked, just reset long ago
+		{Key: model.IPLockoutKey("10.0.0.2"), ResetAt: now.Add(-2 * time.Hour)},
+	}
+	for _, l := range lockouts {
+		assertNoError(t, s.LoginAttempts().SetLockout(ctx, l))
+	}
+
+	count, err := s.LoginAttempts().Purge(ctx, now.Add(-time.Hour))
+	assertNoError(t, err)
+	if count != 2 {
+		t.Errorf("expected 2 purged attempts, got %d", count)
+	}
+
+	list, err := s.LoginAttempts().List(ctx, model.LoginAttemptsFilter{})
+	assertNoError(t, err)
+	if len(list) != 1 || list[0].Login != long {
+		t.Errorf("unexpected attempts after purge: %+v", list)
+	}
+
+	for i, l := range lockouts {
+		_, err = s.LoginAttempts().Lockout(ctx, l.Key)
+		if i == 1 || i == 2 {
+			assertNoError(t, err)
+		} else {
+			assertErrorIs(t, err, storage.ErrNotFound)
+		}
+	}
+}
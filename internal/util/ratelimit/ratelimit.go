@@ -0,0 +1,124 @@
+// Package ratelimit implements token bucket rate limiter which can be paused
+// and retuned at runtime.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by all callers. Bucket capacity is a single
+// token, so requests are spread evenly over a minute instead of bursts.
+type Limiter struct {
+	mu sync.Mutex
+
+	perMinute   int       // 0 - unlimited
+	tokens      float64   // tokens available
+	last        time.Time // last tokens refill time
+	pausedUntil time.Time
+}
+
+// New creates new limiter allowing perMinute calls per minute. Zero or
+// negative perMinute means no limit (though limiter still can be paused).
+func New(perMinute int) *Limiter {
+	if perMinute < 0 {
+		perMinute = 0
+	}
+
+	return &Limiter{
+		perMinute: perMinute,
+		tokens:    1,
+		last:      time.Now(),
+	}
+}
+
+// Wait blocks until call is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if there is one. Otherwise returns duration to wait
+// before next try.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	if l.perMinute == 0 {
+		return 0
+	}
+
+	rate := float64(l.perMinute) / float64(time.Minute)
+
+	l.tokens += float64(now.Sub(l.last)) * rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / rate)
+}
+
+// Pause blocks all calls for d duration. Longer pause already set is kept.
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// SetLimit changes number of calls allowed per minute. Zero or negative value
+// removes the limit.
+func (l *Limiter) SetLimit(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if perMinute < 0 {
+		perMinute = 0
+	}
+
+	l.perMinute = perMinute
+}
+
+// PausedUntil returns time all calls are paused till. It's in the past when
+// limiter is not paused.
+func (l *Limiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pausedUntil
+}
+
+// Limit returns number of calls allowed per minute, zero means no limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.perMinute
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := New(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("unlimited call %d was blocked: %v", i, err)
+		}
+	}
+}
+
+func TestLimiterSpreadsCalls(t *testing.T) {
+	// a token every 10ms
+	l := New(6000)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first call takes the initial token, the rest wait for refills
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("calls weren't spread evenly, 6 calls took %v", elapsed)
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	l := New(0)
+
+	l.Pause(time.Hour)
+	// shorter pause doesn't cut the longer one
+	l.Pause(time.Millisecond)
+
+	if until := l.PausedUntil(); time.Until(until) < 59*time.Minute {
+		t.Fatalf("expected pause to be kept for an hour, paused until %v", until)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected paused call to wait till ctx is done, got %v", err)
+	}
+}
+
+func TestLimiterPauseExpires(t *testing.T) {
+	l := New(0)
+	l.Pause(20 * time.Millisecond)
+
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("call wasn't paused, it took %v", elapsed)
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	l := New(60)
+	if got := l.Limit(); got != 60 {
+		t.Fatalf("expected limit 60, got %d", got)
+	}
+
+	// the initial token is spent, the next one is due in a second
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected call to be limited, got %v", err)
+	}
+
+	l.SetLimit(-1)
+	if got := l.Limit(); got != 0 {
+		t.Fatalf("expected negative limit to remove it, got %d", got)
+	}
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Errorf("expected unlimited call to pass, got %v", err)
+	}
+}
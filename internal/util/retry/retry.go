@@ -2,6 +2,7 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -59,8 +60,9 @@ func (r *Retrier) progression(currentAttempt int) {
 	// 0.5, 2.0, 5.0, 11.0, 23.0
 }
 
-// Do does a retry of f().
-func (r *Retrier) Do(action string, f func() error) (err error) {
+// Do does a retry of f(). Retrying stops as soon as ctx is done, the last
+// error of f() is returned then (or ctx error when f() wasn't called at all).
+func (r *Retrier) Do(ctx context.Context, action string, f func(ctx context.Context) error) (err error) {
 	var retriable model.RetriableError
 
 	i := 0
@@ -70,7 +72,14 @@ func (r *Retrier) Do(action string, f func() error) (err error) {
 		}
 
 		if i > 0 {
-			time.Sleep(r.interval)
+			timer := time.NewTimer(r.interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+
 			r.progression(i)
 			logger.Log.Info("retrying...", zap.String("action", action),
 				zap.Int("attempt", i),
@@ -78,7 +87,14 @@ func (r *Retrier) Do(action string, f func() error) (err error) {
 			)
 		}
 
-		err = f()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err == nil {
+				err = ctxErr
+			}
+			break
+		}
+
+		err = f(ctx)
 		if err == nil || (!r.retryAny && !errors.As(err, &retriable)) {
 			break // no need to try again
 		}
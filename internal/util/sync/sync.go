@@ -1,6 +1,8 @@
 // Package sync implements some custom concurrency specific code.
 package sync
 
+import "context"
+
 // Semaphore структура семафора
 type Semaphore struct {
 	semaCh chan struct{}
@@ -13,8 +15,15 @@ func NewSemaphore(maxReq int) *Semaphore {
 	}
 }
 
-func (s *Semaphore) Acquire() {
-	s.semaCh <- struct{}{}
+// Acquire blocks until slot is free or ctx is done. Release must be called
+// only when nil error was returned.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.semaCh <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *Semaphore) Release() {